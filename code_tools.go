@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 代码格式化与静态检查
+//
+// 根据文件扩展名确定语言，在容器内运行对应的工具。工具不存在时先通过
+// installPackages走系统包管理器安装依赖，再执行工具自身的安装命令（npm、pip等）。
+
+// 诊断信息
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"` // error, warning, info
+	Message  string `json:"message"`
+	Code     string `json:"code,omitempty"`   // 规则编号
+	Source   string `json:"source,omitempty"` // 产生诊断的工具
+}
+
+// 格式化或检查的结果
+type CodeToolResult struct {
+	Path        string       `json:"path"`
+	Language    string       `json:"language"`
+	Tool        string       `json:"tool"`
+	Changed     bool         `json:"changed,omitempty"` // 格式化是否修改了文件
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// 容器内代码工具的定义
+type codeTool struct {
+	Name       string   // 可执行文件名，用于检查是否已安装
+	Check      []string // 自定义检查命令（退出码为0表示已安装），为空时使用which Name
+	Args       []string // 参数，文件路径追加在最后
+	PackageDir bool     // 以文件所在目录为工作目录并以"."代替文件路径（go vet）
+	Packages   []string // 通过系统包管理器安装的依赖
+	InstallCmd []string // 系统包之外的安装命令
+	// 解析输出，返回nil时使用通用的 file:line:col: message 解析
+	Parse func(stdout, stderr string) []Diagnostic
+}
+
+const (
+	codeToolTimeout    = 60 * time.Second
+	codeInstallTimeout = 10 * time.Minute
+)
+
+// 扩展名到语言的映射
+var languageByExtension = map[string]string{
+	".go":   "go",
+	".js":   "javascript",
+	".jsx":  "javascript",
+	".mjs":  "javascript",
+	".cjs":  "javascript",
+	".ts":   "typescript",
+	".tsx":  "typescript",
+	".vue":  "vue",
+	".json": "json",
+	".css":  "css",
+	".scss": "scss",
+	".less": "less",
+	".html": "html",
+	".md":   "markdown",
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".py":   "python",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".hpp":  "cpp",
+	".sh":   "shell",
+	".bash": "shell",
+}
+
+func detectLanguage(filePath string) string {
+	return languageByExtension[strings.ToLower(filepath.Ext(filePath))]
+}
+
+var (
+	prettierTool = codeTool{
+		Name:       "prettier",
+		Args:       []string{"prettier", "--write", "--log-level", "warn"},
+		Packages:   []string{"nodejs", "npm"},
+		InstallCmd: []string{"npm", "install", "-g", "prettier"},
+		Parse:      parsePrettierOutput,
+	}
+
+	eslintTool = codeTool{
+		Name:       "eslint",
+		Args:       []string{"eslint", "--format", "json", "--no-error-on-unmatched-pattern"},
+		Packages:   []string{"nodejs", "npm"},
+		InstallCmd: []string{"npm", "install", "-g", "eslint"},
+		Parse:      parseESLintOutput,
+	}
+
+	// pip在较新的发行版上需要--break-system-packages
+	pipInstall = func(pkg string) []string {
+		return []string{"sh", "-c", "pip3 install " + pkg + " || pip3 install --break-system-packages " + pkg}
+	}
+
+	codeFormatters = map[string]codeTool{
+		"go": {
+			Name:     "gofmt",
+			Args:     []string{"gofmt", "-w"},
+			Packages: []string{"golang"},
+		},
+		"javascript": prettierTool,
+		"typescript": prettierTool,
+		"vue":        prettierTool,
+		"json":       prettierTool,
+		"css":        prettierTool,
+		"scss":       prettierTool,
+		"less":       prettierTool,
+		"html":       prettierTool,
+		"markdown":   prettierTool,
+		"yaml":       prettierTool,
+		"python": {
+			Name:       "black",
+			Args:       []string{"black", "--quiet"},
+			Packages:   []string{"python3", "python3-pip"},
+			InstallCmd: pipInstall("black"),
+			Parse:      parseBlackOutput,
+		},
+		"c":   {Name: "clang-format", Args: []string{"clang-format", "-i"}, Packages: []string{"clang-format"}},
+		"cpp": {Name: "clang-format", Args: []string{"clang-format", "-i"}, Packages: []string{"clang-format"}},
+	}
+
+	codeLinters = map[string]codeTool{
+		"go": {
+			Name:       "go",
+			Args:       []string{"go", "vet"},
+			PackageDir: true,
+			Packages:   []string{"golang"},
+		},
+		"javascript": eslintTool,
+		"typescript": eslintTool,
+		"vue":        eslintTool,
+		"python": {
+			Name:       "flake8",
+			Args:       []string{"flake8"},
+			Packages:   []string{"python3", "python3-pip"},
+			InstallCmd: pipInstall("flake8"),
+			Parse:      parseFlake8Output,
+		},
+		"shell": {
+			Name:     "shellcheck",
+			Args:     []string{"shellcheck", "--format", "gcc"},
+			Packages: []string{"shellcheck"},
+		},
+	}
+)
+
+// 同一工作空间内同一工具只安装一次，键为"工作空间ID/工具名"
+var codeToolInstallLocks sync.Map
+
+// 删除工作空间时清理安装锁
+func forgetCodeToolLocks(workspaceID string) {
+	prefix := workspaceID + "/"
+	codeToolInstallLocks.Range(func(key, _ interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			codeToolInstallLocks.Delete(key)
+		}
+		return true
+	})
+}
+
+// 确保容器内存在工具，缺失时安装
+func (oem *OnlineEditorManager) ensureCodeTool(workspaceID string, tool codeTool) error {
+	lock, _ := codeToolInstallLocks.LoadOrStore(workspaceID+"/"+tool.Name, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), codeInstallTimeout)
+	defer cancel()
+
+	checkCmd := tool.Check
+	if len(checkCmd) == 0 {
+		checkCmd = []string{"which", tool.Name}
+	}
+	hasTool := func() bool {
+		result, err := oem.execInWorkspace(ctx, workspaceID, checkCmd, "/", nil)
+		return err == nil && result.ExitCode == 0
+	}
+
+	if hasTool() {
+		return nil
+	}
+
+	log.Printf("[%s] 未找到 %s，开始安装...", workspaceID, tool.Name)
+
+	if len(tool.Packages) > 0 {
+		oem.installPackages(workspaceID, tool.Packages, []string{"DEBIAN_FRONTEND=noninteractive"})
+	}
+
+	if len(tool.InstallCmd) > 0 && !hasTool() {
+		result, err := oem.execInWorkspace(ctx, workspaceID, tool.InstallCmd, "/", []string{"DEBIAN_FRONTEND=noninteractive"})
+		if err != nil {
+			return fmt.Errorf("安装 %s 失败: %v", tool.Name, err)
+		}
+		if result.ExitCode != 0 {
+			log.Printf("[%s] 安装 %s 输出: %s", workspaceID, tool.Name, result.Stderr)
+		}
+	}
+
+	if !hasTool() {
+		return fmt.Errorf("无法在容器内安装 %s", tool.Name)
+	}
+
+	log.Printf("[%s] %s 安装完成", workspaceID, tool.Name)
+	return nil
+}
+
+// 在容器内对文件运行工具
+func (oem *OnlineEditorManager) runCodeTool(workspaceID, filePath string, tools map[string]codeTool, action string) (*CodeToolResult, error) {
+	status, err := oem.GetWorkspaceStatus(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if status != "running" {
+		return nil, fmt.Errorf("工作空间未运行，无法%s。当前状态: %s", action, status)
+	}
+
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return nil, fmt.Errorf("文件不存在: %s", filePath)
+	}
+
+	workspaceDir, err := oem.workspaceRealDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	relPath, _ := filepath.Rel(workspaceDir, fullPath)
+	relPath = filepath.ToSlash(relPath)
+
+	language := detectLanguage(relPath)
+	tool, supported := tools[language]
+	if language == "" || !supported {
+		return nil, fmt.Errorf("不支持%s此类型的文件: %s", action, filepath.Ext(relPath))
+	}
+
+	if err := oem.ensureCodeTool(workspaceID, tool); err != nil {
+		return nil, err
+	}
+
+	// 直接传递argv，不经过shell
+	containerPath := path.Join(containerWorkspaceRoot, relPath)
+	workingDir := path.Dir(containerPath)
+	cmd := append([]string{}, tool.Args...)
+	if tool.PackageDir {
+		cmd = append(cmd, ".")
+	} else {
+		cmd = append(cmd, containerPath)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), codeToolTimeout)
+	defer cancel()
+
+	execResult, err := oem.execInWorkspace(ctx, workspaceID, cmd, workingDir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CodeToolResult{
+		Path:     relPath,
+		Language: language,
+		Tool:     tool.Name,
+	}
+
+	var diagnostics []Diagnostic
+	if tool.Parse != nil {
+		diagnostics = tool.Parse(execResult.Stdout, execResult.Stderr)
+	} else {
+		diagnostics = parseGenericDiagnostics(execResult.Stdout + "\n" + execResult.Stderr)
+	}
+
+	// 工具失败却没有可解析的诊断时，把原始输出作为一条错误返回
+	if execResult.ExitCode != 0 && len(diagnostics) == 0 {
+		message := strings.TrimSpace(execResult.Stderr)
+		if message == "" {
+			message = strings.TrimSpace(execResult.Stdout)
+		}
+		if message == "" {
+			message = fmt.Sprintf("%s 退出码 %d", tool.Name, execResult.ExitCode)
+		}
+		diagnostics = append(diagnostics, Diagnostic{File: relPath, Severity: "error", Message: message})
+	}
+
+	for i := range diagnostics {
+		diagnostics[i].File = normalizeDiagnosticPath(diagnostics[i].File, workingDir, relPath)
+		if diagnostics[i].Source == "" {
+			diagnostics[i].Source = tool.Name
+		}
+	}
+	result.Diagnostics = diagnostics
+	if result.Diagnostics == nil {
+		result.Diagnostics = []Diagnostic{}
+	}
+
+	return result, nil
+}
+
+// 格式化文件，工具直接修改容器内的文件
+func (oem *OnlineEditorManager) FormatFile(workspaceID, filePath string) (*CodeToolResult, error) {
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath)
+	if err != nil {
+		return nil, err
+	}
+	before, _ := os.ReadFile(fullPath)
+
+	result, err := oem.runCodeTool(workspaceID, filePath, codeFormatters, "格式化")
+	if err != nil {
+		return nil, err
+	}
+
+	after, _ := os.ReadFile(fullPath)
+	result.Changed = string(before) != string(after)
+	if result.Changed {
+		oem.markDiskUsageDirty(workspaceID, fullPath)
+	}
+	return result, nil
+}
+
+// 静态检查文件
+func (oem *OnlineEditorManager) LintFile(workspaceID, filePath string) (*CodeToolResult, error) {
+	return oem.runCodeTool(workspaceID, filePath, codeLinters, "检查")
+}
+
+// 把工具输出中的路径转换为工作空间相对路径
+func normalizeDiagnosticPath(file, workingDir, fallback string) string {
+	if file == "" || file == "-" || file == "<stdin>" {
+		return fallback
+	}
+	if !path.IsAbs(file) {
+		file = path.Join(workingDir, file)
+	}
+	if rel := strings.TrimPrefix(file, containerWorkspaceRoot+"/"); rel != file {
+		return rel
+	}
+	return file
+}
+
+var (
+	// 通用格式: file:line[:col]: [severity:] message
+	genericDiagnosticPattern = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*(?:(error|warning|note|info):\s*)?(.+)$`)
+	shellcheckCodePattern    = regexp.MustCompile(`\s*\[(SC\d+)\]$`)
+	lintCodePattern          = regexp.MustCompile(`^[A-Z]+\d+$`)
+)
+
+func parseGenericDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		match := genericDiagnosticPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		severity := match[4]
+		switch severity {
+		case "":
+			severity = "error"
+		case "note":
+			severity = "info"
+		}
+
+		diagnostic := Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   column,
+			Severity: severity,
+			Message:  strings.TrimSpace(match[5]),
+		}
+
+		// shellcheck: "message [SC2086]"
+		if code := shellcheckCodePattern.FindStringSubmatch(diagnostic.Message); code != nil {
+			diagnostic.Code = code[1]
+			diagnostic.Message = strings.TrimSuffix(diagnostic.Message, code[0])
+		}
+
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// flake8: file:line:col: E501 line too long
+func parseFlake8Output(stdout, stderr string) []Diagnostic {
+	diagnostics := parseGenericDiagnostics(stdout)
+	for i, diagnostic := range diagnostics {
+		code, message, found := strings.Cut(diagnostic.Message, " ")
+		if !found || !lintCodePattern.MatchString(code) {
+			continue
+		}
+		diagnostics[i].Code = code
+		diagnostics[i].Message = message
+		// E9/F是语法和未定义名称错误，其余按警告处理
+		if !strings.HasPrefix(code, "E9") && !strings.HasPrefix(code, "F") {
+			diagnostics[i].Severity = "warning"
+		}
+	}
+	return diagnostics
+}
+
+// eslint --format json
+func parseESLintOutput(stdout, stderr string) []Diagnostic {
+	var report []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   string `json:"ruleId"`
+			Severity int    `json:"severity"`
+			Message  string `json:"message"`
+			Line     int    `json:"line"`
+			Column   int    `json:"column"`
+		} `json:"messages"`
+	}
+
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		return nil
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, file := range report {
+		for _, message := range file.Messages {
+			severity := "warning"
+			if message.Severity == 2 {
+				severity = "error"
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     file.FilePath,
+				Line:     message.Line,
+				Column:   message.Column,
+				Severity: severity,
+				Message:  message.Message,
+				Code:     message.RuleID,
+			})
+		}
+	}
+	return diagnostics
+}
+
+// [error] file: SyntaxError: Unexpected token (1:5)
+var prettierErrorPattern = regexp.MustCompile(`^\[error\] (.+?): (.+?) \((\d+):(\d+)\)`)
+
+func parsePrettierOutput(stdout, stderr string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(stderr, "\n") {
+		match := prettierErrorPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		lineNum, _ := strconv.Atoi(match[3])
+		column, _ := strconv.Atoi(match[4])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   column,
+			Severity: "error",
+			Message:  match[2],
+		})
+	}
+	return diagnostics
+}
+
+// error: cannot format file: Cannot parse: 1:4: source
+var blackErrorPattern = regexp.MustCompile(`cannot format (.+?): (?:Cannot parse(?: for target version [^:]+)?: )?(\d+):(\d+): (.*)`)
+
+func parseBlackOutput(stdout, stderr string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(stderr, "\n") {
+		match := blackErrorPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		lineNum, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     match[1],
+			Line:     lineNum,
+			Column:   column,
+			Severity: "error",
+			Message:  "无法解析: " + match[4],
+		})
+	}
+	return diagnostics
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleFormatFile(w http.ResponseWriter, r *http.Request) {
+	oem.handleCodeTool(w, r, oem.FormatFile)
+}
+
+func (oem *OnlineEditorManager) handleLintFile(w http.ResponseWriter, r *http.Request) {
+	oem.handleCodeTool(w, r, oem.LintFile)
+}
+
+func (oem *OnlineEditorManager) handleCodeTool(w http.ResponseWriter, r *http.Request, run func(string, string) (*CodeToolResult, error)) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		Path string `json:"path"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" {
+		http.Error(w, "缺少文件路径参数", http.StatusBadRequest)
+		return
+	}
+
+	result, err := run(workspaceID, req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
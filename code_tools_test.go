@@ -0,0 +1,120 @@
+package main
+
+import "testing"
+
+func checkDiagnostics(t *testing.T, got, want []Diagnostic) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("诊断数量 = %d, 期望 %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("诊断 %d = %+v, 期望 %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseGenericDiagnostics(t *testing.T) {
+	output := `main.c: In function 'main':
+main.c:3:5: error: expected ';' before 'return'
+    3 |     return 0
+      |     ^~~~~~
+main.c:10:1: warning: control reaches end of non-void function [-Wreturn-type]
+main.c:1:1: note: in expansion of macro 'X'
+./main.go:12:2: unreachable code
+script.sh:3:6: warning: Double quote to prevent globbing and word splitting. [SC2086]
+script.sh:7: missing closing quote
+1 error generated.
+`
+	checkDiagnostics(t, parseGenericDiagnostics(output), []Diagnostic{
+		{File: "main.c", Line: 3, Column: 5, Severity: "error", Message: "expected ';' before 'return'"},
+		{File: "main.c", Line: 10, Column: 1, Severity: "warning", Message: "control reaches end of non-void function [-Wreturn-type]"},
+		{File: "main.c", Line: 1, Column: 1, Severity: "info", Message: "in expansion of macro 'X'"},
+		{File: "./main.go", Line: 12, Column: 2, Severity: "error", Message: "unreachable code"},
+		{File: "script.sh", Line: 3, Column: 6, Severity: "warning", Message: "Double quote to prevent globbing and word splitting.", Code: "SC2086"},
+		{File: "script.sh", Line: 7, Severity: "error", Message: "missing closing quote"},
+	})
+
+	if diagnostics := parseGenericDiagnostics(""); len(diagnostics) != 0 {
+		t.Fatalf("空输出应没有诊断: %+v", diagnostics)
+	}
+}
+
+func TestParseFlake8Output(t *testing.T) {
+	stdout := `app.py:1:1: F401 'os' imported but unused
+app.py:3:5: E999 SyntaxError: invalid syntax
+app.py:7:1: W391 blank line at end of file
+app.py:10:80: E501 line too long (88 > 79 characters)
+app.py:12:1: custom plugin message
+`
+	checkDiagnostics(t, parseFlake8Output(stdout, ""), []Diagnostic{
+		{File: "app.py", Line: 1, Column: 1, Severity: "error", Message: "'os' imported but unused", Code: "F401"},
+		{File: "app.py", Line: 3, Column: 5, Severity: "error", Message: "SyntaxError: invalid syntax", Code: "E999"},
+		{File: "app.py", Line: 7, Column: 1, Severity: "warning", Message: "blank line at end of file", Code: "W391"},
+		{File: "app.py", Line: 10, Column: 80, Severity: "warning", Message: "line too long (88 > 79 characters)", Code: "E501"},
+		{File: "app.py", Line: 12, Column: 1, Severity: "error", Message: "custom plugin message"},
+	})
+}
+
+func TestParseESLintOutput(t *testing.T) {
+	stdout := `[
+  {
+    "filePath": "/workspace/src/a.js",
+    "messages": [
+      {"ruleId": "no-unused-vars", "severity": 1, "message": "'x' is assigned a value but never used.", "line": 1, "column": 7},
+      {"ruleId": null, "severity": 2, "message": "Parsing error: Unexpected token )", "line": 3, "column": 12, "fatal": true}
+    ],
+    "errorCount": 1,
+    "warningCount": 1
+  },
+  {"filePath": "/workspace/src/b.js", "messages": []}
+]`
+	checkDiagnostics(t, parseESLintOutput(stdout, ""), []Diagnostic{
+		{File: "/workspace/src/a.js", Line: 1, Column: 7, Severity: "warning", Message: "'x' is assigned a value but never used.", Code: "no-unused-vars"},
+		{File: "/workspace/src/a.js", Line: 3, Column: 12, Severity: "error", Message: "Parsing error: Unexpected token )"},
+	})
+
+	if diagnostics := parseESLintOutput("[]", ""); diagnostics == nil || len(diagnostics) != 0 {
+		t.Fatalf("没有问题时应返回空列表: %#v", diagnostics)
+	}
+	// 配置错误等情况eslint不输出JSON
+	if diagnostics := parseESLintOutput("Oops! Something went wrong!", ""); diagnostics != nil {
+		t.Fatalf("非JSON输出应返回nil: %+v", diagnostics)
+	}
+}
+
+func TestParsePrettierOutput(t *testing.T) {
+	stderr := `[error] src/a.js: SyntaxError: Unexpected token (3:7)
+[error]   1 | const a = {
+[error]   2 |   b: 1
+[error] > 3 |   c: 2
+[error]     |       ^
+[error] src/b.ts: SyntaxError: ';' expected. (10:1)
+`
+	checkDiagnostics(t, parsePrettierOutput("", stderr), []Diagnostic{
+		{File: "src/a.js", Line: 3, Column: 7, Severity: "error", Message: "SyntaxError: Unexpected token"},
+		{File: "src/b.ts", Line: 10, Column: 1, Severity: "error", Message: "SyntaxError: ';' expected."},
+	})
+
+	// 诊断只从stderr读取，stdout是格式化后的内容
+	if diagnostics := parsePrettierOutput("[error] x.js: y (1:1)", ""); len(diagnostics) != 0 {
+		t.Fatalf("不应解析stdout: %+v", diagnostics)
+	}
+}
+
+func TestParseBlackOutput(t *testing.T) {
+	stderr := `error: cannot format app.py: Cannot parse: 1:4: def f(:
+error: cannot format legacy.py: Cannot parse for target version Python 3.12: 2:6: print 'x'
+
+Oh no! 💥 💔 💥
+2 files failed to reformat.
+`
+	checkDiagnostics(t, parseBlackOutput("", stderr), []Diagnostic{
+		{File: "app.py", Line: 1, Column: 4, Severity: "error", Message: "无法解析: def f(:"},
+		{File: "legacy.py", Line: 2, Column: 6, Severity: "error", Message: "无法解析: print 'x'"},
+	})
+
+	if diagnostics := parseBlackOutput("", "All done! ✨ 🍰 ✨\n1 file left unchanged.\n"); len(diagnostics) != 0 {
+		t.Fatalf("成功输出不应有诊断: %+v", diagnostics)
+	}
+}
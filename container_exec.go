@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// 容器内命令执行结果
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// 容器内执行命令的基础环境变量
+func workspaceExecEnv(workspace *Workspace) []string {
+	envs := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/usr/local/go/bin:/opt/homebrew/bin",
+		"TERM=xterm-256color",
+		"HOME=/root",
+		"USER=root",
+		"SHELL=/bin/bash",
+		"PWD=/workspace",
+		"LANG=C.UTF-8",
+		"LC_ALL=C.UTF-8",
+	}
+
+	// 添加镜像特定的环境变量
+	for k, v := range workspace.Environment {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	return envs
+}
+
+// 在工作空间容器内执行命令，分别收集stdout、stderr和退出码
+// 与ExecuteCommand不同，这里不做终端输出过滤，适合需要解析输出的场景
+func (oem *OnlineEditorManager) execInWorkspace(ctx context.Context, workspaceID string, cmd []string, workingDir string, extraEnv []string) (*ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := oem.execInWorkspaceStream(ctx, workspaceID, cmd, workingDir, extraEnv, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+	}, nil
+}
+
+// 在工作空间容器内执行命令，输出实时写入stdout和stderr，返回退出码
+func (oem *OnlineEditorManager) execInWorkspaceStream(ctx context.Context, workspaceID string, cmd []string, workingDir string, extraEnv []string, stdout, stderr io.Writer) (int, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var containerID string
+	var envs []string
+	if exists {
+		containerID = workspace.ContainerID
+		envs = workspaceExecEnv(workspace)
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		return 0, fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	if containerID == "" {
+		return 0, fmt.Errorf("工作空间容器尚未创建: %s", workspaceID)
+	}
+
+	if workingDir == "" {
+		workingDir = "/workspace"
+	}
+
+	execConfig := container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+		WorkingDir:   workingDir,
+		Env:          append(envs, extraEnv...),
+	}
+
+	execResp, err := oem.dockerClient.ContainerExecCreate(ctx, containerID, execConfig)
+	if err != nil {
+		return 0, fmt.Errorf("创建执行配置失败: %v", err)
+	}
+
+	execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("执行命令失败: %v", err)
+	}
+	defer execAttachResp.Close()
+
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, execAttachResp.Reader)
+		copyDone <- err
+	}()
+
+	select {
+	case err := <-copyDone:
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("读取命令输出失败: %v", err)
+		}
+	case <-ctx.Done():
+		return 0, fmt.Errorf("命令执行超时")
+	}
+
+	inspect, err := oem.dockerClient.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return 0, fmt.Errorf("获取命令退出码失败: %v", err)
+	}
+
+	return inspect.ExitCode, nil
+}
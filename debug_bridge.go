@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// 调试适配器（DAP）桥接
+//
+// 与语言服务器一样，每个WebSocket连接对应容器内一个调试适配器进程。delve和js-debug
+// 只支持TCP，由dap_tcp_bridge脚本在容器内转接到stdio。启动配置按工作空间保存在服务端，
+// 客户端在launch/attach请求的arguments中带上configuration字段即可引用。
+
+// 调试启动配置
+type LaunchConfiguration struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`    // go, python, node
+	Request     string                 `json:"request"` // launch, attach
+	Program     string                 `json:"program,omitempty"`
+	Args        []string               `json:"args,omitempty"`
+	Cwd         string                 `json:"cwd,omitempty"`
+	Env         map[string]string      `json:"env,omitempty"`
+	Port        int                    `json:"port,omitempty"` // attach时被调试进程的调试端口
+	StopOnEntry bool                   `json:"stop_on_entry,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"` // 调试器特有的参数，原样传给适配器
+}
+
+// 调试适配器定义
+type debugAdapter struct {
+	Tool   codeTool
+	Cmd    []string                // 通过stdio通信的适配器
+	Listen func(port int) []string // 只支持TCP的适配器，参数为容器内监听端口
+}
+
+// js-debug的DAP服务端
+const (
+	jsDebugVersion = "v1.90.0"
+	jsDebugServer  = "/opt/js-debug/src/dapDebugServer.js"
+)
+
+// 只支持TCP的适配器在容器内监听的端口范围
+const (
+	debugPortMin   = 41000
+	debugPortCount = 8000
+)
+
+var debugAdapters = map[string]debugAdapter{
+	"go": {
+		Tool: codeTool{
+			Name:       "dlv",
+			Packages:   []string{"golang"},
+			InstallCmd: []string{"sh", "-c", "GOBIN=/usr/local/bin go install github.com/go-delve/delve/cmd/dlv@latest"},
+		},
+		Listen: func(port int) []string {
+			return []string{"dlv", "dap", "--listen=127.0.0.1:" + strconv.Itoa(port)}
+		},
+	},
+	"python": {
+		Tool: codeTool{
+			Name:       "debugpy",
+			Check:      []string{"python3", "-c", "import debugpy"},
+			Packages:   []string{"python3", "python3-pip"},
+			InstallCmd: pipInstall("debugpy"),
+		},
+		Cmd: []string{"python3", "-m", "debugpy.adapter"},
+	},
+	"node": {
+		Tool: codeTool{
+			Name:     "js-debug",
+			Check:    []string{"test", "-f", jsDebugServer},
+			Packages: []string{"nodejs", "curl"},
+			InstallCmd: []string{"sh", "-c", fmt.Sprintf(
+				"curl -fsSL https://github.com/microsoft/vscode-js-debug/releases/download/%s/js-debug-dap-%s.tar.gz | tar -xz -C /opt",
+				jsDebugVersion, jsDebugVersion)},
+		},
+		Listen: func(port int) []string {
+			return []string{"node", jsDebugServer, strconv.Itoa(port), "127.0.0.1"}
+		},
+	},
+}
+
+// 启动配置文件路径
+func (oem *OnlineEditorManager) launchConfigsFile(workspaceID string) string {
+	return filepath.Join(oem.baseDir, "debug", workspaceID+".json")
+}
+
+// 读取工作空间的启动配置
+func (oem *OnlineEditorManager) GetLaunchConfigurations(workspaceID string) ([]LaunchConfiguration, error) {
+	if _, err := oem.GetWorkspace(workspaceID); err != nil {
+		return nil, err
+	}
+
+	oem.debugMutex.Lock()
+	defer oem.debugMutex.Unlock()
+	return oem.readLaunchConfigurations(workspaceID)
+}
+
+func (oem *OnlineEditorManager) readLaunchConfigurations(workspaceID string) ([]LaunchConfiguration, error) {
+	configs := []LaunchConfiguration{}
+	data, err := os.ReadFile(oem.launchConfigsFile(workspaceID))
+	if os.IsNotExist(err) {
+		return configs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取启动配置失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("解析启动配置失败: %v", err)
+	}
+	return configs, nil
+}
+
+// 保存工作空间的启动配置（整体替换）
+func (oem *OnlineEditorManager) SaveLaunchConfigurations(workspaceID string, configs []LaunchConfiguration) error {
+	if _, err := oem.GetWorkspace(workspaceID); err != nil {
+		return err
+	}
+
+	oem.debugMutex.Lock()
+	defer oem.debugMutex.Unlock()
+	return oem.writeLaunchConfigurations(workspaceID, configs)
+}
+
+// 校验并写入启动配置，调用者必须持有debugMutex
+func (oem *OnlineEditorManager) writeLaunchConfigurations(workspaceID string, configs []LaunchConfiguration) error {
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if config.Name == "" {
+			return fmt.Errorf("启动配置名称不能为空")
+		}
+		if names[config.Name] {
+			return fmt.Errorf("启动配置名称重复: %s", config.Name)
+		}
+		names[config.Name] = true
+
+		if _, exists := debugAdapters[config.Type]; !exists {
+			return fmt.Errorf("不支持的调试类型: %s", config.Type)
+		}
+		if config.Request != "launch" && config.Request != "attach" {
+			return fmt.Errorf("无效的请求类型: %s", config.Request)
+		}
+	}
+
+	configFile := oem.launchConfigsFile(workspaceID)
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		return fmt.Errorf("创建配置目录失败: %v", err)
+	}
+
+	data, _ := json.MarshalIndent(configs, "", "  ")
+	if err := os.WriteFile(configFile, data, 0644); err != nil {
+		return fmt.Errorf("保存启动配置失败: %v", err)
+	}
+	return nil
+}
+
+// 删除单个启动配置
+func (oem *OnlineEditorManager) DeleteLaunchConfiguration(workspaceID, name string) error {
+	if _, err := oem.GetWorkspace(workspaceID); err != nil {
+		return err
+	}
+
+	// 读取和写回在同一把锁内，避免并发修改丢失
+	oem.debugMutex.Lock()
+	defer oem.debugMutex.Unlock()
+
+	configs, err := oem.readLaunchConfigurations(workspaceID)
+	if err != nil {
+		return err
+	}
+
+	for i, config := range configs {
+		if config.Name == name {
+			return oem.writeLaunchConfigurations(workspaceID, append(configs[:i], configs[i+1:]...))
+		}
+	}
+	return fmt.Errorf("启动配置不存在: %s", name)
+}
+
+// 把相对于工作空间的路径转换为容器内路径
+func containerPathFor(p string) string {
+	if p == "" || path.IsAbs(p) {
+		return p
+	}
+	return path.Join(containerWorkspaceRoot, p)
+}
+
+// 把启动配置转换为DAP launch/attach请求的arguments
+func (config LaunchConfiguration) arguments() map[string]interface{} {
+	args := make(map[string]interface{})
+	for key, value := range config.Options {
+		args[key] = value
+	}
+
+	args["name"] = config.Name
+	args["type"] = config.Type
+	args["request"] = config.Request
+
+	cwd := containerPathFor(config.Cwd)
+	if cwd == "" {
+		cwd = containerWorkspaceRoot
+	}
+	args["cwd"] = cwd
+
+	if config.Program != "" {
+		args["program"] = containerPathFor(config.Program)
+	}
+	if len(config.Args) > 0 {
+		args["args"] = config.Args
+	}
+	if len(config.Env) > 0 {
+		args["env"] = config.Env
+	}
+	if config.StopOnEntry {
+		args["stopOnEntry"] = true
+	}
+
+	if config.Request == "attach" && config.Port > 0 {
+		switch config.Type {
+		case "go":
+			args["mode"] = "remote"
+			args["port"] = config.Port
+			args["host"] = "127.0.0.1"
+		case "python":
+			args["connect"] = map[string]interface{}{"host": "127.0.0.1", "port": config.Port}
+		case "node":
+			args["port"] = config.Port
+		}
+	} else if config.Type == "go" {
+		if _, exists := args["mode"]; !exists {
+			args["mode"] = "debug"
+		}
+	}
+
+	return args
+}
+
+// 客户端launch/attach请求带有configuration字段时，以保存的配置为基础合并客户端参数
+func (oem *OnlineEditorManager) applyLaunchConfiguration(workspaceID string, body []byte) []byte {
+	var message map[string]interface{}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return body
+	}
+
+	command, _ := message["command"].(string)
+	if message["type"] != "request" || (command != "launch" && command != "attach") {
+		return body
+	}
+
+	clientArgs, _ := message["arguments"].(map[string]interface{})
+	name, _ := clientArgs["configuration"].(string)
+	if name == "" {
+		return body
+	}
+
+	configs, err := oem.GetLaunchConfigurations(workspaceID)
+	if err != nil {
+		return body
+	}
+
+	for _, config := range configs {
+		if config.Name != name {
+			continue
+		}
+		args := config.arguments()
+		for key, value := range clientArgs {
+			if key != "configuration" {
+				args[key] = value
+			}
+		}
+		message["arguments"] = args
+
+		merged, err := json.Marshal(message)
+		if err != nil {
+			return body
+		}
+		return merged
+	}
+
+	log.Printf("[%s] 启动配置不存在: %s", workspaceID, name)
+	return body
+}
+
+// 启动调试适配器
+func (oem *OnlineEditorManager) StartDebugAdapter(workspaceID, debugType string) (*stdioSession, error) {
+	adapter, exists := debugAdapters[debugType]
+	if !exists {
+		return nil, fmt.Errorf("不支持的调试类型: %s", debugType)
+	}
+
+	if err := oem.ensureCodeTool(workspaceID, adapter.Tool); err != nil {
+		return nil, err
+	}
+
+	cmd := adapter.Cmd
+	if adapter.Listen != nil {
+		script, err := scriptManager.GetScript("dap_tcp_bridge")
+		if err != nil {
+			return nil, err
+		}
+		port, err := oem.allocateDebugPort(workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		cmd = append([]string{"bash", "-c", script, "dap-bridge", strconv.Itoa(port)}, adapter.Listen(port)...)
+
+		session, err := oem.startStdioSession(workspaceID, "dap", debugType, cmd, containerWorkspaceRoot)
+		if err != nil {
+			oem.releaseDebugPort(workspaceID, port)
+			return nil, err
+		}
+		go func() {
+			<-session.done
+			oem.releaseDebugPort(workspaceID, port)
+		}()
+		return session, nil
+	}
+
+	return oem.startStdioSession(workspaceID, "dap", debugType, cmd, containerWorkspaceRoot)
+}
+
+// 为TCP调试适配器分配容器内端口，跳过其他调试会话已占用和容器内正在监听的端口
+func (oem *OnlineEditorManager) allocateDebugPort(workspaceID string) (int, error) {
+	listening, _ := oem.detectedPorts(workspaceID)
+
+	oem.debugMutex.Lock()
+	defer oem.debugMutex.Unlock()
+
+	used := oem.debugPorts[workspaceID]
+	if used == nil {
+		used = make(map[int]bool)
+		oem.debugPorts[workspaceID] = used
+	}
+
+	offset := rand.Intn(debugPortCount)
+	for i := 0; i < debugPortCount; i++ {
+		port := debugPortMin + (offset+i)%debugPortCount
+		if _, busy := listening[port]; busy || used[port] {
+			continue
+		}
+		used[port] = true
+		return port, nil
+	}
+	return 0, fmt.Errorf("没有可用的调试端口")
+}
+
+func (oem *OnlineEditorManager) releaseDebugPort(workspaceID string, port int) {
+	oem.debugMutex.Lock()
+	defer oem.debugMutex.Unlock()
+
+	delete(oem.debugPorts[workspaceID], port)
+	if len(oem.debugPorts[workspaceID]) == 0 {
+		delete(oem.debugPorts, workspaceID)
+	}
+}
+
+// 结束调试会话：先请求适配器断开并结束被调试进程，再结束适配器
+func disconnectDebugAdapter(session *stdioSession) {
+	disconnect, _ := json.Marshal(map[string]interface{}{
+		"seq":       1 << 30,
+		"type":      "request",
+		"command":   "disconnect",
+		"arguments": map[string]interface{}{"terminateDebuggee": true},
+	})
+
+	if err := session.WriteMessage(disconnect); err == nil {
+		select {
+		case <-session.done:
+		case <-time.After(2 * time.Second):
+		}
+	}
+	session.Close()
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/debug/{type}/ws?root_path=
+// root_path为客户端使用的工作空间根路径，默认与容器内一致（/workspace）
+func (oem *OnlineEditorManager) handleDebugWebSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	debugType := vars["type"]
+
+	clientRoot := strings.TrimSuffix(r.URL.Query().Get("root_path"), "/")
+	if clientRoot == "" {
+		clientRoot = containerWorkspaceRoot
+	}
+
+	conn, err := oem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	session, err := oem.StartDebugAdapter(workspaceID, debugType)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		return
+	}
+	defer disconnectDebugAdapter(session)
+
+	log.Printf("[%s] 调试会话开始: %s (%s)", workspaceID, session.ID, debugType)
+
+	relayStdioWebSocket(conn, session,
+		func(body []byte) []byte {
+			body = oem.applyLaunchConfiguration(workspaceID, body)
+			return rewriteJSONPrefix(body, clientRoot, containerWorkspaceRoot)
+		},
+		func(body []byte) []byte { return rewriteJSONPrefix(body, containerWorkspaceRoot, clientRoot) },
+	)
+
+	log.Printf("[%s] 调试会话结束: %s", workspaceID, session.ID)
+}
+
+func (oem *OnlineEditorManager) handleListDebugSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	types := make([]string, 0, len(debugAdapters))
+	for debugType := range debugAdapters {
+		types = append(types, debugType)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"supported": types,
+		"sessions":  oem.listStdioSessions(workspaceID, "dap"),
+	})
+}
+
+func (oem *OnlineEditorManager) handleStopDebugSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	sessionID := vars["sessionId"]
+
+	for _, session := range oem.listStdioSessions(workspaceID, "dap") {
+		if session.ID == sessionID {
+			disconnectDebugAdapter(session)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	http.Error(w, "调试会话不存在", http.StatusNotFound)
+}
+
+func (oem *OnlineEditorManager) handleGetLaunchConfigurations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	configs, err := oem.GetLaunchConfigurations(workspaceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configs)
+}
+
+func (oem *OnlineEditorManager) handleSaveLaunchConfigurations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var configs []LaunchConfiguration
+	if err := json.NewDecoder(r.Body).Decode(&configs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := oem.SaveLaunchConfigurations(workspaceID, configs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (oem *OnlineEditorManager) handleDeleteLaunchConfiguration(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	name := vars["name"]
+
+	if err := oem.DeleteLaunchConfiguration(workspaceID, name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
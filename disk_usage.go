@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 工作空间磁盘用量与配额
+//
+// 用量按工作空间根目录下的顶级条目分别统计并缓存。文件API写入时直接累加变化量，
+// 删除、移动等无法廉价得知大小变化的操作只把对应顶级条目标记为脏，下次读取时
+// 只重新扫描脏条目。容器内进程的写入无法感知，由后台任务定期做全量扫描。
+
+// 全量扫描缓存的最长有效期
+const diskUsageMaxAge = 5 * time.Minute
+
+// 磁盘配额，单位字节，0表示不限制
+type DiskQuota struct {
+	SoftLimit int64 `json:"soft_limit"`
+	HardLimit int64 `json:"hard_limit"`
+}
+
+// 顶级条目的用量
+type DiskUsageEntry struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"is_dir"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+}
+
+// 工作空间磁盘用量报告
+type DiskUsage struct {
+	WorkspaceID   string           `json:"workspace_id"`
+	DisplayName   string           `json:"display_name,omitempty"`
+	TotalSize     int64            `json:"total_size"`
+	FileCount     int64            `json:"file_count"`
+	TrashSize     int64            `json:"trash_size"`
+	Quota         DiskQuota        `json:"quota"`
+	OverSoftLimit bool             `json:"over_soft_limit"`
+	OverHardLimit bool             `json:"over_hard_limit"`
+	Breakdown     []DiskUsageEntry `json:"breakdown,omitempty"`
+	ScannedAt     time.Time        `json:"scanned_at"`
+}
+
+// 超出硬配额
+type DiskQuotaError struct {
+	Used      int64
+	Requested int64
+	Limit     int64
+}
+
+func (e *DiskQuotaError) Error() string {
+	return fmt.Sprintf("超出工作空间磁盘配额: 已使用 %s，本次需要 %s，硬限制 %s",
+		formatBytes(e.Used), formatBytes(e.Requested), formatBytes(e.Limit))
+}
+
+// 单个工作空间的用量缓存
+type workspaceDiskUsage struct {
+	entries   map[string]*DiskUsageEntry // 按顶级条目名索引
+	dirty     map[string]bool
+	scannedAt time.Time
+}
+
+func (u *workspaceDiskUsage) total() (size, files int64) {
+	for _, entry := range u.entries {
+		size += entry.Size
+		files += entry.Files
+	}
+	return size, files
+}
+
+// 磁盘用量管理器
+type DiskUsageManager struct {
+	defaultQuota DiskQuota
+	quotas       map[string]DiskQuota // 按工作空间覆盖的配额
+	quotasFile   string
+	usage        map[string]*workspaceDiskUsage
+	mutex        sync.Mutex
+}
+
+// 创建磁盘用量管理器，默认配额从环境变量读取（单位MB）
+func NewDiskUsageManager(baseDir string) *DiskUsageManager {
+	dum := &DiskUsageManager{
+		defaultQuota: DiskQuota{
+			SoftLimit: envMegabytes("WORKSPACE_DISK_SOFT_LIMIT_MB"),
+			HardLimit: envMegabytes("WORKSPACE_DISK_HARD_LIMIT_MB"),
+		},
+		quotas:     make(map[string]DiskQuota),
+		quotasFile: filepath.Join(baseDir, "disk_quotas.json"),
+		usage:      make(map[string]*workspaceDiskUsage),
+	}
+
+	if data, err := os.ReadFile(dum.quotasFile); err == nil {
+		if err := json.Unmarshal(data, &dum.quotas); err != nil {
+			log.Printf("读取磁盘配额配置失败: %v", err)
+		}
+	}
+
+	return dum
+}
+
+func envMegabytes(name string) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value * 1024 * 1024
+}
+
+// 格式化字节数
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// 工作空间生效的配额（调用者必须持有dum.mutex）
+func (dum *DiskUsageManager) quotaLocked(workspaceID string) DiskQuota {
+	if quota, exists := dum.quotas[workspaceID]; exists {
+		return quota
+	}
+	return dum.defaultQuota
+}
+
+func (dum *DiskUsageManager) saveQuotasLocked() error {
+	data, err := json.MarshalIndent(dum.quotas, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dum.quotasFile, data, 0644)
+}
+
+// 统计单个顶级条目的大小，不跟随符号链接
+func scanDiskUsageEntry(workspaceDir, name string) *DiskUsageEntry {
+	entry := &DiskUsageEntry{Path: name}
+	root := filepath.Join(workspaceDir, name)
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		return nil
+	}
+	entry.IsDir = info.IsDir()
+
+	filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			entry.Size += info.Size()
+			entry.Files++
+		}
+		return nil
+	})
+
+	return entry
+}
+
+// 全量扫描工作空间目录
+func scanWorkspaceDiskUsage(workspaceDir string) (*workspaceDiskUsage, error) {
+	dirEntries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("读取工作空间目录失败: %v", err)
+	}
+
+	usage := &workspaceDiskUsage{
+		entries:   make(map[string]*DiskUsageEntry),
+		dirty:     make(map[string]bool),
+		scannedAt: time.Now(),
+	}
+	for _, dirEntry := range dirEntries {
+		if entry := scanDiskUsageEntry(workspaceDir, dirEntry.Name()); entry != nil {
+			usage.entries[dirEntry.Name()] = entry
+		}
+	}
+	return usage, nil
+}
+
+// 根据宿主机路径得到所属的顶级条目名，根目录本身返回空字符串
+func topLevelEntry(workspaceDir, fullPath string) string {
+	rel, err := filepath.Rel(workspaceDir, fullPath)
+	if err != nil || rel == "." || !isSubPath(workspaceDir, fullPath) {
+		return ""
+	}
+	return strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+}
+
+// 获取用量缓存，必要时扫描（调用者必须持有dum.mutex）
+// full为true时忽略缓存重新全量扫描，否则只重新扫描脏条目
+func (dum *DiskUsageManager) usageLocked(workspaceID, workspaceDir string, full bool) (*workspaceDiskUsage, error) {
+	usage, exists := dum.usage[workspaceID]
+	if !exists || full {
+		scanned, err := scanWorkspaceDiskUsage(workspaceDir)
+		if err != nil {
+			return nil, err
+		}
+		dum.usage[workspaceID] = scanned
+		return scanned, nil
+	}
+
+	for name := range usage.dirty {
+		if entry := scanDiskUsageEntry(workspaceDir, name); entry != nil {
+			usage.entries[name] = entry
+		} else {
+			delete(usage.entries, name)
+		}
+	}
+	usage.dirty = make(map[string]bool)
+	return usage, nil
+}
+
+// 获取用量缓存，上次全量扫描超过diskUsageMaxAge时重新全量扫描，
+// 容器内进程的写入（npm install、构建等）由此反映到报告和配额检查中（调用者必须持有dum.mutex）
+func (dum *DiskUsageManager) currentUsageLocked(workspaceID, workspaceDir string, refresh bool) (*workspaceDiskUsage, error) {
+	usage, exists := dum.usage[workspaceID]
+	full := refresh || !exists || time.Since(usage.scannedAt) > diskUsageMaxAge
+	return dum.usageLocked(workspaceID, workspaceDir, full)
+}
+
+// 检查写入additional字节后是否超出硬配额
+// 可能需要扫描工作空间目录，调用者不能持有oem.mutex
+func (dum *DiskUsageManager) CheckQuota(workspaceID, workspaceDir string, additional int64) error {
+	dum.mutex.Lock()
+	defer dum.mutex.Unlock()
+
+	quota := dum.quotaLocked(workspaceID)
+	if quota.HardLimit <= 0 || additional <= 0 {
+		return nil
+	}
+
+	usage, err := dum.currentUsageLocked(workspaceID, workspaceDir, false)
+	if err != nil {
+		return err
+	}
+
+	used, _ := usage.total()
+	if used+additional > quota.HardLimit {
+		return &DiskQuotaError{Used: used, Requested: additional, Limit: quota.HardLimit}
+	}
+	return nil
+}
+
+// 记录已知的大小变化
+func (dum *DiskUsageManager) AddUsage(workspaceDir, workspaceID, fullPath string, sizeDelta, filesDelta int64) {
+	name := topLevelEntry(workspaceDir, fullPath)
+	if name == "" {
+		return
+	}
+
+	dum.mutex.Lock()
+	defer dum.mutex.Unlock()
+
+	usage, exists := dum.usage[workspaceID]
+	if !exists || usage.dirty[name] {
+		return
+	}
+
+	entry, exists := usage.entries[name]
+	if !exists {
+		// 新出现的顶级条目，交给下次读取时扫描
+		usage.dirty[name] = true
+		return
+	}
+	entry.Size += sizeDelta
+	entry.Files += filesDelta
+}
+
+// 标记路径所在的顶级条目需要重新扫描
+func (dum *DiskUsageManager) MarkDirty(workspaceDir, workspaceID string, fullPaths ...string) {
+	dum.mutex.Lock()
+	defer dum.mutex.Unlock()
+
+	usage, exists := dum.usage[workspaceID]
+	if !exists {
+		return
+	}
+	for _, fullPath := range fullPaths {
+		if name := topLevelEntry(workspaceDir, fullPath); name != "" {
+			usage.dirty[name] = true
+		}
+	}
+}
+
+// 工作空间删除时清理用量缓存和配额配置
+func (dum *DiskUsageManager) Forget(workspaceID string) {
+	dum.mutex.Lock()
+	defer dum.mutex.Unlock()
+
+	delete(dum.usage, workspaceID)
+	if _, exists := dum.quotas[workspaceID]; exists {
+		delete(dum.quotas, workspaceID)
+		if err := dum.saveQuotasLocked(); err != nil {
+			log.Printf("保存磁盘配额配置失败: %v", err)
+		}
+	}
+}
+
+// 生成用量报告
+func (dum *DiskUsageManager) Report(workspaceID, workspaceDir, trashDir string, refresh bool) (*DiskUsage, error) {
+	dum.mutex.Lock()
+	usage, err := dum.currentUsageLocked(workspaceID, workspaceDir, refresh)
+	if err != nil {
+		dum.mutex.Unlock()
+		return nil, err
+	}
+
+	report := &DiskUsage{
+		WorkspaceID: workspaceID,
+		Quota:       dum.quotaLocked(workspaceID),
+		ScannedAt:   usage.scannedAt,
+	}
+	report.TotalSize, report.FileCount = usage.total()
+	for _, entry := range usage.entries {
+		report.Breakdown = append(report.Breakdown, *entry)
+	}
+	dum.mutex.Unlock()
+
+	report.TrashSize = pathSize(trashDir)
+	report.OverSoftLimit = report.Quota.SoftLimit > 0 && report.TotalSize > report.Quota.SoftLimit
+	report.OverHardLimit = report.Quota.HardLimit > 0 && report.TotalSize > report.Quota.HardLimit
+
+	sort.Slice(report.Breakdown, func(i, j int) bool {
+		return report.Breakdown[i].Size > report.Breakdown[j].Size
+	})
+
+	return report, nil
+}
+
+// 设置工作空间配额，quota为nil时恢复默认配额
+func (dum *DiskUsageManager) SetQuota(workspaceID string, quota *DiskQuota) (DiskQuota, error) {
+	dum.mutex.Lock()
+	defer dum.mutex.Unlock()
+
+	if quota == nil {
+		delete(dum.quotas, workspaceID)
+	} else {
+		if quota.SoftLimit < 0 || quota.HardLimit < 0 {
+			return DiskQuota{}, fmt.Errorf("配额不能为负数")
+		}
+		if quota.HardLimit > 0 && quota.SoftLimit > quota.HardLimit {
+			return DiskQuota{}, fmt.Errorf("软限制不能大于硬限制")
+		}
+		dum.quotas[workspaceID] = *quota
+	}
+
+	if err := dum.saveQuotasLocked(); err != nil {
+		return DiskQuota{}, fmt.Errorf("保存磁盘配额配置失败: %v", err)
+	}
+	return dum.quotaLocked(workspaceID), nil
+}
+
+// 工作空间用量报告
+func (oem *OnlineEditorManager) GetDiskUsage(workspaceID string, refresh bool) (*DiskUsage, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var displayName string
+	if exists {
+		displayName = workspace.DisplayName
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+
+	workspaceDir, err := oem.workspaceRealDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := oem.diskUsage.Report(workspaceID, workspaceDir, oem.workspaceTrashDir(workspaceID), refresh)
+	if err != nil {
+		return nil, err
+	}
+	report.DisplayName = displayName
+	return report, nil
+}
+
+// 检查工作空间写入配额，调用者不能持有oem.mutex
+func (oem *OnlineEditorManager) checkDiskQuota(workspaceID string, additional int64) error {
+	workspaceDir, err := oem.workspaceRealDir(workspaceID)
+	if err != nil {
+		return err
+	}
+	return oem.diskUsage.CheckQuota(workspaceID, workspaceDir, additional)
+}
+
+// 记录文件API造成的用量变化
+func (oem *OnlineEditorManager) addDiskUsage(workspaceID, fullPath string, sizeDelta, filesDelta int64) {
+	if workspaceDir, err := oem.workspaceRealDir(workspaceID); err == nil {
+		oem.diskUsage.AddUsage(workspaceDir, workspaceID, fullPath, sizeDelta, filesDelta)
+	}
+}
+
+// 标记文件API修改过的路径需要重新统计
+func (oem *OnlineEditorManager) markDiskUsageDirty(workspaceID string, fullPaths ...string) {
+	if workspaceDir, err := oem.workspaceRealDir(workspaceID); err == nil {
+		oem.diskUsage.MarkDirty(workspaceDir, workspaceID, fullPaths...)
+	}
+}
+
+// 按用量从大到小列出工作空间，limit<=0表示全部
+func (oem *OnlineEditorManager) ListDiskUsage(limit int) []*DiskUsage {
+	oem.mutex.RLock()
+	workspaceIDs := make([]string, 0, len(oem.workspaces))
+	for workspaceID := range oem.workspaces {
+		workspaceIDs = append(workspaceIDs, workspaceID)
+	}
+	oem.mutex.RUnlock()
+
+	reports := []*DiskUsage{}
+	for _, workspaceID := range workspaceIDs {
+		report, err := oem.GetDiskUsage(workspaceID, false)
+		if err != nil {
+			continue
+		}
+		report.Breakdown = nil
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].TotalSize+reports[i].TrashSize > reports[j].TotalSize+reports[j].TrashSize
+	})
+
+	if limit > 0 && len(reports) > limit {
+		reports = reports[:limit]
+	}
+	return reports
+}
+
+// 启动后台用量扫描，捕获容器内进程的写入并提示超出软配额的工作空间
+func (oem *OnlineEditorManager) StartDiskUsageMonitor() {
+	go func() {
+		ticker := time.NewTicker(diskUsageMaxAge)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			oem.mutex.RLock()
+			workspaceIDs := make([]string, 0, len(oem.workspaces))
+			for workspaceID := range oem.workspaces {
+				workspaceIDs = append(workspaceIDs, workspaceID)
+			}
+			oem.mutex.RUnlock()
+
+			for _, workspaceID := range workspaceIDs {
+				report, err := oem.GetDiskUsage(workspaceID, true)
+				if err != nil {
+					continue
+				}
+				if report.OverHardLimit {
+					log.Printf("[%s] 磁盘用量 %s 已超出硬限制 %s", workspaceID, formatBytes(report.TotalSize), formatBytes(report.Quota.HardLimit))
+				} else if report.OverSoftLimit {
+					log.Printf("[%s] 磁盘用量 %s 已超出软限制 %s", workspaceID, formatBytes(report.TotalSize), formatBytes(report.Quota.SoftLimit))
+				}
+			}
+		}
+	}()
+}
+
+// 根据错误类型选择状态码，超出配额返回507
+func fileWriteErrorStatus(err error) int {
+	if _, ok := err.(*DiskQuotaError); ok {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleGetDiskUsage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	refresh := r.URL.Query().Get("refresh") == "true"
+
+	report, err := oem.GetDiskUsage(workspaceID, refresh)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (oem *OnlineEditorManager) handleSetDiskQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	if _, err := oem.GetWorkspace(workspaceID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var quota *DiskQuota
+	if r.Method == http.MethodPut {
+		quota = &DiskQuota{}
+		if err := json.NewDecoder(r.Body).Decode(quota); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	effective, err := oem.diskUsage.SetQuota(workspaceID, quota)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(effective)
+}
+
+func (oem *OnlineEditorManager) handleListDiskUsage(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oem.ListDiskUsage(limit))
+}
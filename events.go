@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	eventTypes "github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// 工作空间生命周期事件
+//
+// 管理器内部的事件总线，发布状态变化、错误、端口变化、工具安装进度和容器事件，
+// 客户端可以按工作空间或全局通过SSE和WebSocket订阅，不再需要轮询GET /workspaces/{id}。
+// 同时订阅Docker的Events接口，发现在外部停止、删除或内存不足的容器。
+// 最近的事件保存在内存中，断线重连时可以通过Last-Event-ID（或since参数）补发。
+// 订阅者的缓冲区满时断开连接，客户端重连后按Last-Event-ID补发，不会静默丢失事件。
+// 订阅所有工作空间时只推送请求者自己的工作空间的事件，管理员可以看到全部。
+
+const (
+	eventTypeStatus      = "status"
+	eventTypeError       = "error"
+	eventTypePort        = "port"
+	eventTypeToolInstall = "tool_install"
+	eventTypeContainer   = "container"
+	eventTypeSidecar     = "sidecar"
+
+	eventHistorySize      = 500
+	eventSubscriberBuffer = 64
+	eventHeartbeat        = 30 * time.Second
+	expectedEventTTL      = 2 * time.Minute // 服务自身操作容器后忽略对应Docker事件的时长
+)
+
+// 工作空间事件
+type WorkspaceEvent struct {
+	ID          int64       `json:"id"`
+	Type        string      `json:"type"`
+	WorkspaceID string      `json:"workspace_id"`
+	Time        time.Time   `json:"time"`
+	Status      string      `json:"status,omitempty"`
+	Previous    string      `json:"previous_status,omitempty"`
+	Message     string      `json:"message,omitempty"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+type eventSubscriber struct {
+	workspaceID string          // 为空时订阅所有工作空间
+	types       map[string]bool // 为空时订阅所有类型
+	ch          chan WorkspaceEvent
+	dropped     chan struct{} // 缓冲区满时关闭，订阅随之取消
+}
+
+func (s *eventSubscriber) matches(event WorkspaceEvent) bool {
+	if s.workspaceID != "" && s.workspaceID != event.WorkspaceID {
+		return false
+	}
+	return len(s.types) == 0 || s.types[event.Type]
+}
+
+type eventBus struct {
+	nextID      int64
+	history     []WorkspaceEvent
+	subscribers map[*eventSubscriber]struct{}
+	expected    map[string]time.Time // 容器ID/事件 -> 过期时间
+	mutex       sync.Mutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[*eventSubscriber]struct{}),
+		expected:    make(map[string]time.Time),
+	}
+}
+
+// 发布事件。不获取oem.mutex，持有oem.mutex时也可以调用
+func (oem *OnlineEditorManager) publishEvent(event WorkspaceEvent) {
+	bus := oem.events
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.nextID++
+	event.ID = bus.nextID
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.history = append(bus.history, event)
+	if len(bus.history) > eventHistorySize {
+		bus.history = bus.history[len(bus.history)-eventHistorySize:]
+	}
+
+	// 不阻塞发布者，慢订阅者取消订阅，由处理器断开连接
+	for sub := range bus.subscribers {
+		if !sub.matches(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			delete(bus.subscribers, sub)
+			close(sub.dropped)
+		}
+	}
+}
+
+func (oem *OnlineEditorManager) publishWorkspaceError(workspaceID, message string) {
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeError, WorkspaceID: workspaceID, Message: message})
+}
+
+// 更新工作空间状态并发布状态变化事件，调用者必须持有oem.mutex写锁
+func (oem *OnlineEditorManager) setStatusLocked(workspace *Workspace, status, message string) {
+	previous := workspace.Status
+	workspace.Status = status
+	if previous == status {
+		return
+	}
+	oem.publishEvent(WorkspaceEvent{
+		Type:        eventTypeStatus,
+		WorkspaceID: workspace.ID,
+		Status:      status,
+		Previous:    previous,
+		Message:     message,
+	})
+}
+
+// 订阅事件，since大于0时先返回之后的历史事件
+func (oem *OnlineEditorManager) subscribeEvents(workspaceID string, types map[string]bool, since int64) (*eventSubscriber, []WorkspaceEvent) {
+	sub := &eventSubscriber{
+		workspaceID: workspaceID,
+		types:       types,
+		ch:          make(chan WorkspaceEvent, eventSubscriberBuffer),
+		dropped:     make(chan struct{}),
+	}
+
+	bus := oem.events
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	var backlog []WorkspaceEvent
+	if since > 0 {
+		for _, event := range bus.history {
+			if event.ID > since && sub.matches(event) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	bus.subscribers[sub] = struct{}{}
+	return sub, backlog
+}
+
+func (oem *OnlineEditorManager) unsubscribeEvents(sub *eventSubscriber) {
+	oem.events.mutex.Lock()
+	delete(oem.events.subscribers, sub)
+	oem.events.mutex.Unlock()
+}
+
+func expectedEventKey(containerID string, action eventTypes.Action) string {
+	return containerID + "/" + string(action)
+}
+
+// 标记服务自身即将启动、停止或删除容器，对应的Docker事件不视为外部操作
+// 每个标记只匹配一次事件，之后同一容器的同类事件重新按外部操作处理
+func (oem *OnlineEditorManager) expectContainerEvent(containerID string, actions ...eventTypes.Action) {
+	if containerID == "" {
+		return
+	}
+	oem.events.mutex.Lock()
+	deadline := time.Now().Add(expectedEventTTL)
+	for _, action := range actions {
+		oem.events.expected[expectedEventKey(containerID, action)] = deadline
+	}
+	oem.events.mutex.Unlock()
+}
+
+// 操作失败时取消标记，避免之后的外部操作被忽略
+func (oem *OnlineEditorManager) cancelContainerEvent(containerID string, actions ...eventTypes.Action) {
+	oem.events.mutex.Lock()
+	for _, action := range actions {
+		delete(oem.events.expected, expectedEventKey(containerID, action))
+	}
+	oem.events.mutex.Unlock()
+}
+
+// 事件是否由服务自身的操作产生，匹配后消耗标记
+func (oem *OnlineEditorManager) isExpectedContainerEvent(containerID string, action eventTypes.Action) bool {
+	oem.events.mutex.Lock()
+	defer oem.events.mutex.Unlock()
+
+	now := time.Now()
+	for key, deadline := range oem.events.expected {
+		if now.After(deadline) {
+			delete(oem.events.expected, key)
+		}
+	}
+	key := expectedEventKey(containerID, action)
+	_, expected := oem.events.expected[key]
+	delete(oem.events.expected, key)
+	return expected
+}
+
+// Docker事件
+
+// 启动Docker事件监听，连接断开后自动重连
+func (oem *OnlineEditorManager) StartDockerEventWatcher() {
+	go func() {
+		for {
+			ctx, cancel := context.WithCancel(context.Background())
+			messages, errs := oem.dockerClient.Events(ctx, eventTypes.ListOptions{
+				Filters: filters.NewArgs(
+					filters.Arg("type", string(eventTypes.ContainerEventType)),
+					filters.Arg("event", string(eventTypes.ActionStart)),
+					filters.Arg("event", string(eventTypes.ActionDie)),
+					filters.Arg("event", string(eventTypes.ActionOOM)),
+					filters.Arg("event", string(eventTypes.ActionDestroy)),
+					filters.Arg("event", string(eventTypes.ActionHealthStatus)),
+				),
+			})
+
+		receive:
+			for {
+				select {
+				case message := <-messages:
+					oem.handleDockerEvent(message)
+				case err := <-errs:
+					if err != nil {
+						log.Printf("Docker事件监听中断: %v，5秒后重连", err)
+					}
+					break receive
+				}
+			}
+			cancel()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+}
+
+func (oem *OnlineEditorManager) handleDockerEvent(message eventTypes.Message) {
+	containerID := message.Actor.ID
+	attributes := message.Actor.Attributes
+	action := string(message.Action)
+
+	if workspaceID := attributes[sidecarOfLabel]; workspaceID != "" {
+		oem.handleSidecarDockerEvent(workspaceID, containerID, action, attributes)
+		return
+	}
+
+	workspaceID := attributes["name"]
+	if !strings.HasPrefix(workspaceID, "ws_") {
+		return
+	}
+
+	expected := oem.isExpectedContainerEvent(containerID, message.Action)
+	data := map[string]string{"action": action, "container_id": containerID}
+	if exitCode := attributes["exitCode"]; exitCode != "" {
+		data["exit_code"] = exitCode
+	}
+
+	oem.mutex.Lock()
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists || workspace.ContainerID != containerID {
+		oem.mutex.Unlock()
+		return
+	}
+
+	changed := false
+	switch message.Action {
+	case eventTypes.ActionDie:
+		if !expected && workspace.Status == "running" {
+			oem.setStatusLocked(workspace, "exited", fmt.Sprintf("容器意外退出 (退出码: %s)", attributes["exitCode"]))
+			workspace.Started = nil
+			changed = true
+		}
+	case eventTypes.ActionDestroy:
+		if !expected {
+			oem.setStatusLocked(workspace, "failed", "容器已在外部被删除")
+			workspace.Started = nil
+			changed = true
+		}
+	case eventTypes.ActionStart:
+		if !expected && (workspace.Status == "stopped" || workspace.Status == "exited") {
+			oem.setStatusLocked(workspace, "running", "容器在外部启动")
+			now := time.Now()
+			workspace.Started = &now
+			changed = true
+		}
+	}
+	oem.mutex.Unlock()
+
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeContainer, WorkspaceID: workspaceID, Message: action, Data: data})
+	if message.Action == eventTypes.ActionOOM {
+		oem.publishWorkspaceError(workspaceID, "容器内存不足（OOM）")
+	}
+
+	if changed {
+		if message.Action == eventTypes.ActionStart {
+			oem.refreshNetworkIP(workspaceID)
+			oem.mutex.Lock()
+			oem.generateAccessURLs(workspace)
+			oem.mutex.Unlock()
+		}
+		oem.syncPortForwards(workspaceID)
+	}
+}
+
+func (oem *OnlineEditorManager) handleSidecarDockerEvent(workspaceID, containerID, action string, attributes map[string]string) {
+	var name string
+	for _, sidecar := range oem.workspaceSidecars(workspaceID) {
+		if sidecar.ContainerID == containerID {
+			name = sidecar.Name
+			break
+		}
+	}
+	if name == "" {
+		return
+	}
+
+	oem.setSidecarState(workspaceID, name, func(s *SidecarService) {
+		switch {
+		case action == string(eventTypes.ActionStart):
+			s.Status = "running"
+		case action == string(eventTypes.ActionDie):
+			s.Status = "exited"
+			s.Health = ""
+		case strings.HasPrefix(action, string(eventTypes.ActionHealthStatus)+": "):
+			s.Health = strings.TrimPrefix(action, string(eventTypes.ActionHealthStatus)+": ")
+		}
+	})
+
+	data := map[string]string{"name": name, "action": action, "container_id": containerID}
+	if exitCode := attributes["exitCode"]; exitCode != "" {
+		data["exit_code"] = exitCode
+	}
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeSidecar, WorkspaceID: workspaceID, Message: action, Data: data})
+}
+
+// HTTP处理器
+
+// 解析订阅参数：types=status,port；since或Last-Event-ID
+func eventSubscribeOptions(r *http.Request) (map[string]bool, int64) {
+	var types map[string]bool
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[t] = true
+			}
+		}
+	}
+
+	since := r.URL.Query().Get("since")
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		since = lastID
+	}
+	id, _ := strconv.ParseInt(since, 10, 64)
+	return types, id
+}
+
+// 检查订阅的工作空间并返回事件过滤函数，返回false时已写入响应。
+// 订阅所有工作空间需要用户身份，只能收到自己的工作空间的事件（管理员除外）。
+// 工作空间删除后查不到所有者，订阅时已属于该用户的工作空间的事件继续推送
+func (oem *OnlineEditorManager) eventSubscriptionFilter(w http.ResponseWriter, r *http.Request, workspaceID string) (func(WorkspaceEvent) bool, bool) {
+	all := func(WorkspaceEvent) bool { return true }
+	if workspaceID != "" {
+		if _, err := oem.GetWorkspaceStatus(workspaceID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return nil, false
+		}
+		return all, true
+	}
+
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return nil, false
+	}
+	if isAdminUser(userID) {
+		return all, true
+	}
+
+	owned := make(map[string]bool)
+	oem.mutex.RLock()
+	for id, workspace := range oem.workspaces {
+		owned[id] = workspace.Owner == userID
+	}
+	oem.mutex.RUnlock()
+
+	// 只在处理器的goroutine中调用，不需要加锁
+	return func(event WorkspaceEvent) bool {
+		if visible, checked := owned[event.WorkspaceID]; checked {
+			return visible
+		}
+		oem.mutex.RLock()
+		workspace, exists := oem.workspaces[event.WorkspaceID]
+		visible := exists && workspace.Owner == userID
+		oem.mutex.RUnlock()
+		if exists {
+			owned[event.WorkspaceID] = visible
+		}
+		return visible
+	}, true
+}
+
+// GET /events 和 GET /workspaces/{id}/events
+// 以SSE推送事件
+func (oem *OnlineEditorManager) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	visible, ok := oem.eventSubscriptionFilter(w, r, workspaceID)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	types, since := eventSubscribeOptions(r)
+	sub, backlog := oem.subscribeEvents(workspaceID, types, since)
+	defer oem.unsubscribeEvents(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(event WorkspaceEvent) error {
+		if !visible(event) {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, event := range backlog {
+		if err := writeEvent(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-sub.ch:
+			if err := writeEvent(event); err != nil {
+				return
+			}
+		case <-sub.dropped:
+			// 断开后EventSource自动重连并带上Last-Event-ID
+			log.Printf("事件订阅者处理过慢，断开SSE连接")
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// GET /events/ws 和 GET /workspaces/{id}/events/ws
+// 以WebSocket推送事件
+func (oem *OnlineEditorManager) handleEventWebSocket(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	visible, ok := oem.eventSubscriptionFilter(w, r, workspaceID)
+	if !ok {
+		return
+	}
+
+	conn, err := oem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+	pusher := newWebSocketPusher(conn)
+
+	types, since := eventSubscribeOptions(r)
+	sub, backlog := oem.subscribeEvents(workspaceID, types, since)
+	defer oem.unsubscribeEvents(sub)
+
+	send := func(event WorkspaceEvent) error {
+		if !visible(event) {
+			return nil
+		}
+		return pusher.send(event)
+	}
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event := <-sub.ch:
+			if err := send(event); err != nil {
+				return
+			}
+		case <-sub.dropped:
+			// 客户端用最后收到的事件ID作为since重新连接
+			log.Printf("事件订阅者处理过慢，断开WebSocket连接")
+			pusher.close(websocket.CloseTryAgainLater, "事件积压，请使用since参数重新连接")
+			return
+		case <-pusher.gone:
+			pusher.close(websocket.CloseNormalClosure, "")
+			return
+		}
+	}
+}
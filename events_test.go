@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestPublishEventDropsSlowSubscriber(t *testing.T) {
+	oem := &OnlineEditorManager{events: newEventBus()}
+	slow, _ := oem.subscribeEvents("", nil, 0)
+	other, _ := oem.subscribeEvents("ws_2", nil, 0)
+	defer oem.unsubscribeEvents(other)
+
+	for i := 0; i < eventSubscriberBuffer; i++ {
+		oem.publishEvent(WorkspaceEvent{Type: eventTypeStatus, WorkspaceID: "ws_1"})
+	}
+	select {
+	case <-slow.dropped:
+		t.Fatal("缓冲区未满时不应断开")
+	default:
+	}
+
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeStatus, WorkspaceID: "ws_1"})
+	select {
+	case <-slow.dropped:
+	default:
+		t.Fatal("缓冲区满时应断开订阅者")
+	}
+	if _, exists := oem.events.subscribers[slow]; exists {
+		t.Fatal("断开的订阅者应被移除")
+	}
+	// 已移除的订阅者不会再被关闭一次
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeStatus, WorkspaceID: "ws_1"})
+	oem.unsubscribeEvents(slow)
+
+	// 其他订阅者不受影响
+	select {
+	case <-other.dropped:
+		t.Fatal("其他订阅者不应被断开")
+	default:
+	}
+
+	// 重连后从历史中补发断开之后的事件
+	resumed, backlog := oem.subscribeEvents("", nil, eventSubscriberBuffer)
+	defer oem.unsubscribeEvents(resumed)
+	if len(backlog) != 2 || backlog[0].ID != eventSubscriberBuffer+1 {
+		t.Fatalf("补发的事件 = %+v", backlog)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 文件详细信息
+type FileStat struct {
+	FileInfo
+	Mode          string `json:"mode"` // 八进制权限，如 0755
+	UID           int    `json:"uid"`
+	GID           int    `json:"gid"`
+	Owner         string `json:"owner,omitempty"` // 容器内的用户名
+	Group         string `json:"group,omitempty"` // 容器内的组名
+	Inode         uint64 `json:"inode"`
+	Links         uint64 `json:"links"`
+	SymlinkTarget string `json:"symlink_target,omitempty"`
+	// 链接目标在工作空间内的相对路径，目标超出工作空间或不存在时为空
+	SymlinkResolved string `json:"symlink_resolved,omitempty"`
+	MimeType        string `json:"mime_type"`
+}
+
+// 列出文件的选项
+type ListFilesOptions struct {
+	Depth      int    `json:"depth"`       // 递归深度，1表示只列出当前目录，负数表示不限（受maxListDepth约束）
+	ShowHidden bool   `json:"show_hidden"` // 是否显示以.开头的文件
+	SortBy     string `json:"sort_by"`     // name, size, modified, type
+	Order      string `json:"order"`       // asc, desc
+}
+
+// 递归列出文件的最大深度
+const maxListDepth = 10
+
+// 默认列出选项，与原有行为保持一致
+func defaultListFilesOptions() ListFilesOptions {
+	return ListFilesOptions{Depth: 1, ShowHidden: true}
+}
+
+// 读取目录条目并按选项过滤、排序、递归
+func readDirEntries(fullPath, relBase string, opts ListFilesOptions, depth int) ([]FileInfo, error) {
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取目录失败: %v", err)
+	}
+
+	files := []FileInfo{}
+	for _, entry := range entries {
+		if !opts.ShowHidden && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// 构建相对路径
+		relativePath := entry.Name()
+		if relBase != "." {
+			relativePath = filepath.Join(relBase, entry.Name())
+		}
+
+		fileInfo := FileInfo{
+			Name:         entry.Name(),
+			Path:         relativePath,
+			IsDir:        entry.IsDir(),
+			IsSymlink:    info.Mode()&os.ModeSymlink != 0,
+			Size:         info.Size(),
+			ModifiedTime: info.ModTime(),
+			Permissions:  info.Mode().String(),
+		}
+
+		// 只递归真实目录，不跟随符号链接，避免循环
+		if entry.IsDir() && depth > 1 {
+			children, err := readDirEntries(filepath.Join(fullPath, entry.Name()), relativePath, opts, depth-1)
+			if err == nil {
+				fileInfo.Children = children
+			}
+		}
+
+		files = append(files, fileInfo)
+	}
+
+	sortFileInfos(files, opts.SortBy, opts.Order)
+	return files, nil
+}
+
+// 按指定字段排序，目录始终排在文件前面
+func sortFileInfos(files []FileInfo, sortBy, order string) {
+	if sortBy == "" {
+		return
+	}
+
+	less := func(a, b FileInfo) bool {
+		switch sortBy {
+		case "size":
+			return a.Size < b.Size
+		case "modified":
+			return a.ModifiedTime.Before(b.ModifiedTime)
+		case "type":
+			extA, extB := strings.ToLower(filepath.Ext(a.Name)), strings.ToLower(filepath.Ext(b.Name))
+			if extA != extB {
+				return extA < extB
+			}
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		default:
+			return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		if order == "desc" {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+}
+
+// 解析列出文件的查询参数
+func parseListFilesOptions(r *http.Request) ListFilesOptions {
+	opts := defaultListFilesOptions()
+	query := r.URL.Query()
+
+	if depth, err := strconv.Atoi(query.Get("depth")); err == nil {
+		opts.Depth = depth
+	}
+	if hidden := query.Get("hidden"); hidden != "" {
+		opts.ShowHidden = hidden == "true" || hidden == "1"
+	}
+	opts.SortBy = query.Get("sort")
+	opts.Order = query.Get("order")
+
+	return opts
+}
+
+// 获取文件详细信息，符号链接返回链接本身的信息
+func (oem *OnlineEditorManager) StatFile(workspaceID, filePath string) (*FileStat, error) {
+	oem.mutex.RLock()
+	workspaceDir, err := oem.fileAccessDir(workspaceID)
+	oem.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if filePath == "" {
+		filePath = "."
+	}
+
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("获取文件信息失败: %v", err)
+	}
+
+	relPath, _ := filepath.Rel(workspaceDir, fullPath)
+	stat := &FileStat{
+		FileInfo: FileInfo{
+			Name:         info.Name(),
+			Path:         filepath.ToSlash(relPath),
+			IsDir:        info.IsDir(),
+			IsSymlink:    info.Mode()&os.ModeSymlink != 0,
+			Size:         info.Size(),
+			ModifiedTime: info.ModTime(),
+			Permissions:  info.Mode().String(),
+		},
+		Mode: fmt.Sprintf("%04o", unixPermBits(info.Mode())),
+	}
+
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		stat.UID = int(sys.Uid)
+		stat.GID = int(sys.Gid)
+		stat.Inode = uint64(sys.Ino)
+		stat.Links = uint64(sys.Nlink)
+	}
+
+	switch {
+	case stat.IsSymlink:
+		stat.MimeType = "inode/symlink"
+		stat.SymlinkTarget, _ = os.Readlink(fullPath)
+		if resolved, err := oem.resolveWorkspacePath(workspaceID, filePath); err == nil {
+			if _, err := os.Stat(resolved); err == nil {
+				rel, _ := filepath.Rel(workspaceDir, resolved)
+				stat.SymlinkResolved = filepath.ToSlash(rel)
+			}
+		}
+	case info.IsDir():
+		stat.MimeType = "inode/directory"
+	default:
+		stat.MimeType = detectMimeType(fullPath)
+	}
+
+	// 用户名和组名以容器内为准，容器未运行时只返回数字ID
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if idMap, err := oem.containerIDMap(ctx, workspaceID); err == nil {
+		stat.Owner = idMap.userNames[stat.UID]
+		stat.Group = idMap.groupNames[stat.GID]
+	}
+
+	return stat, nil
+}
+
+// 将os.FileMode转换为unix权限位（包含setuid/setgid/sticky）
+func unixPermBits(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// 将unix权限位转换为os.FileMode
+func fileModeFromUnix(bits uint32) os.FileMode {
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// 根据扩展名和文件内容检测MIME类型
+func detectMimeType(fullPath string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(fullPath)); mimeType != "" {
+		return mimeType
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 512)
+	n, _ := io.ReadFull(file, buffer)
+	return http.DetectContentType(buffer[:n])
+}
+
+var (
+	octalModePattern    = regexp.MustCompile(`^[0-7]{3,4}$`)
+	symbolicModePattern = regexp.MustCompile(`^([ugoa]*)([+\-=])([rwx]*)$`)
+)
+
+// 工作空间目录是宿主机上的bind mount，不允许设置setuid/setgid位
+const forbiddenModeBits = 06000
+
+// 解析权限表达式，支持八进制（755、0644）和符号形式（+x、u+rw,go-w）
+// 不允许设置setuid/setgid，已有的setuid/setgid位在修改权限时清除
+func parseFileMode(spec string, current os.FileMode) (os.FileMode, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0, fmt.Errorf("权限不能为空")
+	}
+
+	if octalModePattern.MatchString(spec) {
+		bits, _ := strconv.ParseUint(spec, 8, 32)
+		if bits&forbiddenModeBits != 0 {
+			return 0, fmt.Errorf("不允许设置setuid/setgid权限位: %s", spec)
+		}
+		return fileModeFromUnix(uint32(bits)), nil
+	}
+
+	bits := unixPermBits(current) &^ forbiddenModeBits
+	for _, clause := range strings.Split(spec, ",") {
+		match := symbolicModePattern.FindStringSubmatch(clause)
+		if match == nil {
+			return 0, fmt.Errorf("无效的权限表达式: %s", spec)
+		}
+
+		who := match[1]
+		if who == "" || strings.Contains(who, "a") {
+			who = "ugo"
+		}
+
+		var perm uint32
+		for _, p := range match[3] {
+			switch p {
+			case 'r':
+				perm |= 4
+			case 'w':
+				perm |= 2
+			case 'x':
+				perm |= 1
+			}
+		}
+
+		for _, w := range who {
+			shift := map[rune]uint{'u': 6, 'g': 3, 'o': 0}[w]
+			mask := uint32(7) << shift
+			switch match[2] {
+			case "+":
+				bits |= perm << shift
+			case "-":
+				bits &^= perm << shift
+			case "=":
+				bits = bits&^mask | perm<<shift
+			}
+		}
+	}
+
+	return fileModeFromUnix(bits), nil
+}
+
+// 修改文件权限
+func (oem *OnlineEditorManager) ChmodFile(workspaceID, filePath, modeSpec string, recursive bool) error {
+	oem.mutex.Lock()
+	defer oem.mutex.Unlock()
+
+	if _, err := oem.fileAccessDir(workspaceID); err != nil {
+		return err
+	}
+
+	// chmod总是作用于链接目标，因此目标也必须在工作空间内
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fullPath); err != nil {
+		return fmt.Errorf("文件不存在: %s", filePath)
+	}
+
+	chmodOne := func(path string, info os.FileInfo) error {
+		mode, err := parseFileMode(modeSpec, info.Mode())
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("修改权限失败: %v", err)
+		}
+		return nil
+	}
+
+	if !recursive {
+		info, _ := os.Stat(fullPath)
+		return chmodOne(fullPath, info)
+	}
+
+	return filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// 递归时跳过符号链接，os.Chmod会跟随链接
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return chmodOne(path, info)
+	})
+}
+
+// 容器内用户和组的ID映射
+type containerIDMapping struct {
+	users      map[string]int
+	groups     map[string]int
+	userNames  map[int]string
+	groupNames map[int]string
+}
+
+// 读取容器内的/etc/passwd和/etc/group，构建名称与ID的映射
+func (oem *OnlineEditorManager) containerIDMap(ctx context.Context, workspaceID string) (*containerIDMapping, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	running := exists && workspace.Status == "running"
+	oem.mutex.RUnlock()
+
+	if !running {
+		return nil, fmt.Errorf("工作空间未运行: %s", workspaceID)
+	}
+
+	mapping := &containerIDMapping{
+		users:      make(map[string]int),
+		groups:     make(map[string]int),
+		userNames:  make(map[int]string),
+		groupNames: make(map[int]string),
+	}
+
+	for _, source := range []struct {
+		file   string
+		byName map[string]int
+		byID   map[int]string
+	}{
+		{"/etc/passwd", mapping.users, mapping.userNames},
+		{"/etc/group", mapping.groups, mapping.groupNames},
+	} {
+		result, err := oem.execInWorkspace(ctx, workspaceID, []string{"cat", source.file}, "/", nil)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			continue
+		}
+
+		// 格式: name:x:id:...
+		scanner := bufio.NewScanner(strings.NewReader(result.Stdout))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 3 {
+				continue
+			}
+			id, err := strconv.Atoi(fields[2])
+			if err != nil {
+				continue
+			}
+			source.byName[fields[0]] = id
+			if _, exists := source.byID[id]; !exists {
+				source.byID[id] = fields[0]
+			}
+		}
+	}
+
+	return mapping, nil
+}
+
+// 将容器内的用户名/组名或数字转换为ID，空字符串返回-1表示不修改
+func lookupContainerID(name string, byName map[string]int, kind string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		if id < 0 {
+			return 0, fmt.Errorf("无效的%sID: %s", kind, name)
+		}
+		return id, nil
+	}
+	if byName == nil {
+		return 0, fmt.Errorf("工作空间未运行，无法解析%s名: %s", kind, name)
+	}
+	id, exists := byName[name]
+	if !exists {
+		return 0, fmt.Errorf("容器内不存在%s: %s", kind, name)
+	}
+	return id, nil
+}
+
+// 修改文件所有者，用户和组以容器内的名称或数字ID指定
+// 工作空间目录通过bind mount挂载，容器内的UID/GID与宿主机上的数值一致
+func (oem *OnlineEditorManager) ChownFile(workspaceID, filePath, user, group string, recursive bool) error {
+	if user == "" && group == "" {
+		return fmt.Errorf("用户和组不能同时为空")
+	}
+
+	// 先在不持锁的情况下解析容器内的名称
+	var users, groups map[string]int
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if idMap, err := oem.containerIDMap(ctx, workspaceID); err == nil {
+		users, groups = idMap.users, idMap.groups
+	}
+
+	uid, err := lookupContainerID(user, users, "用户")
+	if err != nil {
+		return err
+	}
+	gid, err := lookupContainerID(group, groups, "组")
+	if err != nil {
+		return err
+	}
+	// 容器内的root对应宿主机的root，不允许把文件交给root
+	if uid == 0 || gid == 0 {
+		return fmt.Errorf("不允许将所有者或组修改为root")
+	}
+
+	oem.mutex.Lock()
+	defer oem.mutex.Unlock()
+
+	if _, err := oem.fileAccessDir(workspaceID); err != nil {
+		return err
+	}
+
+	// 使用Lchown，不跟随符号链接
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, filePath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Lstat(fullPath); err != nil {
+		return fmt.Errorf("文件不存在: %s", filePath)
+	}
+
+	chownOne := func(path string) error {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("修改所有者失败: %v", err)
+		}
+		return nil
+	}
+
+	if !recursive {
+		return chownOne(fullPath)
+	}
+
+	return filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return chownOne(path)
+	})
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleStatFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	path := r.URL.Query().Get("path")
+
+	stat, err := oem.StatFile(workspaceID, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stat)
+}
+
+func (oem *OnlineEditorManager) handleChmodFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		Path      string `json:"path"`
+		Mode      string `json:"mode"`
+		Recursive bool   `json:"recursive"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" || req.Mode == "" {
+		http.Error(w, "缺少文件路径或权限参数", http.StatusBadRequest)
+		return
+	}
+
+	if err := oem.ChmodFile(workspaceID, req.Path, req.Mode, req.Recursive); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (oem *OnlineEditorManager) handleChownFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		Path      string `json:"path"`
+		User      string `json:"user"`
+		Group     string `json:"group"`
+		Recursive bool   `json:"recursive"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" {
+		http.Error(w, "缺少文件路径参数", http.StatusBadRequest)
+		return
+	}
+
+	if err := oem.ChownFile(workspaceID, req.Path, req.User, req.Group, req.Recursive); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
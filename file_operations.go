@@ -0,0 +1,965 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 批量操作和回收站相关的数据结构
+
+// 批量移动条目
+type BatchMoveItem struct {
+	SourcePath string `json:"source_path"`
+	TargetPath string `json:"target_path"`
+}
+
+// 批量操作中单个条目的执行结果
+type BatchItemResult struct {
+	Path    string `json:"path"`
+	Target  string `json:"target,omitempty"`
+	Success bool   `json:"success"`
+	Skipped bool   `json:"skipped,omitempty"`
+	TrashID string `json:"trash_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 回收站条目
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"original_path"`
+	IsDir        bool      `json:"is_dir"`
+	Size         int64     `json:"size"`
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+// 生成回收站条目ID
+func generateTrashID() string {
+	return fmt.Sprintf("trash_%d", time.Now().UnixNano())
+}
+
+// 检查工作空间是否允许文件操作，返回工作空间目录的真实路径（调用者必须持有锁）
+func (oem *OnlineEditorManager) fileAccessDir(workspaceID string) (string, error) {
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		return "", fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+
+	// 只在工作空间明确失败或停止时才禁止访问文件系统
+	if workspace.Status == "failed" || workspace.Status == "stopped" {
+		return "", fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
+	}
+
+	return oem.workspaceRealDir(workspaceID)
+}
+
+// 检查工作空间是否允许文件操作，只在检查时持有读锁。
+// 复制、移动等操作本身不持有oem.mutex，大目录的操作不会阻塞其他工作空间
+func (oem *OnlineEditorManager) fileOperationDir(workspaceID string) (string, error) {
+	oem.mutex.RLock()
+	defer oem.mutex.RUnlock()
+	return oem.fileAccessDir(workspaceID)
+}
+
+// 工作空间回收站目录
+func (oem *OnlineEditorManager) workspaceTrashDir(workspaceID string) string {
+	return filepath.Join(oem.trashDir, workspaceID)
+}
+
+// 根据冲突策略确定最终目标路径
+// 策略: error(默认) / overwrite / skip / rename
+// overwrite时replace为true，原目标在新内容准备好之后才由commitStaged替换
+func resolveConflictTarget(targetFullPath, conflict string) (finalPath string, replace, skipped bool, err error) {
+	if _, err := os.Lstat(targetFullPath); os.IsNotExist(err) {
+		return targetFullPath, false, false, nil
+	}
+
+	switch conflict {
+	case "", "error":
+		return "", false, false, fmt.Errorf("目标路径已存在: %s", filepath.Base(targetFullPath))
+	case "skip":
+		return "", false, true, nil
+	case "overwrite":
+		return targetFullPath, true, false, nil
+	case "rename":
+		dir := filepath.Dir(targetFullPath)
+		base := filepath.Base(targetFullPath)
+		ext := filepath.Ext(base)
+		name := strings.TrimSuffix(base, ext)
+		for i := 1; i < 1000; i++ {
+			candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", name, i, ext))
+			if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+				return candidate, false, false, nil
+			}
+		}
+		return "", false, false, fmt.Errorf("无法为 %s 生成不冲突的名称", base)
+	default:
+		return "", false, false, fmt.Errorf("不支持的冲突策略: %s", conflict)
+	}
+}
+
+// 目标旁边的临时路径。复制、移动、上传先写到这里，成功后再替换目标，
+// 中途失败（如超出配额、磁盘写满）时原目标保持不变
+func stagingPath(finalPath string) string {
+	return filepath.Join(filepath.Dir(finalPath), fmt.Sprintf(".%s.tmp-%d", filepath.Base(finalPath), time.Now().UnixNano()))
+}
+
+// 用暂存内容替换目标：replace为true时先把原目标移入回收站，替换失败时放回原目标。
+// 返回错误时暂存内容仍在staged，由调用者清理或移回
+func (oem *OnlineEditorManager) commitStaged(workspaceID, workspaceDir, staged, finalPath string, replace bool) error {
+	var trashedItem string
+	if replace {
+		trashID, err := oem.trashPath(workspaceID, workspaceDir, finalPath)
+		if err != nil {
+			return fmt.Errorf("覆盖目标失败: %v", err)
+		}
+		trashedItem = filepath.Join(oem.workspaceTrashDir(workspaceID), trashID, "item")
+	}
+
+	if err := os.Rename(staged, finalPath); err != nil {
+		if trashedItem != "" {
+			if restoreErr := movePath(trashedItem, finalPath); restoreErr != nil {
+				log.Printf("[%s] 放回原目标失败，原内容保留在回收站: %v", workspaceID, restoreErr)
+			} else {
+				os.RemoveAll(filepath.Dir(trashedItem))
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// 递归复制文件、目录或符号链接
+func copyPath(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		// 符号链接按原样复制，不跟随
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := copyPath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+}
+
+// 移动路径，跨文件系统时回退为复制后删除
+func movePath(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyPath(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// 计算路径占用大小
+func pathSize(fullPath string) int64 {
+	var size int64
+	filepath.Walk(fullPath, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// 复制文件或文件夹，返回最终的目标相对路径
+func (oem *OnlineEditorManager) CopyFile(workspaceID, sourcePath, targetPath, conflict string) (string, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return "", err
+	}
+
+	result := oem.copyOne(workspaceID, workspaceDir, sourcePath, targetPath, conflict)
+	if result.Error != "" {
+		return "", fmt.Errorf("%s", result.Error)
+	}
+	if result.Skipped {
+		return "", nil
+	}
+	return result.Target, nil
+}
+
+// 复制单个条目
+func (oem *OnlineEditorManager) copyOne(workspaceID, workspaceDir, sourcePath, targetPath, conflict string) BatchItemResult {
+	result := BatchItemResult{Path: sourcePath, Target: targetPath}
+
+	sourceFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, sourcePath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	targetFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, targetPath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if _, err := os.Lstat(sourceFullPath); os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("源文件不存在: %s", sourcePath)
+		return result
+	}
+
+	// 禁止把目录复制到自身内部
+	if isSubPath(sourceFullPath, targetFullPath) {
+		result.Error = "不能将文件夹复制到其自身内部"
+		return result
+	}
+	// 覆盖源路径的上级目录会连同源一起删除
+	if isSubPath(targetFullPath, sourceFullPath) {
+		result.Error = "目标路径不能是源路径的上级目录"
+		return result
+	}
+
+	finalPath, replace, skipped, err := resolveConflictTarget(targetFullPath, conflict)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if skipped {
+		result.Skipped = true
+		result.Success = true
+		return result
+	}
+
+	if err := oem.checkDiskQuota(workspaceID, pathSize(sourceFullPath)); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		result.Error = fmt.Sprintf("创建目标目录失败: %v", err)
+		return result
+	}
+
+	staged := stagingPath(finalPath)
+	err = copyPath(sourceFullPath, staged)
+	if err == nil {
+		err = oem.commitStaged(workspaceID, workspaceDir, staged, finalPath, replace)
+	}
+	oem.markDiskUsageDirty(workspaceID, staged, finalPath)
+	if err != nil {
+		os.RemoveAll(staged)
+		result.Error = fmt.Sprintf("复制文件失败: %v", err)
+		return result
+	}
+
+	result.Target, _ = filepath.Rel(workspaceDir, finalPath)
+	result.Target = filepath.ToSlash(result.Target)
+	result.Success = true
+	return result
+}
+
+// 移动单个条目
+func (oem *OnlineEditorManager) moveOne(workspaceID, workspaceDir, sourcePath, targetPath, conflict string) BatchItemResult {
+	result := BatchItemResult{Path: sourcePath, Target: targetPath}
+
+	sourceFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, sourcePath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	targetFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, targetPath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if sourceFullPath == workspaceDir {
+		result.Error = "不能移动工作空间根目录"
+		return result
+	}
+
+	if _, err := os.Lstat(sourceFullPath); os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("源文件不存在: %s", sourcePath)
+		return result
+	}
+
+	if isSubPath(sourceFullPath, targetFullPath) {
+		result.Error = "不能将文件夹移动到其自身内部"
+		return result
+	}
+	if isSubPath(targetFullPath, sourceFullPath) {
+		result.Error = "目标路径不能是源路径的上级目录"
+		return result
+	}
+
+	finalPath, replace, skipped, err := resolveConflictTarget(targetFullPath, conflict)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if skipped {
+		result.Skipped = true
+		result.Success = true
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		result.Error = fmt.Sprintf("创建目标目录失败: %v", err)
+		return result
+	}
+
+	staged := stagingPath(finalPath)
+	err = movePath(sourceFullPath, staged)
+	if err == nil {
+		if err = oem.commitStaged(workspaceID, workspaceDir, staged, finalPath, replace); err != nil {
+			// 放回源路径
+			if restoreErr := movePath(staged, sourceFullPath); restoreErr != nil {
+				log.Printf("[%s] 移回源文件失败 %s: %v", workspaceID, staged, restoreErr)
+			}
+		}
+	}
+	oem.markDiskUsageDirty(workspaceID, sourceFullPath, staged, finalPath)
+	if err != nil {
+		result.Error = fmt.Sprintf("移动文件失败: %v", err)
+		return result
+	}
+
+	result.Target, _ = filepath.Rel(workspaceDir, finalPath)
+	result.Target = filepath.ToSlash(result.Target)
+	result.Success = true
+	return result
+}
+
+// 删除单个条目，可选择移入回收站
+func (oem *OnlineEditorManager) deleteOne(workspaceID, workspaceDir, filePath string, toTrash bool) BatchItemResult {
+	result := BatchItemResult{Path: filePath}
+
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, filePath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if fullPath == workspaceDir {
+		result.Error = "不能删除工作空间根目录"
+		return result
+	}
+
+	if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+		result.Error = fmt.Sprintf("文件不存在: %s", filePath)
+		return result
+	}
+
+	defer oem.markDiskUsageDirty(workspaceID, fullPath)
+
+	if !toTrash {
+		if err := os.RemoveAll(fullPath); err != nil {
+			result.Error = fmt.Sprintf("删除文件失败: %v", err)
+			return result
+		}
+		result.Success = true
+		return result
+	}
+
+	trashID, err := oem.trashPath(workspaceID, workspaceDir, fullPath)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.TrashID = trashID
+	result.Success = true
+	return result
+}
+
+// 把工作空间内的路径移入回收站：<trash>/<workspaceID>/<trashID>/item + meta.json
+func (oem *OnlineEditorManager) trashPath(workspaceID, workspaceDir, fullPath string) (string, error) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return "", err
+	}
+
+	relPath, _ := filepath.Rel(workspaceDir, fullPath)
+	entry := TrashEntry{
+		ID:           generateTrashID(),
+		OriginalPath: filepath.ToSlash(relPath),
+		IsDir:        info.IsDir(),
+		Size:         pathSize(fullPath),
+		DeletedAt:    time.Now(),
+	}
+
+	entryDir := filepath.Join(oem.workspaceTrashDir(workspaceID), entry.ID)
+	if err := os.MkdirAll(entryDir, 0755); err != nil {
+		return "", fmt.Errorf("创建回收站目录失败: %v", err)
+	}
+
+	metaData, _ := json.MarshalIndent(entry, "", "  ")
+	if err := os.WriteFile(filepath.Join(entryDir, "meta.json"), metaData, 0644); err != nil {
+		os.RemoveAll(entryDir)
+		return "", fmt.Errorf("写入回收站信息失败: %v", err)
+	}
+
+	if err := movePath(fullPath, filepath.Join(entryDir, "item")); err != nil {
+		os.RemoveAll(entryDir)
+		return "", fmt.Errorf("移入回收站失败: %v", err)
+	}
+	return entry.ID, nil
+}
+
+// 将文件或文件夹移入回收站
+func (oem *OnlineEditorManager) TrashFile(workspaceID, filePath string) (string, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return "", err
+	}
+
+	result := oem.deleteOne(workspaceID, workspaceDir, filePath, true)
+	if !result.Success {
+		return "", fmt.Errorf("%s", result.Error)
+	}
+	return result.TrashID, nil
+}
+
+// 批量删除文件，每个条目单独返回结果
+func (oem *OnlineEditorManager) BatchDeleteFiles(workspaceID string, paths []string, toTrash bool) ([]BatchItemResult, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchItemResult, 0, len(paths))
+	for _, p := range paths {
+		results = append(results, oem.deleteOne(workspaceID, workspaceDir, p, toTrash))
+	}
+
+	return results, nil
+}
+
+// 批量移动文件，每个条目单独返回结果
+func (oem *OnlineEditorManager) BatchMoveFiles(workspaceID string, items []BatchMoveItem, conflict string) ([]BatchItemResult, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchItemResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, oem.moveOne(workspaceID, workspaceDir, item.SourcePath, item.TargetPath, conflict))
+	}
+
+	return results, nil
+}
+
+// 批量复制文件，每个条目单独返回结果
+func (oem *OnlineEditorManager) BatchCopyFiles(workspaceID string, items []BatchMoveItem, conflict string) ([]BatchItemResult, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchItemResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, oem.copyOne(workspaceID, workspaceDir, item.SourcePath, item.TargetPath, conflict))
+	}
+
+	return results, nil
+}
+
+// 上传文件到targetDir。上传文件夹时relPaths按顺序给出每个文件相对targetDir的路径
+// （multipart会去掉文件名中的目录部分），为空时使用文件名
+// 所有文件的总大小超出磁盘硬配额时整体拒绝
+func (oem *OnlineEditorManager) UploadFiles(workspaceID, targetDir string, files []*multipart.FileHeader, relPaths []string, conflict string) ([]BatchItemResult, error) {
+	if len(relPaths) > 0 && len(relPaths) != len(files) {
+		return nil, fmt.Errorf("相对路径数量(%d)与文件数量(%d)不一致", len(relPaths), len(files))
+	}
+
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalSize int64
+	for _, header := range files {
+		totalSize += header.Size
+	}
+	if err := oem.checkDiskQuota(workspaceID, totalSize); err != nil {
+		return nil, err
+	}
+
+	if conflict == "" {
+		conflict = "overwrite"
+	}
+
+	results := make([]BatchItemResult, 0, len(files))
+	for i, header := range files {
+		name := filepath.Base(header.Filename)
+		if len(relPaths) > 0 {
+			name = relPaths[i]
+		}
+		results = append(results, oem.uploadOne(workspaceID, workspaceDir, targetDir, name, header, conflict))
+	}
+	return results, nil
+}
+
+// 保存单个上传文件
+func (oem *OnlineEditorManager) uploadOne(workspaceID, workspaceDir, targetDir, name string, header *multipart.FileHeader, conflict string) BatchItemResult {
+	result := BatchItemResult{Path: name}
+
+	// 不用filepath.Join预先清理，".."由secureJoin逐级检查
+	targetFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, targetDir+"/"+filepath.ToSlash(name))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if targetFullPath == workspaceDir {
+		result.Error = errPathOutsideWorkspace.Error()
+		return result
+	}
+
+	finalPath, replace, skipped, err := resolveConflictTarget(targetFullPath, conflict)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if skipped {
+		result.Skipped = true
+		result.Success = true
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		result.Error = fmt.Sprintf("创建目标目录失败: %v", err)
+		return result
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		result.Error = fmt.Sprintf("读取上传文件失败: %v", err)
+		return result
+	}
+	defer src.Close()
+
+	staged := stagingPath(finalPath)
+	dst, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		result.Error = fmt.Sprintf("创建文件失败: %v", err)
+		return result
+	}
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = oem.commitStaged(workspaceID, workspaceDir, staged, finalPath, replace)
+	}
+	oem.markDiskUsageDirty(workspaceID, staged, finalPath)
+	if err != nil {
+		os.Remove(staged)
+		result.Error = fmt.Sprintf("保存上传文件失败: %v", err)
+		return result
+	}
+
+	result.Target, _ = filepath.Rel(workspaceDir, finalPath)
+	result.Target = filepath.ToSlash(result.Target)
+	result.Success = true
+	return result
+}
+
+// 读取回收站条目信息
+func readTrashEntry(entryDir string) (*TrashEntry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// 列出回收站内容，按删除时间倒序
+func (oem *OnlineEditorManager) ListTrash(workspaceID string) ([]TrashEntry, error) {
+	oem.mutex.RLock()
+	defer oem.mutex.RUnlock()
+
+	if _, exists := oem.workspaces[workspaceID]; !exists {
+		return nil, fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+
+	trashDir := oem.workspaceTrashDir(workspaceID)
+	dirEntries, err := os.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取回收站失败: %v", err)
+	}
+
+	entries := []TrashEntry{}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		entry, err := readTrashEntry(filepath.Join(trashDir, dirEntry.Name()))
+		if err != nil {
+			log.Printf("[%s] 跳过损坏的回收站条目 %s: %v", workspaceID, dirEntry.Name(), err)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+
+	return entries, nil
+}
+
+// 从回收站恢复，targetPath为空时恢复到原路径
+func (oem *OnlineEditorManager) RestoreTrash(workspaceID, trashID, targetPath, conflict string) (string, error) {
+	workspaceDir, err := oem.fileOperationDir(workspaceID)
+	if err != nil {
+		return "", err
+	}
+
+	// trashID只能是单级名称
+	if trashID == "" || trashID != filepath.Base(trashID) || strings.HasPrefix(trashID, ".") {
+		return "", fmt.Errorf("无效的回收站条目ID: %s", trashID)
+	}
+
+	entryDir := filepath.Join(oem.workspaceTrashDir(workspaceID), trashID)
+	entry, err := readTrashEntry(entryDir)
+	if err != nil {
+		return "", fmt.Errorf("回收站条目不存在: %s", trashID)
+	}
+
+	if targetPath == "" {
+		targetPath = entry.OriginalPath
+	}
+
+	targetFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, targetPath)
+	if err != nil {
+		return "", err
+	}
+	if targetFullPath == workspaceDir {
+		return "", errPathOutsideWorkspace
+	}
+
+	finalPath, replace, skipped, err := resolveConflictTarget(targetFullPath, conflict)
+	if err != nil {
+		return "", err
+	}
+	if skipped {
+		return "", fmt.Errorf("目标路径已存在: %s", targetPath)
+	}
+
+	if err := oem.checkDiskQuota(workspaceID, entry.Size); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return "", fmt.Errorf("创建目标目录失败: %v", err)
+	}
+
+	item := filepath.Join(entryDir, "item")
+	staged := stagingPath(finalPath)
+	err = movePath(item, staged)
+	if err == nil {
+		if err = oem.commitStaged(workspaceID, workspaceDir, staged, finalPath, replace); err != nil {
+			if restoreErr := movePath(staged, item); restoreErr != nil {
+				log.Printf("[%s] 移回回收站条目失败 %s: %v", workspaceID, trashID, restoreErr)
+			}
+		}
+	}
+	oem.markDiskUsageDirty(workspaceID, staged, finalPath)
+	if err != nil {
+		return "", fmt.Errorf("恢复文件失败: %v", err)
+	}
+
+	if err := os.RemoveAll(entryDir); err != nil {
+		log.Printf("[%s] 清理回收站条目失败 %s: %v", workspaceID, trashID, err)
+	}
+
+	restored, _ := filepath.Rel(workspaceDir, finalPath)
+	return filepath.ToSlash(restored), nil
+}
+
+// 清空回收站，trashID不为空时只永久删除该条目
+func (oem *OnlineEditorManager) PurgeTrash(workspaceID, trashID string) error {
+	oem.mutex.RLock()
+	_, exists := oem.workspaces[workspaceID]
+	oem.mutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+
+	trashDir := oem.workspaceTrashDir(workspaceID)
+	if trashID == "" {
+		if err := os.RemoveAll(trashDir); err != nil {
+			return fmt.Errorf("清空回收站失败: %v", err)
+		}
+		return nil
+	}
+
+	if trashID != filepath.Base(trashID) || strings.HasPrefix(trashID, ".") {
+		return fmt.Errorf("无效的回收站条目ID: %s", trashID)
+	}
+
+	if err := os.RemoveAll(filepath.Join(trashDir, trashID)); err != nil {
+		return fmt.Errorf("删除回收站条目失败: %v", err)
+	}
+	return nil
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleCopyFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		SourcePath string `json:"source_path"`
+		TargetPath string `json:"target_path"`
+		Conflict   string `json:"conflict"` // error, overwrite, skip, rename
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.SourcePath == "" || req.TargetPath == "" {
+		http.Error(w, "缺少源路径或目标路径参数", http.StatusBadRequest)
+		return
+	}
+
+	target, err := oem.CopyFile(workspaceID, req.SourcePath, req.TargetPath, req.Conflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"target":  target,
+		"skipped": target == "",
+	})
+}
+
+func (oem *OnlineEditorManager) handleBatchDeleteFiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		Paths []string `json:"paths"`
+		Trash bool     `json:"trash"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Paths) == 0 {
+		http.Error(w, "缺少文件路径参数", http.StatusBadRequest)
+		return
+	}
+
+	results, err := oem.BatchDeleteFiles(workspaceID, req.Paths, req.Trash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeBatchResults(w, results)
+}
+
+func (oem *OnlineEditorManager) handleBatchMoveFiles(w http.ResponseWriter, r *http.Request) {
+	oem.handleBatchTransfer(w, r, oem.BatchMoveFiles)
+}
+
+func (oem *OnlineEditorManager) handleBatchCopyFiles(w http.ResponseWriter, r *http.Request) {
+	oem.handleBatchTransfer(w, r, oem.BatchCopyFiles)
+}
+
+// 批量移动/复制共用的请求处理
+func (oem *OnlineEditorManager) handleBatchTransfer(w http.ResponseWriter, r *http.Request, transfer func(string, []BatchMoveItem, string) ([]BatchItemResult, error)) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		Items    []BatchMoveItem `json:"items"`
+		Conflict string          `json:"conflict"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Items) == 0 {
+		http.Error(w, "缺少操作条目", http.StatusBadRequest)
+		return
+	}
+
+	results, err := transfer(workspaceID, req.Items, req.Conflict)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeBatchResults(w, results)
+}
+
+func (oem *OnlineEditorManager) handleUploadFiles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "解析上传表单失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["files"]
+	if len(files) == 0 {
+		http.Error(w, "没有上传文件", http.StatusBadRequest)
+		return
+	}
+
+	// 上传文件夹时每个文件对应一个paths字段（如webkitRelativePath）
+	relPaths := r.MultipartForm.Value["paths"]
+	if len(relPaths) > 0 && len(relPaths) != len(files) {
+		http.Error(w, "paths字段数量必须与文件数量一致", http.StatusBadRequest)
+		return
+	}
+
+	results, err := oem.UploadFiles(workspaceID, r.FormValue("path"), files, relPaths, r.FormValue("conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), fileWriteErrorStatus(err))
+		return
+	}
+
+	writeBatchResults(w, results)
+}
+
+// 输出批量操作结果报告
+func writeBatchResults(w http.ResponseWriter, results []BatchItemResult) {
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   succeeded == len(results),
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+func (oem *OnlineEditorManager) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	entries, err := oem.ListTrash(workspaceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (oem *OnlineEditorManager) handleRestoreTrash(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	trashID := vars["trashId"]
+
+	var req struct {
+		TargetPath string `json:"target_path"`
+		Conflict   string `json:"conflict"`
+	}
+
+	// 请求体可选
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	restored, err := oem.RestoreTrash(workspaceID, trashID, req.TargetPath, req.Conflict)
+	if err != nil {
+		http.Error(w, err.Error(), fileWriteErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    restored,
+	})
+}
+
+func (oem *OnlineEditorManager) handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	if err := oem.PurgeTrash(workspaceID, vars["trashId"]); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,1309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 结构化Git API
+//
+// 旧的POST /workspaces/{id}/git直接返回git的原始输出，这里的接口使用机器可读的
+// 输出格式（porcelain v2、自定义--format等）并解析成JSON。所有命令以argv形式
+// 在容器的/workspace中执行，不经过shell。
+
+// Git命令超时时间
+const (
+	gitCommandTimeout = 30 * time.Second
+	gitNetworkTimeout = 5 * time.Minute // fetch等需要访问远程仓库的命令
+)
+
+// 日志分页
+const (
+	defaultGitLogLimit = 50
+	maxGitLogLimit     = 500
+)
+
+// 工作区状态（git status --porcelain=v2）
+type GitStatus struct {
+	Commit   string           `json:"commit"`   // 为空表示尚无提交
+	Branch   string           `json:"branch"`   // 分离HEAD时为空
+	Detached bool             `json:"detached"` // 是否处于分离HEAD
+	Upstream string           `json:"upstream,omitempty"`
+	Ahead    int              `json:"ahead"`
+	Behind   int              `json:"behind"`
+	Clean    bool             `json:"clean"`
+	Entries  []GitStatusEntry `json:"entries"`
+}
+
+type GitStatusEntry struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"orig_path,omitempty"` // 重命名或复制前的路径
+	Kind     string `json:"kind"`                // changed, renamed, unmerged, untracked, ignored
+	Staged   string `json:"staged"`              // 暂存区状态码（M/A/D/R/C/U，"."表示无变化）
+	Unstaged string `json:"unstaged"`            // 工作区状态码
+}
+
+// 提交记录
+type GitCommit struct {
+	Hash           string    `json:"hash"`
+	Parents        []string  `json:"parents"`
+	AuthorName     string    `json:"author_name"`
+	AuthorEmail    string    `json:"author_email"`
+	AuthorDate     time.Time `json:"author_date"`
+	CommitterName  string    `json:"committer_name"`
+	CommitterEmail string    `json:"committer_email"`
+	CommitterDate  time.Time `json:"committer_date"`
+	Subject        string    `json:"subject"`
+	Body           string    `json:"body,omitempty"`
+}
+
+type GitLogOptions struct {
+	Ref   string // 起始引用，默认HEAD
+	Path  string // 只列出涉及该路径的提交
+	Skip  int
+	Limit int
+}
+
+type GitLog struct {
+	Commits []GitCommit `json:"commits"`
+	Skip    int         `json:"skip"`
+	Limit   int         `json:"limit"`
+	HasMore bool        `json:"has_more"`
+}
+
+// 分支
+type GitBranch struct {
+	Name       string    `json:"name"`
+	FullName   string    `json:"full_name"`
+	Remote     bool      `json:"remote"`
+	Current    bool      `json:"current"`
+	Commit     string    `json:"commit"`
+	Subject    string    `json:"subject"`
+	CommitDate time.Time `json:"commit_date"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Ahead      int       `json:"ahead"`
+	Behind     int       `json:"behind"`
+	Gone       bool      `json:"gone,omitempty"` // 上游分支已被删除
+}
+
+// 差异
+type GitFileDiff struct {
+	OldPath string        `json:"old_path"`
+	NewPath string        `json:"new_path"`
+	Status  string        `json:"status"` // added, deleted, modified, renamed, copied
+	Binary  bool          `json:"binary"`
+	Hunks   []GitDiffHunk `json:"hunks"`
+}
+
+type GitDiffHunk struct {
+	Header   string        `json:"header"` // @@行之后的函数上下文
+	OldStart int           `json:"old_start"`
+	OldLines int           `json:"old_lines"`
+	NewStart int           `json:"new_start"`
+	NewLines int           `json:"new_lines"`
+	Lines    []GitDiffLine `json:"lines"`
+}
+
+type GitDiffLine struct {
+	Type      string `json:"type"` // context, add, delete
+	Content   string `json:"content"`
+	OldLine   int    `json:"old_line,omitempty"`
+	NewLine   int    `json:"new_line,omitempty"`
+	NoNewline bool   `json:"no_newline,omitempty"` // 文件末尾没有换行
+}
+
+// 贮藏
+type GitStash struct {
+	Index   int       `json:"index"`
+	Ref     string    `json:"ref"` // stash@{n}
+	Commit  string    `json:"commit"`
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+}
+
+// 标签
+type GitTag struct {
+	Name      string    `json:"name"`
+	Commit    string    `json:"commit"` // 标签指向的提交
+	Annotated bool      `json:"annotated"`
+	Message   string    `json:"message,omitempty"`
+	Date      time.Time `json:"date"`
+}
+
+// 远程仓库
+type GitRemote struct {
+	Name     string `json:"name"`
+	FetchURL string `json:"fetch_url"`
+	PushURL  string `json:"push_url"`
+}
+
+// 逐行追溯
+type GitBlame struct {
+	Path    string                    `json:"path"`
+	Commits map[string]GitBlameCommit `json:"commits"`
+	Lines   []GitBlameLine            `json:"lines"`
+}
+
+type GitBlameCommit struct {
+	Hash        string    `json:"hash"`
+	AuthorName  string    `json:"author_name"`
+	AuthorEmail string    `json:"author_email"`
+	AuthorDate  time.Time `json:"author_date"`
+	Summary     string    `json:"summary"`
+	Filename    string    `json:"filename"` // 该提交中文件的路径（可能因重命名而不同）
+}
+
+type GitBlameLine struct {
+	Line     int    `json:"line"`
+	Commit   string `json:"commit"`
+	OrigLine int    `json:"orig_line"`
+	Content  string `json:"content"`
+}
+
+// merge、rebase、stash pop等可能产生冲突的命令结果
+type GitCommandResult struct {
+	Success   bool     `json:"success"`
+	Output    string   `json:"output"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Git命令执行失败
+type GitCommandError struct {
+	Args     []string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *GitCommandError) Error() string {
+	message := strings.TrimSpace(e.Stderr)
+	if message == "" {
+		message = fmt.Sprintf("退出码 %d", e.ExitCode)
+	}
+	return fmt.Sprintf("git %s 执行失败: %s", e.Args[0], message)
+}
+
+// 在工作空间内执行git命令，退出码非0时返回GitCommandError
+// auth不为nil时注入访问远程仓库的凭据
+func (oem *OnlineEditorManager) runGit(ctx context.Context, workspaceID string, auth *gitAuth, args ...string) (*ExecResult, error) {
+	status, err := oem.GetWorkspaceStatus(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if status != "running" {
+		return nil, fmt.Errorf("工作空间未运行: %s", workspaceID)
+	}
+
+	// 固定输出格式，禁止交互式提示和编辑器
+	cmd := append([]string{"git", "-c", "core.quotepath=false", "-c", "color.ui=false"}, args...)
+	env := append([]string{"GIT_TERMINAL_PROMPT=0", "GIT_EDITOR=true", "GIT_PAGER=cat", "LC_ALL=C", gitAllowProtocolEnv()}, auth.environment()...)
+
+	result, err := oem.execInWorkspace(ctx, workspaceID, auth.command(cmd), containerWorkspaceRoot, env)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return result, &GitCommandError{Args: args, ExitCode: result.ExitCode, Stderr: result.Stderr}
+	}
+	return result, nil
+}
+
+// 执行本地git命令（带默认超时）
+func (oem *OnlineEditorManager) gitOutput(workspaceID string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+
+	result, err := oem.runGit(ctx, workspaceID, nil, args...)
+	if err != nil {
+		return "", err
+	}
+	return result.Stdout, nil
+}
+
+// 执行可能产生冲突的git命令，冲突不视为错误而是返回冲突文件列表
+func (oem *OnlineEditorManager) gitConflictingCommand(workspaceID string, args ...string) (*GitCommandResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+
+	result, err := oem.runGit(ctx, workspaceID, nil, args...)
+	if err == nil {
+		return &GitCommandResult{Success: true, Output: result.Stdout + result.Stderr}, nil
+	}
+	if _, ok := err.(*GitCommandError); !ok {
+		return nil, err
+	}
+
+	conflicts, conflictErr := oem.gitConflictedFiles(workspaceID)
+	if conflictErr != nil || len(conflicts) == 0 {
+		return nil, err
+	}
+	return &GitCommandResult{Output: result.Stdout + result.Stderr, Conflicts: conflicts}, nil
+}
+
+// 列出存在冲突的文件
+func (oem *OnlineEditorManager) gitConflictedFiles(workspaceID string) ([]string, error) {
+	output, err := oem.gitOutput(workspaceID, "diff", "--name-only", "--diff-filter=U", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return splitNul(output), nil
+}
+
+// 按NUL分割输出，忽略空字段
+func splitNul(output string) []string {
+	fields := []string{}
+	for _, field := range strings.Split(output, "\x00") {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// 把客户端传入的路径转换为相对/workspace的pathspec，接受相对路径或/workspace下的绝对路径
+func gitPathspec(p string) string {
+	if p == containerWorkspaceRoot || strings.HasPrefix(p, containerWorkspaceRoot+"/") {
+		p = strings.TrimPrefix(p, containerWorkspaceRoot)
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// 解析ISO 8601时间，失败时返回零值
+func parseGitTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	return t
+}
+
+// 状态
+
+func (oem *OnlineEditorManager) GitStatusInfo(workspaceID string) (*GitStatus, error) {
+	output, err := oem.gitOutput(workspaceID, "status", "--porcelain=v2", "--branch", "--untracked-files=all", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatus(output), nil
+}
+
+// 解析git status --porcelain=v2 --branch -z的输出
+func parseGitStatus(output string) *GitStatus {
+	status := &GitStatus{Entries: []GitStatusEntry{}}
+	records := strings.Split(output, "\x00")
+
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			continue
+		}
+
+		switch record[0] {
+		case '#':
+			fields := strings.Fields(record)
+			if len(fields) < 3 {
+				continue
+			}
+			switch fields[1] {
+			case "branch.oid":
+				if fields[2] != "(initial)" {
+					status.Commit = fields[2]
+				}
+			case "branch.head":
+				if fields[2] == "(detached)" {
+					status.Detached = true
+				} else {
+					status.Branch = fields[2]
+				}
+			case "branch.upstream":
+				status.Upstream = fields[2]
+			case "branch.ab":
+				if len(fields) >= 4 {
+					status.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+					status.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			fields := strings.SplitN(record, " ", 9)
+			if len(fields) == 9 {
+				status.Entries = append(status.Entries, newGitStatusEntry("changed", fields[1], fields[8]))
+			}
+
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path，原路径在下一个NUL字段
+			fields := strings.SplitN(record, " ", 10)
+			if len(fields) == 10 {
+				entry := newGitStatusEntry("renamed", fields[1], fields[9])
+				if i+1 < len(records) {
+					i++
+					entry.OrigPath = records[i]
+				}
+				status.Entries = append(status.Entries, entry)
+			}
+
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			fields := strings.SplitN(record, " ", 11)
+			if len(fields) == 11 {
+				status.Entries = append(status.Entries, newGitStatusEntry("unmerged", fields[1], fields[10]))
+			}
+
+		case '?':
+			status.Entries = append(status.Entries, GitStatusEntry{Path: record[2:], Kind: "untracked", Staged: ".", Unstaged: "?"})
+
+		case '!':
+			status.Entries = append(status.Entries, GitStatusEntry{Path: record[2:], Kind: "ignored", Staged: ".", Unstaged: "!"})
+		}
+	}
+
+	status.Clean = len(status.Entries) == 0
+	return status
+}
+
+func newGitStatusEntry(kind, xy, filePath string) GitStatusEntry {
+	entry := GitStatusEntry{Path: filePath, Kind: kind, Staged: ".", Unstaged: "."}
+	if len(xy) == 2 {
+		entry.Staged = xy[:1]
+		entry.Unstaged = xy[1:]
+	}
+	return entry
+}
+
+// 日志
+
+// 提交记录的字段以NUL分隔、记录以0x1e分隔（提交说明中可能包含换行）
+const gitLogFormat = "%H%x00%P%x00%an%x00%ae%x00%aI%x00%cn%x00%ce%x00%cI%x00%s%x00%b%x1e"
+
+func (oem *OnlineEditorManager) GitLog(workspaceID string, options GitLogOptions) (*GitLog, error) {
+	if options.Limit <= 0 {
+		options.Limit = defaultGitLogLimit
+	}
+	if options.Limit > maxGitLogLimit {
+		options.Limit = maxGitLogLimit
+	}
+	if options.Skip < 0 {
+		options.Skip = 0
+	}
+
+	// 多取一条用于判断是否还有下一页
+	args := []string{"log", "--format=" + gitLogFormat,
+		"--skip=" + strconv.Itoa(options.Skip), "--max-count=" + strconv.Itoa(options.Limit+1)}
+	if options.Ref != "" {
+		if err := validateGitRevision(options.Ref); err != nil {
+			return nil, err
+		}
+		args = append(args, options.Ref)
+	}
+	args = append(args, "--")
+	if options.Path != "" {
+		args = append(args, gitPathspec(options.Path))
+	}
+
+	output, err := oem.gitOutput(workspaceID, args...)
+	if err != nil {
+		// 尚无任何提交的仓库返回空日志
+		if gitErr, ok := err.(*GitCommandError); ok && strings.Contains(gitErr.Stderr, "does not have any commits") {
+			return &GitLog{Commits: []GitCommit{}, Skip: options.Skip, Limit: options.Limit}, nil
+		}
+		return nil, err
+	}
+
+	commits := parseGitLog(output)
+	result := &GitLog{Commits: commits, Skip: options.Skip, Limit: options.Limit}
+	if len(commits) > options.Limit {
+		result.Commits = commits[:options.Limit]
+		result.HasMore = true
+	}
+	return result, nil
+}
+
+func parseGitLog(output string) []GitCommit {
+	commits := []GitCommit{}
+	for _, record := range strings.Split(output, "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		fields := strings.Split(record, "\x00")
+		if len(fields) < 10 {
+			continue
+		}
+
+		commits = append(commits, GitCommit{
+			Hash:           fields[0],
+			Parents:        strings.Fields(fields[1]),
+			AuthorName:     fields[2],
+			AuthorEmail:    fields[3],
+			AuthorDate:     parseGitTime(fields[4]),
+			CommitterName:  fields[5],
+			CommitterEmail: fields[6],
+			CommitterDate:  parseGitTime(fields[7]),
+			Subject:        fields[8],
+			Body:           strings.TrimSpace(fields[9]),
+		})
+	}
+	return commits
+}
+
+// 分支
+
+const gitBranchFormat = "%(refname)%00%(refname:short)%00%(objectname)%00%(HEAD)%00%(upstream:short)%00%(upstream:track,nobracket)%00%(committerdate:iso8601-strict)%00%(subject)%00%(symref)"
+
+func (oem *OnlineEditorManager) GitBranches(workspaceID string) ([]GitBranch, error) {
+	output, err := oem.gitOutput(workspaceID, "for-each-ref", "--format="+gitBranchFormat, "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	return parseGitBranches(output), nil
+}
+
+func parseGitBranches(output string) []GitBranch {
+	branches := []GitBranch{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) < 9 {
+			continue
+		}
+		// 跳过origin/HEAD这类符号引用
+		if fields[8] != "" {
+			continue
+		}
+
+		branch := GitBranch{
+			FullName:   fields[0],
+			Name:       fields[1],
+			Remote:     strings.HasPrefix(fields[0], "refs/remotes/"),
+			Commit:     fields[2],
+			Current:    fields[3] == "*",
+			Upstream:   fields[4],
+			CommitDate: parseGitTime(fields[6]),
+			Subject:    fields[7],
+		}
+		branch.Ahead, branch.Behind, branch.Gone = parseGitTrack(fields[5])
+		branches = append(branches, branch)
+	}
+	return branches
+}
+
+// 解析%(upstream:track,nobracket)：如"ahead 1, behind 2"或"gone"
+func parseGitTrack(track string) (ahead, behind int, gone bool) {
+	if track == "gone" {
+		return 0, 0, true
+	}
+	for _, part := range strings.Split(track, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			continue
+		}
+		count, _ := strconv.Atoi(fields[1])
+		switch fields[0] {
+		case "ahead":
+			ahead = count
+		case "behind":
+			behind = count
+		}
+	}
+	return ahead, behind, false
+}
+
+// 差异
+
+// staged为true时比较暂存区与HEAD，否则比较工作区与暂存区；filePath为空时返回全部文件
+func (oem *OnlineEditorManager) GitDiff(workspaceID, filePath string, staged bool, contextLines int) ([]GitFileDiff, error) {
+	if contextLines < 0 {
+		contextLines = 3
+	}
+
+	args := []string{"diff", "--no-ext-diff", "--find-renames", "-U" + strconv.Itoa(contextLines)}
+	if staged {
+		args = append(args, "--cached")
+	}
+	args = append(args, "--")
+	if filePath != "" {
+		args = append(args, gitPathspec(filePath))
+	}
+
+	output, err := oem.gitOutput(workspaceID, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitDiff(output), nil
+}
+
+// 解析统一格式的diff输出
+func parseGitDiff(output string) []GitFileDiff {
+	files := []GitFileDiff{}
+	var file *GitFileDiff
+	var hunk *GitDiffHunk
+	oldLine, newLine := 0, 0
+
+	flushFile := func() {
+		if file != nil {
+			files = append(files, *file)
+		}
+		file, hunk = nil, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flushFile()
+			file = &GitFileDiff{Status: "modified", Hunks: []GitDiffHunk{}}
+			// "a/x b/x"：路径含空格时无法可靠拆分，只处理两侧相同的情况，其余由后续行补全
+			names := strings.TrimPrefix(line, "diff --git ")
+			if half := len(names) / 2; len(names)%2 == 1 && names[half] == ' ' && names[2:half] == names[half+3:] {
+				file.OldPath = names[2:half]
+				file.NewPath = file.OldPath
+			}
+
+		case file == nil:
+			continue
+
+		case hunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")):
+			diffLine := GitDiffLine{Content: line[1:]}
+			switch line[0] {
+			case ' ':
+				diffLine.Type = "context"
+				diffLine.OldLine, diffLine.NewLine = oldLine, newLine
+				oldLine++
+				newLine++
+			case '+':
+				diffLine.Type = "add"
+				diffLine.NewLine = newLine
+				newLine++
+			case '-':
+				diffLine.Type = "delete"
+				diffLine.OldLine = oldLine
+				oldLine++
+			}
+			hunk.Lines = append(hunk.Lines, diffLine)
+
+		case strings.HasPrefix(line, `\`):
+			if hunk != nil && len(hunk.Lines) > 0 {
+				hunk.Lines[len(hunk.Lines)-1].NoNewline = true
+			}
+
+		case strings.HasPrefix(line, "@@ "):
+			h := GitDiffHunk{Lines: []GitDiffLine{}}
+			var ranges string
+			if end := strings.Index(line[3:], " @@"); end >= 0 {
+				ranges = line[3 : 3+end]
+				h.Header = strings.TrimSpace(line[3+end+3:])
+			}
+			for _, r := range strings.Fields(ranges) {
+				start, count := parseGitHunkRange(r[1:])
+				if r[0] == '-' {
+					h.OldStart, h.OldLines = start, count
+				} else {
+					h.NewStart, h.NewLines = start, count
+				}
+			}
+			file.Hunks = append(file.Hunks, h)
+			hunk = &file.Hunks[len(file.Hunks)-1]
+			oldLine, newLine = h.OldStart, h.NewStart
+
+		case strings.HasPrefix(line, "new file mode"):
+			file.Status = "added"
+		case strings.HasPrefix(line, "deleted file mode"):
+			file.Status = "deleted"
+		case strings.HasPrefix(line, "rename from "):
+			file.Status = "renamed"
+			file.OldPath = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			file.NewPath = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "copy from "):
+			file.Status = "copied"
+			file.OldPath = strings.TrimPrefix(line, "copy from ")
+		case strings.HasPrefix(line, "copy to "):
+			file.NewPath = strings.TrimPrefix(line, "copy to ")
+		case strings.HasPrefix(line, "Binary files "):
+			file.Binary = true
+		case strings.HasPrefix(line, "--- "):
+			if name := parseGitDiffName(line[4:]); name != "" {
+				file.OldPath = name
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if name := parseGitDiffName(line[4:]); name != "" {
+				file.NewPath = name
+			}
+		}
+
+		// hunk内容结束于下一个非内容行
+		if hunk != nil && !strings.HasPrefix(line, "@@ ") && line != "" && !strings.ContainsRune(" +-\\", rune(line[0])) {
+			hunk = nil
+		}
+	}
+	flushFile()
+
+	// 新增、删除的文件只有一侧路径
+	for i := range files {
+		if files[i].OldPath == "" {
+			files[i].OldPath = files[i].NewPath
+		}
+		if files[i].NewPath == "" {
+			files[i].NewPath = files[i].OldPath
+		}
+	}
+	return files
+}
+
+// 解析"start,count"（count省略时为1）
+func parseGitHunkRange(r string) (start, count int) {
+	count = 1
+	parts := strings.SplitN(r, ",", 2)
+	start, _ = strconv.Atoi(parts[0])
+	if len(parts) == 2 {
+		count, _ = strconv.Atoi(parts[1])
+	}
+	return start, count
+}
+
+// 解析---/+++行的文件名，/dev/null返回空；含空格的文件名后git会追加一个制表符
+func parseGitDiffName(name string) string {
+	name = strings.TrimSuffix(name, "\t")
+	if name == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		return name[2:]
+	}
+	return name
+}
+
+// 远程同步与合并
+
+func (oem *OnlineEditorManager) GitFetch(workspaceID, remote string, prune bool, authRequest GitAuthRequest) (string, error) {
+	if remote != "" {
+		if err := validateGitRevision(remote); err != nil {
+			return "", err
+		}
+	}
+
+	auth, err := oem.gitAuthForRemote(workspaceID, remote, authRequest)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"fetch"}
+	if prune {
+		args = append(args, "--prune")
+	}
+	if remote == "" {
+		args = append(args, "--all")
+	} else {
+		args = append(args, "--", remote)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitNetworkTimeout)
+	defer cancel()
+
+	result, err := oem.runGit(ctx, workspaceID, auth, args...)
+	if err != nil {
+		return "", err
+	}
+	// fetch的进度信息输出在stderr
+	return result.Stdout + result.Stderr, nil
+}
+
+func (oem *OnlineEditorManager) GitMerge(workspaceID, ref, message string, noFastForward, squash bool) (*GitCommandResult, error) {
+	if ref == "" {
+		return nil, invalidGitInput("缺少要合并的分支")
+	}
+	if err := validateGitRevision(ref); err != nil {
+		return nil, err
+	}
+
+	args := []string{"merge", "--no-edit"}
+	if noFastForward {
+		args = append(args, "--no-ff")
+	}
+	if squash {
+		args = append(args, "--squash")
+	}
+	if message != "" {
+		args = append(args, "-m", message)
+	}
+	args = append(args, ref)
+
+	return oem.gitConflictingCommand(workspaceID, args...)
+}
+
+func (oem *OnlineEditorManager) GitRebase(workspaceID, upstream, onto string) (*GitCommandResult, error) {
+	if upstream == "" {
+		return nil, invalidGitInput("缺少变基的目标分支")
+	}
+	if err := validateGitRevision(upstream); err != nil {
+		return nil, err
+	}
+	if onto != "" {
+		if err := validateGitRevision(onto); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []string{"rebase"}
+	if onto != "" {
+		args = append(args, "--onto", onto)
+	}
+	args = append(args, upstream)
+
+	return oem.gitConflictingCommand(workspaceID, args...)
+}
+
+// 贮藏
+
+func (oem *OnlineEditorManager) GitStashList(workspaceID string) ([]GitStash, error) {
+	output, err := oem.gitOutput(workspaceID, "stash", "list", "--format=%gd%x00%H%x00%gs%x00%cI")
+	if err != nil {
+		return nil, err
+	}
+
+	stashes := []GitStash{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) < 4 {
+			continue
+		}
+		index, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(fields[0], "stash@{"), "}"))
+		stashes = append(stashes, GitStash{
+			Index:   index,
+			Ref:     fields[0],
+			Commit:  fields[1],
+			Message: fields[2],
+			Date:    parseGitTime(fields[3]),
+		})
+	}
+	return stashes, nil
+}
+
+// action: push, apply, pop, drop
+func (oem *OnlineEditorManager) GitStash(workspaceID, action, message string, index int, includeUntracked bool) (*GitCommandResult, error) {
+	ref := fmt.Sprintf("stash@{%d}", index)
+
+	switch action {
+	case "push", "":
+		args := []string{"stash", "push"}
+		if includeUntracked {
+			args = append(args, "--include-untracked")
+		}
+		if message != "" {
+			args = append(args, "-m", message)
+		}
+		return oem.gitConflictingCommand(workspaceID, args...)
+	case "apply", "pop", "drop":
+		if index < 0 {
+			return nil, invalidGitInput("无效的贮藏序号: %d", index)
+		}
+		return oem.gitConflictingCommand(workspaceID, "stash", action, ref)
+	default:
+		return nil, invalidGitInput("不支持的贮藏操作: %s", action)
+	}
+}
+
+// 标签
+
+func (oem *OnlineEditorManager) GitTags(workspaceID string) ([]GitTag, error) {
+	// 附注标签的%(*objectname)为其指向的提交，轻量标签为空
+	format := "%(refname:short)%00%(objectname)%00%(*objectname)%00%(contents:subject)%00%(creatordate:iso8601-strict)"
+	output, err := oem.gitOutput(workspaceID, "for-each-ref", "--sort=-creatordate", "--format="+format, "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []GitTag{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) < 5 {
+			continue
+		}
+		tag := GitTag{Name: fields[0], Commit: fields[1], Date: parseGitTime(fields[4])}
+		if fields[2] != "" {
+			tag.Commit = fields[2]
+			tag.Annotated = true
+			tag.Message = fields[3]
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// message不为空时创建附注标签
+func (oem *OnlineEditorManager) GitCreateTag(workspaceID, name, ref, message string) error {
+	if err := oem.validateGitRefName(workspaceID, "tag", name); err != nil {
+		return err
+	}
+	if ref != "" {
+		if err := validateGitRevision(ref); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"tag"}
+	if message != "" {
+		args = append(args, "-a", "-m", message)
+	}
+	args = append(args, "--", name)
+	if ref != "" {
+		args = append(args, ref)
+	}
+
+	_, err := oem.gitOutput(workspaceID, args...)
+	return err
+}
+
+func (oem *OnlineEditorManager) GitDeleteTag(workspaceID, name string) error {
+	_, err := oem.gitOutput(workspaceID, "tag", "-d", "--", name)
+	return err
+}
+
+// 重置
+
+// paths不为空时只把这些文件从暂存区撤出（mode被忽略），否则把当前分支重置到ref
+func (oem *OnlineEditorManager) GitReset(workspaceID, mode, ref string, paths []string) error {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := validateGitRevision(ref); err != nil {
+		return err
+	}
+
+	var args []string
+	if len(paths) > 0 {
+		args = []string{"reset", "-q", ref, "--"}
+		for _, p := range paths {
+			args = append(args, gitPathspec(p))
+		}
+	} else {
+		if mode == "" {
+			mode = "mixed"
+		}
+		if mode != "soft" && mode != "mixed" && mode != "hard" {
+			return invalidGitInput("不支持的重置模式: %s", mode)
+		}
+		args = []string{"reset", "-q", "--" + mode, ref, "--"}
+	}
+
+	_, err := oem.gitOutput(workspaceID, args...)
+	return err
+}
+
+// 远程仓库管理
+
+func (oem *OnlineEditorManager) GitRemotes(workspaceID string) ([]GitRemote, error) {
+	output, err := oem.gitOutput(workspaceID, "remote", "-v")
+	if err != nil {
+		return nil, err
+	}
+
+	// 每行格式："name\turl (fetch)"或"name\turl (push)"
+	remotes := []GitRemote{}
+	index := map[string]int{}
+	for _, line := range strings.Split(output, "\n") {
+		name, rest, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+		url, kind, _ := strings.Cut(rest, " ")
+
+		i, exists := index[name]
+		if !exists {
+			i = len(remotes)
+			index[name] = i
+			remotes = append(remotes, GitRemote{Name: name})
+		}
+		if kind == "(push)" {
+			remotes[i].PushURL = url
+		} else {
+			remotes[i].FetchURL = url
+		}
+	}
+	return remotes, nil
+}
+
+func (oem *OnlineEditorManager) GitAddRemote(workspaceID, name, url string) error {
+	if err := validateGitURL(url); err != nil {
+		return err
+	}
+	if err := oem.validateGitRefName(workspaceID, "remote", name); err != nil {
+		return err
+	}
+	_, err := oem.gitOutput(workspaceID, "remote", "add", "--", name, url)
+	return err
+}
+
+func (oem *OnlineEditorManager) GitSetRemoteURL(workspaceID, name, url string) error {
+	if err := validateGitURL(url); err != nil {
+		return err
+	}
+	_, err := oem.gitOutput(workspaceID, "remote", "set-url", "--", name, url)
+	return err
+}
+
+func (oem *OnlineEditorManager) GitRemoveRemote(workspaceID, name string) error {
+	_, err := oem.gitOutput(workspaceID, "remote", "remove", "--", name)
+	return err
+}
+
+// 逐行追溯
+
+func (oem *OnlineEditorManager) GitBlame(workspaceID, filePath, ref string) (*GitBlame, error) {
+	if filePath == "" {
+		return nil, invalidGitInput("缺少文件路径参数")
+	}
+
+	args := []string{"blame", "--porcelain"}
+	if ref != "" {
+		if err := validateGitRevision(ref); err != nil {
+			return nil, err
+		}
+		args = append(args, ref)
+	}
+	args = append(args, "--", gitPathspec(filePath))
+
+	output, err := oem.gitOutput(workspaceID, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	blame := parseGitBlame(output)
+	blame.Path = gitPathspec(filePath)
+	return blame, nil
+}
+
+// 解析git blame --porcelain：每行以"<hash> <原行号> <行号> [<行数>]"开头，
+// 提交第一次出现时跟随作者等信息，最后是以制表符开头的行内容
+func parseGitBlame(output string) *GitBlame {
+	blame := &GitBlame{Commits: map[string]GitBlameCommit{}, Lines: []GitBlameLine{}}
+	var current *GitBlameLine
+	var commit GitBlameCommit
+	var authorTime int64
+	var authorTZ string
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if current != nil {
+				current.Content = line[1:]
+				if _, exists := blame.Commits[commit.Hash]; !exists {
+					commit.AuthorDate = time.Unix(authorTime, 0).In(parseGitTimezone(authorTZ))
+					blame.Commits[commit.Hash] = commit
+				}
+				blame.Lines = append(blame.Lines, *current)
+				current = nil
+			}
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if current == nil {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || len(fields[0]) < 40 {
+				continue
+			}
+			origLine, _ := strconv.Atoi(fields[1])
+			finalLine, _ := strconv.Atoi(fields[2])
+			current = &GitBlameLine{Line: finalLine, Commit: fields[0], OrigLine: origLine}
+			if existing, exists := blame.Commits[fields[0]]; exists {
+				commit = existing
+			} else {
+				commit = GitBlameCommit{Hash: fields[0]}
+				authorTime, authorTZ = 0, ""
+			}
+			continue
+		}
+
+		switch key {
+		case "author":
+			commit.AuthorName = value
+		case "author-mail":
+			commit.AuthorEmail = strings.Trim(value, "<>")
+		case "author-time":
+			authorTime, _ = strconv.ParseInt(value, 10, 64)
+		case "author-tz":
+			authorTZ = value
+		case "summary":
+			commit.Summary = value
+		case "filename":
+			commit.Filename = value
+		}
+	}
+	return blame
+}
+
+// 解析"+0800"格式的时区
+func parseGitTimezone(tz string) *time.Location {
+	if len(tz) != 5 {
+		return time.UTC
+	}
+	hours, err1 := strconv.Atoi(tz[1:3])
+	minutes, err2 := strconv.Atoi(tz[3:5])
+	if err1 != nil || err2 != nil {
+		return time.UTC
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(tz, offset)
+}
+
+// HTTP处理器
+
+// 返回JSON结果，参数无效或git命令本身失败时返回400（如不是git仓库、引用不存在），
+// 代码托管平台返回的错误除404和参数错误外返回502，其余返回500
+func writeGitResponse(w http.ResponseWriter, result interface{}, err error) {
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch e := err.(type) {
+		case *GitCommandError, *GitValidationError:
+			status = http.StatusBadRequest
+		case *GitForgeError:
+			switch e.StatusCode {
+			case http.StatusNotFound:
+				status = http.StatusNotFound
+			case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+				status = http.StatusBadRequest
+			default:
+				status = http.StatusBadGateway
+			}
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 有冲突时返回409，响应体中包含冲突文件
+func writeGitCommandResult(w http.ResponseWriter, result *GitCommandResult, err error) {
+	if err == nil && !result.Success {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	writeGitResponse(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitStatus(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	status, err := oem.GitStatusInfo(workspaceID)
+	writeGitResponse(w, status, err)
+}
+
+// GET /workspaces/{id}/git/log?ref=&path=&skip=&limit=
+func (oem *OnlineEditorManager) handleGitLog(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	options := GitLogOptions{Ref: query.Get("ref"), Path: query.Get("path")}
+	options.Skip, _ = strconv.Atoi(query.Get("skip"))
+	options.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	result, err := oem.GitLog(workspaceID, options)
+	writeGitResponse(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitBranches(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	branches, err := oem.GitBranches(workspaceID)
+	writeGitResponse(w, branches, err)
+}
+
+// GET /workspaces/{id}/git/diff?path=&staged=true&context=3
+func (oem *OnlineEditorManager) handleGitDiff(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	staged, _ := strconv.ParseBool(query.Get("staged"))
+	contextLines := -1
+	if value := query.Get("context"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			contextLines = n
+		}
+	}
+
+	diff, err := oem.GitDiff(workspaceID, query.Get("path"), staged, contextLines)
+	writeGitResponse(w, diff, err)
+}
+
+func (oem *OnlineEditorManager) handleGitFetch(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Remote       string `json:"remote"`
+		Prune        bool   `json:"prune"`
+		CredentialID string `json:"credential_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authRequest := GitAuthRequest{UserID: requestUserID(r), CredentialID: req.CredentialID}
+	output, err := oem.GitFetch(workspaceID, req.Remote, req.Prune, authRequest)
+	writeGitResponse(w, map[string]string{"output": output}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitMerge(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Ref     string `json:"ref"`
+		Message string `json:"message"`
+		NoFF    bool   `json:"no_ff"`
+		Squash  bool   `json:"squash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := oem.GitMerge(workspaceID, req.Ref, req.Message, req.NoFF, req.Squash)
+	writeGitCommandResult(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitRebase(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Upstream string `json:"upstream"`
+		Onto     string `json:"onto"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := oem.GitRebase(workspaceID, req.Upstream, req.Onto)
+	writeGitCommandResult(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitStashList(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	stashes, err := oem.GitStashList(workspaceID)
+	writeGitResponse(w, stashes, err)
+}
+
+func (oem *OnlineEditorManager) handleGitStash(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Action           string `json:"action"` // push, apply, pop, drop
+		Message          string `json:"message"`
+		Index            int    `json:"index"`
+		IncludeUntracked bool   `json:"include_untracked"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := oem.GitStash(workspaceID, req.Action, req.Message, req.Index, req.IncludeUntracked)
+	writeGitCommandResult(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitTags(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	tags, err := oem.GitTags(workspaceID)
+	writeGitResponse(w, tags, err)
+}
+
+func (oem *OnlineEditorManager) handleGitCreateTag(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Name    string `json:"name"`
+		Ref     string `json:"ref"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := oem.GitCreateTag(workspaceID, req.Name, req.Ref, req.Message)
+	writeGitResponse(w, map[string]string{"status": "created"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitDeleteTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := oem.GitDeleteTag(vars["id"], vars["name"])
+	writeGitResponse(w, map[string]string{"status": "deleted"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitReset(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Mode  string   `json:"mode"` // soft, mixed, hard
+		Ref   string   `json:"ref"`
+		Paths []string `json:"paths"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := oem.GitReset(workspaceID, req.Mode, req.Ref, req.Paths)
+	writeGitResponse(w, map[string]string{"status": "reset"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitRemotes(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	remotes, err := oem.GitRemotes(workspaceID)
+	writeGitResponse(w, remotes, err)
+}
+
+func (oem *OnlineEditorManager) handleGitAddRemote(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := oem.GitAddRemote(workspaceID, req.Name, req.URL)
+	writeGitResponse(w, map[string]string{"status": "added"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitUpdateRemote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := oem.GitSetRemoteURL(vars["id"], vars["name"], req.URL)
+	writeGitResponse(w, map[string]string{"status": "updated"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitRemoveRemote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	err := oem.GitRemoveRemote(vars["id"], vars["name"])
+	writeGitResponse(w, map[string]string{"status": "removed"}, err)
+}
+
+// GET /workspaces/{id}/git/blame?path=&ref=
+func (oem *OnlineEditorManager) handleGitBlame(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	blame, err := oem.GitBlame(workspaceID, query.Get("path"), query.Get("ref"))
+	writeGitResponse(w, blame, err)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGitStatus(t *testing.T) {
+	records := []string{
+		"# branch.oid 1111111111111111111111111111111111111111",
+		"# branch.head main",
+		"# branch.upstream origin/main",
+		"# branch.ab +2 -1",
+		"1 .M N... 100644 100644 100644 aaaa bbbb src/main.go",
+		"1 A. N... 000000 100644 100644 0000 cccc dir with space/new file.txt",
+		"2 R. N... 100644 100644 100644 dddd dddd R100 new name.go",
+		"old name.go",
+		"u UU N... 100644 100644 100644 100644 e1 e2 e3 conflict.go",
+		"? untracked.txt",
+		"! ignored.log",
+	}
+	status := parseGitStatus(strings.Join(records, "\x00") + "\x00")
+
+	if status.Commit != "1111111111111111111111111111111111111111" || status.Branch != "main" || status.Detached {
+		t.Fatalf("分支信息错误: %+v", status)
+	}
+	if status.Upstream != "origin/main" || status.Ahead != 2 || status.Behind != 1 {
+		t.Fatalf("上游信息错误: %+v", status)
+	}
+	if status.Clean {
+		t.Fatalf("有变更时Clean应为false")
+	}
+
+	want := []GitStatusEntry{
+		{Path: "src/main.go", Kind: "changed", Staged: ".", Unstaged: "M"},
+		{Path: "dir with space/new file.txt", Kind: "changed", Staged: "A", Unstaged: "."},
+		{Path: "new name.go", OrigPath: "old name.go", Kind: "renamed", Staged: "R", Unstaged: "."},
+		{Path: "conflict.go", Kind: "unmerged", Staged: "U", Unstaged: "U"},
+		{Path: "untracked.txt", Kind: "untracked", Staged: ".", Unstaged: "?"},
+		{Path: "ignored.log", Kind: "ignored", Staged: ".", Unstaged: "!"},
+	}
+	if len(status.Entries) != len(want) {
+		t.Fatalf("条目数量 = %d, 期望 %d: %+v", len(status.Entries), len(want), status.Entries)
+	}
+	for i := range want {
+		if status.Entries[i] != want[i] {
+			t.Errorf("条目 %d = %+v, 期望 %+v", i, status.Entries[i], want[i])
+		}
+	}
+}
+
+func TestParseGitStatusInitialAndDetached(t *testing.T) {
+	status := parseGitStatus("# branch.oid (initial)\x00# branch.head (detached)\x00")
+	if status.Commit != "" || !status.Detached || status.Branch != "" || !status.Clean {
+		t.Fatalf("解析结果错误: %+v", status)
+	}
+}
+
+func TestParseGitLog(t *testing.T) {
+	record := func(fields ...string) string {
+		return strings.Join(fields, "\x00") + "\x1e"
+	}
+	output := record("aaaa", "", "Alice", "alice@example.com", "2024-05-01T10:00:00+08:00",
+		"Bob", "bob@example.com", "2024-05-02T11:00:00Z", "初始提交", "") +
+		"\n" + record("bbbb", "aaaa cccc", "Alice", "alice@example.com", "2024-05-03T10:00:00+08:00",
+		"Alice", "alice@example.com", "2024-05-03T10:00:00+08:00", "Merge branch 'dev'", "第一行\n\n第二行\n")
+
+	commits := parseGitLog(output)
+	if len(commits) != 2 {
+		t.Fatalf("提交数量 = %d, 期望 2", len(commits))
+	}
+
+	first := commits[0]
+	if first.Hash != "aaaa" || len(first.Parents) != 0 || first.AuthorName != "Alice" || first.CommitterEmail != "bob@example.com" {
+		t.Fatalf("第一条提交解析错误: %+v", first)
+	}
+	if want := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC); !first.AuthorDate.Equal(want) {
+		t.Fatalf("作者时间 = %v, 期望 %v", first.AuthorDate, want)
+	}
+
+	second := commits[1]
+	if len(second.Parents) != 2 || second.Parents[1] != "cccc" {
+		t.Fatalf("合并提交的父提交解析错误: %v", second.Parents)
+	}
+	if second.Subject != "Merge branch 'dev'" || second.Body != "第一行\n\n第二行" {
+		t.Fatalf("提交说明解析错误: %q / %q", second.Subject, second.Body)
+	}
+}
+
+func TestParseGitDiff(t *testing.T) {
+	output := strings.Join([]string{
+		"diff --git a/main.go b/main.go",
+		"index 1111111..2222222 100644",
+		"--- a/main.go",
+		"+++ b/main.go",
+		"@@ -1,3 +1,4 @@ package main",
+		" line1",
+		"-line2",
+		"+line2 changed",
+		"+line3",
+		" line4",
+		"\\ No newline at end of file",
+		"diff --git a/new.txt b/new.txt",
+		"new file mode 100644",
+		"index 0000000..3333333",
+		"--- /dev/null",
+		"+++ b/new.txt",
+		"@@ -0,0 +1 @@",
+		"+hello",
+		"diff --git a/old.go b/renamed.go",
+		"similarity index 100%",
+		"rename from old.go",
+		"rename to renamed.go",
+		"diff --git a/image.png b/image.png",
+		"deleted file mode 100644",
+		"Binary files a/image.png and /dev/null differ",
+		"",
+	}, "\n")
+
+	files := parseGitDiff(output)
+	if len(files) != 4 {
+		t.Fatalf("文件数量 = %d, 期望 4", len(files))
+	}
+
+	modified := files[0]
+	if modified.Status != "modified" || modified.OldPath != "main.go" || modified.NewPath != "main.go" {
+		t.Fatalf("修改文件解析错误: %+v", modified)
+	}
+	if len(modified.Hunks) != 1 {
+		t.Fatalf("hunk数量 = %d, 期望 1", len(modified.Hunks))
+	}
+	hunk := modified.Hunks[0]
+	if hunk.Header != "package main" || hunk.OldStart != 1 || hunk.OldLines != 3 || hunk.NewStart != 1 || hunk.NewLines != 4 {
+		t.Fatalf("hunk头解析错误: %+v", hunk)
+	}
+	wantLines := []GitDiffLine{
+		{Type: "context", Content: "line1", OldLine: 1, NewLine: 1},
+		{Type: "delete", Content: "line2", OldLine: 2},
+		{Type: "add", Content: "line2 changed", NewLine: 2},
+		{Type: "add", Content: "line3", NewLine: 3},
+		{Type: "context", Content: "line4", OldLine: 3, NewLine: 4, NoNewline: true},
+	}
+	if len(hunk.Lines) != len(wantLines) {
+		t.Fatalf("行数量 = %d, 期望 %d: %+v", len(hunk.Lines), len(wantLines), hunk.Lines)
+	}
+	for i := range wantLines {
+		if hunk.Lines[i] != wantLines[i] {
+			t.Errorf("行 %d = %+v, 期望 %+v", i, hunk.Lines[i], wantLines[i])
+		}
+	}
+
+	added := files[1]
+	if added.Status != "added" || added.OldPath != "new.txt" || added.NewPath != "new.txt" {
+		t.Fatalf("新增文件解析错误: %+v", added)
+	}
+	if len(added.Hunks) != 1 || added.Hunks[0].NewStart != 1 || added.Hunks[0].NewLines != 1 {
+		t.Fatalf("新增文件hunk解析错误: %+v", added.Hunks)
+	}
+
+	renamed := files[2]
+	if renamed.Status != "renamed" || renamed.OldPath != "old.go" || renamed.NewPath != "renamed.go" {
+		t.Fatalf("重命名文件解析错误: %+v", renamed)
+	}
+
+	binary := files[3]
+	if binary.Status != "deleted" || !binary.Binary || binary.OldPath != "image.png" {
+		t.Fatalf("二进制文件解析错误: %+v", binary)
+	}
+}
+
+func TestParseGitBlame(t *testing.T) {
+	hashA := strings.Repeat("a", 40)
+	hashB := strings.Repeat("b", 40)
+	output := strings.Join([]string{
+		hashA + " 1 1 2",
+		"author Alice",
+		"author-mail <alice@example.com>",
+		"author-time 1714528800",
+		"author-tz +0800",
+		"committer Alice",
+		"summary 初始提交",
+		"filename old.go",
+		"\tpackage main",
+		hashA + " 2 2",
+		"\t",
+		hashB + " 5 3 1",
+		"author Bob",
+		"author-mail <bob@example.com>",
+		"author-time 1714615200",
+		"author-tz -0130",
+		"summary 添加函数",
+		"previous " + hashA + " old.go",
+		"filename main.go",
+		"\tfunc main() {}",
+		"",
+	}, "\n")
+
+	blame := parseGitBlame(output)
+	if len(blame.Lines) != 3 {
+		t.Fatalf("行数量 = %d, 期望 3", len(blame.Lines))
+	}
+	wantLines := []GitBlameLine{
+		{Line: 1, Commit: hashA, OrigLine: 1, Content: "package main"},
+		{Line: 2, Commit: hashA, OrigLine: 2, Content: ""},
+		{Line: 3, Commit: hashB, OrigLine: 5, Content: "func main() {}"},
+	}
+	for i := range wantLines {
+		if blame.Lines[i] != wantLines[i] {
+			t.Errorf("行 %d = %+v, 期望 %+v", i, blame.Lines[i], wantLines[i])
+		}
+	}
+
+	if len(blame.Commits) != 2 {
+		t.Fatalf("提交数量 = %d, 期望 2", len(blame.Commits))
+	}
+	a := blame.Commits[hashA]
+	if a.AuthorName != "Alice" || a.AuthorEmail != "alice@example.com" || a.Summary != "初始提交" || a.Filename != "old.go" {
+		t.Fatalf("提交A解析错误: %+v", a)
+	}
+	if _, offset := a.AuthorDate.Zone(); offset != 8*3600 || a.AuthorDate.Unix() != 1714528800 {
+		t.Fatalf("提交A时间解析错误: %v", a.AuthorDate)
+	}
+	b := blame.Commits[hashB]
+	if _, offset := b.AuthorDate.Zone(); offset != -(3600+1800) || b.Filename != "main.go" {
+		t.Fatalf("提交B解析错误: %+v", b)
+	}
+}
+
+func TestParseGitTrack(t *testing.T) {
+	tests := []struct {
+		track         string
+		ahead, behind int
+		gone          bool
+	}{
+		{"", 0, 0, false},
+		{"ahead 3", 3, 0, false},
+		{"behind 2", 0, 2, false},
+		{"ahead 1, behind 4", 1, 4, false},
+		{"gone", 0, 0, true},
+	}
+	for _, tt := range tests {
+		ahead, behind, gone := parseGitTrack(tt.track)
+		if ahead != tt.ahead || behind != tt.behind || gone != tt.gone {
+			t.Errorf("parseGitTrack(%q) = %d, %d, %v", tt.track, ahead, behind, gone)
+		}
+	}
+}
+
+func TestGitStashRejectsNegativeIndex(t *testing.T) {
+	oem := &OnlineEditorManager{}
+	for _, action := range []string{"apply", "pop", "drop"} {
+		_, err := oem.GitStash("ws_1", action, "", -1, false)
+		if _, ok := err.(*GitValidationError); !ok {
+			t.Errorf("GitStash(%s, -1) 错误 = %v, 期望参数校验错误", action, err)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// 创建工作空间时克隆Git仓库
+//
+// 克隆不使用git clone（要求目标目录为空），而是在/workspace中依次执行
+// init、remote add、fetch、checkout：checkout不会覆盖已有的未跟踪文件，
+// 失败时只删除本次创建的.git目录，工作空间中原有的文件保持不变。
+// git输出的进度信息会实时解析，客户端可以通过WebSocket订阅。
+
+// 克隆超时时间
+const gitCloneTimeout = 10 * time.Minute
+
+// 克隆选项
+type GitCloneOptions struct {
+	Depth        int      `json:"depth,omitempty"`        // 浅克隆深度，0表示完整克隆
+	Submodules   bool     `json:"submodules,omitempty"`   // 递归初始化子模块
+	SparsePaths  []string `json:"sparse_paths,omitempty"` // 稀疏检出的目录，为空时检出全部
+	CredentialID string   `json:"credential_id,omitempty"`
+	Replace      bool     `json:"replace,omitempty"` // 先清空工作空间再克隆（会删除全部已有文件）
+}
+
+// 克隆进度
+type GitCloneProgress struct {
+	Repo     string     `json:"repo"`
+	Branch   string     `json:"branch"`
+	Status   string     `json:"status"`  // cloning, completed, failed
+	Stage    string     `json:"stage"`   // 当前阶段，如Receiving objects
+	Percent  int        `json:"percent"` // 当前阶段的完成百分比
+	Message  string     `json:"message"` // 最近一行输出
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// 克隆任务
+type gitCloneTask struct {
+	mutex    sync.Mutex
+	progress GitCloneProgress
+	updated  chan struct{} // 每次更新时关闭并替换，用于通知订阅者
+}
+
+func newGitCloneTask(repo, branch string) *gitCloneTask {
+	return &gitCloneTask{
+		progress: GitCloneProgress{Repo: repo, Branch: branch, Status: "cloning", Started: time.Now()},
+		updated:  make(chan struct{}),
+	}
+}
+
+// 当前进度和下一次更新的通知
+func (t *gitCloneTask) snapshot() (GitCloneProgress, <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.progress, t.updated
+}
+
+func (t *gitCloneTask) update(apply func(progress *GitCloneProgress)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	apply(&t.progress)
+	close(t.updated)
+	t.updated = make(chan struct{})
+}
+
+func (t *gitCloneTask) finish(err error) {
+	t.update(func(progress *GitCloneProgress) {
+		now := time.Now()
+		progress.Finished = &now
+		if err != nil {
+			progress.Status = "failed"
+			progress.Error = err.Error()
+		} else {
+			progress.Status = "completed"
+			progress.Percent = 100
+		}
+	})
+}
+
+// 进度行，如"Receiving objects:  45% (450/1000), 1.20 MiB | 1.00 MiB/s"
+var gitProgressPattern = regexp.MustCompile(`^(?:remote: )?([A-Za-z][A-Za-z ]*):\s+(\d+)%`)
+
+// 解析git写到stderr的进度输出，进度行以\r刷新
+type gitProgressWriter struct {
+	task    *gitCloneTask
+	pending []byte
+}
+
+func (w *gitProgressWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		end := bytes.IndexAny(w.pending, "\r\n")
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:end]))
+		w.pending = w.pending[end+1:]
+		if line == "" {
+			continue
+		}
+
+		w.task.update(func(progress *GitCloneProgress) {
+			progress.Message = line
+			if match := gitProgressPattern.FindStringSubmatch(line); match != nil {
+				progress.Stage = match[1]
+				progress.Percent, _ = strconv.Atoi(match[2])
+			}
+		})
+	}
+	return len(p), nil
+}
+
+// 执行一个克隆步骤，返回stdout；失败时错误信息取自最后一行输出
+func (oem *OnlineEditorManager) runGitCloneStep(ctx context.Context, workspaceID string, task *gitCloneTask, auth *gitAuth, args ...string) (string, error) {
+	cmd := append([]string{"git", "-c", "core.quotepath=false"}, args...)
+	env := append([]string{"GIT_TERMINAL_PROMPT=0", "LC_ALL=C", gitAllowProtocolEnv()}, auth.environment()...)
+
+	var stdout bytes.Buffer
+	exitCode, err := oem.execInWorkspaceStream(ctx, workspaceID, auth.command(cmd), containerWorkspaceRoot, env, &stdout, &gitProgressWriter{task: task})
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		progress, _ := task.snapshot()
+		return "", fmt.Errorf("git %s 执行失败: %s", args[0], progress.Message)
+	}
+	return stdout.String(), nil
+}
+
+// 把仓库克隆到工作空间，branch为空时使用远程仓库的默认分支
+func (oem *OnlineEditorManager) cloneGitRepo(workspaceID, repo, branch string, options GitCloneOptions, auth *gitAuth) error {
+	if err := validateGitURL(repo); err != nil {
+		return err
+	}
+	for _, sparsePath := range options.SparsePaths {
+		if strings.HasPrefix(sparsePath, "-") {
+			return invalidGitInput("无效的稀疏检出路径: %s", sparsePath)
+		}
+	}
+
+	oem.gitClonesMutex.Lock()
+	if existing, exists := oem.gitClones[workspaceID]; exists {
+		if progress, _ := existing.snapshot(); progress.Status == "cloning" {
+			oem.gitClonesMutex.Unlock()
+			return fmt.Errorf("工作空间正在克隆仓库")
+		}
+	}
+	task := newGitCloneTask(repo, branch)
+	oem.gitClones[workspaceID] = task
+	oem.gitClonesMutex.Unlock()
+
+	err := oem.runGitClone(workspaceID, task, repo, branch, options, auth)
+	task.finish(err)
+
+	if workspaceDir, dirErr := oem.workspaceRealDir(workspaceID); dirErr == nil {
+		if entries, readErr := os.ReadDir(workspaceDir); readErr == nil {
+			paths := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				paths = append(paths, filepath.Join(workspaceDir, entry.Name()))
+			}
+			oem.markDiskUsageDirty(workspaceID, paths...)
+		}
+	}
+
+	if err != nil {
+		log.Printf("[%s] 克隆仓库失败: %v", workspaceID, err)
+		return err
+	}
+	log.Printf("[%s] 克隆仓库完成: %s", workspaceID, repo)
+	return nil
+}
+
+func (oem *OnlineEditorManager) runGitClone(workspaceID string, task *gitCloneTask, repo, branch string, options GitCloneOptions, auth *gitAuth) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCloneTimeout)
+	defer cancel()
+
+	if err := oem.ensureCodeTool(workspaceID, codeTool{Name: "git", Packages: []string{"git"}}); err != nil {
+		return err
+	}
+	if auth != nil && auth.ssh {
+		if err := oem.ensureCodeTool(workspaceID, codeTool{Name: "ssh-agent", Packages: []string{"openssh-client"}}); err != nil {
+			return err
+		}
+	}
+
+	if branch != "" {
+		if err := oem.validateGitRefName(workspaceID, "branch", branch); err != nil {
+			return err
+		}
+	}
+
+	// 替换模式需要调用方显式开启：删除工作空间中的全部内容（包括隐藏文件和已有的.git）
+	if options.Replace {
+		task.update(func(progress *GitCloneProgress) { progress.Message = "清空工作空间" })
+		result, err := oem.execInWorkspace(ctx, workspaceID,
+			[]string{"find", containerWorkspaceRoot, "-mindepth", "1", "-maxdepth", "1", "-exec", "rm", "-rf", "--", "{}", "+"}, "/", nil)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("清空工作空间失败: %s", strings.TrimSpace(result.Stderr))
+		}
+		log.Printf("[%s] 克隆前已清空工作空间", workspaceID)
+	}
+
+	gitDir, err := oem.resolveWorkspacePathNoFollow(workspaceID, ".git")
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(gitDir); err == nil {
+		return fmt.Errorf("工作空间中已存在Git仓库，如需重新克隆请使用替换模式")
+	}
+
+	step := func(auth *gitAuth, args ...string) (string, error) {
+		return oem.runGitCloneStep(ctx, workspaceID, task, auth, args...)
+	}
+
+	// 检出成功之前出错时删除本次创建的.git，不触碰其他文件
+	checkedOut := false
+	defer func() {
+		if !checkedOut {
+			rollbackCtx, rollbackCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer rollbackCancel()
+			oem.execInWorkspace(rollbackCtx, workspaceID, []string{"rm", "-rf", "--", containerWorkspaceRoot + "/.git"}, "/", nil)
+		}
+	}()
+
+	if _, err := step(nil, "init", "-q"); err != nil {
+		return err
+	}
+
+	if _, err := step(nil, "remote", "add", "--", "origin", repo); err != nil {
+		return err
+	}
+
+	if branch == "" {
+		output, err := step(auth, "ls-remote", "--symref", "origin", "HEAD")
+		if err != nil {
+			return err
+		}
+		branch = parseGitDefaultBranch(output)
+		if branch == "" {
+			return fmt.Errorf("无法确定远程仓库的默认分支，请指定分支")
+		}
+		if err := oem.validateGitRefName(workspaceID, "branch", branch); err != nil {
+			return err
+		}
+		task.update(func(progress *GitCloneProgress) { progress.Branch = branch })
+	}
+
+	if len(options.SparsePaths) > 0 {
+		args := []string{"sparse-checkout", "set"}
+		for _, sparsePath := range options.SparsePaths {
+			args = append(args, gitPathspec(sparsePath))
+		}
+		if _, err := step(nil, args...); err != nil {
+			return err
+		}
+	}
+
+	fetchArgs := []string{"fetch", "--progress"}
+	if options.Depth > 0 {
+		// 浅克隆只获取目标分支，与git clone --depth的行为一致
+		if _, err := step(nil, "remote", "set-branches", "origin", branch); err != nil {
+			return err
+		}
+		fetchArgs = append(fetchArgs, "--depth", strconv.Itoa(options.Depth))
+	}
+	fetchArgs = append(fetchArgs, "origin")
+	if _, err := step(auth, fetchArgs...); err != nil {
+		return err
+	}
+
+	// 稀疏检出模式下git会直接覆盖已有的同名文件，因此检出前自行检查冲突
+	output, err := step(nil, "ls-tree", "-r", "-z", "--name-only", "origin/"+branch)
+	if err != nil {
+		return err
+	}
+	checkoutPaths := splitNul(output)
+	if conflicts := oem.existingWorkspaceFiles(workspaceID, checkoutPaths); len(conflicts) > 0 {
+		if len(conflicts) > 5 {
+			conflicts = append(conflicts[:5], "...")
+		}
+		return fmt.Errorf("工作空间中已存在同名文件，未执行检出: %s", strings.Join(conflicts, ", "))
+	}
+	newDirs := oem.missingWorkspaceDirs(workspaceID, checkoutPaths)
+
+	if _, err := step(nil, "checkout", "--progress", "-b", branch, "--track", "origin/"+branch); err != nil {
+		// 检出中途失败时删除已写出的文件，这些文件和目录在检出前都不存在
+		oem.removeWorkspacePaths(workspaceID, checkoutPaths, newDirs)
+		return err
+	}
+	checkedOut = true
+
+	oem.mutex.Lock()
+	if workspace, exists := oem.workspaces[workspaceID]; exists {
+		workspace.GitBranch = branch
+	}
+	oem.mutex.Unlock()
+
+	if options.Submodules {
+		args := []string{"submodule", "update", "--init", "--recursive", "--progress"}
+		if options.Depth > 0 {
+			args = append(args, "--depth", strconv.Itoa(options.Depth))
+		}
+		if _, err := step(auth, args...); err != nil {
+			return fmt.Errorf("仓库已检出，但子模块初始化失败: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// 旧版Git操作中的clone，未指定仓库和分支时使用工作空间创建时的配置
+// 默认不会删除已有文件，operation.Replace为true时才清空工作空间
+func (oem *OnlineEditorManager) gitOperationClone(workspaceID string, operation GitOperation) (string, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var repo, branch, status string
+	if exists {
+		repo, branch, status = workspace.GitRepo, workspace.GitBranch, workspace.Status
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	if status != "running" {
+		return "", fmt.Errorf("工作空间未运行: %s", workspaceID)
+	}
+
+	if operation.Repo != "" {
+		repo = operation.Repo
+	}
+	if operation.Branch != "" {
+		branch = operation.Branch
+	}
+	if repo == "" {
+		return "", fmt.Errorf("工作空间未配置Git仓库")
+	}
+
+	auth, err := oem.gitAuthForURL(GitAuthRequest{UserID: operation.UserID, CredentialID: operation.CredentialID}, repo)
+	if err != nil {
+		return "", err
+	}
+
+	options := GitCloneOptions{CredentialID: operation.CredentialID, Replace: operation.Replace}
+	if err := oem.cloneGitRepo(workspaceID, repo, branch, options, auth); err != nil {
+		return "", err
+	}
+
+	if task := oem.gitCloneTask(workspaceID); task != nil {
+		progress, _ := task.snapshot()
+		branch = progress.Branch
+	}
+	return fmt.Sprintf("已克隆 %s（分支: %s）\n", repo, branch), nil
+}
+
+// 返回paths的上级目录中尚不存在的目录，按深度从深到浅排列
+func (oem *OnlineEditorManager) missingWorkspaceDirs(workspaceID string, paths []string) []string {
+	seen := make(map[string]bool)
+	missing := []string{}
+	for _, p := range paths {
+		for dir := path.Dir(p); dir != "." && dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, dir)
+			if err != nil {
+				continue
+			}
+			if _, err := os.Lstat(fullPath); os.IsNotExist(err) {
+				missing = append(missing, dir)
+			}
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return strings.Count(missing[i], "/") > strings.Count(missing[j], "/")
+	})
+	return missing
+}
+
+// 删除工作空间中的文件和（为空的）目录，用于回滚失败的检出
+func (oem *OnlineEditorManager) removeWorkspacePaths(workspaceID string, files, dirs []string) {
+	for _, p := range append(append([]string{}, files...), dirs...) {
+		fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, p)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("[%s] 回滚检出时删除 %s 失败: %v", workspaceID, p, err)
+		}
+	}
+}
+
+// 返回paths中已存在于工作空间的文件
+func (oem *OnlineEditorManager) existingWorkspaceFiles(workspaceID string, paths []string) []string {
+	existing := []string{}
+	for _, p := range paths {
+		fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, p)
+		if err != nil {
+			existing = append(existing, p)
+			continue
+		}
+		if _, err := os.Lstat(fullPath); err == nil {
+			existing = append(existing, p)
+		}
+	}
+	return existing
+}
+
+// 解析git ls-remote --symref origin HEAD的输出："ref: refs/heads/main\tHEAD"
+func parseGitDefaultBranch(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if ref, found := strings.CutPrefix(line, "ref: "); found {
+			ref, _, _ = strings.Cut(ref, "\t")
+			return strings.TrimPrefix(ref, "refs/heads/")
+		}
+	}
+	return ""
+}
+
+// 工作空间最近一次克隆的进度
+func (oem *OnlineEditorManager) gitCloneTask(workspaceID string) *gitCloneTask {
+	oem.gitClonesMutex.Lock()
+	defer oem.gitClonesMutex.Unlock()
+	return oem.gitClones[workspaceID]
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleGetCloneProgress(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	task := oem.gitCloneTask(workspaceID)
+	if task == nil {
+		http.Error(w, "工作空间没有克隆任务", http.StatusNotFound)
+		return
+	}
+
+	progress, _ := task.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// GET /workspaces/{id}/git/clone/ws
+// 推送克隆进度，克隆结束后关闭连接。工作空间仍在准备阶段时等待克隆开始
+func (oem *OnlineEditorManager) handleCloneProgressWebSocket(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	conn, err := oem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+	pusher := newWebSocketPusher(conn)
+
+	// 等待克隆任务出现
+	var task *gitCloneTask
+	for task == nil {
+		if task = oem.gitCloneTask(workspaceID); task != nil {
+			break
+		}
+
+		status, err := oem.GetWorkspaceStatus(workspaceID)
+		if err != nil {
+			pusher.close(websocket.CloseNormalClosure, err.Error())
+			return
+		}
+		// 状态变为cloning后还要准备Git凭据，克隆任务稍后才登记
+		switch status {
+		case "pending", "pulling", "creating", "starting", "cloning":
+		default:
+			pusher.close(websocket.CloseNormalClosure, "工作空间没有克隆任务")
+			return
+		}
+
+		select {
+		case <-pusher.gone:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+
+	for {
+		progress, updated := task.snapshot()
+
+		if err := pusher.send(progress); err != nil {
+			return
+		}
+		if progress.Status != "cloning" {
+			pusher.close(websocket.CloseNormalClosure, progress.Status)
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-pusher.gone:
+			return
+		}
+		// 进度刷新很频繁，限制推送频率
+		time.Sleep(200 * time.Millisecond)
+	}
+}
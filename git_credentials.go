@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Git凭据管理
+//
+// 凭据按已认证的用户（见user_identity.go）保存，没有用户身份的请求不能使用凭据。令牌和私钥使用AES-GCM加密后落盘。执行git命令时凭据只通过
+// 本次exec的环境变量传入容器：HTTPS令牌经由临时的credential.helper提供，SSH私钥
+// 加载到仅在本次命令期间存在的ssh-agent中。工作空间的Environment和容器文件系统
+// 中都不会出现明文凭据。
+
+// Git凭据
+type GitCredential struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"` // https, ssh
+	Host     string    `json:"host"` // 匹配远程仓库的主机名
+	Username string    `json:"username,omitempty"`
+	Created  time.Time `json:"created"`
+
+	// 加密后的令牌（https）或私钥（ssh），不通过API返回
+	Secret string `json:"secret,omitempty"`
+}
+
+// 返回给客户端的凭据信息（不含密文）
+func (c *GitCredential) public() *GitCredential {
+	copied := *c
+	copied.Secret = ""
+	return &copied
+}
+
+// 创建凭据的请求
+type GitCredentialRequest struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Host       string `json:"host"`
+	Username   string `json:"username"`
+	Token      string `json:"token"`       // https
+	PrivateKey string `json:"private_key"` // ssh，需为无口令的私钥
+}
+
+// 选择git命令使用的凭据
+type GitAuthRequest struct {
+	UserID       string
+	CredentialID string // 为空时按远程仓库主机名自动匹配
+}
+
+// Git凭据管理器
+type GitCredentialManager struct {
+	credentials map[string]*GitCredential
+	file        string
+	aead        cipher.AEAD
+	mutex       sync.RWMutex
+}
+
+// 创建凭据管理器，加密密钥取自GIT_CREDENTIAL_KEY，未设置时在baseDir下生成并保存
+func NewGitCredentialManager(baseDir string) (*GitCredentialManager, error) {
+	key, err := loadCredentialKey(filepath.Join(baseDir, "credential.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("初始化凭据加密失败: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("初始化凭据加密失败: %v", err)
+	}
+
+	manager := &GitCredentialManager{
+		credentials: make(map[string]*GitCredential),
+		file:        filepath.Join(baseDir, "git_credentials.json"),
+		aead:        aead,
+	}
+
+	if data, err := os.ReadFile(manager.file); err == nil {
+		var credentials []*GitCredential
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			log.Printf("读取Git凭据失败: %v", err)
+		}
+		for _, credential := range credentials {
+			manager.credentials[credential.ID] = credential
+		}
+	}
+
+	return manager, nil
+}
+
+func loadCredentialKey(keyFile string) ([]byte, error) {
+	return loadSecretKey("GIT_CREDENTIAL_KEY", keyFile, "凭据密钥")
+}
+
+// 读取32字节密钥：优先使用环境变量envName（取SHA-256），否则读取keyFile，
+// 文件不存在时随机生成并保存（0600）
+func loadSecretKey(envName, keyFile, purpose string) ([]byte, error) {
+	if secret := os.Getenv(envName); secret != "" {
+		key := sha256.Sum256([]byte(secret))
+		return key[:], nil
+	}
+
+	if data, err := os.ReadFile(keyFile); err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s文件无效: %s", purpose, keyFile)
+		}
+		return key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("生成%s失败: %v", purpose, err)
+	}
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("保存%s失败: %v", purpose, err)
+	}
+	log.Printf("已生成%s: %s", purpose, keyFile)
+	return key, nil
+}
+
+// 加密，凭据ID作为附加数据，防止密文在凭据之间挪用
+func (gcm *GitCredentialManager) encrypt(id, plaintext string) (string, error) {
+	nonce := make([]byte, gcm.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (gcm *GitCredentialManager) decrypt(id, ciphertext string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(data) < gcm.aead.NonceSize() {
+		return "", fmt.Errorf("凭据数据损坏: %s", id)
+	}
+	nonceSize := gcm.aead.NonceSize()
+	plaintext, err := gcm.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("解密凭据失败: %s", id)
+	}
+	return string(plaintext), nil
+}
+
+func (gcm *GitCredentialManager) saveLocked() error {
+	credentials := make([]*GitCredential, 0, len(gcm.credentials))
+	for _, credential := range gcm.credentials {
+		credentials = append(credentials, credential)
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].Created.Before(credentials[j].Created) })
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(gcm.file, data, 0600)
+}
+
+// 列出用户的凭据（不含密文），按创建时间排序
+func (gcm *GitCredentialManager) List(userID string) []*GitCredential {
+	gcm.mutex.RLock()
+	defer gcm.mutex.RUnlock()
+
+	credentials := []*GitCredential{}
+	for _, credential := range gcm.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential.public())
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].Created.Before(credentials[j].Created) })
+	return credentials
+}
+
+func (gcm *GitCredentialManager) Add(userID string, req GitCredentialRequest) (*GitCredential, error) {
+	host := strings.ToLower(strings.TrimSpace(req.Host))
+	if host == "" {
+		return nil, fmt.Errorf("缺少主机名")
+	}
+
+	var secret string
+	switch req.Type {
+	case "https":
+		if req.Token == "" {
+			return nil, fmt.Errorf("缺少访问令牌")
+		}
+		if req.Username == "" {
+			// 大多数托管平台对令牌认证不校验用户名
+			req.Username = "git"
+		}
+		secret = req.Token
+	case "ssh":
+		if !strings.Contains(req.PrivateKey, "PRIVATE KEY") {
+			return nil, fmt.Errorf("无效的SSH私钥")
+		}
+		secret = strings.TrimSpace(req.PrivateKey) + "\n"
+	default:
+		return nil, fmt.Errorf("不支持的凭据类型: %s", req.Type)
+	}
+
+	credential := &GitCredential{
+		ID:       fmt.Sprintf("cred_%d", time.Now().UnixNano()),
+		UserID:   userID,
+		Name:     req.Name,
+		Type:     req.Type,
+		Host:     host,
+		Username: req.Username,
+		Created:  time.Now(),
+	}
+	if credential.Name == "" {
+		credential.Name = host
+	}
+
+	encrypted, err := gcm.encrypt(credential.ID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("加密凭据失败: %v", err)
+	}
+	credential.Secret = encrypted
+
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
+
+	gcm.credentials[credential.ID] = credential
+	if err := gcm.saveLocked(); err != nil {
+		delete(gcm.credentials, credential.ID)
+		return nil, fmt.Errorf("保存凭据失败: %v", err)
+	}
+
+	log.Printf("用户 %s 添加Git凭据: %s (%s %s)", userID, credential.ID, credential.Type, credential.Host)
+	return credential.public(), nil
+}
+
+func (gcm *GitCredentialManager) Delete(userID, credentialID string) error {
+	gcm.mutex.Lock()
+	defer gcm.mutex.Unlock()
+
+	credential, exists := gcm.credentials[credentialID]
+	if !exists || credential.UserID != userID {
+		return fmt.Errorf("凭据不存在: %s", credentialID)
+	}
+
+	delete(gcm.credentials, credentialID)
+	if err := gcm.saveLocked(); err != nil {
+		gcm.credentials[credentialID] = credential
+		return fmt.Errorf("保存凭据失败: %v", err)
+	}
+	return nil
+}
+
+// 查找凭据：指定ID时直接使用，否则按远程仓库地址的协议和主机名匹配。
+// 没有匹配的凭据时返回nil
+func (gcm *GitCredentialManager) Find(auth GitAuthRequest, remoteURL string) (*GitCredential, error) {
+	if auth.UserID == "" {
+		if auth.CredentialID != "" {
+			return nil, fmt.Errorf("使用凭据需要经过认证的用户身份")
+		}
+		return nil, nil
+	}
+
+	gcm.mutex.RLock()
+	defer gcm.mutex.RUnlock()
+
+	if auth.CredentialID != "" {
+		credential, exists := gcm.credentials[auth.CredentialID]
+		if !exists || credential.UserID != auth.UserID {
+			return nil, fmt.Errorf("凭据不存在: %s", auth.CredentialID)
+		}
+		return credential, nil
+	}
+
+	scheme, host := parseGitRemoteHost(remoteURL)
+	if host == "" {
+		return nil, nil
+	}
+
+	var matched *GitCredential
+	for _, credential := range gcm.credentials {
+		if credential.UserID != auth.UserID || credential.Host != host || credential.Type != scheme {
+			continue
+		}
+		if matched == nil || credential.Created.Before(matched.Created) {
+			matched = credential
+		}
+	}
+	return matched, nil
+}
+
+// 解析远程仓库地址，返回凭据类型（https/ssh）和主机名
+// 支持https://host/path、ssh://user@host:port/path和scp风格的user@host:path
+func parseGitRemoteHost(remoteURL string) (scheme, host string) {
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", ""
+		}
+		switch u.Scheme {
+		case "http", "https":
+			return "https", strings.ToLower(u.Hostname())
+		case "ssh", "git+ssh":
+			return "ssh", strings.ToLower(u.Hostname())
+		}
+		return "", ""
+	}
+
+	// scp风格：冒号前不能含有斜杠，否则是本地路径
+	hostPart, _, found := strings.Cut(remoteURL, ":")
+	if !found || strings.Contains(hostPart, "/") {
+		return "", ""
+	}
+	if at := strings.LastIndex(hostPart, "@"); at >= 0 {
+		hostPart = hostPart[at+1:]
+	}
+	return "ssh", strings.ToLower(hostPart)
+}
+
+// 一次git命令的凭据注入方式
+type gitAuth struct {
+	env []string
+	ssh bool // 需要通过ssh-agent包装命令
+}
+
+// 通过ssh-agent执行命令：私钥从环境变量读入agent后立即unset，命令结束后关闭agent
+const gitSSHAgentScript = `eval "$(ssh-agent -s)" >/dev/null || exit 128
+printf '%s\n' "$GIT_AUTH_SSH_KEY" | ssh-add - >/dev/null 2>&1
+added=$?
+unset GIT_AUTH_SSH_KEY
+if [ $added -ne 0 ]; then
+	echo "加载SSH私钥失败（不支持带口令的私钥）" >&2
+	ssh-agent -k >/dev/null 2>&1
+	exit 128
+fi
+"$@"
+status=$?
+ssh-agent -k >/dev/null 2>&1
+exit $status`
+
+// 包装命令
+func (a *gitAuth) command(cmd []string) []string {
+	if a == nil || !a.ssh {
+		return cmd
+	}
+	return append([]string{"sh", "-c", gitSSHAgentScript, "git-auth"}, cmd...)
+}
+
+// 追加到exec的环境变量
+func (a *gitAuth) environment() []string {
+	if a == nil {
+		return nil
+	}
+	return a.env
+}
+
+// 生成凭据注入方式
+func (gcm *GitCredentialManager) authFor(credential *GitCredential) (*gitAuth, error) {
+	secret, err := gcm.decrypt(credential.ID, credential.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch credential.Type {
+	case "https":
+		// 通过GIT_CONFIG_*环境变量配置credential.helper：先清空已有的helper，避免令牌
+		// 被credential-store等写入磁盘，再添加一个从环境变量读取令牌的内联helper
+		helper := `!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$GIT_AUTH_USERNAME" "$GIT_AUTH_TOKEN"; }; f`
+		return &gitAuth{env: []string{
+			"GIT_CONFIG_COUNT=2",
+			"GIT_CONFIG_KEY_0=credential.helper",
+			"GIT_CONFIG_VALUE_0=",
+			"GIT_CONFIG_KEY_1=credential.helper",
+			"GIT_CONFIG_VALUE_1=" + helper,
+			"GIT_AUTH_USERNAME=" + credential.Username,
+			"GIT_AUTH_TOKEN=" + secret,
+		}}, nil
+	case "ssh":
+		return &gitAuth{
+			env: []string{
+				"GIT_AUTH_SSH_KEY=" + secret,
+				"GIT_SSH_COMMAND=ssh -o BatchMode=yes -o StrictHostKeyChecking=accept-new",
+			},
+			ssh: true,
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的凭据类型: %s", credential.Type)
+	}
+}
+
+// 为访问remoteURL的git命令准备凭据，没有匹配的凭据时返回nil（按匿名访问）
+func (oem *OnlineEditorManager) gitAuthForURL(auth GitAuthRequest, remoteURL string) (*gitAuth, error) {
+	credential, err := oem.gitCredentials.Find(auth, remoteURL)
+	if err != nil || credential == nil {
+		return nil, err
+	}
+	return oem.gitCredentials.authFor(credential)
+}
+
+// 为访问工作空间远程仓库的git命令准备凭据，remote为空时使用origin
+func (oem *OnlineEditorManager) gitAuthForRemote(workspaceID, remote string, auth GitAuthRequest) (*gitAuth, error) {
+	if remote == "" {
+		remote = "origin"
+	}
+
+	output, err := oem.gitOutput(workspaceID, "remote", "get-url", "--", remote)
+	if err != nil {
+		// 远程仓库不存在时不注入凭据，由git自身报告错误
+		if auth.CredentialID == "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return oem.gitAuthForURL(auth, strings.TrimSpace(output))
+}
+
+// 旧版GitOperation中访问远程仓库的操作（push、pull）所使用的凭据，clone见gitOperationClone
+func (oem *OnlineEditorManager) gitOperationAuth(workspaceID string, operation GitOperation) (*gitAuth, error) {
+	request := GitAuthRequest{UserID: operation.UserID, CredentialID: operation.CredentialID}
+
+	switch operation.Type {
+	case "push", "pull":
+		return oem.gitAuthForRemote(workspaceID, "", request)
+	default:
+		return nil, nil
+	}
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleListGitCredentials(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oem.gitCredentials.List(userID))
+}
+
+func (oem *OnlineEditorManager) handleAddGitCredential(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req GitCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credential, err := oem.gitCredentials.Add(userID, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credential)
+}
+
+func (oem *OnlineEditorManager) handleDeleteGitCredential(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+	credentialID := mux.Vars(r)["credentialId"]
+
+	if err := oem.gitCredentials.Delete(userID, credentialID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 代码托管平台集成（拉取请求）
+//
+// 通过各平台的REST API创建、列出拉取请求，并把拉取请求的分支检出到工作空间。
+// 平台类型按仓库主机名识别：github.com、gitlab.com、gitea.com、codeberg.org内置，
+// 自建实例通过GIT_FORGES配置，如"git.example.com=gitea,127.0.0.1:3000=gitea"。
+// API地址使用仓库地址的协议和主机（ssh地址使用https），因此本地的替身服务器
+// （如http://127.0.0.1:3000/owner/repo.git）也可以直接使用。
+// 访问API的令牌取自用户的https类型Git凭据，仓库地址为http时拒绝发送令牌。
+
+const (
+	gitForgeTimeout = 30 * time.Second
+
+	gitForgePageSize = 50 // 列表每页数量，也是返回的最大数量
+	gitForgeMaxPages = 10 // 需要在本地筛选状态时最多翻的页数
+)
+
+// 拉取请求（GitLab中为合并请求）
+type PullRequest struct {
+	Number  int       `json:"number"`
+	Title   string    `json:"title"`
+	Body    string    `json:"body"`
+	State   string    `json:"state"` // open, closed, merged
+	Draft   bool      `json:"draft"`
+	Author  string    `json:"author"`
+	Head    string    `json:"head"` // 源分支
+	HeadSHA string    `json:"head_sha"`
+	Base    string    `json:"base"` // 目标分支
+	URL     string    `json:"url"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+type PullRequestRequest struct {
+	Title        string `json:"title"`
+	Body         string `json:"body"`
+	Head         string `json:"head"` // 为空时使用当前分支
+	Base         string `json:"base"` // 为空时使用远程仓库的默认分支
+	Draft        bool   `json:"draft"`
+	Push         bool   `json:"push"` // 创建前先推送源分支
+	CredentialID string `json:"credential_id"`
+}
+
+// 代码托管平台，每个实例绑定一个仓库
+type GitForge interface {
+	// state: open, closed, merged, all
+	ListPullRequests(ctx context.Context, state string) ([]PullRequest, error)
+	GetPullRequest(ctx context.Context, number int) (*PullRequest, error)
+	CreatePullRequest(ctx context.Context, req PullRequestRequest) (*PullRequest, error)
+	// 拉取请求在仓库中的引用，用于git fetch
+	PullRequestRef(number int) string
+}
+
+// 平台实现，按类型注册
+var gitForgeFactories = map[string]func(repo *gitForgeRepo, token string) GitForge{
+	"github": newGitHubForge,
+	"gitea":  newGiteaForge,
+	"gitlab": newGitLabForge,
+}
+
+// 内置的平台主机名
+var defaultGitForgeHosts = map[string]string{
+	"github.com":   "github",
+	"gitlab.com":   "gitlab",
+	"gitea.com":    "gitea",
+	"codeberg.org": "gitea",
+}
+
+// 仓库地址解析结果
+type gitForgeRepo struct {
+	Scheme   string // http, https
+	Host     string // 含端口
+	Hostname string
+	Path     string // owner/repo，GitLab可以有多级分组
+}
+
+// 仓库的Web地址
+func (r *gitForgeRepo) baseURL() string {
+	return r.Scheme + "://" + r.Host
+}
+
+// API请求失败
+type GitForgeError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *GitForgeError) Error() string {
+	return fmt.Sprintf("代码托管平台返回错误（%d）: %s", e.StatusCode, e.Message)
+}
+
+// 解析仓库地址，支持https://host/owner/repo.git、ssh://git@host/owner/repo.git和git@host:owner/repo.git
+func parseGitForgeRepo(remoteURL string) (*gitForgeRepo, error) {
+	repo := &gitForgeRepo{Scheme: "https"}
+	var repoPath string
+
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return nil, invalidGitInput("无效的仓库地址: %s", remoteURL)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			repo.Scheme, repo.Host = u.Scheme, u.Host
+		case "ssh", "git+ssh":
+			repo.Host = u.Hostname()
+		default:
+			return nil, invalidGitInput("不支持的仓库协议: %s", u.Scheme)
+		}
+		repo.Hostname, repoPath = u.Hostname(), u.Path
+	} else {
+		_, host := parseGitRemoteHost(remoteURL)
+		if host == "" {
+			return nil, invalidGitInput("无效的仓库地址: %s", remoteURL)
+		}
+		_, repoPath, _ = strings.Cut(remoteURL, ":")
+		repo.Host, repo.Hostname = host, host
+	}
+
+	repo.Host = strings.ToLower(repo.Host)
+	repo.Hostname = strings.ToLower(repo.Hostname)
+	repo.Path = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if strings.Count(repo.Path, "/") < 1 {
+		return nil, invalidGitInput("无法从仓库地址中识别所有者和仓库名: %s", remoteURL)
+	}
+	return repo, nil
+}
+
+// 按主机名识别平台类型，GIT_FORGES中的配置优先于内置配置
+func gitForgeKind(repo *gitForgeRepo) (string, error) {
+	for _, entry := range strings.Split(os.Getenv("GIT_FORGES"), ",") {
+		host, kind, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			continue
+		}
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == repo.Host || host == repo.Hostname {
+			return strings.TrimSpace(kind), nil
+		}
+	}
+
+	if kind, ok := defaultGitForgeHosts[repo.Hostname]; ok {
+		return kind, nil
+	}
+	return "", invalidGitInput("无法识别仓库所在的代码托管平台: %s，请在GIT_FORGES中配置", repo.Host)
+}
+
+func newGitForge(remoteURL, token string) (GitForge, error) {
+	repo, err := parseGitForgeRepo(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	kind, err := gitForgeKind(repo)
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := gitForgeFactories[kind]
+	if !ok {
+		return nil, fmt.Errorf("不支持的代码托管平台: %s", kind)
+	}
+	return factory(repo, token), nil
+}
+
+// 发送API请求，body不为nil时以JSON发送，响应解码到result
+func gitForgeRequest(ctx context.Context, method, endpoint string, headers map[string]string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: gitForgeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求代码托管平台失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// 各平台的错误信息都在message字段中
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &apiError) == nil && apiError.Message != "" {
+			message = apiError.Message
+		}
+		return &GitForgeError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("解析响应失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// GitHub和Gitea的API结构基本一致
+
+type githubForge struct {
+	api        string // .../repos/owner/repo
+	headers    map[string]string
+	limitParam string // 分页大小参数名
+}
+
+type githubPullRequest struct {
+	Number   int        `json:"number"`
+	Title    string     `json:"title"`
+	Body     string     `json:"body"`
+	State    string     `json:"state"`
+	Draft    bool       `json:"draft"`
+	Merged   bool       `json:"merged"`
+	MergedAt *time.Time `json:"merged_at"`
+	HTMLURL  string     `json:"html_url"`
+	User     struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (p *githubPullRequest) normalize() PullRequest {
+	state := p.State
+	if p.Merged || p.MergedAt != nil {
+		state = "merged"
+	}
+	return PullRequest{
+		Number:  p.Number,
+		Title:   p.Title,
+		Body:    p.Body,
+		State:   state,
+		Draft:   p.Draft,
+		Author:  p.User.Login,
+		Head:    p.Head.Ref,
+		HeadSHA: p.Head.SHA,
+		Base:    p.Base.Ref,
+		URL:     p.HTMLURL,
+		Created: p.CreatedAt,
+		Updated: p.UpdatedAt,
+	}
+}
+
+func newGitHubForge(repo *gitForgeRepo, token string) GitForge {
+	api := repo.baseURL() + "/api/v3"
+	if repo.Hostname == "github.com" {
+		api = "https://api.github.com"
+	}
+
+	headers := map[string]string{"Accept": "application/vnd.github+json"}
+	if token != "" {
+		headers["Authorization"] = "Bearer " + token
+	}
+	return &githubForge{api: api + "/repos/" + repo.Path, headers: headers, limitParam: "per_page"}
+}
+
+func newGiteaForge(repo *gitForgeRepo, token string) GitForge {
+	headers := map[string]string{}
+	if token != "" {
+		headers["Authorization"] = "token " + token
+	}
+	return &githubForge{api: repo.baseURL() + "/api/v1/repos/" + repo.Path, headers: headers, limitParam: "limit"}
+}
+
+func (f *githubForge) ListPullRequests(ctx context.Context, state string) ([]PullRequest, error) {
+	// 没有merged状态的过滤条件，先取已关闭的再筛选；筛选后可能不足一页，需要继续翻页
+	apiState := state
+	if state == "merged" {
+		apiState = "closed"
+	}
+	filtered := state == "closed" || state == "merged"
+
+	pulls := []PullRequest{}
+	for page := 1; page <= gitForgeMaxPages; page++ {
+		query := url.Values{
+			"state":      {apiState},
+			f.limitParam: {strconv.Itoa(gitForgePageSize)},
+			"page":       {strconv.Itoa(page)},
+		}
+		var items []githubPullRequest
+		if err := gitForgeRequest(ctx, http.MethodGet, f.api+"/pulls?"+query.Encode(), f.headers, nil, &items); err != nil {
+			return nil, err
+		}
+
+		for i := range items {
+			pull := items[i].normalize()
+			if state == "closed" && pull.State == "merged" || state == "merged" && pull.State != "merged" {
+				continue
+			}
+			pulls = append(pulls, pull)
+		}
+		if !filtered || len(pulls) >= gitForgePageSize || len(items) < gitForgePageSize {
+			break
+		}
+	}
+
+	if len(pulls) > gitForgePageSize {
+		pulls = pulls[:gitForgePageSize]
+	}
+	return pulls, nil
+}
+
+func (f *githubForge) GetPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	var item githubPullRequest
+	if err := gitForgeRequest(ctx, http.MethodGet, f.api+"/pulls/"+strconv.Itoa(number), f.headers, nil, &item); err != nil {
+		return nil, err
+	}
+	pull := item.normalize()
+	return &pull, nil
+}
+
+func (f *githubForge) CreatePullRequest(ctx context.Context, req PullRequestRequest) (*PullRequest, error) {
+	body := map[string]interface{}{
+		"title": req.Title,
+		"body":  req.Body,
+		"head":  req.Head,
+		"base":  req.Base,
+	}
+	if req.Draft {
+		body["draft"] = true
+	}
+
+	var item githubPullRequest
+	if err := gitForgeRequest(ctx, http.MethodPost, f.api+"/pulls", f.headers, body, &item); err != nil {
+		return nil, err
+	}
+	pull := item.normalize()
+	return &pull, nil
+}
+
+func (f *githubForge) PullRequestRef(number int) string {
+	return fmt.Sprintf("refs/pull/%d/head", number)
+}
+
+// GitLab合并请求
+
+type gitlabForge struct {
+	api     string // .../projects/<编码后的路径>
+	headers map[string]string
+}
+
+type gitlabMergeRequest struct {
+	IID         int    `json:"iid"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"` // opened, closed, merged, locked
+	Draft       bool   `json:"draft"`
+	WebURL      string `json:"web_url"`
+	Author      struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	SourceBranch string    `json:"source_branch"`
+	TargetBranch string    `json:"target_branch"`
+	SHA          string    `json:"sha"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (m *gitlabMergeRequest) normalize() PullRequest {
+	state := m.State
+	switch state {
+	case "opened":
+		state = "open"
+	case "locked":
+		state = "closed"
+	}
+	return PullRequest{
+		Number:  m.IID,
+		Title:   m.Title,
+		Body:    m.Description,
+		State:   state,
+		Draft:   m.Draft,
+		Author:  m.Author.Username,
+		Head:    m.SourceBranch,
+		HeadSHA: m.SHA,
+		Base:    m.TargetBranch,
+		URL:     m.WebURL,
+		Created: m.CreatedAt,
+		Updated: m.UpdatedAt,
+	}
+}
+
+func newGitLabForge(repo *gitForgeRepo, token string) GitForge {
+	headers := map[string]string{}
+	if token != "" {
+		headers["PRIVATE-TOKEN"] = token
+	}
+	return &gitlabForge{api: repo.baseURL() + "/api/v4/projects/" + url.PathEscape(repo.Path), headers: headers}
+}
+
+func (f *gitlabForge) ListPullRequests(ctx context.Context, state string) ([]PullRequest, error) {
+	query := url.Values{"per_page": {strconv.Itoa(gitForgePageSize)}}
+	switch state {
+	case "open":
+		query.Set("state", "opened")
+	case "closed", "merged":
+		query.Set("state", state)
+	}
+
+	var items []gitlabMergeRequest
+	if err := gitForgeRequest(ctx, http.MethodGet, f.api+"/merge_requests?"+query.Encode(), f.headers, nil, &items); err != nil {
+		return nil, err
+	}
+
+	pulls := []PullRequest{}
+	for i := range items {
+		pulls = append(pulls, items[i].normalize())
+	}
+	return pulls, nil
+}
+
+func (f *gitlabForge) GetPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	var item gitlabMergeRequest
+	if err := gitForgeRequest(ctx, http.MethodGet, f.api+"/merge_requests/"+strconv.Itoa(number), f.headers, nil, &item); err != nil {
+		return nil, err
+	}
+	pull := item.normalize()
+	return &pull, nil
+}
+
+func (f *gitlabForge) CreatePullRequest(ctx context.Context, req PullRequestRequest) (*PullRequest, error) {
+	// GitLab通过标题前缀标记草稿
+	title := req.Title
+	if req.Draft {
+		title = "Draft: " + title
+	}
+	body := map[string]interface{}{
+		"title":         title,
+		"description":   req.Body,
+		"source_branch": req.Head,
+		"target_branch": req.Base,
+	}
+
+	var item gitlabMergeRequest
+	if err := gitForgeRequest(ctx, http.MethodPost, f.api+"/merge_requests", f.headers, body, &item); err != nil {
+		return nil, err
+	}
+	pull := item.normalize()
+	return &pull, nil
+}
+
+func (f *gitlabForge) PullRequestRef(number int) string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", number)
+}
+
+// 工作空间操作
+
+// 工作空间对应的远程仓库地址：优先使用创建时配置的GitRepo，否则使用origin
+func (oem *OnlineEditorManager) workspaceGitRepo(workspaceID string) (string, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var repo string
+	if exists {
+		repo = workspace.GitRepo
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		return "", fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	if repo != "" {
+		return repo, nil
+	}
+
+	output, err := oem.gitOutput(workspaceID, "remote", "get-url", "--", "origin")
+	if err != nil {
+		return "", invalidGitInput("工作空间未配置Git仓库")
+	}
+	return strings.TrimSpace(output), nil
+}
+
+// 创建工作空间仓库所在平台的客户端，令牌取自匹配API主机的https凭据（没有时匿名访问）。
+// 仓库地址为http时令牌会以明文发送，此时拒绝使用凭据
+func (oem *OnlineEditorManager) workspaceGitForge(workspaceID string, authRequest GitAuthRequest) (GitForge, string, error) {
+	repoURL, err := oem.workspaceGitRepo(workspaceID)
+	if err != nil {
+		return nil, "", err
+	}
+	repo, err := parseGitForgeRepo(repoURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := ""
+	credential, err := oem.gitCredentials.Find(authRequest, "https://"+repo.Hostname+"/")
+	if err != nil {
+		return nil, "", err
+	}
+	if credential != nil {
+		if credential.Type != "https" {
+			return nil, "", invalidGitInput("访问代码托管平台需要https类型的凭据")
+		}
+		if repo.Scheme != "https" {
+			return nil, "", invalidGitInput("仓库地址使用%s协议，拒绝以明文发送访问令牌", repo.Scheme)
+		}
+		if token, err = oem.gitCredentials.decrypt(credential.ID, credential.Secret); err != nil {
+			return nil, "", err
+		}
+	}
+
+	forge, err := newGitForge(repoURL, token)
+	if err != nil {
+		return nil, "", err
+	}
+	return forge, repoURL, nil
+}
+
+func (oem *OnlineEditorManager) GitListPullRequests(workspaceID, state string, authRequest GitAuthRequest) ([]PullRequest, error) {
+	switch state {
+	case "":
+		state = "open"
+	case "open", "closed", "merged", "all":
+	default:
+		return nil, invalidGitInput("未知的状态: %s", state)
+	}
+
+	forge, _, err := oem.workspaceGitForge(workspaceID, authRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitForgeTimeout)
+	defer cancel()
+	return forge.ListPullRequests(ctx, state)
+}
+
+// 从当前分支（或req.Head）创建拉取请求
+func (oem *OnlineEditorManager) GitCreatePullRequest(workspaceID string, req PullRequestRequest, authRequest GitAuthRequest) (*PullRequest, error) {
+	if strings.TrimSpace(req.Title) == "" {
+		return nil, invalidGitInput("缺少标题")
+	}
+
+	if req.Head == "" {
+		status, err := oem.GitStatusInfo(workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		if status.Detached || status.Branch == "" {
+			return nil, invalidGitInput("当前处于分离头指针状态，请指定源分支")
+		}
+		req.Head = status.Branch
+	}
+	if err := validateGitRevision(req.Head); err != nil {
+		return nil, err
+	}
+
+	if req.Base == "" {
+		// origin/HEAD指向远程仓库的默认分支
+		output, err := oem.gitOutput(workspaceID, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+		if err != nil {
+			return nil, invalidGitInput("无法确定远程仓库的默认分支，请指定目标分支")
+		}
+		req.Base = strings.TrimPrefix(strings.TrimSpace(output), "origin/")
+	}
+
+	forge, _, err := oem.workspaceGitForge(workspaceID, authRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Push {
+		auth, err := oem.gitAuthForRemote(workspaceID, "origin", authRequest)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), gitNetworkTimeout)
+		_, err = oem.runGit(ctx, workspaceID, auth, "push", "--set-upstream", "--", "origin", "refs/heads/"+req.Head)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitForgeTimeout)
+	defer cancel()
+	return forge.CreatePullRequest(ctx, req)
+}
+
+// 检出结果
+type PullRequestCheckout struct {
+	PullRequest *PullRequest `json:"pull_request"`
+	Branch      string       `json:"branch"`
+	Output      string       `json:"output"`
+}
+
+// 把拉取请求的提交取到本地分支pr/<编号>并检出，分支已存在时快进到最新提交
+func (oem *OnlineEditorManager) GitCheckoutPullRequest(workspaceID string, number int, authRequest GitAuthRequest) (*PullRequestCheckout, error) {
+	if number <= 0 {
+		return nil, invalidGitInput("无效的拉取请求编号: %d", number)
+	}
+
+	forge, repoURL, err := oem.workspaceGitForge(workspaceID, authRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateGitURL(repoURL); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitForgeTimeout)
+	pull, err := forge.GetPullRequest(ctx, number)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := oem.gitAuthForURL(authRequest, repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), gitNetworkTimeout)
+	defer cancel()
+
+	output := &strings.Builder{}
+	fetch, err := oem.runGit(ctx, workspaceID, auth, "fetch", "--", repoURL, forge.PullRequestRef(number))
+	if err != nil {
+		return nil, err
+	}
+	output.WriteString(fetch.Stderr)
+
+	branch := fmt.Sprintf("pr/%d", number)
+	var steps [][]string
+	if _, err := oem.runGit(ctx, workspaceID, nil, "rev-parse", "-q", "--verify", "refs/heads/"+branch); err == nil {
+		steps = [][]string{{"checkout", branch, "--"}, {"merge", "--ff-only", "FETCH_HEAD"}}
+	} else {
+		steps = [][]string{{"checkout", "-b", branch, "FETCH_HEAD", "--"}}
+	}
+	for _, args := range steps {
+		result, err := oem.runGit(ctx, workspaceID, nil, args...)
+		if err != nil {
+			return nil, err
+		}
+		output.WriteString(result.Stdout + result.Stderr)
+	}
+
+	return &PullRequestCheckout{PullRequest: pull, Branch: branch, Output: output.String()}, nil
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/git/pulls?state=open|closed|merged|all
+func (oem *OnlineEditorManager) handleGitListPullRequests(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+
+	authRequest := GitAuthRequest{UserID: requestUserID(r), CredentialID: query.Get("credential_id")}
+	pulls, err := oem.GitListPullRequests(workspaceID, query.Get("state"), authRequest)
+	writeGitResponse(w, pulls, err)
+}
+
+func (oem *OnlineEditorManager) handleGitCreatePullRequest(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req PullRequestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authRequest := GitAuthRequest{UserID: requestUserID(r), CredentialID: req.CredentialID}
+	pull, err := oem.GitCreatePullRequest(workspaceID, req, authRequest)
+	writeGitResponse(w, pull, err)
+}
+
+func (oem *OnlineEditorManager) handleGitCheckoutPullRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	number, err := strconv.Atoi(vars["number"])
+	if err != nil {
+		http.Error(w, "无效的拉取请求编号", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		CredentialID string `json:"credential_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	authRequest := GitAuthRequest{UserID: requestUserID(r), CredentialID: req.CredentialID}
+	result, err := oem.GitCheckoutPullRequest(vars["id"], number, authRequest)
+	writeGitResponse(w, result, err)
+}
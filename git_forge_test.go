@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动替身服务器，并通过GIT_FORGES把它的地址注册为指定类型的平台
+func newTestGitForge(t *testing.T, kind, token string, handler http.HandlerFunc) GitForge {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	t.Setenv("GIT_FORGES", u.Host+"="+kind)
+	forge, err := newGitForge(server.URL+"/owner/repo.git", token)
+	if err != nil {
+		t.Fatalf("newGitForge 返回错误: %v", err)
+	}
+	return forge
+}
+
+func writeTestJSON(t *testing.T, w http.ResponseWriter, status int, value interface{}) {
+	t.Helper()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		t.Error(err)
+	}
+}
+
+func githubTestPull(number int, state string, merged bool) map[string]interface{} {
+	pull := map[string]interface{}{
+		"number":     number,
+		"title":      fmt.Sprintf("PR %d", number),
+		"state":      state,
+		"html_url":   fmt.Sprintf("https://example.com/owner/repo/pull/%d", number),
+		"user":       map[string]string{"login": "alice"},
+		"head":       map[string]string{"ref": "feature", "sha": "abc123"},
+		"base":       map[string]string{"ref": "main"},
+		"created_at": "2024-05-01T10:00:00Z",
+		"updated_at": "2024-05-02T10:00:00Z",
+	}
+	if merged {
+		pull["merged_at"] = "2024-05-02T10:00:00Z"
+	}
+	return pull
+}
+
+func TestGitHubForgeListPullRequests(t *testing.T) {
+	forge := newTestGitForge(t, "github", "tok", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/repos/owner/repo/pulls" {
+			t.Errorf("请求路径 = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		query := r.URL.Query()
+		if query.Get("state") != "open" || query.Get("per_page") != "50" {
+			t.Errorf("查询参数 = %s", r.URL.RawQuery)
+		}
+		writeTestJSON(t, w, http.StatusOK, []interface{}{githubTestPull(1, "open", false), githubTestPull(2, "open", false)})
+	})
+
+	pulls, err := forge.ListPullRequests(context.Background(), "open")
+	if err != nil {
+		t.Fatalf("ListPullRequests 返回错误: %v", err)
+	}
+	if len(pulls) != 2 {
+		t.Fatalf("数量 = %d, 期望 2", len(pulls))
+	}
+	want := PullRequest{
+		Number:  1,
+		Title:   "PR 1",
+		State:   "open",
+		Author:  "alice",
+		Head:    "feature",
+		HeadSHA: "abc123",
+		Base:    "main",
+		URL:     "https://example.com/owner/repo/pull/1",
+		Created: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Updated: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC),
+	}
+	if pulls[0] != want {
+		t.Fatalf("拉取请求 = %+v, 期望 %+v", pulls[0], want)
+	}
+}
+
+func TestGitHubForgeMergedPaginates(t *testing.T) {
+	var pages []string
+	forge := newTestGitForge(t, "github", "", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != "closed" {
+			t.Errorf("state = %s, 期望 closed", query.Get("state"))
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("匿名访问不应发送Authorization")
+		}
+		page := query.Get("page")
+		pages = append(pages, page)
+
+		// 第一页全是未合并就关闭的，合并的在第二页
+		var items []interface{}
+		switch page {
+		case "1":
+			for i := 1; i <= gitForgePageSize; i++ {
+				items = append(items, githubTestPull(i, "closed", false))
+			}
+		case "2":
+			items = append(items, githubTestPull(100, "closed", true), githubTestPull(101, "closed", false))
+		}
+		writeTestJSON(t, w, http.StatusOK, items)
+	})
+
+	merged, err := forge.ListPullRequests(context.Background(), "merged")
+	if err != nil {
+		t.Fatalf("ListPullRequests 返回错误: %v", err)
+	}
+	if len(merged) != 1 || merged[0].Number != 100 || merged[0].State != "merged" {
+		t.Fatalf("已合并的拉取请求 = %+v", merged)
+	}
+	if strings.Join(pages, ",") != "1,2" {
+		t.Fatalf("请求的页 = %v, 期望 1,2", pages)
+	}
+
+	pages = nil
+	closed, err := forge.ListPullRequests(context.Background(), "closed")
+	if err != nil {
+		t.Fatalf("ListPullRequests 返回错误: %v", err)
+	}
+	if len(closed) != gitForgePageSize {
+		t.Fatalf("已关闭数量 = %d, 期望 %d", len(closed), gitForgePageSize)
+	}
+	for _, pull := range closed {
+		if pull.State != "closed" {
+			t.Fatalf("closed列表中不应包含 %+v", pull)
+		}
+	}
+}
+
+func TestGitHubForgeGetAndCreate(t *testing.T) {
+	forge := newTestGitForge(t, "github", "tok", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/repos/owner/repo/pulls/7":
+			pull := githubTestPull(7, "closed", false)
+			pull["merged"] = true
+			writeTestJSON(t, w, http.StatusOK, pull)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/repos/owner/repo/pulls":
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+				return
+			}
+			if body["title"] != "新功能" || body["head"] != "feature" || body["base"] != "main" || body["draft"] != true {
+				t.Errorf("请求体 = %v", body)
+			}
+			pull := githubTestPull(8, "open", false)
+			pull["draft"] = true
+			writeTestJSON(t, w, http.StatusCreated, pull)
+		default:
+			writeTestJSON(t, w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		}
+	})
+
+	pull, err := forge.GetPullRequest(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("GetPullRequest 返回错误: %v", err)
+	}
+	if pull.Number != 7 || pull.State != "merged" {
+		t.Fatalf("拉取请求 = %+v", pull)
+	}
+
+	created, err := forge.CreatePullRequest(context.Background(), PullRequestRequest{Title: "新功能", Head: "feature", Base: "main", Draft: true})
+	if err != nil {
+		t.Fatalf("CreatePullRequest 返回错误: %v", err)
+	}
+	if created.Number != 8 || !created.Draft || created.State != "open" {
+		t.Fatalf("创建结果 = %+v", created)
+	}
+
+	_, err = forge.GetPullRequest(context.Background(), 9)
+	forgeErr, ok := err.(*GitForgeError)
+	if !ok || forgeErr.StatusCode != http.StatusNotFound || forgeErr.Message != "Not Found" {
+		t.Fatalf("错误 = %v, 期望404的GitForgeError", err)
+	}
+	if ref := forge.PullRequestRef(7); ref != "refs/pull/7/head" {
+		t.Fatalf("PullRequestRef = %s", ref)
+	}
+}
+
+func TestGiteaForge(t *testing.T) {
+	forge := newTestGitForge(t, "gitea", "tok", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "token tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/pulls":
+			if r.URL.Query().Get("limit") != "50" {
+				t.Errorf("查询参数 = %s", r.URL.RawQuery)
+			}
+			writeTestJSON(t, w, http.StatusOK, []interface{}{
+				githubTestPull(1, "closed", true),
+				githubTestPull(2, "closed", false),
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/pulls/3":
+			writeTestJSON(t, w, http.StatusOK, githubTestPull(3, "open", false))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/pulls":
+			writeTestJSON(t, w, http.StatusUnprocessableEntity, map[string]string{"message": "pull request already exists"})
+		default:
+			t.Errorf("意外的请求: %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	pulls, err := forge.ListPullRequests(context.Background(), "all")
+	if err != nil {
+		t.Fatalf("ListPullRequests 返回错误: %v", err)
+	}
+	if len(pulls) != 2 || pulls[0].State != "merged" || pulls[1].State != "closed" {
+		t.Fatalf("拉取请求 = %+v", pulls)
+	}
+
+	pull, err := forge.GetPullRequest(context.Background(), 3)
+	if err != nil || pull.Number != 3 || pull.State != "open" {
+		t.Fatalf("GetPullRequest = %+v, %v", pull, err)
+	}
+
+	_, err = forge.CreatePullRequest(context.Background(), PullRequestRequest{Title: "t", Head: "feature", Base: "main"})
+	forgeErr, ok := err.(*GitForgeError)
+	if !ok || forgeErr.StatusCode != http.StatusUnprocessableEntity || forgeErr.Message != "pull request already exists" {
+		t.Fatalf("错误 = %v, 期望422的GitForgeError", err)
+	}
+}
+
+func gitlabTestMergeRequest(iid int, state string) map[string]interface{} {
+	return map[string]interface{}{
+		"iid":           iid,
+		"title":         fmt.Sprintf("MR %d", iid),
+		"description":   "说明",
+		"state":         state,
+		"web_url":       fmt.Sprintf("https://example.com/owner/repo/-/merge_requests/%d", iid),
+		"author":        map[string]string{"username": "bob"},
+		"source_branch": "feature",
+		"target_branch": "main",
+		"sha":           "def456",
+		"created_at":    "2024-05-01T10:00:00Z",
+		"updated_at":    "2024-05-02T10:00:00Z",
+	}
+}
+
+func TestGitLabForge(t *testing.T) {
+	const api = "/api/v4/projects/owner%2Frepo/merge_requests"
+	forge := newTestGitForge(t, "gitlab", "tok", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("PRIVATE-TOKEN"); got != "tok" {
+			t.Errorf("PRIVATE-TOKEN = %q", got)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.EscapedPath() == api:
+			if r.URL.Query().Get("state") != "opened" {
+				t.Errorf("state = %s, 期望 opened", r.URL.Query().Get("state"))
+			}
+			writeTestJSON(t, w, http.StatusOK, []interface{}{gitlabTestMergeRequest(1, "opened")})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.EscapedPath(), api+"/"):
+			iid, _ := strconv.Atoi(strings.TrimPrefix(r.URL.EscapedPath(), api+"/"))
+			states := map[int]string{2: "merged", 3: "locked", 4: "closed"}
+			writeTestJSON(t, w, http.StatusOK, gitlabTestMergeRequest(iid, states[iid]))
+		case r.Method == http.MethodPost && r.URL.EscapedPath() == api:
+			var body map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+				return
+			}
+			if body["title"] != "Draft: 新功能" || body["source_branch"] != "feature" || body["target_branch"] != "main" {
+				t.Errorf("请求体 = %v", body)
+			}
+			writeTestJSON(t, w, http.StatusCreated, gitlabTestMergeRequest(5, "opened"))
+		default:
+			t.Errorf("意外的请求: %s %s", r.Method, r.URL.EscapedPath())
+		}
+	})
+
+	pulls, err := forge.ListPullRequests(context.Background(), "open")
+	if err != nil {
+		t.Fatalf("ListPullRequests 返回错误: %v", err)
+	}
+	if len(pulls) != 1 || pulls[0].Number != 1 || pulls[0].State != "open" || pulls[0].Author != "bob" || pulls[0].Body != "说明" {
+		t.Fatalf("合并请求 = %+v", pulls)
+	}
+
+	for iid, want := range map[int]string{2: "merged", 3: "closed", 4: "closed"} {
+		pull, err := forge.GetPullRequest(context.Background(), iid)
+		if err != nil {
+			t.Fatalf("GetPullRequest(%d) 返回错误: %v", iid, err)
+		}
+		if pull.State != want {
+			t.Errorf("GetPullRequest(%d).State = %s, 期望 %s", iid, pull.State, want)
+		}
+	}
+
+	created, err := forge.CreatePullRequest(context.Background(), PullRequestRequest{Title: "新功能", Head: "feature", Base: "main", Draft: true})
+	if err != nil || created.Number != 5 {
+		t.Fatalf("CreatePullRequest = %+v, %v", created, err)
+	}
+	if ref := forge.PullRequestRef(5); ref != "refs/merge-requests/5/head" {
+		t.Fatalf("PullRequestRef = %s", ref)
+	}
+}
+
+func TestWorkspaceGitForgeRefusesTokenOverHTTP(t *testing.T) {
+	t.Setenv("GIT_FORGES", "git.example.com=gitea")
+	oem := &OnlineEditorManager{
+		workspaces: map[string]*Workspace{"ws_1": {GitRepo: "http://git.example.com/owner/repo.git"}},
+		gitCredentials: &GitCredentialManager{credentials: map[string]*GitCredential{
+			"cred_1": {ID: "cred_1", UserID: "alice", Type: "https", Host: "git.example.com"},
+		}},
+	}
+
+	_, _, err := oem.workspaceGitForge("ws_1", GitAuthRequest{UserID: "alice"})
+	if _, ok := err.(*GitValidationError); !ok {
+		t.Fatalf("错误 = %v, 期望拒绝以http发送令牌", err)
+	}
+
+	// 没有凭据时仍可匿名访问
+	if _, _, err := oem.workspaceGitForge("ws_1", GitAuthRequest{UserID: "bob"}); err != nil {
+		t.Fatalf("匿名访问返回错误: %v", err)
+	}
+}
+
+func TestWriteGitResponseStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{invalidGitInput("无效"), http.StatusBadRequest},
+		{&GitCommandError{Args: []string{"push"}, ExitCode: 1}, http.StatusBadRequest},
+		{&GitForgeError{StatusCode: http.StatusNotFound}, http.StatusNotFound},
+		{&GitForgeError{StatusCode: http.StatusBadRequest}, http.StatusBadRequest},
+		{&GitForgeError{StatusCode: http.StatusConflict}, http.StatusBadRequest},
+		{&GitForgeError{StatusCode: http.StatusUnprocessableEntity}, http.StatusBadRequest},
+		{&GitForgeError{StatusCode: http.StatusUnauthorized}, http.StatusBadGateway},
+		{&GitForgeError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway},
+		{fmt.Errorf("其他错误"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		writeGitResponse(recorder, map[string]string{"ok": "1"}, tt.err)
+		if recorder.Code != tt.want {
+			t.Errorf("writeGitResponse(%v) 状态码 = %d, 期望 %d", tt.err, recorder.Code, tt.want)
+		}
+	}
+}
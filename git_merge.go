@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// 合并冲突处理
+//
+// 三方合并编辑器的服务端部分：列出冲突文件，按暂存区的三个阶段（1=base、2=ours、3=theirs）
+// 返回各版本的内容，接收解决后的内容并暂存，最后继续或中止合并/变基。
+// 注意变基时ours是变基的目标分支，theirs是正在重放的提交，与合并时相反。
+
+// 进行中的操作以及记录对方提交的文件（位于.git目录下）
+var gitInProgressOperations = []struct {
+	Operation string
+	Marker    string // 存在即表示操作进行中
+	Head      string // 对方提交
+}{
+	{"rebase", "rebase-merge", "REBASE_HEAD"},
+	{"rebase", "rebase-apply", "REBASE_HEAD"},
+	{"merge", "MERGE_HEAD", "MERGE_HEAD"},
+	{"cherry-pick", "CHERRY_PICK_HEAD", "CHERRY_PICK_HEAD"},
+	{"revert", "REVERT_HEAD", "REVERT_HEAD"},
+}
+
+// 冲突状态
+type GitConflictState struct {
+	Operation string            `json:"operation"` // merge, rebase, cherry-pick, revert，没有进行中的操作时为空
+	Head      string            `json:"head"`
+	Other     string            `json:"other,omitempty"` // 正在合并/重放的提交
+	Files     []GitConflictFile `json:"files"`
+}
+
+// 冲突文件
+type GitConflictFile struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`   // both-modified, both-added, deleted-by-us, deleted-by-them, added-by-us, added-by-them, both-deleted
+	Stages []int  `json:"stages"` // 暂存区中存在的阶段
+}
+
+// 冲突文件的各个版本
+type GitConflictContent struct {
+	Path    string              `json:"path"`
+	Kind    string              `json:"kind"`
+	Base    *GitConflictVersion `json:"base"`
+	Ours    *GitConflictVersion `json:"ours"`
+	Theirs  *GitConflictVersion `json:"theirs"`
+	Working *string             `json:"working"` // 工作区中带冲突标记的内容，文件不存在时为null
+}
+
+type GitConflictVersion struct {
+	Mode    string `json:"mode"`
+	Object  string `json:"object"`
+	Content string `json:"content"`
+	Binary  bool   `json:"binary"` // 二进制文件不返回内容
+}
+
+// git ls-files -u中的一条记录
+type gitUnmergedEntry struct {
+	Mode   string
+	Object string
+	Stage  int
+}
+
+// 冲突类型，按存在的阶段判断
+var gitConflictKinds = map[string]string{
+	"123": "both-modified",
+	"23":  "both-added",
+	"12":  "deleted-by-them",
+	"13":  "deleted-by-us",
+	"2":   "added-by-us",
+	"3":   "added-by-them",
+	"1":   "both-deleted",
+}
+
+// 解决冲突时可以直接采用的版本对应的阶段
+var gitConflictSides = map[string]int{
+	"base":   1,
+	"ours":   2,
+	"theirs": 3,
+}
+
+// 读取暂存区中未合并的条目，按路径分组
+func (oem *OnlineEditorManager) gitUnmergedEntries(workspaceID string) (map[string]map[int]gitUnmergedEntry, error) {
+	output, err := oem.gitOutput(workspaceID, "ls-files", "--unmerged", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return parseGitUnmerged(output), nil
+}
+
+// 解析git ls-files -u -z的输出：<mode> <object> <stage>\t<path>
+func parseGitUnmerged(output string) map[string]map[int]gitUnmergedEntry {
+	entries := map[string]map[int]gitUnmergedEntry{}
+	for _, record := range splitNul(output) {
+		info, filePath, ok := strings.Cut(record, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		stage, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if entries[filePath] == nil {
+			entries[filePath] = map[int]gitUnmergedEntry{}
+		}
+		entries[filePath][stage] = gitUnmergedEntry{Mode: fields[0], Object: fields[1], Stage: stage}
+	}
+	return entries
+}
+
+func newGitConflictFile(filePath string, stages map[int]gitUnmergedEntry) GitConflictFile {
+	file := GitConflictFile{Path: filePath, Stages: []int{}}
+	key := ""
+	for stage := 1; stage <= 3; stage++ {
+		if _, ok := stages[stage]; ok {
+			file.Stages = append(file.Stages, stage)
+			key += strconv.Itoa(stage)
+		}
+	}
+	file.Kind = gitConflictKinds[key]
+	return file
+}
+
+// 检测进行中的合并、变基等操作，返回操作名和对方提交
+func (oem *OnlineEditorManager) gitInProgressOperation(workspaceID string) (operation, other string, err error) {
+	args := []string{"rev-parse"}
+	for _, op := range gitInProgressOperations {
+		args = append(args, "--git-path", op.Marker, "--git-path", op.Head)
+	}
+	output, err := oem.gitOutput(workspaceID, args...)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 输出的路径相对于/workspace（或是/workspace下的绝对路径），在宿主机上检查
+	paths := strings.Split(strings.TrimSpace(output), "\n")
+	if len(paths) != 2*len(gitInProgressOperations) {
+		return "", "", nil
+	}
+	for i, op := range gitInProgressOperations {
+		markerPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, gitPathspec(paths[2*i]))
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(markerPath); err != nil {
+			continue
+		}
+
+		if headPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, gitPathspec(paths[2*i+1])); err == nil {
+			if content, err := os.ReadFile(headPath); err == nil {
+				other, _, _ = strings.Cut(strings.TrimSpace(string(content)), "\n")
+			}
+		}
+		return op.Operation, other, nil
+	}
+	return "", "", nil
+}
+
+func (oem *OnlineEditorManager) GitConflicts(workspaceID string) (*GitConflictState, error) {
+	operation, other, err := oem.gitInProgressOperation(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := oem.gitUnmergedEntries(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &GitConflictState{Operation: operation, Other: other, Files: []GitConflictFile{}}
+	if head, err := oem.gitOutput(workspaceID, "rev-parse", "-q", "--verify", "HEAD"); err == nil {
+		state.Head = strings.TrimSpace(head)
+	}
+
+	for filePath, stages := range entries {
+		state.Files = append(state.Files, newGitConflictFile(filePath, stages))
+	}
+	sort.Slice(state.Files, func(i, j int) bool { return state.Files[i].Path < state.Files[j].Path })
+	return state, nil
+}
+
+// 读取冲突文件的base、ours、theirs和工作区版本
+func (oem *OnlineEditorManager) GitConflictContent(workspaceID, filePath string) (*GitConflictContent, error) {
+	if filePath == "" {
+		return nil, invalidGitInput("缺少文件路径")
+	}
+	filePath = gitPathspec(filePath)
+
+	entries, err := oem.gitUnmergedEntries(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	stages, ok := entries[filePath]
+	if !ok {
+		return nil, invalidGitInput("文件没有冲突: %s", filePath)
+	}
+
+	result := &GitConflictContent{Path: filePath, Kind: newGitConflictFile(filePath, stages).Kind}
+	versions := map[int]**GitConflictVersion{1: &result.Base, 2: &result.Ours, 3: &result.Theirs}
+	for stage, entry := range stages {
+		target, ok := versions[stage]
+		if !ok {
+			continue
+		}
+		content, err := oem.gitOutput(workspaceID, "cat-file", "blob", entry.Object)
+		if err != nil {
+			return nil, err
+		}
+		version := &GitConflictVersion{Mode: entry.Mode, Object: entry.Object}
+		if strings.Contains(content, "\x00") || !utf8.ValidString(content) {
+			version.Binary = true
+		} else {
+			version.Content = content
+		}
+		*target = version
+	}
+
+	if fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath); err == nil {
+		if content, err := os.ReadFile(fullPath); err == nil {
+			working := string(content)
+			result.Working = &working
+		}
+	}
+	return result, nil
+}
+
+// 解决冲突并暂存，三种方式只能选一种：
+// content不为nil时写入解决后的内容；side为base/ours/theirs时直接采用该版本（该版本不存在即删除文件）；
+// remove为true时删除文件
+func (oem *OnlineEditorManager) GitResolveConflict(workspaceID, filePath string, content *string, side string, remove bool) error {
+	if filePath == "" {
+		return invalidGitInput("缺少文件路径")
+	}
+	filePath = gitPathspec(filePath)
+
+	choices := 0
+	for _, chosen := range []bool{content != nil, side != "", remove} {
+		if chosen {
+			choices++
+		}
+	}
+	if choices != 1 {
+		return invalidGitInput("content、side、delete必须且只能指定一个")
+	}
+
+	entries, err := oem.gitUnmergedEntries(workspaceID)
+	if err != nil {
+		return err
+	}
+	stages, ok := entries[filePath]
+	if !ok {
+		return invalidGitInput("文件没有冲突: %s", filePath)
+	}
+
+	if side != "" {
+		stage, ok := gitConflictSides[side]
+		if !ok {
+			return invalidGitInput("未知的版本: %s", side)
+		}
+		if _, exists := stages[stage]; !exists {
+			remove = true
+		} else if _, err := oem.gitOutput(workspaceID, "checkout-index", "-f", "--stage="+strconv.Itoa(stage), "--", filePath); err != nil {
+			return err
+		}
+	}
+
+	if remove {
+		_, err := oem.gitOutput(workspaceID, "rm", "-q", "-f", "--", filePath)
+		return err
+	}
+
+	if content != nil {
+		if err := oem.WriteFile(workspaceID, filePath, *content); err != nil {
+			return err
+		}
+	}
+	_, err = oem.gitOutput(workspaceID, "add", "--", filePath)
+	return err
+}
+
+// 所有冲突解决后继续合并/变基，变基时后续提交可能再次产生冲突
+func (oem *OnlineEditorManager) GitContinueOperation(workspaceID, message string) (*GitCommandResult, error) {
+	operation, _, err := oem.gitInProgressOperation(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if operation == "" {
+		return nil, invalidGitInput("当前没有进行中的合并或变基")
+	}
+
+	conflicts, err := oem.gitConflictedFiles(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return &GitCommandResult{Output: "仍有未解决的冲突", Conflicts: conflicts}, nil
+	}
+
+	if operation == "merge" {
+		args := []string{"commit", "--no-edit"}
+		if message != "" {
+			args = []string{"commit", "-m", message}
+		}
+		output, err := oem.gitOutput(workspaceID, args...)
+		if err != nil {
+			return nil, err
+		}
+		return &GitCommandResult{Success: true, Output: output}, nil
+	}
+	return oem.gitConflictingCommand(workspaceID, operation, "--continue")
+}
+
+// 中止合并/变基，恢复到操作之前的状态
+func (oem *OnlineEditorManager) GitAbortOperation(workspaceID string) error {
+	operation, _, err := oem.gitInProgressOperation(workspaceID)
+	if err != nil {
+		return err
+	}
+	if operation == "" {
+		return invalidGitInput("当前没有进行中的合并或变基")
+	}
+
+	_, err = oem.gitOutput(workspaceID, operation, "--abort")
+	return err
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleGitConflicts(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	state, err := oem.GitConflicts(workspaceID)
+	writeGitResponse(w, state, err)
+}
+
+// GET /workspaces/{id}/git/conflicts/file?path=
+func (oem *OnlineEditorManager) handleGitConflictContent(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	content, err := oem.GitConflictContent(workspaceID, r.URL.Query().Get("path"))
+	writeGitResponse(w, content, err)
+}
+
+func (oem *OnlineEditorManager) handleGitResolveConflict(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Path    string  `json:"path"`
+		Content *string `json:"content"`
+		Side    string  `json:"side"` // base, ours, theirs
+		Delete  bool    `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := oem.GitResolveConflict(workspaceID, req.Path, req.Content, req.Side, req.Delete)
+	writeGitResponse(w, map[string]string{"status": "resolved"}, err)
+}
+
+func (oem *OnlineEditorManager) handleGitContinueOperation(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Message string `json:"message"` // 合并提交的说明，为空时使用git生成的默认说明
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := oem.GitContinueOperation(workspaceID, req.Message)
+	writeGitCommandResult(w, result, err)
+}
+
+func (oem *OnlineEditorManager) handleGitAbortOperation(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	err := oem.GitAbortOperation(workspaceID)
+	writeGitResponse(w, map[string]string{"status": "aborted"}, err)
+}
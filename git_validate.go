@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Git参数校验
+//
+// 所有git命令都以argv形式执行，不经过shell，但用户输入仍可能被git当作选项
+// （如以"-"开头的分支名"--upload-pack=..."）或危险的传输协议（ext::、file://）。
+// 引用名用git check-ref-format校验，修订号和远程地址在Go中校验。
+
+// 默认允许的远程仓库协议，可通过GIT_ALLOWED_SCHEMES（逗号分隔）覆盖
+const defaultGitAllowedSchemes = "https,http,ssh"
+
+// 允许的远程仓库协议，scp风格的地址（user@host:path）视为ssh
+func gitAllowedSchemes() []string {
+	value := os.Getenv("GIT_ALLOWED_SCHEMES")
+	if value == "" {
+		value = defaultGitAllowedSchemes
+	}
+
+	schemes := []string{}
+	for _, scheme := range strings.Split(value, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+// 传给git的GIT_ALLOW_PROTOCOL，子模块等间接访问的地址也受同样的限制
+func gitAllowProtocolEnv() string {
+	return "GIT_ALLOW_PROTOCOL=" + strings.Join(gitAllowedSchemes(), ":")
+}
+
+// 校验远程仓库地址
+func validateGitURL(remoteURL string) error {
+	if remoteURL == "" {
+		return invalidGitInput("缺少仓库地址")
+	}
+	if strings.HasPrefix(remoteURL, "-") || strings.IndexFunc(remoteURL, unicode.IsControl) >= 0 || strings.ContainsAny(remoteURL, " \t") {
+		return invalidGitInput("无效的仓库地址: %s", remoteURL)
+	}
+
+	scheme := ""
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil || u.Host == "" {
+			return invalidGitInput("无效的仓库地址: %s", remoteURL)
+		}
+		scheme = strings.ToLower(u.Scheme)
+	} else if strings.Contains(remoteURL, "::") {
+		// <transport>::<address>形式（如ext::）
+		scheme, _, _ = strings.Cut(remoteURL, "::")
+		scheme = strings.ToLower(scheme)
+	} else if _, host := parseGitRemoteHost(remoteURL); host != "" {
+		scheme = "ssh"
+	} else {
+		return invalidGitInput("不允许使用本地路径作为仓库地址: %s", remoteURL)
+	}
+
+	for _, allowed := range gitAllowedSchemes() {
+		if scheme == allowed {
+			return nil
+		}
+	}
+	return invalidGitInput("不允许的仓库协议: %s（允许: %s）", scheme, strings.Join(gitAllowedSchemes(), ", "))
+}
+
+// 校验修订号（分支、标签、提交哈希、HEAD~1等），只做语法检查：
+// 不能为空、不能以"-"开头（会被当作选项）、不能包含空白和控制字符
+func validateGitRevision(rev string) error {
+	if rev == "" {
+		return invalidGitInput("缺少引用")
+	}
+	if strings.HasPrefix(rev, "-") || strings.IndexFunc(rev, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) >= 0 {
+		return invalidGitInput("无效的引用: %q", rev)
+	}
+	return nil
+}
+
+// 校验将要创建的引用名
+// kind: branch（分支名）、tag（标签名）、remote（远程仓库名）
+func (oem *OnlineEditorManager) validateGitRefName(workspaceID, kind, name string) error {
+	if err := validateGitRevision(name); err != nil {
+		return err
+	}
+
+	var args []string
+	switch kind {
+	case "branch":
+		args = []string{"check-ref-format", "--branch", name}
+	case "tag":
+		args = []string{"check-ref-format", "refs/tags/" + name}
+	case "remote":
+		args = []string{"check-ref-format", "refs/remotes/" + name + "/HEAD"}
+	default:
+		return fmt.Errorf("未知的引用类型: %s", kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+
+	result, err := oem.execInWorkspace(ctx, workspaceID, append([]string{"git"}, args...), "/", nil)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return invalidGitInput("无效的%s名称: %s", gitRefKindNames[kind], name)
+	}
+	return nil
+}
+
+// 用户输入校验失败
+type GitValidationError struct {
+	Message string
+}
+
+func (e *GitValidationError) Error() string {
+	return e.Message
+}
+
+func invalidGitInput(format string, args ...interface{}) error {
+	return &GitValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+var gitRefKindNames = map[string]string{
+	"branch": "分支",
+	"tag":    "标签",
+	"remote": "远程仓库",
+}
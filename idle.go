@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 空闲自动停止与自动启动
+//
+// 活动来源：终端输入输出（TerminalSession.LastActivity()）、语言服务器和调试会话、
+// 文件/执行/Git等API调用、预览代理的HTTP请求和端口转发连接。
+// 运行中的工作空间超过WORKSPACE_IDLE_TIMEOUT（默认30m，0表示不自动停止）没有活动时自动停止。
+// 自动停止的工作空间在下一次API调用、预览请求或终端连接时自动启动，请求等待容器就绪后继续处理。
+// 活动时间和自动停止标记保存在workspace_activity.json中，重启后保留。
+
+const (
+	defaultIdleTimeout       = 30 * time.Minute
+	idleCheckInterval        = time.Minute
+	workspaceWakeTimeout     = 2 * time.Minute
+	workspaceWakeCheckPeriod = 500 * time.Millisecond
+)
+
+type activityRecord struct {
+	LastActivity time.Time `json:"last_activity"`
+	AutoStopped  bool      `json:"auto_stopped,omitempty"`
+}
+
+// 正在进行的自动启动，同一工作空间的并发请求共用一次启动
+type wakeTask struct {
+	done chan struct{}
+	err  error
+}
+
+type activityTracker struct {
+	file    string
+	timeout time.Duration
+	last    map[string]time.Time
+	waking  map[string]*wakeTask
+	saved   map[string]activityRecord // 启动时读取的记录，恢复工作空间后应用
+	mutex   sync.Mutex
+}
+
+func newActivityTracker(baseDir string) *activityTracker {
+	tracker := &activityTracker{
+		file:    filepath.Join(baseDir, "workspace_activity.json"),
+		timeout: idleTimeout(),
+		last:    make(map[string]time.Time),
+		waking:  make(map[string]*wakeTask),
+		saved:   make(map[string]activityRecord),
+	}
+
+	if data, err := os.ReadFile(tracker.file); err == nil {
+		if err := json.Unmarshal(data, &tracker.saved); err != nil {
+			log.Printf("读取工作空间活动记录失败: %v", err)
+		}
+	}
+	for workspaceID, record := range tracker.saved {
+		tracker.last[workspaceID] = record.LastActivity
+	}
+	return tracker
+}
+
+func idleTimeout() time.Duration {
+	value := os.Getenv("WORKSPACE_IDLE_TIMEOUT")
+	if value == "" {
+		return defaultIdleTimeout
+	}
+	if value == "0" {
+		return 0
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < time.Minute {
+		log.Printf("无效的WORKSPACE_IDLE_TIMEOUT: %s，使用默认值 %v", value, defaultIdleTimeout)
+		return defaultIdleTimeout
+	}
+	return timeout
+}
+
+// 记录工作空间活动
+func (oem *OnlineEditorManager) recordActivity(workspaceID string) {
+	oem.activity.mutex.Lock()
+	oem.activity.last[workspaceID] = time.Now()
+	oem.activity.mutex.Unlock()
+}
+
+// 工作空间最近一次活动的时间，综合API调用、终端和stdio会话。调用者不能持有oem.mutex
+func (oem *OnlineEditorManager) lastActivity(workspaceID string) time.Time {
+	oem.activity.mutex.Lock()
+	last := oem.activity.last[workspaceID]
+	oem.activity.mutex.Unlock()
+
+	later := func(t time.Time) {
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	oem.mutex.RLock()
+	if workspace, exists := oem.workspaces[workspaceID]; exists && workspace.Started != nil {
+		later(*workspace.Started)
+	}
+	for _, session := range oem.terminalSessions {
+		if session.WorkspaceID == workspaceID {
+			later(session.LastActivity())
+		}
+	}
+	oem.mutex.RUnlock()
+
+	oem.stdioMutex.Lock()
+	for _, session := range oem.stdioSessions {
+		if session.WorkspaceID == workspaceID {
+			later(session.LastActivity())
+		}
+	}
+	oem.stdioMutex.Unlock()
+
+	return last
+}
+
+// 保存活动时间和自动停止标记
+func (oem *OnlineEditorManager) saveActivity() {
+	records := make(map[string]activityRecord)
+
+	oem.mutex.RLock()
+	oem.activity.mutex.Lock()
+	for workspaceID, workspace := range oem.workspaces {
+		records[workspaceID] = activityRecord{
+			LastActivity: oem.activity.last[workspaceID],
+			AutoStopped:  workspace.AutoStopped,
+		}
+	}
+	oem.activity.mutex.Unlock()
+	oem.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		err = os.WriteFile(oem.activity.file, data, 0644)
+	}
+	if err != nil {
+		log.Printf("保存工作空间活动记录失败: %v", err)
+	}
+}
+
+// 停止超过空闲时间的工作空间
+func (oem *OnlineEditorManager) stopIdleWorkspaces() {
+	timeout := oem.activity.timeout
+	if timeout <= 0 {
+		return
+	}
+
+	var running []string
+	oem.mutex.RLock()
+	for workspaceID, workspace := range oem.workspaces {
+		if workspace.Status == "running" {
+			running = append(running, workspaceID)
+		}
+	}
+	oem.mutex.RUnlock()
+
+	for _, workspaceID := range running {
+		idle := time.Since(oem.lastActivity(workspaceID))
+		if idle < timeout {
+			continue
+		}
+		// 正在克隆仓库的工作空间不算空闲
+		if task := oem.gitCloneTask(workspaceID); task != nil {
+			if progress, _ := task.snapshot(); progress.Status == "cloning" {
+				continue
+			}
+		}
+
+		log.Printf("[%s] 工作空间空闲 %v，自动停止", workspaceID, idle.Round(time.Second))
+		if err := oem.stopWorkspace(workspaceID, true); err != nil {
+			log.Printf("[%s] 自动停止失败: %v", workspaceID, err)
+		}
+	}
+}
+
+// 启动空闲检测任务
+func (oem *OnlineEditorManager) StartIdleMonitor() {
+	// 恢复重启前的自动停止标记
+	oem.mutex.Lock()
+	for workspaceID, record := range oem.activity.saved {
+		if workspace, exists := oem.workspaces[workspaceID]; exists && workspace.Status != "running" {
+			workspace.AutoStopped = record.AutoStopped
+		}
+	}
+	oem.mutex.Unlock()
+
+	if oem.activity.timeout > 0 {
+		log.Printf("工作空间空闲 %v 后自动停止", oem.activity.timeout)
+	} else {
+		log.Printf("工作空间空闲自动停止已关闭")
+	}
+
+	go func() {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			oem.stopIdleWorkspaces()
+			oem.saveActivity()
+		}
+	}()
+}
+
+// 自动停止的工作空间在使用时重新启动，等待容器就绪后返回。其他状态直接返回nil
+func (oem *OnlineEditorManager) wakeWorkspace(workspaceID string) error {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	needsStart := exists && workspace.AutoStopped && workspace.Status != "running"
+	oem.activity.mutex.Lock()
+	task, waking := oem.activity.waking[workspaceID]
+	if !waking && needsStart {
+		task = &wakeTask{done: make(chan struct{})}
+		oem.activity.waking[workspaceID] = task
+	}
+	oem.activity.mutex.Unlock()
+	oem.mutex.RUnlock()
+
+	if waking {
+		<-task.done
+		return task.err
+	}
+	if !needsStart {
+		return nil
+	}
+
+	log.Printf("[%s] 自动启动空闲停止的工作空间", workspaceID)
+	task.err = oem.startAndWaitReady(workspaceID)
+	if task.err != nil {
+		log.Printf("[%s] 自动启动失败: %v", workspaceID, task.err)
+		oem.publishWorkspaceError(workspaceID, fmt.Sprintf("自动启动失败: %v", task.err))
+	}
+
+	oem.activity.mutex.Lock()
+	delete(oem.activity.waking, workspaceID)
+	oem.activity.mutex.Unlock()
+	close(task.done)
+
+	oem.recordActivity(workspaceID)
+	oem.saveActivity()
+	return task.err
+}
+
+func (oem *OnlineEditorManager) startAndWaitReady(workspaceID string) error {
+	if err := oem.StartWorkspace(workspaceID); err != nil {
+		if status, statusErr := oem.GetWorkspaceStatus(workspaceID); statusErr != nil || status != "running" {
+			return fmt.Errorf("启动工作空间失败: %v", err)
+		}
+	}
+
+	// 等待容器可以执行命令
+	ctx, cancel := context.WithTimeout(context.Background(), workspaceWakeTimeout)
+	defer cancel()
+	for {
+		result, err := oem.execInWorkspace(ctx, workspaceID, []string{"true"}, "", nil)
+		if err == nil && result.ExitCode == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待工作空间就绪超时")
+		case <-time.After(workspaceWakeCheckPeriod):
+		}
+	}
+}
+
+// 算作用户活动的工作空间API：文件、编辑、终端、执行、Git、语言服务器和调试。
+// 状态查询、端口事件等轮询接口不算，否则工作空间永远不会空闲
+func isActivityRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil || !strings.HasPrefix(template, "/api/v1/workspaces/{id}/") {
+		return false
+	}
+
+	rest := strings.TrimPrefix(template, "/api/v1/workspaces/{id}/")
+	section, _, _ := strings.Cut(rest, "/")
+	switch section {
+	case "files", "format", "lint", "lsp", "debug", "terminal", "exec", "test-port":
+		return true
+	case "git":
+		return !strings.HasPrefix(rest, "git/clone")
+	}
+	return false
+}
+
+// 记录API活动，自动停止的工作空间先启动再处理请求
+func (oem *OnlineEditorManager) workspaceActivityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if workspaceID := mux.Vars(r)["id"]; workspaceID != "" && isActivityRoute(r) {
+			oem.recordActivity(workspaceID)
+			if err := oem.wakeWorkspace(workspaceID); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /workspaces/{id}/activity
+func (oem *OnlineEditorManager) handleGetWorkspaceActivity(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var status string
+	var autoStopped bool
+	if exists {
+		status, autoStopped = workspace.Status, workspace.AutoStopped
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "工作空间不存在", http.StatusNotFound)
+		return
+	}
+
+	last := oem.lastActivity(workspaceID)
+	response := map[string]interface{}{
+		"workspace_id":         workspaceID,
+		"status":               status,
+		"auto_stopped":         autoStopped,
+		"idle_timeout_seconds": int(oem.activity.timeout.Seconds()),
+	}
+	if !last.IsZero() {
+		response["last_activity"] = last
+		response["idle_seconds"] = int(time.Since(last).Seconds())
+		if oem.activity.timeout > 0 && status == "running" {
+			response["auto_stop_at"] = last.Add(oem.activity.timeout)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
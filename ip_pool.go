@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+)
+
+// 共享网络的IP池
+//
+// 共享模式的工作空间连接到受管理的用户自定义网络（WORKSPACE_NETWORK，默认online-editor-net），
+// 子网取自WORKSPACE_SUBNET（默认172.30.0.0/16）。创建工作空间时从子网中分配固定IP，
+// 通过EndpointSettings.IPAMConfig指定给容器，重建容器后IP不变。
+// 网络创建时把子网的后一半设为IPRange，Docker只在其中为其他容器动态分配地址，
+// 固定IP从前一半分配，两者不会冲突。没有IPRange的旧网络在分配前检查网络中已使用的地址。
+// 分配记录保存在ip_allocations.json中，重启后在恢复工作空间时重新占用。
+// WORKSPACE_NETWORK设为bridge时使用Docker默认网络，不分配固定IP。
+// 以下方法（除HTTP处理器外）的调用者必须持有oem.mutex写锁。
+
+const (
+	defaultWorkspaceNetwork = "online-editor-net"
+	defaultWorkspaceSubnet  = "172.30.0.0/16"
+	dockerDefaultNetwork    = "bridge"
+)
+
+// 已分配的IP
+type IPAllocation struct {
+	IP          string    `json:"ip"`
+	WorkspaceID string    `json:"workspace_id"`
+	Network     string    `json:"network"`
+	Allocated   time.Time `json:"allocated"`
+}
+
+// IP池统计
+type IPPoolStats struct {
+	Enabled     bool    `json:"enabled"`
+	Network     string  `json:"network"`
+	Subnet      string  `json:"subnet,omitempty"`
+	Gateway     string  `json:"gateway,omitempty"`
+	Total       int     `json:"total"`
+	Allocated   int     `json:"allocated"`
+	Available   int     `json:"available"`
+	Utilization float64 `json:"utilization"` // 百分比
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func uint32ToIP(value uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, value)
+	return ip
+}
+
+// 网络地址范围的首尾地址
+func ipNetBounds(subnet *net.IPNet) (first, last uint32) {
+	ones, bits := subnet.Mask.Size()
+	first = ipToUint32(subnet.IP.Mask(subnet.Mask))
+	return first, first + uint32(1)<<uint(bits-ones) - 1
+}
+
+// 子网中可分配固定IP的地址范围（不含网络地址、网关、广播地址和动态分配范围）。
+// 没有可分配的地址时（如/31、动态范围占满子网）返回的first大于last
+func ipPoolRange(subnet *net.IPNet, gateway net.IP, dynamic *net.IPNet) (first, last uint32) {
+	// 用int64计算，避免首尾地址加减时溢出
+	networkAddr, broadcast := ipNetBounds(subnet)
+	low, high := int64(networkAddr)+1, int64(broadcast)-1
+	if gateway != nil && int64(ipToUint32(gateway)) == low {
+		low++
+	}
+	if dynamic != nil {
+		// 取动态范围两侧较大的一段
+		dynamicFirst, dynamicLast := ipNetBounds(dynamic)
+		if int64(dynamicFirst)-low >= high-int64(dynamicLast) {
+			if int64(dynamicFirst)-1 < high {
+				high = int64(dynamicFirst) - 1
+			}
+		} else if int64(dynamicLast)+1 > low {
+			low = int64(dynamicLast) + 1
+		}
+	}
+	if low > high {
+		return 1, 0
+	}
+	return uint32(low), uint32(high)
+}
+
+// 范围内的地址数量
+func ipPoolSize(first, last uint32) int {
+	if first > last {
+		return 0
+	}
+	return int(last-first) + 1
+}
+
+// 子网的后一半，作为Docker动态分配的范围
+func dynamicIPRange(subnet *net.IPNet) *net.IPNet {
+	ones, bits := subnet.Mask.Size()
+	_, last := ipNetBounds(subnet)
+	mask := net.CIDRMask(ones+1, bits)
+	return &net.IPNet{IP: uint32ToIP(last).Mask(mask), Mask: mask}
+}
+
+// 创建或检查受管理的共享网络，已存在时以其子网为准。
+// 在恢复工作空间之前调用，失败时退回Docker默认网络
+func (oem *OnlineEditorManager) setupManagedNetwork() {
+	name := oem.networkName
+	if name == dockerDefaultNetwork {
+		log.Printf("使用Docker默认网络，不分配固定IP")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	existing, err := oem.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil && !cerrdefs.IsNotFound(err) {
+		log.Printf("检查工作空间网络失败: %v，使用Docker默认网络", err)
+		oem.networkName = dockerDefaultNetwork
+		return
+	}
+
+	if err != nil {
+		subnetValue := os.Getenv("WORKSPACE_SUBNET")
+		if subnetValue == "" {
+			subnetValue = defaultWorkspaceSubnet
+		}
+		_, subnet, err := net.ParseCIDR(subnetValue)
+		if err != nil || subnet.IP.To4() == nil {
+			log.Printf("无效的WORKSPACE_SUBNET: %s，使用默认子网 %s", subnetValue, defaultWorkspaceSubnet)
+			_, subnet, _ = net.ParseCIDR(defaultWorkspaceSubnet)
+		}
+		gateway := uint32ToIP(ipToUint32(subnet.IP) + 1)
+		config := network.IPAMConfig{Subnet: subnet.String(), Gateway: gateway.String()}
+		if ones, bits := subnet.Mask.Size(); bits-ones >= 3 {
+			config.IPRange = dynamicIPRange(subnet).String()
+		}
+
+		_, err = oem.dockerClient.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver: "bridge",
+			IPAM: &network.IPAM{
+				Config: []network.IPAMConfig{config},
+			},
+			Labels: map[string]string{managedLabel: "true"},
+		})
+		if err != nil && !cerrdefs.IsConflict(err) {
+			log.Printf("创建工作空间网络失败: %v，使用Docker默认网络", err)
+			oem.networkName = dockerDefaultNetwork
+			return
+		}
+		log.Printf("创建工作空间网络: %s (子网: %s)", name, subnet)
+
+		if existing, err = oem.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{}); err != nil {
+			log.Printf("检查工作空间网络失败: %v，使用Docker默认网络", err)
+			oem.networkName = dockerDefaultNetwork
+			return
+		}
+	}
+
+	for _, config := range existing.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(config.Subnet)
+		if err != nil || subnet.IP.To4() == nil {
+			continue
+		}
+		ones, bits := subnet.Mask.Size()
+		if bits-ones < 2 {
+			continue
+		}
+		oem.ipSubnet = subnet
+		oem.ipGateway = net.ParseIP(config.Gateway).To4()
+		if _, ipRange, err := net.ParseCIDR(config.IPRange); err == nil && subnet.Contains(ipRange.IP) {
+			oem.ipRange = ipRange
+		}
+		log.Printf("工作空间网络: %s (子网: %s, 网关: %s, 动态分配范围: %s)", name, subnet, config.Gateway, config.IPRange)
+		return
+	}
+	log.Printf("工作空间网络 %s 没有可用的IPv4子网，不分配固定IP", name)
+}
+
+func (oem *OnlineEditorManager) ipPoolFile() string {
+	return filepath.Join(oem.baseDir, "ip_allocations.json")
+}
+
+// 保存分配记录
+func (oem *OnlineEditorManager) saveIPPool() {
+	allocations := oem.sortedIPAllocations()
+	data, err := json.MarshalIndent(allocations, "", "  ")
+	if err == nil {
+		err = os.WriteFile(oem.ipPoolFile(), data, 0644)
+	}
+	if err != nil {
+		log.Printf("保存IP分配记录失败: %v", err)
+	}
+}
+
+func (oem *OnlineEditorManager) sortedIPAllocations() []*IPAllocation {
+	allocations := make([]*IPAllocation, 0, len(oem.ipPool))
+	for _, allocation := range oem.ipPool {
+		allocations = append(allocations, allocation)
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		return ipToUint32(net.ParseIP(allocations[i].IP)) < ipToUint32(net.ParseIP(allocations[j].IP))
+	})
+	return allocations
+}
+
+// 共享网络中容器正在使用的地址。网络配置了动态分配范围时不需要检查，返回nil。
+// 需要调用Docker接口，不能持有oem.mutex
+func (oem *OnlineEditorManager) sharedNetworkContainerIPs() map[string]bool {
+	oem.mutex.RLock()
+	enabled := oem.ipSubnet != nil && oem.ipRange == nil
+	name := oem.networkName
+	oem.mutex.RUnlock()
+	if !enabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	existing, err := oem.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		log.Printf("检查工作空间网络中已使用的地址失败: %v", err)
+		return nil
+	}
+
+	used := map[string]bool{}
+	for _, endpoint := range existing.Containers {
+		if ip, _, err := net.ParseCIDR(endpoint.IPv4Address); err == nil {
+			used[ip.String()] = true
+		}
+	}
+	return used
+}
+
+// 为工作空间分配固定IP，已分配时返回原IP，跳过networkIPs中已被其他容器使用的地址。
+// IP池未启用时返回空字符串
+func (oem *OnlineEditorManager) allocateWorkspaceIP(workspaceID string, networkIPs map[string]bool) (string, error) {
+	if oem.ipSubnet == nil {
+		return "", nil
+	}
+	if ip := oem.workspaceStaticIP(workspaceID); ip != "" {
+		return ip, nil
+	}
+
+	first, last := ipPoolRange(oem.ipSubnet, oem.ipGateway, oem.ipRange)
+	for value := first; value <= last; value++ {
+		ip := uint32ToIP(value).String()
+		if _, used := oem.ipPool[ip]; used || networkIPs[ip] {
+			continue
+		}
+		oem.ipPool[ip] = &IPAllocation{
+			IP:          ip,
+			WorkspaceID: workspaceID,
+			Network:     oem.networkName,
+			Allocated:   time.Now(),
+		}
+		oem.saveIPPool()
+		log.Printf("[%s] 分配固定IP: %s", workspaceID, ip)
+		return ip, nil
+	}
+	return "", fmt.Errorf("子网 %s 中没有可用的IP地址", oem.ipSubnet)
+}
+
+// 工作空间的固定IP，没有分配时返回空字符串
+func (oem *OnlineEditorManager) workspaceStaticIP(workspaceID string) string {
+	for ip, allocation := range oem.ipPool {
+		if allocation.WorkspaceID == workspaceID {
+			return ip
+		}
+	}
+	return ""
+}
+
+// 释放工作空间的IP
+func (oem *OnlineEditorManager) releaseWorkspaceIP(workspaceID string) {
+	for ip, allocation := range oem.ipPool {
+		if allocation.WorkspaceID == workspaceID {
+			delete(oem.ipPool, ip)
+			log.Printf("[%s] 释放固定IP: %s", workspaceID, ip)
+		}
+	}
+	oem.saveIPPool()
+}
+
+// 恢复工作空间后重新占用IP：保留仍存在的工作空间的分配记录，
+// 并补充共享网络中容器当前使用的地址
+func (oem *OnlineEditorManager) restoreIPPool() {
+	if oem.ipSubnet == nil {
+		return
+	}
+
+	var allocations []*IPAllocation
+	if data, err := os.ReadFile(oem.ipPoolFile()); err == nil {
+		if err := json.Unmarshal(data, &allocations); err != nil {
+			log.Printf("读取IP分配记录失败: %v", err)
+		}
+	}
+
+	for _, allocation := range allocations {
+		if _, exists := oem.workspaces[allocation.WorkspaceID]; !exists {
+			continue
+		}
+		if ip := net.ParseIP(allocation.IP); ip == nil || !oem.ipSubnet.Contains(ip) {
+			continue
+		}
+		oem.ipPool[allocation.IP] = allocation
+	}
+
+	for _, workspace := range oem.workspaces {
+		if workspace.NetworkName != oem.networkName || workspace.NetworkIP == "" {
+			continue
+		}
+		if _, exists := oem.ipPool[workspace.NetworkIP]; exists || oem.workspaceStaticIP(workspace.ID) != "" {
+			continue
+		}
+		oem.ipPool[workspace.NetworkIP] = &IPAllocation{
+			IP:          workspace.NetworkIP,
+			WorkspaceID: workspace.ID,
+			Network:     oem.networkName,
+			Allocated:   workspace.Created,
+		}
+	}
+
+	oem.saveIPPool()
+	log.Printf("恢复IP分配: %d个", len(oem.ipPool))
+}
+
+// HTTP处理器
+
+// GET /network/ip-pool/stats
+func (oem *OnlineEditorManager) handleGetIPPoolStats(w http.ResponseWriter, r *http.Request) {
+	oem.mutex.RLock()
+	stats := IPPoolStats{
+		Enabled:   oem.ipSubnet != nil,
+		Network:   oem.networkName,
+		Allocated: len(oem.ipPool),
+	}
+	if oem.ipSubnet != nil {
+		first, last := ipPoolRange(oem.ipSubnet, oem.ipGateway, oem.ipRange)
+		stats.Subnet = oem.ipSubnet.String()
+		if oem.ipGateway != nil {
+			stats.Gateway = oem.ipGateway.String()
+		}
+		stats.Total = ipPoolSize(first, last)
+		// 恢复的分配记录可能不在当前范围内，已分配数可以超过总数
+		if stats.Allocated < stats.Total {
+			stats.Available = stats.Total - stats.Allocated
+		}
+		if stats.Total > 0 {
+			stats.Utilization = float64(stats.Allocated) * 100 / float64(stats.Total)
+		}
+	}
+	oem.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GET /network/ip-pool/allocations
+func (oem *OnlineEditorManager) handleGetIPPoolAllocations(w http.ResponseWriter, r *http.Request) {
+	type allocationInfo struct {
+		*IPAllocation
+		WorkspaceName string `json:"workspace_name"`
+		Status        string `json:"status"`
+	}
+
+	oem.mutex.RLock()
+	allocations := []allocationInfo{}
+	for _, allocation := range oem.sortedIPAllocations() {
+		info := allocationInfo{IPAllocation: allocation}
+		if workspace, exists := oem.workspaces[allocation.WorkspaceID]; exists {
+			info.WorkspaceName = workspace.DisplayName
+			info.Status = workspace.Status
+		}
+		allocations = append(allocations, info)
+	}
+	oem.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allocations)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, value string) *net.IPNet {
+	t.Helper()
+	_, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return subnet
+}
+
+func TestDynamicIPRange(t *testing.T) {
+	tests := map[string]string{
+		"172.30.0.0/16": "172.30.128.0/17",
+		"10.0.0.0/24":   "10.0.0.128/25",
+		"10.0.0.0/29":   "10.0.0.4/30",
+		"10.0.0.0/30":   "10.0.0.2/31",
+	}
+	for subnet, want := range tests {
+		if got := dynamicIPRange(mustParseCIDR(t, subnet)).String(); got != want {
+			t.Errorf("dynamicIPRange(%s) = %s, 期望 %s", subnet, got, want)
+		}
+	}
+}
+
+func TestIPPoolRange(t *testing.T) {
+	tests := []struct {
+		name            string
+		subnet, gateway string
+		dynamic         string // 空表示没有IPRange，"half"表示setupManagedNetwork设置的后一半
+		first, last     string // 都为空表示没有可分配的地址
+		total           int
+	}{
+		{"/16 有IPRange", "172.30.0.0/16", "172.30.0.1", "half", "172.30.0.2", "172.30.127.255", 32766},
+		{"/16 没有IPRange", "172.30.0.0/16", "172.30.0.1", "", "172.30.0.2", "172.30.255.254", 65533},
+		{"/16 没有网关", "172.30.0.0/16", "", "", "172.30.0.1", "172.30.255.254", 65534},
+		{"/29 有IPRange", "10.0.0.0/29", "10.0.0.1", "half", "10.0.0.2", "10.0.0.3", 2},
+		{"/29 没有IPRange", "10.0.0.0/29", "10.0.0.1", "", "10.0.0.2", "10.0.0.6", 5},
+		{"/30 没有IPRange", "10.0.0.0/30", "10.0.0.1", "", "10.0.0.2", "10.0.0.2", 1},
+		// 手动创建的网络：动态范围在前一半时取后一段
+		{"IPRange在前一半", "10.0.0.0/24", "10.0.0.1", "10.0.0.0/25", "10.0.0.128", "10.0.0.254", 127},
+		// 以下范围为空，之前计算总数时会溢出
+		{"/30 有IPRange", "10.0.0.0/30", "10.0.0.1", "half", "", "", 0},
+		{"IPRange占满子网", "10.0.0.0/30", "10.0.0.1", "10.0.0.0/30", "", "", 0},
+		{"/31", "10.0.0.0/31", "", "", "", "", 0},
+		{"/32", "10.0.0.1/32", "", "", "", "", 0},
+	}
+	for _, tt := range tests {
+		subnet := mustParseCIDR(t, tt.subnet)
+		var gateway net.IP
+		if tt.gateway != "" {
+			gateway = net.ParseIP(tt.gateway).To4()
+		}
+		var dynamic *net.IPNet
+		switch tt.dynamic {
+		case "":
+		case "half":
+			dynamic = dynamicIPRange(subnet)
+		default:
+			dynamic = mustParseCIDR(t, tt.dynamic)
+		}
+
+		first, last := ipPoolRange(subnet, gateway, dynamic)
+		if total := ipPoolSize(first, last); total != tt.total {
+			t.Errorf("%s: 地址数量 = %d, 期望 %d", tt.name, total, tt.total)
+		}
+		if tt.total == 0 {
+			if first <= last {
+				t.Errorf("%s: 范围应为空, 实际 %s - %s", tt.name, uint32ToIP(first), uint32ToIP(last))
+			}
+			continue
+		}
+		if uint32ToIP(first).String() != tt.first || uint32ToIP(last).String() != tt.last {
+			t.Errorf("%s: 范围 = %s - %s, 期望 %s - %s", tt.name, uint32ToIP(first), uint32ToIP(last), tt.first, tt.last)
+		}
+	}
+}
+
+func TestIPPoolStatsEmptyRange(t *testing.T) {
+	subnet := mustParseCIDR(t, "10.0.0.0/30")
+	oem := &OnlineEditorManager{
+		networkName: defaultWorkspaceNetwork,
+		ipSubnet:    subnet,
+		ipGateway:   net.ParseIP("10.0.0.1").To4(),
+		ipRange:     dynamicIPRange(subnet),
+		ipPool: map[string]*IPAllocation{
+			"10.0.0.2": {IP: "10.0.0.2", WorkspaceID: "ws_1"},
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	oem.handleGetIPPoolStats(recorder, httptest.NewRequest("GET", "/api/v1/network/ip-pool/stats", nil))
+
+	var stats IPPoolStats
+	if err := json.NewDecoder(recorder.Body).Decode(&stats); err != nil {
+		t.Fatalf("响应不是合法JSON: %v", err)
+	}
+	if stats.Total != 0 || stats.Available != 0 || stats.Allocated != 1 || stats.Utilization != 0 {
+		t.Fatalf("统计 = %+v, 期望总数和可用数为0", stats)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// 语言服务器（LSP）桥接
+//
+// 每个WebSocket连接对应容器内一个语言服务器进程，连接断开时按LSP协议发送
+// shutdown/exit后结束进程。进程登记在工作空间下，工作空间停止或删除时一并结束。
+
+// 语言服务器定义
+type languageServer struct {
+	Tool codeTool // 安装方式，Tool.Name为可执行文件名
+	Cmd  []string
+}
+
+var (
+	typescriptLanguageServer = languageServer{
+		Tool: codeTool{
+			Name:       "typescript-language-server",
+			Packages:   []string{"nodejs", "npm"},
+			InstallCmd: []string{"npm", "install", "-g", "typescript", "typescript-language-server"},
+		},
+		Cmd: []string{"typescript-language-server", "--stdio"},
+	}
+
+	clangdLanguageServer = languageServer{
+		Tool: codeTool{Name: "clangd", Packages: []string{"clangd"}},
+		Cmd:  []string{"clangd"},
+	}
+
+	// 按detectLanguage返回的语言名索引
+	languageServers = map[string]languageServer{
+		"go": {
+			Tool: codeTool{
+				Name:       "gopls",
+				Packages:   []string{"golang"},
+				InstallCmd: []string{"sh", "-c", "GOBIN=/usr/local/bin go install golang.org/x/tools/gopls@latest"},
+			},
+			Cmd: []string{"gopls"},
+		},
+		"javascript": typescriptLanguageServer,
+		"typescript": typescriptLanguageServer,
+		"python": {
+			Tool: codeTool{
+				Name:       "pyright-langserver",
+				Packages:   []string{"nodejs", "npm"},
+				InstallCmd: []string{"npm", "install", "-g", "pyright"},
+			},
+			Cmd: []string{"pyright-langserver", "--stdio"},
+		},
+		"c":   clangdLanguageServer,
+		"cpp": clangdLanguageServer,
+	}
+)
+
+// 容器内的工作空间根URI
+const containerWorkspaceURI = "file://" + containerWorkspaceRoot
+
+const (
+	languageServerShutdownTimeout = 5 * time.Second // 等待shutdown响应
+	languageServerExitTimeout     = 2 * time.Second // 发送exit后等待进程自行退出
+)
+
+// 启动语言服务器
+func (oem *OnlineEditorManager) StartLanguageServer(workspaceID, language string) (*stdioSession, error) {
+	server, exists := languageServers[language]
+	if !exists {
+		return nil, fmt.Errorf("不支持的语言: %s", language)
+	}
+
+	if err := oem.ensureCodeTool(workspaceID, server.Tool); err != nil {
+		return nil, err
+	}
+
+	return oem.startStdioSession(workspaceID, "lsp", language, server.Cmd, containerWorkspaceRoot)
+}
+
+// 按LSP协议关闭语言服务器：shutdown请求 -> 等待响应 -> exit通知 -> 结束进程
+// 读取响应依赖relayStdioWebSocket中仍在运行的读取goroutine
+func shutdownLanguageServer(session *stdioSession) {
+	const shutdownID = "bridge-shutdown"
+	shutdown, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": shutdownID, "method": "shutdown"})
+	exit, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+
+	replied := session.expectResponse(shutdownID)
+	if err := session.WriteMessage(shutdown); err == nil {
+		// 服务器在回复shutdown之前收到exit会按异常退出处理，可能丢失未保存的状态
+		select {
+		case <-replied:
+		case <-session.done:
+		case <-time.After(languageServerShutdownTimeout):
+			log.Printf("[%s] 语言服务器未响应shutdown: %s", session.WorkspaceID, session.ID)
+		}
+
+		session.WriteMessage(exit)
+		// 给服务器一点时间自行退出
+		select {
+		case <-session.done:
+		case <-time.After(languageServerExitTimeout):
+		}
+	}
+	session.Close()
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/lsp/{language}/ws?root_uri=
+// root_uri为客户端使用的工作空间根URI，默认与容器内一致（file:///workspace）
+func (oem *OnlineEditorManager) handleLanguageServerWebSocket(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	language := vars["language"]
+
+	clientRoot := strings.TrimSuffix(r.URL.Query().Get("root_uri"), "/")
+	if clientRoot == "" {
+		clientRoot = containerWorkspaceURI
+	}
+
+	conn, err := oem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	session, err := oem.StartLanguageServer(workspaceID, language)
+	if err != nil {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		return
+	}
+	defer shutdownLanguageServer(session)
+
+	log.Printf("[%s] 语言服务器会话开始: %s (%s)", workspaceID, session.ID, language)
+
+	relayStdioWebSocket(conn, session,
+		func(body []byte) []byte { return rewriteJSONPrefix(body, clientRoot, containerWorkspaceURI) },
+		func(body []byte) []byte { return rewriteJSONPrefix(body, containerWorkspaceURI, clientRoot) },
+	)
+
+	log.Printf("[%s] 语言服务器会话结束: %s", workspaceID, session.ID)
+}
+
+func (oem *OnlineEditorManager) handleListLanguageServers(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	languages := make([]string, 0, len(languageServers))
+	for language := range languageServers {
+		languages = append(languages, language)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"supported": languages,
+		"sessions":  oem.listStdioSessions(workspaceID, "lsp"),
+	})
+}
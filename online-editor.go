@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,13 +19,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
+	cerrdefs "github.com/containerd/errdefs"
 	"github.com/docker/docker/api/types/container"
+	eventTypes "github.com/docker/docker/api/types/events"
 	imageTypes "github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
 	"github.com/gorilla/mux"
@@ -51,9 +53,17 @@ type Workspace struct {
 	GitBranch   string            `json:"git_branch"`
 	NetworkIP   string            `json:"network_ip,omitempty"`
 	NetworkName string            `json:"network_name,omitempty"`
+	Network     WorkspaceNetwork  `json:"network"`
 	AccessURLs  []AccessURL       `json:"access_urls,omitempty"`
 	Tools       []string          `json:"tools,omitempty"` // 用户选择的工具
 	IsFavorite  bool              `json:"is_favorite"`     // 是否收藏
+	IsPinned    bool              `json:"is_pinned"`       // 是否固定，固定的工作空间不会过期
+	Owner       string            `json:"owner,omitempty"` // 创建者的用户ID
+	Sidecars    []SidecarService  `json:"sidecars,omitempty"`
+	AutoStopped bool              `json:"auto_stopped,omitempty"` // 空闲自动停止，使用时自动启动
+
+	// 旧版本创建的容器通过Docker端口绑定发布的宿主机端口，由Docker转发，重建容器后清空
+	dockerPortBindings map[hostPortKey]bool
 }
 
 type AccessURL struct {
@@ -61,7 +71,8 @@ type AccessURL struct {
 	Protocol    string `json:"protocol"`
 	InternalURL string `json:"internal_url"`
 	ExternalURL string `json:"external_url,omitempty"`
-	Status      string `json:"status"` // "available", "unavailable", "checking"
+	ProxyURL    string `json:"proxy_url,omitempty"` // 经认证反向代理访问的地址
+	Status      string `json:"status"`              // "available", "unavailable", "checking"
 }
 
 type PortMapping struct {
@@ -72,35 +83,63 @@ type PortMapping struct {
 }
 
 type VolumeMount struct {
-	HostPath      string `json:"host_path"`
+	Type          string `json:"type,omitempty"` // "bind"（工作空间目录）, "volume"（工作空间专属卷）, "cache"（共享缓存卷）
+	Name          string `json:"name,omitempty"` // 卷名称，cache类型可使用预设名称（npm、maven、go等）
+	VolumeName    string `json:"volume_name,omitempty"`
+	HostPath      string `json:"host_path,omitempty"`
 	ContainerPath string `json:"container_path"`
 	ReadOnly      bool   `json:"read_only"`
 }
 
 type FileInfo struct {
-	Name         string    `json:"name"`
-	Path         string    `json:"path"`
-	IsDir        bool      `json:"is_dir"`
-	Size         int64     `json:"size"`
-	ModifiedTime time.Time `json:"modified_time"`
-	Permissions  string    `json:"permissions"`
+	Name         string     `json:"name"`
+	Path         string     `json:"path"`
+	IsDir        bool       `json:"is_dir"`
+	IsSymlink    bool       `json:"is_symlink,omitempty"`
+	Size         int64      `json:"size"`
+	ModifiedTime time.Time  `json:"modified_time"`
+	Permissions  string     `json:"permissions"`
+	Children     []FileInfo `json:"children,omitempty"`
 }
 
 type TerminalSession struct {
-	ID           string          `json:"id"`
-	WorkspaceID  string          `json:"workspace_id"`
-	Process      *exec.Cmd       `json:"-"`
-	WebSocket    *websocket.Conn `json:"-"`
-	Created      time.Time       `json:"created"`
-	LastActivity time.Time       `json:"last_activity"`
+	ID          string          `json:"id"`
+	WorkspaceID string          `json:"workspace_id"`
+	Process     *exec.Cmd       `json:"-"`
+	WebSocket   *websocket.Conn `json:"-"`
+	Created     time.Time       `json:"created"`
+
+	// 最近一次输入输出的时间（UnixNano），终端读写goroutine与空闲检测并发访问
+	lastActivity atomic.Int64
+}
+
+func (s *TerminalSession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// 最近一次输入输出的时间
+func (s *TerminalSession) LastActivity() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
+func (s *TerminalSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID           string    `json:"id"`
+		WorkspaceID  string    `json:"workspace_id"`
+		Created      time.Time `json:"created"`
+		LastActivity time.Time `json:"last_activity"`
+	}{s.ID, s.WorkspaceID, s.Created, s.LastActivity()})
 }
 
 type GitOperation struct {
-	Type    string   `json:"type"` // clone, pull, push, commit, checkout
-	Repo    string   `json:"repo"`
-	Branch  string   `json:"branch"`
-	Message string   `json:"message"`
-	Files   []string `json:"files"`
+	Type         string   `json:"type"` // clone, pull, push, commit, checkout
+	Repo         string   `json:"repo"`
+	Branch       string   `json:"branch"`
+	Message      string   `json:"message"`
+	Files        []string `json:"files"`
+	CredentialID string   `json:"credential_id"` // 访问远程仓库使用的凭据，为空时按主机名自动匹配
+	Replace      bool     `json:"replace"`       // clone时先清空工作空间（需显式开启）
+	UserID       string   `json:"-"`
 }
 
 // 导出相关的数据结构
@@ -142,8 +181,6 @@ type ImportTaskInfo struct {
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 }
 
-// 简化网络管理，移除IP池，直接使用端口绑定
-
 // 在线编辑器管理器
 type OnlineEditorManager struct {
 	workspaces       map[string]*Workspace
@@ -154,15 +191,59 @@ type OnlineEditorManager struct {
 	imagesDir        string
 	upgrader         websocket.Upgrader
 
-	dockerClient *client.Client // Docker 客户端
-	networkName  string         // 工作空间网络名称
-	portPool     map[int]bool   // 端口池管理
+	dockerClient *client.Client                  // Docker 客户端
+	networkName  string                          // 工作空间网络名称
+	portPool     map[hostPortKey]*PortAllocation // 端口池管理：已分配的宿主机端口和协议
+	portCursor   int                             // 下次自动分配端口时开始查找的位置
+	ipPool       map[string]*IPAllocation        // IP池管理：共享网络中已分配的固定IP
+	ipSubnet     *net.IPNet                      // 共享网络的子网，为nil时不分配固定IP
+	ipGateway    net.IP
+	ipRange      *net.IPNet // Docker动态分配地址的范围，固定IP在此范围之外分配
 
 	// 新增：导出下载管理
 	downloadsDir   string                   // 下载文件存储目录
 	downloads      map[string]*DownloadInfo // 下载信息管理
 	downloadsMutex sync.RWMutex             // 下载信息锁
 
+	// 新增：文件回收站目录
+	trashDir string
+
+	// 新增：磁盘用量与配额
+	diskUsage *DiskUsageManager
+
+	// 新增：容器内stdio进程（语言服务器、调试适配器）
+	stdioSessions map[string]*stdioSession
+	stdioMutex    sync.Mutex
+
+	// 新增：调试适配器端口（工作空间 -> 容器内已分配端口）和启动配置文件锁
+	debugPorts map[string]map[int]bool
+	debugMutex sync.Mutex
+
+	// 新增：Git凭据
+	gitCredentials *GitCredentialManager
+
+	// 新增：仓库克隆进度
+	gitClones      map[string]*gitCloneTask
+	gitClonesMutex sync.Mutex
+
+	// 新增：端口转发（宿主机端口 -> 转发）
+	portForwards      map[hostPortKey]*portForward
+	portForwardsMutex sync.Mutex
+
+	// 新增：监听端口检测
+	portDetector *portDetector
+
+	// 新增：活动跟踪（空闲自动停止）和保留策略
+	activity  *activityTracker
+	retention *RetentionManager
+
+	// 新增：工作空间生命周期事件
+	events *eventBus
+
+	// 新增：预览代理会话签名密钥和分享链接
+	previewKey    []byte
+	previewShares *PreviewShareManager
+
 	// 新增：自定义镜像管理
 	customImages      map[string]*ImageConfig // 自定义镜像配置
 	customImagesMutex sync.RWMutex            // 自定义镜像锁
@@ -329,20 +410,33 @@ except Exception as e:
 " > /tmp/test_server_%s.log 2>&1 &
 		echo "测试服务器已在后台启动，日志文件: /tmp/test_server_%s.log"
 		echo "请等待几秒钟，然后访问 http://localhost:%s"`,
+
+		// 调试适配器TCP转接脚本：在后台启动只支持TCP的适配器，连接后把stdio转接到该端口
+		// 参数: $1 端口, 其余为适配器命令
+		"dap_tcp_bridge": `port="$1"; shift
+"$@" 1>&2 &
+adapter=$!
+trap 'kill "$adapter" 2>/dev/null' EXIT
+trap 'exit 143' TERM INT
+for i in $(seq 1 100); do
+	if exec 3<>"/dev/tcp/127.0.0.1/$port"; then
+		break
+	fi 2>/dev/null
+	if ! kill -0 "$adapter" 2>/dev/null; then
+		echo "调试适配器已退出" >&2
+		exit 1
+	fi
+	sleep 0.1
+done
+exec 4<&0
+cat <&3 &
+cat <&4 >&3 &
+wait -n`,
 	},
 
 	Commands: map[string][]string{
 		// 检查工具是否存在
-		"check_tool": {"which"},
-
-		// 端口检查命令模板
-		"port_check_template": {"sh", "-c", "netstat -tlnp 2>/dev/null | grep ':%s ' || ss -tlnp 2>/dev/null | grep ':%s ' || lsof -i :%s 2>/dev/null"},
-
-		// 包管理器安装命令
-		"install_apt": {"/bin/bash", "-c", "apt-get update && apt-get install -y %s"},
-		"install_apk": {"/bin/bash", "-c", "apk add --no-cache %s"},
-		"install_yum": {"/bin/bash", "-c", "yum install -y %s"},
-		"install_dnf": {"/bin/bash", "-c", "dnf install -y %s"},
+		"check_tool": {"which", "%s"},
 	},
 }
 
@@ -537,23 +631,39 @@ func NewOnlineEditorManager() (*OnlineEditorManager, error) {
 	workspacesDir := filepath.Join(baseDir, "workspaces")
 	imagesDir := filepath.Join(baseDir, "images")
 	downloadsDir := filepath.Join(baseDir, "downloads")
+	trashDir := filepath.Join(baseDir, "trash")
 
 	// 创建目录
-	dirs := []string{baseDir, workspacesDir, imagesDir, downloadsDir}
+	dirs := []string{baseDir, workspacesDir, imagesDir, downloadsDir, trashDir}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, fmt.Errorf("创建目录失败 %s: %v", dir, err)
 		}
 	}
 
+	// 初始化Git凭据管理器
+	gitCredentials, err := NewGitCredentialManager(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 预览代理会话签名密钥，取自PREVIEW_SESSION_KEY，未设置时在baseDir下生成并保存
+	previewKey, err := loadSecretKey("PREVIEW_SESSION_KEY", filepath.Join(baseDir, "preview.key"), "预览会话密钥")
+	if err != nil {
+		return nil, err
+	}
+
 	// 初始化 Docker 客户端
 	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("初始化 Docker 客户端失败: %v", err)
 	}
 
-	// 使用默认Docker网络，简化网络配置
-	networkName := "bridge" // 使用默认bridge网络
+	// 共享模式的工作空间网络，启动时创建并分配固定IP
+	networkName := os.Getenv("WORKSPACE_NETWORK")
+	if networkName == "" {
+		networkName = defaultWorkspaceNetwork
+	}
 
 	manager := &OnlineEditorManager{
 		workspaces:       make(map[string]*Workspace),
@@ -568,10 +678,24 @@ func NewOnlineEditorManager() (*OnlineEditorManager, error) {
 		},
 		dockerClient:      dockerCli,
 		networkName:       networkName,
-		portPool:          make(map[int]bool),
+		portPool:          make(map[hostPortKey]*PortAllocation),
+		ipPool:            make(map[string]*IPAllocation),
 		downloadsDir:      downloadsDir,
 		downloads:         make(map[string]*DownloadInfo),
 		downloadsMutex:    sync.RWMutex{},
+		trashDir:          trashDir,
+		diskUsage:         NewDiskUsageManager(baseDir),
+		stdioSessions:     make(map[string]*stdioSession),
+		debugPorts:        make(map[string]map[int]bool),
+		gitCredentials:    gitCredentials,
+		gitClones:         make(map[string]*gitCloneTask),
+		portForwards:      make(map[hostPortKey]*portForward),
+		portDetector:      newPortDetector(),
+		activity:          newActivityTracker(baseDir),
+		retention:         NewRetentionManager(baseDir),
+		events:            newEventBus(),
+		previewKey:        previewKey,
+		previewShares:     NewPreviewShareManager(baseDir),
 		customImages:      make(map[string]*ImageConfig),
 		customImagesMutex: sync.RWMutex{},
 		registryManager:   NewRegistryManager(), // 初始化镜像源管理器
@@ -595,7 +719,8 @@ func NewOnlineEditorManager() (*OnlineEditorManager, error) {
 
 	log.Printf("AI配置加载完成，默认模型: %s", manager.aiConfig.DefaultModel)
 
-	// 启动时恢复现有工作空间
+	// 准备共享网络，然后恢复现有工作空间
+	manager.setupManagedNetwork()
 	if err := manager.recoverExistingWorkspaces(); err != nil {
 		log.Printf("恢复现有工作空间失败: %v", err)
 	}
@@ -1526,10 +1651,13 @@ func (oem *OnlineEditorManager) GetWorkspaceFileTree(workspaceID string) ([]stri
 		return nil, fmt.Errorf("工作空间不存在: %s", workspaceID)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspace.Name)
+	workspaceDir, err := oem.workspaceRealDir(workspace.ID)
+	if err != nil {
+		return nil, err
+	}
 	var fileTree []string
 
-	err := filepath.Walk(workspaceDir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(workspaceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -1865,10 +1993,22 @@ func (oem *OnlineEditorManager) recoverExistingWorkspaces() error {
 
 		// 恢复镜像信息
 		workspace.Image = containerInfo.Config.Image
+		workspace.Owner = containerInfo.Config.Labels[previewOwnerLabel]
 
-		// 恢复网络IP
+		// 恢复网络配置和IP
+		workspace.Network = workspaceNetworkFromLabels(containerInfo.Config.Labels)
+		workspace.NetworkName = oem.workspaceNetworkName(workspaceID, workspace.Owner, workspace.Network)
 		if containerInfo.NetworkSettings != nil {
-			if endpointSettings, exists := containerInfo.NetworkSettings.Networks[oem.networkName]; exists {
+			legacyTeamNetwork := legacyTeamNetworkName(workspace.Network)
+			if endpointSettings, exists := containerInfo.NetworkSettings.Networks[workspace.NetworkName]; exists {
+				workspace.NetworkIP = endpointSettings.IPAddress
+			} else if endpointSettings, exists := containerInfo.NetworkSettings.Networks[legacyTeamNetwork]; exists && workspace.Network.Mode == networkModeTeam {
+				// 团队名称按所有者区分之前创建的容器仍在旧的团队网络中
+				workspace.NetworkName = legacyTeamNetwork
+				workspace.NetworkIP = endpointSettings.IPAddress
+			} else if endpointSettings, exists := containerInfo.NetworkSettings.Networks[dockerDefaultNetwork]; exists {
+				// 启用共享网络之前创建的容器仍在默认网络中
+				workspace.NetworkName = dockerDefaultNetwork
 				workspace.NetworkIP = endpointSettings.IPAddress
 			}
 		}
@@ -1889,14 +2029,18 @@ func (oem *OnlineEditorManager) recoverExistingWorkspaces() error {
 			}
 		}
 
-		// 设置工作空间目录挂载
+		workspace.dockerPortBindings = dockerPublishedPorts(containerInfo.HostConfig)
+
+		// 设置工作空间目录挂载，并恢复命名卷
 		workspace.Volumes = []VolumeMount{
 			{
+				Type:          volumeTypeBind,
 				HostPath:      workspaceDir,
 				ContainerPath: "/workspace",
 				ReadOnly:      false,
 			},
 		}
+		workspace.Volumes = append(workspace.Volumes, workspaceVolumesFromMounts(workspaceID, workspace.Owner, containerInfo.Mounts)...)
 
 		// 如果容器正在运行，生成访问URL
 		if workspace.Status == "running" {
@@ -1909,21 +2053,37 @@ func (oem *OnlineEditorManager) recoverExistingWorkspaces() error {
 	}
 
 	log.Printf("成功恢复 %d 个工作空间", len(oem.workspaces))
+
+	// 重新占用已恢复工作空间的宿主机端口和固定IP，并为运行中的工作空间启动端口转发
+	oem.restorePortPool()
+	oem.restoreIPPool()
+	oem.restoreSidecars(containers)
+	for workspaceID := range oem.workspaces {
+		oem.syncPortForwards(workspaceID)
+	}
 	return nil
 }
 
-// IP池相关功能已移除，简化为端口绑定模式
-
-// 生成工作空间访问URL
+// 生成工作空间访问URL：配置的端口映射加上检测到的监听端口
 func (oem *OnlineEditorManager) generateAccessURLs(workspace *Workspace) {
 	if workspace.NetworkIP == "" {
 		return
 	}
 
 	var accessURLs []AccessURL
+	detected, scanned := oem.detectedPorts(workspace.ID)
 
-	// 常见的开发服务器端口
-	commonPorts := []string{"3000", "8000", "8080", "8081", "4200", "5000", "5173", "5174"}
+	// 尚未检测时状态为checking，只监听回环地址的端口无法从容器外访问
+	status := func(port string) string {
+		if !scanned {
+			return "checking"
+		}
+		number, _ := strconv.Atoi(port)
+		if listening, ok := detected[number]; ok && !listening.LocalOnly {
+			return "available"
+		}
+		return "unavailable"
+	}
 
 	// 处理配置的端口映射
 	portMap := make(map[string]bool)
@@ -1932,7 +2092,8 @@ func (oem *OnlineEditorManager) generateAccessURLs(workspace *Workspace) {
 			Port:        port.ContainerPort,
 			Protocol:    "http",
 			InternalURL: fmt.Sprintf("http://%s:%s", workspace.NetworkIP, port.ContainerPort),
-			Status:      "checking",
+			ProxyURL:    previewURL(workspace.ID, port.ContainerPort),
+			Status:      status(port.ContainerPort),
 		}
 
 		// 如果有公共访问配置，添加外部URL
@@ -1944,14 +2105,16 @@ func (oem *OnlineEditorManager) generateAccessURLs(workspace *Workspace) {
 		portMap[port.ContainerPort] = true
 	}
 
-	// 添加常见端口（如果没有在配置中）
-	for _, port := range commonPorts {
+	// 添加检测到的监听端口（如果没有在配置中）
+	for _, listening := range sortedDetectedPorts(detected) {
+		port := strconv.Itoa(listening.Port)
 		if !portMap[port] {
 			accessURL := AccessURL{
 				Port:        port,
 				Protocol:    "http",
 				InternalURL: fmt.Sprintf("http://%s:%s", workspace.NetworkIP, port),
-				Status:      "checking",
+				ProxyURL:    previewURL(workspace.ID, port),
+				Status:      status(port),
 			}
 			accessURLs = append(accessURLs, accessURL)
 		}
@@ -1961,63 +2124,48 @@ func (oem *OnlineEditorManager) generateAccessURLs(workspace *Workspace) {
 	log.Printf("[%s] 生成访问URL: %d个端口", workspace.ID, len(accessURLs))
 }
 
-// 检查端口可用性
+// 检查端口可用性：立即检测一次监听端口，结果更新到AccessURLs
 func (oem *OnlineEditorManager) checkPortAvailability(workspace *Workspace) {
 	if workspace.NetworkIP == "" || workspace.Status != "running" {
 		return
 	}
 
-	ctx := context.Background()
-
-	for i := range workspace.AccessURLs {
-		accessURL := &workspace.AccessURLs[i]
-
-		// 在容器内检查端口是否有服务监听
-		checkCmd, err := scriptManager.GetCommand("port_check_template", accessURL.Port, accessURL.Port, accessURL.Port)
-		if err != nil {
-			accessURL.Status = "unavailable"
-			continue
-		}
-
-		execConfig := container.ExecOptions{
-			Cmd:          checkCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-			WorkingDir:   "/workspace",
-		}
-
-		execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, execConfig)
-		if err != nil {
-			accessURL.Status = "unavailable"
-			continue
-		}
-
-		execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-		if err != nil {
-			accessURL.Status = "unavailable"
-			continue
-		}
-
-		output, err := io.ReadAll(execAttachResp.Reader)
-		execAttachResp.Close()
-
-		if err == nil && len(output) > 0 {
-			accessURL.Status = "available"
-		} else {
-			accessURL.Status = "unavailable"
-		}
+	if _, err := oem.detectPorts(workspace.ID); err != nil {
+		log.Printf("[%s] 检测端口失败: %v", workspace.ID, err)
 	}
 }
 
-// 释放端口
-func (oem *OnlineEditorManager) releasePort(port int) {
-	// 调用者必须持有锁
-	delete(oem.portPool, port)
-}
-
 // 创建工作空间
-func (oem *OnlineEditorManager) CreateWorkspace(name, images, gitRepo, gitBranch string, customPorts []PortMapping, selectedTools []string, customEnvironment map[string]string) (*Workspace, error) {
+func (oem *OnlineEditorManager) CreateWorkspace(name, images, gitRepo, gitBranch string, customPorts []PortMapping, selectedTools []string, customEnvironment map[string]string, cloneOptions GitCloneOptions, networkOptions WorkspaceNetwork, sidecars []SidecarService, extraVolumes []VolumeMount, authRequest GitAuthRequest) (*Workspace, error) {
 	// 先进行基本验证，不持有锁
+	// sidecar服务通过DNS别名访问，需要独立网络，未指定网络模式时自动使用
+	if len(sidecars) > 0 && networkOptions.Mode == "" {
+		networkOptions.Mode = networkModeWorkspace
+	}
+	networkOptions, err := normalizeWorkspaceNetwork(networkOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(sidecars) > 0 && networkOptions.Mode != networkModeWorkspace {
+		return nil, fmt.Errorf("sidecar服务需要使用独立网络（workspace模式）")
+	}
+	if err := validateSidecars(sidecars, nil); err != nil {
+		return nil, err
+	}
+	if networkOptions.Mode == networkModeTeam {
+		if authRequest.UserID == "" {
+			return nil, fmt.Errorf("团队网络需要经过认证的用户身份")
+		}
+		// 加入已有的团队网络时外网策略必须一致，尽早返回错误
+		name := oem.workspaceNetworkName("", authRequest.UserID, networkOptions)
+		if _, err := oem.checkNetworkEgress(context.Background(), name, networkOptions); err != nil {
+			return nil, err
+		}
+	}
+	if err := validatePackageNames(selectedTools); err != nil {
+		return nil, err
+	}
+
 	var imageConfig *ImageConfig
 
 	// 检查自定义镜像
@@ -2051,8 +2199,35 @@ func (oem *OnlineEditorManager) CreateWorkspace(name, images, gitRepo, gitBranch
 	workspaceID := generateWorkspaceID()
 	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
 
+	extraVolumes, err = normalizeWorkspaceVolumes(workspaceID, authRequest.UserID, extraVolumes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 分配宿主机端口，未指定的从端口池中自动分配；共享网络中分配固定IP
+	ports := append([]PortMapping{}, customPorts...)
+	var networkIPs map[string]bool
+	if networkOptions.Mode == networkModeShared {
+		networkIPs = oem.sharedNetworkContainerIPs()
+	}
+	oem.mutex.Lock()
+	err = oem.assignHostPorts(workspaceID, ports)
+	if err == nil && networkOptions.Mode == networkModeShared {
+		if _, err = oem.allocateWorkspaceIP(workspaceID, networkIPs); err != nil {
+			oem.releaseWorkspacePorts(workspaceID)
+		}
+	}
+	oem.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
 	// 创建工作空间目录
 	if err := os.MkdirAll(workspaceDir, 0755); err != nil {
+		oem.mutex.Lock()
+		oem.releaseWorkspacePorts(workspaceID)
+		oem.releaseWorkspaceIP(workspaceID)
+		oem.mutex.Unlock()
 		return nil, fmt.Errorf("创建工作空间目录失败: %v", err)
 	}
 
@@ -2069,23 +2244,28 @@ func (oem *OnlineEditorManager) CreateWorkspace(name, images, gitRepo, gitBranch
 		GitRepo:     gitRepo,
 		GitBranch:   gitBranch,
 		Environment: make(map[string]string),
-		NetworkName: oem.networkName,
+		NetworkName: oem.workspaceNetworkName(workspaceID, authRequest.UserID, networkOptions),
+		Network:     networkOptions,
+		Owner:       authRequest.UserID,
+		Sidecars:    sidecars,
 	}
 
 	// 设置端口映射
-	workspace.Ports = customPorts
+	workspace.Ports = ports
 
 	// 设置用户选择的工具
 	workspace.Tools = selectedTools
 
-	// 设置默认卷挂载
+	// 设置默认卷挂载和额外的命名卷
 	workspace.Volumes = []VolumeMount{
 		{
+			Type:          volumeTypeBind,
 			HostPath:      workspaceDir,
 			ContainerPath: "/workspace",
 			ReadOnly:      false,
 		},
 	}
+	workspace.Volumes = append(workspace.Volumes, extraVolumes...)
 
 	// 设置环境变量 - 合并镜像默认环境变量和用户自定义环境变量
 	workspace.Environment = make(map[string]string)
@@ -2107,20 +2287,31 @@ func (oem *OnlineEditorManager) CreateWorkspace(name, images, gitRepo, gitBranch
 
 	// 异步初始化容器，不阻塞响应
 	go func() {
-		if err := oem.initializeContainer(workspace, images, workspaceDir, imageConfig); err != nil {
+		if err := oem.initializeContainer(workspace, images, workspaceDir, imageConfig, cloneOptions, authRequest); err != nil {
 			log.Printf("容器初始化失败: %v", err)
 			// 更新状态时使用短锁
 			oem.mutex.Lock()
-			workspace.Status = "failed"
+			oem.setStatusLocked(workspace, "failed", err.Error())
 			oem.mutex.Unlock()
+			oem.publishWorkspaceError(workspaceID, fmt.Sprintf("容器初始化失败: %v", err))
 		}
 	}()
 
 	return workspace, nil
 }
 
+// 本服务创建的Docker网络和卷都带有此标签，列表和清理只处理带标签的资源
+const managedLabel = "online-editor.managed"
+
+// 工作空间容器的标签，用于重启后恢复所有者和网络配置
+func workspaceContainerLabels(workspace *Workspace) map[string]string {
+	labels := workspace.Network.labels()
+	labels[previewOwnerLabel] = workspace.Owner
+	return labels
+}
+
 // 初始化容器 - 分阶段进行，增加超时和错误处理
-func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images, workspaceDir string, imageConfig *ImageConfig) error {
+func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images, workspaceDir string, imageConfig *ImageConfig, cloneOptions GitCloneOptions, authRequest GitAuthRequest) error {
 	workspaceID := workspace.ID
 
 	// 设置总超时时间（5分钟）
@@ -2146,8 +2337,18 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 	// 阶段2：更新状态为创建容器中
 	oem.updateWorkspaceStatus(workspaceID, "creating")
 
-	// 简化网络配置，不使用固定IP分配
-	log.Printf("[%s] 使用默认网络配置", workspaceID)
+	// 准备工作空间网络（独立网络模式下创建用户自定义网络）
+	oem.mutex.RLock()
+	networkOptions, networkName := workspace.Network, workspace.NetworkName
+	staticIP := oem.workspaceStaticIP(workspaceID)
+	oem.mutex.RUnlock()
+	if err := oem.ensureWorkspaceNetwork(ctx, workspaceID, networkName, networkOptions); err != nil {
+		oem.updateWorkspaceStatus(workspaceID, "failed")
+		return err
+	}
+
+	// sidecar服务与开发容器并行启动
+	go oem.startSidecars(workspaceID)
 
 	// 设置环境变量
 	envs := []string{}
@@ -2177,16 +2378,11 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 	}
 	envs = append(envs, baseEnvs...)
 
-	// 容器挂载卷 - 确保工作空间目录正确挂载到/workspace
-	mounts := []mount.Mount{
-		{
-			Type:   mount.TypeBind,
-			Source: workspaceDir,
-			Target: "/workspace",
-			BindOptions: &mount.BindOptions{
-				Propagation: mount.PropagationRPrivate,
-			},
-		},
+	// 容器挂载卷 - 确保工作空间目录正确挂载到/workspace，并挂载命名卷
+	mounts, err := oem.workspaceMounts(ctx, workspace, workspaceDir)
+	if err != nil {
+		oem.updateWorkspaceStatus(workspaceID, "failed")
+		return err
 	}
 
 	// 清理可能冲突的容器
@@ -2194,9 +2390,8 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		log.Printf("[%s] 清理冲突容器失败: %v", workspaceID, err)
 	}
 
-	// 处理端口映射 - 修复版本
+	// 处理端口映射 - 只暴露容器端口，宿主机端口由进程内的端口转发提供
 	exposedPorts := nat.PortSet{}
-	portBindings := nat.PortMap{}
 
 	log.Printf("[%s] 配置端口映射，共%d个端口", workspaceID, len(workspace.Ports))
 
@@ -2216,17 +2411,6 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 
 		log.Printf("[%s] 配置端口: 容器%s -> 宿主机%s (协议:%s, 公共访问:%v)",
 			workspaceID, containerPortStr, hostPortStr, protocol, portMapping.PublicAccess)
-
-		// 如果有宿主机端口配置，设置端口绑定
-		if hostPortStr != "" && portMapping.PublicAccess {
-			portBindings[containerPort] = []nat.PortBinding{
-				{
-					HostIP:   "0.0.0.0",
-					HostPort: hostPortStr,
-				},
-			}
-			log.Printf("[%s] 端口绑定已设置: %s:%s -> %s", workspaceID, "0.0.0.0", hostPortStr, containerPortStr)
-		}
 	}
 
 	// 常用开发端口默认暴露（不绑定到宿主机）
@@ -2243,7 +2427,7 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		}
 	}
 
-	log.Printf("[%s] 总计暴露端口: %d, 绑定端口: %d", workspaceID, len(exposedPorts), len(portBindings))
+	log.Printf("[%s] 总计暴露端口: %d", workspaceID, len(exposedPorts))
 
 	// 创建容器配置
 	containerConfig := &container.Config{
@@ -2253,14 +2437,14 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		OpenStdin:    true,
 		ExposedPorts: exposedPorts,
 		WorkingDir:   "/workspace",
+		Labels:       workspaceContainerLabels(workspace),
 		// 使用tail命令保持容器运行
 		Cmd: []string{"tail", "-f", "/dev/null"},
 	}
 
 	hostConfig := &container.HostConfig{
-		Mounts:       mounts,
-		Privileged:   false,
-		PortBindings: portBindings,
+		Mounts:     mounts,
+		Privileged: false,
 		// 添加资源限制
 		Resources: container.Resources{
 			Memory:    512 * 1024 * 1024, // 512MB
@@ -2268,8 +2452,8 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		},
 	}
 
-	// 使用默认网络配置
-	networkingConfig := &network.NetworkingConfig{}
+	// 连接到工作空间网络
+	networkingConfig := workspaceNetworkingConfig(workspace, staticIP)
 
 	log.Printf("[%s] 创建容器配置", workspaceID)
 
@@ -2332,7 +2516,21 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		}
 	}
 
-	// 阶段4：更新状态为初始化中
+	// 阶段4：克隆Git仓库，失败时保留工作空间和已有文件，错误通过克隆进度和错误事件报告
+	if workspace.GitRepo != "" {
+		oem.updateWorkspaceStatus(workspaceID, "cloning")
+
+		authRequest.CredentialID = cloneOptions.CredentialID
+		auth, err := oem.gitAuthForURL(authRequest, workspace.GitRepo)
+		if err != nil {
+			log.Printf("[%s] 获取Git凭据失败: %v", workspaceID, err)
+			oem.publishWorkspaceError(workspaceID, fmt.Sprintf("获取Git凭据失败，未克隆仓库: %v", err))
+		} else if err := oem.cloneGitRepo(workspaceID, workspace.GitRepo, workspace.GitBranch, cloneOptions, auth); err != nil {
+			oem.publishWorkspaceError(workspaceID, fmt.Sprintf("克隆仓库失败: %v", err))
+		}
+	}
+
+	// 阶段5：更新状态为初始化中
 	oem.updateWorkspaceStatus(workspaceID, "initializing")
 
 	// 等待容器完全启动并初始化环境
@@ -2345,8 +2543,9 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 		}
 	}()
 
-	// 阶段5：所有初始化完成，状态设为运行中
+	// 阶段6：所有初始化完成，状态设为运行中
 	oem.updateWorkspaceStatus(workspaceID, "running")
+	oem.refreshNetworkIP(workspaceID)
 
 	// 设置启动时间
 	oem.mutex.Lock()
@@ -2357,6 +2556,9 @@ func (oem *OnlineEditorManager) initializeContainer(workspace *Workspace, images
 	oem.generateAccessURLs(workspace)
 	oem.mutex.Unlock()
 
+	// 启动端口转发
+	oem.syncPortForwards(workspaceID)
+
 	// 验证端口绑定
 	if err := oem.verifyPortBindings(workspaceID); err != nil {
 		log.Printf("[%s] 初始化后端口绑定验证失败: %v", workspaceID, err)
@@ -2372,7 +2574,7 @@ func (oem *OnlineEditorManager) updateWorkspaceStatus(workspaceID, status string
 	defer oem.mutex.Unlock()
 
 	if workspace, exists := oem.workspaces[workspaceID]; exists {
-		workspace.Status = status
+		oem.setStatusLocked(workspace, status, "")
 		log.Printf("[%s] 状态更新: %s", workspaceID, status)
 	}
 }
@@ -2475,8 +2677,6 @@ func (oem *OnlineEditorManager) installDevelopmentTools(workspaceID string, envs
 		return fmt.Errorf("工作空间不存在: %s", workspaceID)
 	}
 
-	ctx := context.Background()
-
 	// 检查并安装用户选择的工具
 	var requiredTools []string
 	if workspace.Tools != nil && len(workspace.Tools) > 0 {
@@ -2485,87 +2685,113 @@ func (oem *OnlineEditorManager) installDevelopmentTools(workspaceID string, envs
 		// 默认工具列表
 		requiredTools = []string{"git", "curl", "wget", "vim"}
 	}
+
+	oem.installPackages(workspaceID, requiredTools, envs)
+
+	log.Printf("[%s] 开发环境初始化完成", workspaceID)
+	return nil
+}
+
+// 包名只允许发行版包管理器使用的字符，并且不能以"-"开头（避免被当作选项）
+var packageNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+_-]{0,127}$`)
+
+func validatePackageNames(packages []string) error {
+	for _, name := range packages {
+		if !packageNamePattern.MatchString(name) {
+			return fmt.Errorf("无效的工具名称: %q", name)
+		}
+	}
+	return nil
+}
+
+// 依次尝试的包管理器（按常见程度排序），每种方式是按顺序执行的一组命令，包名作为独立参数传入
+func packageInstallCommands(packages []string) [][][]string {
+	withPackages := func(cmd ...string) []string {
+		return append(cmd, packages...)
+	}
+	return [][][]string{
+		// Debian/Ubuntu
+		{{"apt-get", "update"}, withPackages("apt-get", "install", "-y")},
+		// Alpine
+		{withPackages("apk", "add", "--no-cache")},
+		// CentOS/RHEL/Rocky
+		{withPackages("yum", "install", "-y")},
+		// Fedora
+		{withPackages("dnf", "install", "-y")},
+	}
+}
+
+// 检查工具是否存在，缺失的工具通过系统包管理器安装
+func (oem *OnlineEditorManager) installPackages(workspaceID string, requiredTools []string, envs []string) {
+	if err := validatePackageNames(requiredTools); err != nil {
+		log.Printf("[%s] 跳过工具安装: %v", workspaceID, err)
+		oem.publishWorkspaceError(workspaceID, fmt.Sprintf("跳过工具安装: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), codeInstallTimeout)
+	defer cancel()
 	missingTools := []string{}
 
 	// 检查工具是否存在
 	for _, tool := range requiredTools {
-		checkCmd, _ := scriptManager.GetCommand("check_tool", tool)
-		execConfig := container.ExecOptions{
-			Cmd:          checkCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-			WorkingDir:   "/workspace",
-			Env:          envs,
+		result, err := oem.execInWorkspace(ctx, workspaceID, []string{"which", tool}, "/workspace", envs)
+		if err != nil {
+			continue
 		}
-
-		execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, execConfig)
-		if err == nil {
-			execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-			if err == nil {
-				output, _ := io.ReadAll(execAttachResp.Reader)
-				execAttachResp.Close()
-				if len(output) == 0 {
-					missingTools = append(missingTools, tool)
-				} else {
-					log.Printf("[%s] 工具 %s 已存在", workspaceID, tool)
-				}
-			}
+		if result.ExitCode != 0 {
+			missingTools = append(missingTools, tool)
+		} else {
+			log.Printf("[%s] 工具 %s 已存在", workspaceID, tool)
 		}
 	}
 
-	// 如果有缺失的工具，尝试安装
-	if len(missingTools) > 0 {
-		log.Printf("[%s] 缺失工具: %v，尝试安装...", workspaceID, missingTools)
-
-		// 尝试不同的包管理器（按常见程度排序）
-		installCommands := [][]string{
-			// Debian/Ubuntu
-			{"/bin/bash", "-c", "apt-get update && apt-get install -y " + strings.Join(missingTools, " ")},
-			// Alpine
-			{"/bin/bash", "-c", "apk add --no-cache " + strings.Join(missingTools, " ")},
-			// CentOS/RHEL/Rocky
-			{"/bin/bash", "-c", "yum install -y " + strings.Join(missingTools, " ")},
-			// Fedora
-			{"/bin/bash", "-c", "dnf install -y " + strings.Join(missingTools, " ")},
-		}
-
-		for i, cmd := range installCommands {
-			log.Printf("[%s] 尝试安装方式 %d", workspaceID, i+1)
-
-			installExecConfig := container.ExecOptions{
-				Cmd:          cmd,
-				AttachStdout: true,
-				AttachStderr: true,
-				WorkingDir:   "/workspace",
-				Env:          envs,
-			}
-
-			execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, installExecConfig)
-			if err != nil {
-				continue
-			}
-
-			execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-			if err != nil {
-				continue
-			}
+	oem.publishEvent(WorkspaceEvent{
+		Type:        eventTypeToolInstall,
+		WorkspaceID: workspaceID,
+		Status:      "checked",
+		Data:        map[string]interface{}{"tools": requiredTools, "missing": missingTools},
+	})
 
-			// 读取安装输出
-			output, _ := io.ReadAll(execAttachResp.Reader)
-			execAttachResp.Close()
+	// 如果有缺失的工具，尝试安装
+	if len(missingTools) == 0 {
+		return
+	}
+	log.Printf("[%s] 缺失工具: %v，尝试安装...", workspaceID, missingTools)
+
+	installCommands := packageInstallCommands(missingTools)
+	installed := false
+	for i, commands := range installCommands {
+		log.Printf("[%s] 尝试安装方式 %d", workspaceID, i+1)
+		oem.publishEvent(WorkspaceEvent{
+			Type:        eventTypeToolInstall,
+			WorkspaceID: workspaceID,
+			Status:      "installing",
+			Message:     fmt.Sprintf("尝试安装方式 %d/%d", i+1, len(installCommands)),
+			Data:        map[string]interface{}{"tools": missingTools, "attempt": i + 1},
+		})
 
-			// 检查安装是否成功
-			if strings.Contains(string(output), "installed") ||
-				strings.Contains(string(output), "upgraded") ||
-				strings.Contains(string(output), "OK:") {
-				log.Printf("[%s] 工具安装成功", workspaceID)
+		succeeded := true
+		for _, cmd := range commands {
+			result, err := oem.execInWorkspace(ctx, workspaceID, cmd, "/workspace", envs)
+			if err != nil || result.ExitCode != 0 {
+				succeeded = false
 				break
 			}
 		}
+		if succeeded {
+			log.Printf("[%s] 工具安装成功", workspaceID)
+			installed = true
+			break
+		}
 	}
 
-	log.Printf("[%s] 开发环境初始化完成", workspaceID)
-	return nil
+	if installed {
+		oem.publishEvent(WorkspaceEvent{Type: eventTypeToolInstall, WorkspaceID: workspaceID, Status: "installed", Data: map[string]interface{}{"tools": missingTools}})
+	} else {
+		oem.publishEvent(WorkspaceEvent{Type: eventTypeToolInstall, WorkspaceID: workspaceID, Status: "failed", Data: map[string]interface{}{"tools": missingTools}})
+		oem.publishWorkspaceError(workspaceID, fmt.Sprintf("工具安装失败: %s", strings.Join(missingTools, ", ")))
+	}
 }
 
 // 重新创建容器
@@ -2624,9 +2850,8 @@ func (oem *OnlineEditorManager) recreateContainer(workspace *Workspace) error {
 		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	// 处理端口映射 - 与创建时保持一致
+	// 处理端口映射 - 与创建时保持一致，只暴露容器端口
 	exposedPorts := nat.PortSet{}
-	portBindings := nat.PortMap{}
 
 	log.Printf("[%s] 重建容器时配置端口映射，共%d个端口", workspaceID, len(workspace.Ports))
 
@@ -2644,30 +2869,14 @@ func (oem *OnlineEditorManager) recreateContainer(workspace *Workspace) error {
 
 		log.Printf("[%s] 重建端口配置: 容器%s -> 宿主机%s (协议:%s, 公共访问:%v)",
 			workspaceID, containerPortStr, hostPortStr, protocol, portMapping.PublicAccess)
-
-		if hostPortStr != "" && portMapping.PublicAccess {
-			portBindings[containerPort] = []nat.PortBinding{
-				{
-					HostIP:   "0.0.0.0",
-					HostPort: hostPortStr,
-				},
-			}
-			log.Printf("[%s] 重建端口绑定: %s:%s -> %s", workspaceID, "0.0.0.0", hostPortStr, containerPortStr)
-		}
 	}
 
-	log.Printf("[%s] 重建容器端口配置完成: 暴露%d个，绑定%d个", workspaceID, len(exposedPorts), len(portBindings))
+	log.Printf("[%s] 重建容器端口配置完成: 暴露%d个", workspaceID, len(exposedPorts))
 
-	// 容器挂载卷
-	mounts := []mount.Mount{
-		{
-			Type:   mount.TypeBind,
-			Source: filepath.Join(oem.workspacesDir, workspaceID),
-			Target: "/workspace",
-			BindOptions: &mount.BindOptions{
-				Propagation: mount.PropagationRPrivate,
-			},
-		},
+	// 容器挂载卷 - 命名卷在重建后保留
+	mounts, err := oem.workspaceMounts(ctx, workspace, filepath.Join(oem.workspacesDir, workspaceID))
+	if err != nil {
+		return err
 	}
 
 	// 创建容器配置
@@ -2678,20 +2887,27 @@ func (oem *OnlineEditorManager) recreateContainer(workspace *Workspace) error {
 		OpenStdin:    true,
 		ExposedPorts: exposedPorts,
 		WorkingDir:   "/workspace",
+		Labels:       workspaceContainerLabels(workspace),
 		Cmd:          []string{"tail", "-f", "/dev/null"},
 	}
 
 	hostConfig := &container.HostConfig{
-		Mounts:       mounts,
-		Privileged:   false,
-		PortBindings: portBindings,
+		Mounts:     mounts,
+		Privileged: false,
 		Resources: container.Resources{
 			Memory:    512 * 1024 * 1024,
 			CPUShares: 1024,
 		},
 	}
 
-	networkingConfig := &network.NetworkingConfig{}
+	// 独立网络可能已被清理，重建前确保存在
+	if err := oem.ensureWorkspaceNetwork(ctx, workspaceID, workspace.NetworkName, workspace.Network); err != nil {
+		return err
+	}
+	oem.mutex.RLock()
+	staticIP := oem.workspaceStaticIP(workspaceID)
+	oem.mutex.RUnlock()
+	networkingConfig := workspaceNetworkingConfig(workspace, staticIP)
 
 	log.Printf("[%s] 重新创建容器配置", workspaceID)
 	resp, err := oem.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, workspaceID)
@@ -2702,7 +2918,8 @@ func (oem *OnlineEditorManager) recreateContainer(workspace *Workspace) error {
 	// 更新容器ID
 	oem.mutex.Lock()
 	workspace.ContainerID = resp.ID
-	workspace.Status = "created"
+	workspace.dockerPortBindings = nil
+	oem.setStatusLocked(workspace, "created", "容器已重新创建")
 	oem.mutex.Unlock()
 
 	log.Printf("[%s] 容器重新创建完成: %s", workspaceID, resp.ID)
@@ -2762,8 +2979,14 @@ func (oem *OnlineEditorManager) StartWorkspace(workspaceID string) error {
 		return fmt.Errorf("工作空间已在运行: %s", workspaceID)
 	}
 
+	// 先启动sidecar服务，开发容器启动时数据库等服务已可用
+	oem.startSidecars(workspaceID)
+
 	ctx := context.Background()
+	oem.expectContainerEvent(workspace.ContainerID, eventTypes.ActionStart)
 	if err := oem.dockerClient.ContainerStart(ctx, workspace.ContainerID, container.StartOptions{}); err != nil {
+		oem.cancelContainerEvent(workspace.ContainerID, eventTypes.ActionStart)
+		oem.publishWorkspaceError(workspaceID, fmt.Sprintf("启动容器失败: %v", err))
 		return fmt.Errorf("启动容器失败: %v", err)
 	}
 
@@ -2785,11 +3008,19 @@ func (oem *OnlineEditorManager) StartWorkspace(workspaceID string) error {
 
 	// 使用短锁更新状态
 	oem.mutex.Lock()
-	workspace.Status = "running"
+	oem.setStatusLocked(workspace, "running", "")
+	workspace.AutoStopped = false
 	now := time.Now()
 	workspace.Started = &now
 	oem.mutex.Unlock()
 
+	// 容器重启后IP可能变化，刷新后启动端口转发
+	oem.refreshNetworkIP(workspaceID)
+	oem.mutex.Lock()
+	oem.generateAccessURLs(workspace)
+	oem.mutex.Unlock()
+	oem.syncPortForwards(workspaceID)
+
 	// 验证端口绑定
 	if err := oem.verifyPortBindings(workspaceID); err != nil {
 		log.Printf("[%s] 端口绑定验证失败: %v", workspaceID, err)
@@ -2800,6 +3031,11 @@ func (oem *OnlineEditorManager) StartWorkspace(workspaceID string) error {
 
 // 停止工作空间
 func (oem *OnlineEditorManager) StopWorkspace(workspaceID string) error {
+	return oem.stopWorkspace(workspaceID, false)
+}
+
+// 停止工作空间，autoStopped为true时在更新状态的同一临界区内标记为空闲自动停止
+func (oem *OnlineEditorManager) stopWorkspace(workspaceID string, autoStopped bool) error {
 	// 先获取工作空间信息，使用读锁
 	oem.mutex.RLock()
 	workspace, exists := oem.workspaces[workspaceID]
@@ -2813,17 +3049,25 @@ func (oem *OnlineEditorManager) StopWorkspace(workspaceID string) error {
 		return fmt.Errorf("工作空间未运行: %s", workspaceID)
 	}
 
+	// 结束语言服务器等容器内进程
+	oem.stopStdioSessions(workspaceID, "")
+
 	ctx := context.Background()
+	oem.expectContainerEvent(workspace.ContainerID, eventTypes.ActionDie)
 	if err := oem.dockerClient.ContainerStop(ctx, workspace.ContainerID, container.StopOptions{}); err != nil {
+		oem.cancelContainerEvent(workspace.ContainerID, eventTypes.ActionDie)
 		return fmt.Errorf("停止容器失败: %v", err)
 	}
 
 	// 使用短锁更新状态
 	oem.mutex.Lock()
-	workspace.Status = "stopped"
+	oem.setStatusLocked(workspace, "stopped", "")
 	workspace.Started = nil
+	workspace.AutoStopped = autoStopped
 	oem.mutex.Unlock()
 
+	oem.stopSidecars(workspaceID)
+	oem.syncPortForwards(workspaceID)
 	return nil
 }
 
@@ -2840,21 +3084,21 @@ func (oem *OnlineEditorManager) DeleteWorkspace(workspaceID string) error {
 
 	ctx := context.Background()
 
-	// 释放端口
-	for _, p := range workspace.Ports {
-		if p.HostPort != "" {
-			if hostPort, err := strconv.Atoi(p.HostPort); err == nil {
-				oem.mutex.Lock()
-				oem.releasePort(hostPort)
-				oem.mutex.Unlock()
-			}
+	oem.stopStdioSessions(workspaceID, "")
+
+	// 强制删除容器，创建失败的工作空间可能没有容器或容器已被删除
+	if workspace.ContainerID != "" {
+		oem.expectContainerEvent(workspace.ContainerID, eventTypes.ActionDie, eventTypes.ActionDestroy)
+		if err := oem.dockerClient.ContainerRemove(ctx, workspace.ContainerID, container.RemoveOptions{Force: true}); err != nil && !cerrdefs.IsNotFound(err) {
+			oem.cancelContainerEvent(workspace.ContainerID, eventTypes.ActionDie, eventTypes.ActionDestroy)
+			return fmt.Errorf("删除容器失败: %v", err)
 		}
 	}
 
-	// 强制删除容器
-	if err := oem.dockerClient.ContainerRemove(ctx, workspace.ContainerID, container.RemoveOptions{Force: true}); err != nil {
-		return fmt.Errorf("删除容器失败: %v", err)
-	}
+	// 删除sidecar容器后清理不再使用的工作空间网络
+	oem.removeSidecarContainers(workspaceID, oem.workspaceSidecars(workspaceID))
+	oem.removeWorkspaceNetwork(ctx, workspaceID, workspace.NetworkName, workspace.Network)
+	oem.removeWorkspaceVolumes(ctx, workspaceID, workspace.Volumes)
 
 	// 删除工作空间目录
 	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
@@ -2862,11 +3106,36 @@ func (oem *OnlineEditorManager) DeleteWorkspace(workspaceID string) error {
 		return fmt.Errorf("删除工作空间目录失败: %v", err)
 	}
 
-	// 最后从map中删除
+	// 删除工作空间回收站
+	if err := os.RemoveAll(oem.workspaceTrashDir(workspaceID)); err != nil {
+		log.Printf("[%s] 删除回收站失败: %v", workspaceID, err)
+	}
+	oem.diskUsage.Forget(workspaceID)
+	forgetCodeToolLocks(workspaceID)
+
+	oem.gitClonesMutex.Lock()
+	delete(oem.gitClones, workspaceID)
+	oem.gitClonesMutex.Unlock()
+
+	// 删除调试启动配置
+	oem.debugMutex.Lock()
+	if err := os.Remove(oem.launchConfigsFile(workspaceID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[%s] 删除调试启动配置失败: %v", workspaceID, err)
+	}
+	oem.debugMutex.Unlock()
+
+	// 最后从map中删除并释放端口
 	oem.mutex.Lock()
 	delete(oem.workspaces, workspaceID)
+	oem.releaseWorkspacePorts(workspaceID)
+	oem.releaseWorkspaceIP(workspaceID)
+	oem.previewShares.DeleteWorkspace(workspaceID)
+	previous := workspace.Status
 	oem.mutex.Unlock()
+	oem.syncPortForwards(workspaceID)
+	oem.retention.Forget(workspaceID)
 
+	oem.publishEvent(WorkspaceEvent{Type: eventTypeStatus, WorkspaceID: workspaceID, Status: "deleted", Previous: previous})
 	return nil
 }
 
@@ -2878,8 +3147,7 @@ func (oem *OnlineEditorManager) ListWorkspaces() ([]*Workspace, error) {
 	var workspaces []*Workspace
 	for _, workspace := range oem.workspaces {
 		// 创建工作空间的副本，避免并发访问问题
-		workspaceCopy := *workspace
-		workspaces = append(workspaces, &workspaceCopy)
+		workspaces = append(workspaces, workspace.clone())
 	}
 
 	return workspaces, nil
@@ -2896,14 +3164,36 @@ func (oem *OnlineEditorManager) GetWorkspace(workspaceID string) (*Workspace, er
 	}
 
 	// 返回工作空间的副本，避免并发访问问题
-	workspaceCopy := *workspace
-	return &workspaceCopy, nil
+	return workspace.clone(), nil
+}
+
+// 工作空间的副本，会被原地修改的切片和map（如sidecar的运行状态）一并复制，
+// 调用者必须持有oem.mutex
+func (w *Workspace) clone() *Workspace {
+	copied := *w
+	copied.Ports = append([]PortMapping(nil), w.Ports...)
+	copied.Volumes = append([]VolumeMount(nil), w.Volumes...)
+	copied.AccessURLs = append([]AccessURL(nil), w.AccessURLs...)
+	copied.Tools = append([]string(nil), w.Tools...)
+	copied.Sidecars = append([]SidecarService(nil), w.Sidecars...)
+	if w.Environment != nil {
+		copied.Environment = make(map[string]string, len(w.Environment))
+		for key, value := range w.Environment {
+			copied.Environment[key] = value
+		}
+	}
+	return &copied
 }
 
 // 文件系统操作
 
 // 列出文件 - 使用主机文件系统
 func (oem *OnlineEditorManager) ListFiles(workspaceID, path string) ([]FileInfo, error) {
+	return oem.ListFilesWithOptions(workspaceID, path, defaultListFilesOptions())
+}
+
+// 按选项列出文件，支持递归深度、隐藏文件过滤和排序
+func (oem *OnlineEditorManager) ListFilesWithOptions(workspaceID, path string, opts ListFilesOptions) ([]FileInfo, error) {
 	oem.mutex.RLock()
 	defer oem.mutex.RUnlock()
 
@@ -2918,18 +3208,15 @@ func (oem *OnlineEditorManager) ListFiles(workspaceID, path string) ([]FileInfo,
 		return nil, fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-
 	// 如果路径为空，使用根路径
 	if path == "" {
 		path = "."
 	}
 
-	fullPath := filepath.Join(workspaceDir, path)
-
 	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return nil, fmt.Errorf("访问路径超出工作空间范围")
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, path)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查目录是否存在，如果不存在则创建
@@ -2942,36 +3229,15 @@ func (oem *OnlineEditorManager) ListFiles(workspaceID, path string) ([]FileInfo,
 		return []FileInfo{}, nil
 	}
 
-	entries, err := os.ReadDir(fullPath)
-	if err != nil {
-		return nil, fmt.Errorf("读取目录失败: %v", err)
+	depth := opts.Depth
+	if depth == 0 {
+		depth = 1
 	}
-
-	var files []FileInfo
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		// 构建相对路径
-		relativePath := entry.Name()
-		if path != "." {
-			relativePath = filepath.Join(path, entry.Name())
-		}
-
-		fileInfo := FileInfo{
-			Name:         entry.Name(),
-			Path:         relativePath,
-			IsDir:        entry.IsDir(),
-			Size:         info.Size(),
-			ModifiedTime: info.ModTime(),
-			Permissions:  info.Mode().String(),
-		}
-		files = append(files, fileInfo)
+	if depth < 0 || depth > maxListDepth {
+		depth = maxListDepth
 	}
 
-	return files, nil
+	return readDirEntries(fullPath, path, opts, depth)
 }
 
 // 读取文件 - 使用主机文件系统
@@ -2989,12 +3255,10 @@ func (oem *OnlineEditorManager) ReadFile(workspaceID, filePath string) (string,
 		return "", fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	fullPath := filepath.Join(workspaceDir, filePath)
-
 	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return "", fmt.Errorf("访问路径超出工作空间范围")
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath)
+	if err != nil {
+		return "", err
 	}
 
 	content, err := os.ReadFile(fullPath)
@@ -3007,25 +3271,25 @@ func (oem *OnlineEditorManager) ReadFile(workspaceID, filePath string) (string,
 
 // 写入文件 - 使用主机文件系统
 func (oem *OnlineEditorManager) WriteFile(workspaceID, filePath, content string) error {
-	oem.mutex.Lock()
-	defer oem.mutex.Unlock()
-
-	workspace, exists := oem.workspaces[workspaceID]
-	if !exists {
-		return fmt.Errorf("工作空间不存在: %s", workspaceID)
+	// 配额检查可能扫描工作空间目录，不持有oem.mutex
+	if _, err := oem.fileOperationDir(workspaceID); err != nil {
+		return err
 	}
 
-	// 只在工作空间明确失败或停止时才禁止访问文件系统
-	if workspace.Status == "failed" || workspace.Status == "stopped" {
-		return fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
+	// 检查路径是否在工作空间内
+	fullPath, err := oem.resolveWorkspacePath(workspaceID, filePath)
+	if err != nil {
+		return err
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	fullPath := filepath.Join(workspaceDir, filePath)
-
-	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return fmt.Errorf("访问路径超出工作空间范围")
+	// 检查磁盘配额，只计算相对原文件增加的部分
+	var existingSize, newFiles int64 = 0, 1
+	if info, err := os.Stat(fullPath); err == nil {
+		existingSize, newFiles = info.Size(), 0
+	}
+	sizeDelta := int64(len(content)) - existingSize
+	if err := oem.checkDiskQuota(workspaceID, sizeDelta); err != nil {
+		return err
 	}
 
 	// 创建目录
@@ -3036,8 +3300,10 @@ func (oem *OnlineEditorManager) WriteFile(workspaceID, filePath, content string)
 
 	// 写入文件
 	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		oem.markDiskUsageDirty(workspaceID, fullPath)
 		return fmt.Errorf("写入文件失败: %v", err)
 	}
+	oem.addDiskUsage(workspaceID, fullPath, sizeDelta, newFiles)
 
 	return nil
 }
@@ -3052,18 +3318,23 @@ func (oem *OnlineEditorManager) DeleteFile(workspaceID, filePath string) error {
 		return fmt.Errorf("工作空间不存在: %s", workspaceID)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	fullPath := filepath.Join(workspaceDir, filePath)
+	// 检查路径是否在工作空间内，删除符号链接本身而不是其目标
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, filePath)
+	if err != nil {
+		return err
+	}
 
-	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return fmt.Errorf("访问路径超出工作空间范围")
+	// 不允许删除工作空间根目录
+	if workspaceDir, err := oem.workspaceRealDir(workspaceID); err != nil || fullPath == workspaceDir {
+		return errPathOutsideWorkspace
 	}
 
 	if err := os.RemoveAll(fullPath); err != nil {
 		return fmt.Errorf("删除文件失败: %v", err)
 	}
 
+	oem.markDiskUsageDirty(workspaceID, fullPath)
+
 	return nil
 }
 
@@ -3082,16 +3353,14 @@ func (oem *OnlineEditorManager) CreateFile(workspaceID, filePath string) error {
 		return fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	fullPath := filepath.Join(workspaceDir, filePath)
-
 	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return fmt.Errorf("访问路径超出工作空间范围")
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, filePath)
+	if err != nil {
+		return err
 	}
 
 	// 检查文件是否已存在
-	if _, err := os.Stat(fullPath); err == nil {
+	if _, err := os.Lstat(fullPath); err == nil {
 		return fmt.Errorf("文件已存在: %s", filePath)
 	}
 
@@ -3126,16 +3395,14 @@ func (oem *OnlineEditorManager) CreateFolder(workspaceID, folderPath string) err
 		return fmt.Errorf("工作空间状态异常，无法访问文件系统。当前状态: %s", workspace.Status)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	fullPath := filepath.Join(workspaceDir, folderPath)
-
 	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(fullPath, workspaceDir) {
-		return fmt.Errorf("访问路径超出工作空间范围")
+	fullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, folderPath)
+	if err != nil {
+		return err
 	}
 
 	// 检查文件夹是否已存在
-	if _, err := os.Stat(fullPath); err == nil {
+	if _, err := os.Lstat(fullPath); err == nil {
 		return fmt.Errorf("文件夹已存在: %s", folderPath)
 	}
 
@@ -3162,22 +3429,23 @@ func (oem *OnlineEditorManager) MoveFile(workspaceID, sourcePath, targetPath str
 		return fmt.Errorf("工作空间未运行: %s", workspaceID)
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	sourceFullPath := filepath.Join(workspaceDir, sourcePath)
-	targetFullPath := filepath.Join(workspaceDir, targetPath)
-
-	// 检查路径是否在工作空间内
-	if !strings.HasPrefix(sourceFullPath, workspaceDir) || !strings.HasPrefix(targetFullPath, workspaceDir) {
-		return fmt.Errorf("访问路径超出工作空间范围")
+	// 检查路径是否在工作空间内，移动符号链接本身而不是其目标
+	sourceFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, sourcePath)
+	if err != nil {
+		return err
+	}
+	targetFullPath, err := oem.resolveWorkspacePathNoFollow(workspaceID, targetPath)
+	if err != nil {
+		return err
 	}
 
 	// 检查源文件是否存在
-	if _, err := os.Stat(sourceFullPath); os.IsNotExist(err) {
+	if _, err := os.Lstat(sourceFullPath); os.IsNotExist(err) {
 		return fmt.Errorf("源文件不存在: %s", sourcePath)
 	}
 
 	// 检查目标路径是否已存在
-	if _, err := os.Stat(targetFullPath); err == nil {
+	if _, err := os.Lstat(targetFullPath); err == nil {
 		return fmt.Errorf("目标路径已存在: %s", targetPath)
 	}
 
@@ -3191,6 +3459,7 @@ func (oem *OnlineEditorManager) MoveFile(workspaceID, sourcePath, targetPath str
 	if err := os.Rename(sourceFullPath, targetFullPath); err != nil {
 		return fmt.Errorf("移动文件失败: %v", err)
 	}
+	oem.markDiskUsageDirty(workspaceID, sourceFullPath, targetFullPath)
 
 	return nil
 }
@@ -3214,11 +3483,11 @@ func (oem *OnlineEditorManager) CreateTerminalSession(workspaceID string) (*Term
 	sessionID := generateTerminalID()
 
 	session := &TerminalSession{
-		ID:           sessionID,
-		WorkspaceID:  workspaceID,
-		Created:      time.Now(),
-		LastActivity: time.Now(),
+		ID:          sessionID,
+		WorkspaceID: workspaceID,
+		Created:     time.Now(),
 	}
+	session.touch()
 
 	oem.terminalSessions[sessionID] = session
 
@@ -3257,9 +3526,8 @@ func (oem *OnlineEditorManager) ExecuteCommand(workspaceID string, command []str
 		case "cd":
 			// cd命令需要特殊处理，因为它是shell内置命令
 			if len(command) > 1 {
-				// 使用shell来执行cd命令并获取新的工作目录
-				shellCmd := fmt.Sprintf("cd %s && pwd", command[1])
-				command = []string{"/bin/bash", "-c", shellCmd}
+				// 使用shell来执行cd命令并获取新的工作目录，目录作为位置参数传入而不是拼接进脚本
+				command = []string{"/bin/bash", "-c", `cd -- "$1" && pwd`, "cd", command[1]}
 			} else {
 				// cd without arguments - go to home directory
 				command = []string{"/bin/bash", "-c", "cd ~ && pwd"}
@@ -3267,14 +3535,11 @@ func (oem *OnlineEditorManager) ExecuteCommand(workspaceID string, command []str
 		case "pwd":
 			// 确保pwd命令在正确的工作目录执行
 			command = []string{"/bin/bash", "-c", "pwd"}
-		case "ls", "ll":
-			// 使用shell来执行ls命令以支持别名
-			if len(command) == 1 {
-				command = []string{"/bin/bash", "-c", command[0]}
-			} else {
-				shellCmd := fmt.Sprintf("%s %s", command[0], strings.Join(command[1:], " "))
-				command = []string{"/bin/bash", "-c", shellCmd}
-			}
+		case "ls":
+			// 参数直接作为argv传入，不经过shell
+		case "ll":
+			// ll是常见的ls别名，展开后直接执行
+			command = append([]string{"ls", "-alF"}, command[1:]...)
 		default:
 			// 对于其他命令，如果只有一个参数且可能是复合命令，使用shell执行
 			if len(command) == 1 && (strings.Contains(command[0], "&&") ||
@@ -3362,48 +3627,19 @@ func (oem *OnlineEditorManager) ExecuteCommand(workspaceID string, command []str
 
 // Git操作
 
-// 克隆Git仓库
-func (oem *OnlineEditorManager) cloneGitRepo(workspaceID, repo, branch string) error {
-	workspace, exists := oem.workspaces[workspaceID]
-	if !exists {
-		return fmt.Errorf("工作空间不存在: %s", workspaceID)
-	}
-
-	ctx := context.Background()
-	// 在容器内执行git clone
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"git", "clone", "-b", branch, repo, "."},
-		AttachStdout: true,
-		AttachStderr: true,
-		WorkingDir:   "/workspace",
-	}
-
-	execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, execConfig)
-	if err != nil {
-		return fmt.Errorf("创建git clone执行配置失败: %v", err)
-	}
-
-	execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-	if err != nil {
-		return fmt.Errorf("附加到git clone执行失败: %v", err)
+// Git操作
+func (oem *OnlineEditorManager) GitOperation(workspaceID string, operation GitOperation) (string, error) {
+	// 克隆使用独立的流程，进度可通过克隆进度接口查看
+	if operation.Type == "clone" {
+		return oem.gitOperationClone(workspaceID, operation)
 	}
-	defer execAttachResp.Close()
 
-	output, err := io.ReadAll(execAttachResp.Reader)
+	// 需要访问远程仓库的操作先准备凭据（查询远程地址需要执行git命令，必须在加锁之前）
+	auth, err := oem.gitOperationAuth(workspaceID, operation)
 	if err != nil {
-		return fmt.Errorf("读取git clone输出失败: %v", err)
-	}
-
-	// 检查执行结果
-	if len(output) > 0 {
-		log.Printf("Git clone output: %s", string(output))
+		return "", err
 	}
 
-	return nil
-}
-
-// Git操作
-func (oem *OnlineEditorManager) GitOperation(workspaceID string, operation GitOperation) (string, error) {
 	oem.mutex.RLock()
 	defer oem.mutex.RUnlock()
 
@@ -3427,29 +3663,14 @@ func (oem *OnlineEditorManager) GitOperation(workspaceID string, operation GitOp
 		return "", fmt.Errorf("容器未运行，当前状态: %s", containerInfo.State.Status)
 	}
 
+	// 所有参数以argv传入，文件和引用放在"--"之后或经过校验，避免被当作选项
 	var cmd []string
 	switch operation.Type {
-	case "clone":
-		// 使用工作空间中保存的Git仓库信息进行克隆
-		if workspace.GitRepo == "" {
-			return "", fmt.Errorf("工作空间未配置Git仓库")
-		}
-		repo := operation.Repo
-		branch := operation.Branch
-		if repo == "" {
-			repo = workspace.GitRepo
-		}
-		if branch == "" {
-			branch = workspace.GitBranch
-		}
-
-		// 先清空工作空间目录，然后克隆
-		cmd = []string{"/bin/sh", "-c", fmt.Sprintf("rm -rf /workspace/* /workspace/.* 2>/dev/null || true && git clone -b %s %s .", branch, repo)}
 	case "status":
 		cmd = []string{"git", "status"}
 	case "add":
 		if len(operation.Files) > 0 {
-			cmd = append([]string{"git", "add"}, operation.Files...)
+			cmd = append([]string{"git", "add", "--"}, operation.Files...)
 		} else {
 			cmd = []string{"git", "add", "."}
 		}
@@ -3460,7 +3681,10 @@ func (oem *OnlineEditorManager) GitOperation(workspaceID string, operation GitOp
 	case "pull":
 		cmd = []string{"git", "pull"}
 	case "checkout":
-		cmd = []string{"git", "checkout", operation.Branch}
+		if err := validateGitRevision(operation.Branch); err != nil {
+			return "", err
+		}
+		cmd = []string{"git", "checkout", operation.Branch, "--"}
 	case "branch":
 		cmd = []string{"git", "branch"}
 	case "log":
@@ -3490,11 +3714,11 @@ func (oem *OnlineEditorManager) GitOperation(workspaceID string, operation GitOp
 
 	// 在容器内执行Git命令
 	execConfig := container.ExecOptions{
-		Cmd:          cmd,
+		Cmd:          auth.command(cmd),
 		AttachStdout: true,
 		AttachStderr: true,
 		WorkingDir:   "/workspace",
-		Env:          envs,
+		Env:          append(append(envs, gitAllowProtocolEnv()), auth.environment()...),
 	}
 
 	execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, execConfig)
@@ -3589,91 +3813,7 @@ func (oem *OnlineEditorManager) installTools(workspaceID string) error {
 	}
 
 	// 检查并安装必要的工具
-	requiredTools := []string{"git", "curl", "wget", "vim", "nano"}
-	missingTools := []string{}
-
-	for _, tool := range requiredTools {
-		checkCmd, _ := scriptManager.GetCommand("check_tool", tool)
-		execConfig := container.ExecOptions{
-			Cmd:          checkCmd,
-			AttachStdout: true,
-			AttachStderr: true,
-			WorkingDir:   "/workspace",
-			Env:          envs,
-		}
-
-		execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, execConfig)
-		if err == nil {
-			execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-			if err == nil {
-				output, _ := io.ReadAll(execAttachResp.Reader)
-				execAttachResp.Close()
-				if len(output) == 0 {
-					missingTools = append(missingTools, tool)
-				} else {
-					log.Printf("工具 %s 已存在: %s", tool, strings.TrimSpace(string(output)))
-				}
-			}
-		}
-	}
-
-	// 如果有缺失的工具，尝试安装
-	if len(missingTools) > 0 {
-		log.Printf("缺失工具: %v，尝试安装...", missingTools)
-
-		// 尝试不同的包管理器
-		tools := strings.Join(missingTools, " ")
-		aptCmd, _ := scriptManager.GetCommand("install_apt", tools)
-		yumCmd, _ := scriptManager.GetCommand("install_yum", tools)
-		apkCmd, _ := scriptManager.GetCommand("install_apk", tools)
-		dnfCmd, _ := scriptManager.GetCommand("install_dnf", tools)
-
-		installCommands := [][]string{
-			aptCmd,
-			yumCmd,
-			apkCmd,
-			dnfCmd,
-		}
-
-		success := false
-		for i, cmd := range installCommands {
-			log.Printf("尝试安装命令 %d: %s", i+1, strings.Join(cmd, " "))
-
-			installExecConfig := container.ExecOptions{
-				Cmd:          cmd,
-				AttachStdout: true,
-				AttachStderr: true,
-				WorkingDir:   "/workspace",
-				Env:          envs,
-			}
-
-			execResp, err := oem.dockerClient.ContainerExecCreate(ctx, workspace.ContainerID, installExecConfig)
-			if err != nil {
-				log.Printf("创建安装命令失败: %v", err)
-				continue
-			}
-
-			execAttachResp, err := oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
-			if err != nil {
-				log.Printf("执行安装命令失败: %v", err)
-				continue
-			}
-
-			// 读取安装输出
-			output, err := io.ReadAll(execAttachResp.Reader)
-			execAttachResp.Close()
-
-			if err == nil {
-				log.Printf("安装输出: %s", string(output))
-				success = true
-				break
-			}
-		}
-
-		if !success {
-			log.Printf("警告: 无法安装工具，容器可能使用了不支持的包管理器")
-		}
-	}
+	oem.installPackages(workspaceID, []string{"git", "curl", "wget", "vim", "nano"}, envs)
 
 	// 设置工作空间状态为完全初始化
 	oem.mutex.Lock()
@@ -3738,6 +3878,7 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 
 	// API路由
 	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(oem.workspaceActivityMiddleware)
 
 	// 工作空间管理
 	api.HandleFunc("/workspaces", oem.handleListWorkspaces).Methods("GET")
@@ -3745,6 +3886,7 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 	api.HandleFunc("/workspaces/{id}", oem.handleGetWorkspace).Methods("GET")
 	api.HandleFunc("/workspaces/{id}/start", oem.handleStartWorkspace).Methods("POST")
 	api.HandleFunc("/workspaces/{id}/stop", oem.handleStopWorkspace).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/activity", oem.handleGetWorkspaceActivity).Methods("GET")
 	api.HandleFunc("/workspaces/{id}", oem.handleDeleteWorkspace).Methods("DELETE")
 
 	// 文件系统
@@ -3755,6 +3897,41 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 	api.HandleFunc("/workspaces/{id}/files/mkdir", oem.handleCreateFolder).Methods("POST")
 	api.HandleFunc("/workspaces/{id}/files/move", oem.handleMoveFile).Methods("POST")
 	api.HandleFunc("/workspaces/{id}/files/delete", oem.handleDeleteFile).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/copy", oem.handleCopyFile).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/batch/delete", oem.handleBatchDeleteFiles).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/batch/move", oem.handleBatchMoveFiles).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/batch/copy", oem.handleBatchCopyFiles).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/stat", oem.handleStatFile).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/files/chmod", oem.handleChmodFile).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/chown", oem.handleChownFile).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/files/upload", oem.handleUploadFiles).Methods("POST")
+
+	// 代码格式化与检查
+	api.HandleFunc("/workspaces/{id}/format", oem.handleFormatFile).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/lint", oem.handleLintFile).Methods("POST")
+
+	// 语言服务器
+	api.HandleFunc("/workspaces/{id}/lsp", oem.handleListLanguageServers).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/lsp/{language}/ws", oem.handleLanguageServerWebSocket).Methods("GET")
+
+	// 调试
+	api.HandleFunc("/workspaces/{id}/debug/configurations", oem.handleGetLaunchConfigurations).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/debug/configurations", oem.handleSaveLaunchConfigurations).Methods("PUT")
+	api.HandleFunc("/workspaces/{id}/debug/configurations/{name}", oem.handleDeleteLaunchConfiguration).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/debug/sessions", oem.handleListDebugSessions).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/debug/sessions/{sessionId}", oem.handleStopDebugSession).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/debug/{type}/ws", oem.handleDebugWebSocket).Methods("GET")
+
+	// 磁盘用量与配额
+	api.HandleFunc("/workspaces/{id}/disk-usage", oem.handleGetDiskUsage).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/disk-quota", oem.handleSetDiskQuota).Methods("PUT", "DELETE")
+	api.HandleFunc("/admin/disk-usage", oem.handleListDiskUsage).Methods("GET")
+
+	// 回收站
+	api.HandleFunc("/workspaces/{id}/trash", oem.handleListTrash).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/trash", oem.handlePurgeTrash).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/trash/{trashId}", oem.handlePurgeTrash).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/trash/{trashId}/restore", oem.handleRestoreTrash).Methods("POST")
 
 	// 终端
 	api.HandleFunc("/workspaces/{id}/terminal", oem.handleCreateTerminal).Methods("POST")
@@ -3765,6 +3942,39 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 
 	// Git操作
 	api.HandleFunc("/workspaces/{id}/git", oem.handleGitOperation).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/clone", oem.handleGetCloneProgress).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/clone/ws", oem.handleCloneProgressWebSocket).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/status", oem.handleGitStatus).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/log", oem.handleGitLog).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/branches", oem.handleGitBranches).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/diff", oem.handleGitDiff).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/blame", oem.handleGitBlame).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/fetch", oem.handleGitFetch).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/merge", oem.handleGitMerge).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/rebase", oem.handleGitRebase).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/reset", oem.handleGitReset).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/conflicts", oem.handleGitConflicts).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/conflicts/file", oem.handleGitConflictContent).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/conflicts/resolve", oem.handleGitResolveConflict).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/conflicts/continue", oem.handleGitContinueOperation).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/conflicts/abort", oem.handleGitAbortOperation).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/pulls", oem.handleGitListPullRequests).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/pulls", oem.handleGitCreatePullRequest).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/pulls/{number}/checkout", oem.handleGitCheckoutPullRequest).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/stash", oem.handleGitStashList).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/stash", oem.handleGitStash).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/tags", oem.handleGitTags).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/tags", oem.handleGitCreateTag).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/tags/{name:.+}", oem.handleGitDeleteTag).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/git/remotes", oem.handleGitRemotes).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/git/remotes", oem.handleGitAddRemote).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/git/remotes/{name}", oem.handleGitUpdateRemote).Methods("PUT")
+	api.HandleFunc("/workspaces/{id}/git/remotes/{name}", oem.handleGitRemoveRemote).Methods("DELETE")
+
+	// Git凭据
+	api.HandleFunc("/git/credentials", oem.handleListGitCredentials).Methods("GET")
+	api.HandleFunc("/git/credentials", oem.handleAddGitCredential).Methods("POST")
+	api.HandleFunc("/git/credentials/{credentialId}", oem.handleDeleteGitCredential).Methods("DELETE")
 
 	// 镜像管理
 	api.HandleFunc("/images", oem.handleListImages).Methods("GET")
@@ -3792,10 +4002,49 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 	// 端口访问管理
 	api.HandleFunc("/workspaces/{id}/ports/check", oem.handleCheckPorts).Methods("POST")
 	api.HandleFunc("/workspaces/{id}/ports/status", oem.handleGetPortStatus).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/ports/detected", oem.handleGetDetectedPorts).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/ports/events/ws", oem.handlePortEventsWebSocket).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/ports", oem.handleListPortForwards).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/ports", oem.handleAddPortMapping).Methods("POST")
 	api.HandleFunc("/workspaces/{id}/ports", oem.handleUpdatePortBindings).Methods("PUT")
+	api.HandleFunc("/workspaces/{id}/ports/{port}", oem.handleUpdatePortMapping).Methods("PATCH")
+	api.HandleFunc("/workspaces/{id}/ports/{port}", oem.handleRemovePortMapping).Methods("DELETE")
+	api.HandleFunc("/workspaces/{id}/proxy/token", oem.handleCreatePreviewToken).Methods("POST")
+	api.HandleFunc("/network/ip-pool/stats", oem.handleGetIPPoolStats).Methods("GET")
+	api.HandleFunc("/network/ip-pool/allocations", oem.handleGetIPPoolAllocations).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/shares", oem.handleListPreviewShares).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/shares", oem.handleCreatePreviewShare).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/shares/{shareId}", oem.handleGetPreviewShare).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/shares/{shareId}", oem.handleRevokePreviewShare).Methods("DELETE")
+
+	// Sidecar服务
+	api.HandleFunc("/workspaces/{id}/sidecars", oem.handleListSidecars).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/sidecars", oem.handleAddSidecar).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/sidecars/import", oem.handleImportComposeSidecars).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/sidecars/{name}", oem.handleRemoveSidecar).Methods("DELETE")
+
+	// 命名卷管理
+	api.HandleFunc("/volumes", oem.handleListVolumes).Methods("GET")
+	api.HandleFunc("/volumes/prune", oem.handlePruneVolumes).Methods("POST")
+	api.HandleFunc("/volumes/{name}", oem.handleGetVolume).Methods("GET")
 
 	// 工作空间收藏
 	api.HandleFunc("/workspaces/{id}/favorite", oem.handleToggleFavorite).Methods("POST")
+	api.HandleFunc("/workspaces/{id}/pin", oem.handleTogglePin).Methods("POST")
+
+	// 保留策略
+	api.HandleFunc("/retention/policy", oem.handleGetRetentionPolicy).Methods("GET")
+	api.HandleFunc("/retention/policy", oem.handleUpdateRetentionPolicy).Methods("PUT")
+	api.HandleFunc("/retention/report", oem.handleGetRetentionReport).Methods("GET")
+	api.HandleFunc("/retention/run", oem.handleRunRetention).Methods("POST")
+	api.HandleFunc("/retention/archives", oem.handleListRetentionArchives).Methods("GET")
+	api.HandleFunc("/retention/archives/{id}/file", oem.handleDownloadRetentionArchive).Methods("GET")
+
+	// 生命周期事件
+	api.HandleFunc("/events", oem.handleEventStream).Methods("GET")
+	api.HandleFunc("/events/ws", oem.handleEventWebSocket).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/events", oem.handleEventStream).Methods("GET")
+	api.HandleFunc("/workspaces/{id}/events/ws", oem.handleEventWebSocket).Methods("GET")
 
 	// 端口测试
 	api.HandleFunc("/workspaces/{id}/test-port/{port}", oem.handleTestPort).Methods("POST")
@@ -3821,7 +4070,7 @@ func (oem *OnlineEditorManager) StartServer(port int) error {
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static")))
 
 	log.Printf("在线代码编辑器服务器启动在端口 %d", port)
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), router)
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), oem.previewProxyHandler(router))
 }
 
 // HTTP处理器
@@ -3845,6 +4094,10 @@ func (oem *OnlineEditorManager) handleCreateWorkspace(w http.ResponseWriter, r *
 		Ports       []PortMapping     `json:"ports"`
 		Tools       []string          `json:"tools"`
 		Environment map[string]string `json:"environment"`
+		GitClone    GitCloneOptions   `json:"git_clone"`
+		Network     WorkspaceNetwork  `json:"network"`
+		Sidecars    []SidecarService  `json:"sidecars"`
+		Volumes     []VolumeMount     `json:"volumes"` // 额外的命名卷和缓存卷
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -3857,7 +4110,8 @@ func (oem *OnlineEditorManager) handleCreateWorkspace(w http.ResponseWriter, r *
 		req.Environment = make(map[string]string)
 	}
 
-	workspace, err := oem.CreateWorkspace(req.Name, req.Image, req.GitRepo, req.GitBranch, req.Ports, req.Tools, req.Environment)
+	authRequest := GitAuthRequest{UserID: requestUserID(r)}
+	workspace, err := oem.CreateWorkspace(req.Name, req.Image, req.GitRepo, req.GitBranch, req.Ports, req.Tools, req.Environment, req.GitClone, req.Network, req.Sidecars, req.Volumes, authRequest)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -3870,6 +4124,7 @@ func (oem *OnlineEditorManager) handleGetWorkspace(w http.ResponseWriter, r *htt
 	vars := mux.Vars(r)
 	workspaceID := vars["id"]
 
+	oem.refreshSidecars(workspaceID)
 	workspace, err := oem.GetWorkspace(workspaceID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
@@ -3920,7 +4175,7 @@ func (oem *OnlineEditorManager) handleListFiles(w http.ResponseWriter, r *http.R
 	workspaceID := vars["id"]
 	path := r.URL.Query().Get("path")
 
-	files, err := oem.ListFiles(workspaceID, path)
+	files, err := oem.ListFilesWithOptions(workspaceID, path, parseListFilesOptions(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -3982,7 +4237,7 @@ func (oem *OnlineEditorManager) handleWriteFile(w http.ResponseWriter, r *http.R
 	}
 
 	if err := oem.WriteFile(workspaceID, req.Path, req.Content); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), fileWriteErrorStatus(err))
 		return
 	}
 
@@ -3994,7 +4249,8 @@ func (oem *OnlineEditorManager) handleDeleteFile(w http.ResponseWriter, r *http.
 	workspaceID := vars["id"]
 
 	var req struct {
-		Path string `json:"path"`
+		Path  string `json:"path"`
+		Trash bool   `json:"trash"` // 移入回收站而不是永久删除
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -4007,6 +4263,17 @@ func (oem *OnlineEditorManager) handleDeleteFile(w http.ResponseWriter, r *http.
 		return
 	}
 
+	if req.Trash {
+		trashID, err := oem.TrashFile(workspaceID, req.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"trash_id": trashID})
+		return
+	}
+
 	if err := oem.DeleteFile(workspaceID, req.Path); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4107,6 +4374,38 @@ func (oem *OnlineEditorManager) handleCreateTerminal(w http.ResponseWriter, r *h
 	json.NewEncoder(w).Encode(session)
 }
 
+const websocketWriteTimeout = 10 * time.Second
+
+// 只推送不接收的WebSocket连接（如克隆进度）：后台读取客户端消息以发现断开，
+// 写入带超时，避免卡住的客户端阻塞推送
+type webSocketPusher struct {
+	conn *websocket.Conn
+	gone chan struct{} // 客户端断开后关闭
+}
+
+func newWebSocketPusher(conn *websocket.Conn) *webSocketPusher {
+	pusher := &webSocketPusher{conn: conn, gone: make(chan struct{})}
+	go func() {
+		defer close(pusher.gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return pusher
+}
+
+func (p *webSocketPusher) send(value interface{}) error {
+	p.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	return p.conn.WriteJSON(value)
+}
+
+func (p *webSocketPusher) close(code int, text string) {
+	p.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, text))
+}
+
 // 优化的终端WebSocket处理器 - 支持真正的交互式终端
 func (oem *OnlineEditorManager) handleTerminalWebSocket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -4140,7 +4439,7 @@ func (oem *OnlineEditorManager) handleTerminalWebSocket(w http.ResponseWriter, r
 	}
 
 	session.WebSocket = conn
-	session.LastActivity = time.Now()
+	session.touch()
 
 	// 创建交互式终端
 	ctx := context.Background()
@@ -4269,7 +4568,7 @@ func (oem *OnlineEditorManager) handleTerminalWebSocket(w http.ResponseWriter, r
 				}
 
 				// 更新活动时间
-				session.LastActivity = time.Now()
+				session.touch()
 			}
 		}
 	}()
@@ -4308,7 +4607,7 @@ func (oem *OnlineEditorManager) handleTerminalWebSocket(w http.ResponseWriter, r
 					}
 
 					// 更新活动时间
-					session.LastActivity = time.Now()
+					session.touch()
 				}
 			}
 		}
@@ -4356,6 +4655,7 @@ func (oem *OnlineEditorManager) handleGitOperation(w http.ResponseWriter, r *htt
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	operation.UserID = requestUserID(r)
 
 	output, err := oem.GitOperation(workspaceID, operation)
 	if err != nil {
@@ -4704,6 +5004,7 @@ func (oem *OnlineEditorManager) handleToggleFavorite(w http.ResponseWriter, r *h
 	// 切换收藏状态
 	workspace.IsFavorite = !workspace.IsFavorite
 	oem.mutex.Unlock()
+	oem.saveRetentionFlags(workspaceID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -4732,6 +5033,12 @@ func (oem *OnlineEditorManager) handleTestPort(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// 端口会被填入测试脚本，必须是合法的端口号
+	if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+		http.Error(w, "无效的端口号", http.StatusBadRequest)
+		return
+	}
+
 	// 在容器内启动一个简单的HTTP服务器进行测试
 	testCmd, err := scriptManager.FormatScript("port_test_server", port, port, port, port, port, port)
 	if err != nil {
@@ -4801,6 +5108,7 @@ func (oem *OnlineEditorManager) handleGetPortStatus(w http.ResponseWriter, r *ht
 	})
 }
 
+// 替换全部端口映射，端口转发立即生效，不重启容器
 func (oem *OnlineEditorManager) handleUpdatePortBindings(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	workspaceID := vars["id"]
@@ -4814,68 +5122,10 @@ func (oem *OnlineEditorManager) handleUpdatePortBindings(w http.ResponseWriter,
 		return
 	}
 
-	oem.mutex.Lock()
-	workspace, exists := oem.workspaces[workspaceID]
-	if !exists {
-		oem.mutex.Unlock()
-		http.Error(w, "工作空间不存在", http.StatusNotFound)
-		return
-	}
-
-	// 更新端口配置
-	workspace.Ports = req.Ports
-	wasRunning := workspace.Status == "running"
-	oem.mutex.Unlock()
-
-	// 如果工作空间正在运行，重启容器以应用新的端口配置
-	if wasRunning {
-		log.Printf("[%s] 端口配置已更新，重启容器以应用新配置", workspaceID)
-
-		// 停止容器
-		if err := oem.StopWorkspace(workspaceID); err != nil {
-			log.Printf("[%s] 停止容器失败: %v", workspaceID, err)
-			http.Error(w, "停止容器失败: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// 删除旧容器
-		ctx := context.Background()
-		if err := oem.dockerClient.ContainerRemove(ctx, workspace.ContainerID, container.RemoveOptions{Force: true}); err != nil {
-			log.Printf("[%s] 删除旧容器失败: %v", workspaceID, err)
-		}
-
-		// 重新创建并启动容器
-		go func() {
-			time.Sleep(2 * time.Second) // 等待容器完全停止
-
-			// 重新初始化容器
-			if err := oem.recreateContainer(workspace); err != nil {
-				log.Printf("[%s] 重新创建容器失败: %v", workspaceID, err)
-				oem.mutex.Lock()
-				workspace.Status = "failed"
-				oem.mutex.Unlock()
-				return
-			}
-
-			// 启动容器
-			if err := oem.StartWorkspace(workspaceID); err != nil {
-				log.Printf("[%s] 启动容器失败: %v", workspaceID, err)
-				oem.mutex.Lock()
-				workspace.Status = "failed"
-				oem.mutex.Unlock()
-			} else {
-				log.Printf("[%s] 容器重启完成，端口配置已应用", workspaceID)
-			}
-		}()
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":    "端口配置已更新",
-		"ports":      workspace.Ports,
-		"restarting": wasRunning,
-		"note":       "容器正在重启以应用新的端口配置",
+	ports, err := oem.updatePortMappings(workspaceID, func([]PortMapping) ([]PortMapping, error) {
+		return req.Ports, nil
 	})
+	writePortMappingResponse(w, oem, workspaceID, ports, err)
 }
 
 // 镜像管理相关方法
@@ -4998,7 +5248,9 @@ func (oem *OnlineEditorManager) UpdateWorkspaceStatus(workspaceID string) error
 		return err
 	}
 
-	workspace.Status = status
+	oem.mutex.Lock()
+	oem.setStatusLocked(workspace, status, "")
+	oem.mutex.Unlock()
 	return nil
 }
 
@@ -5391,39 +5643,20 @@ func (oem *OnlineEditorManager) HealthCheck() error {
 	return nil
 }
 
-// 清理过期的工作空间
-func (oem *OnlineEditorManager) CleanupExpiredWorkspaces(maxAge time.Duration) {
-	oem.mutex.Lock()
-	defer oem.mutex.Unlock()
-
-	now := time.Now()
-	for workspaceID, workspace := range oem.workspaces {
-		if now.Sub(workspace.Created) > maxAge {
-			oem.logInfo("清理过期工作空间", workspaceID)
-			// 删除容器
-			ctx := context.Background()
-			if err := oem.dockerClient.ContainerRemove(ctx, workspace.ContainerID, container.RemoveOptions{Force: true}); err != nil {
-				oem.logError("删除过期容器", err)
-			}
-			// 删除本地目录
-			workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-			if err := os.RemoveAll(workspaceDir); err != nil {
-				oem.logError("删除过期工作空间目录", err)
-			}
-			delete(oem.workspaces, workspaceID)
-		}
-	}
-}
-
 // 启动定期清理任务
 func (oem *OnlineEditorManager) StartCleanupTask() {
+	oem.restoreRetentionFlags()
+
 	go func() {
 		ticker := time.NewTicker(1 * time.Hour) // 每小时清理一次
 		defer ticker.Stop()
 
 		for range ticker.C {
-			oem.CleanupExpiredWorkspaces(24 * time.Hour) // 清理超过24小时的工作空间
-			oem.CleanupExpiredDownloads()                // 清理过期的下载文件
+			// 按保留策略停止、归档、删除长时间无活动的工作空间
+			if _, err := oem.ApplyRetentionPolicy(false); err != nil {
+				log.Printf("执行保留策略失败: %v", err)
+			}
+			oem.CleanupExpiredDownloads() // 清理过期的下载文件
 		}
 	}()
 }
@@ -5472,14 +5705,16 @@ func (oem *OnlineEditorManager) ExportWorkspaceFiles(workspaceID, exportPath, fo
 		format = "zip" // 默认使用zip格式
 	}
 
-	workspaceDir := filepath.Join(oem.workspacesDir, workspaceID)
-	sourceDir := workspaceDir
+	sourceDir, err := oem.workspaceRealDir(workspaceID)
+	if err != nil {
+		return nil, err
+	}
 
 	// 如果指定了路径，使用指定路径
 	if exportPath != "" && exportPath != "." {
-		sourceDir = filepath.Join(workspaceDir, exportPath)
 		// 检查路径是否存在且在工作空间内
-		if !strings.HasPrefix(sourceDir, workspaceDir) {
+		sourceDir, err = oem.resolveWorkspacePath(workspaceID, exportPath)
+		if err != nil {
 			return nil, fmt.Errorf("导出路径超出工作空间范围")
 		}
 		if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
@@ -5487,11 +5722,10 @@ func (oem *OnlineEditorManager) ExportWorkspaceFiles(workspaceID, exportPath, fo
 		}
 	}
 
-	// 如果有选中文件列表，验证所有路径
+	// 如果有选中文件列表，验证所有路径（选中路径相对于导出目录）
 	if len(selectedFiles) > 0 {
 		for _, file := range selectedFiles {
-			fullPath := filepath.Join(workspaceDir, file)
-			if !strings.HasPrefix(fullPath, workspaceDir) {
+			if _, err := secureJoin(sourceDir, file, false); err != nil {
 				return nil, fmt.Errorf("文件路径超出工作空间范围: %s", file)
 			}
 		}
@@ -5510,7 +5744,6 @@ func (oem *OnlineEditorManager) ExportWorkspaceFiles(workspaceID, exportPath, fo
 	}
 
 	var outputPath string
-	var err error
 
 	if format == "zip" {
 		fileName += ".zip"
@@ -5695,6 +5928,11 @@ func (oem *OnlineEditorManager) createZipArchive(sourceDir, outputPath string, s
 			return nil
 		}
 
+		// 符号链接作为链接条目写入，不跟随
+		if info.Mode()&os.ModeSymlink != 0 {
+			return writeZipSymlink(zipWriter, info, filePath, relPath)
+		}
+
 		// 创建zip头
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
@@ -5730,15 +5968,29 @@ func (oem *OnlineEditorManager) addSelectedFilesToZip(zipWriter *zip.Writer, sou
 	addedPaths := make(map[string]bool) // 避免重复添加
 
 	for _, selectedFile := range selectedFiles {
-		fullPath := filepath.Join(sourceDir, selectedFile)
+		fullPath, err := secureJoin(sourceDir, selectedFile, false)
+		if err != nil {
+			log.Printf("警告: 跳过超出工作空间范围的文件 %s: %v", selectedFile, err)
+			continue
+		}
 
-		// 检查文件/目录是否存在
-		info, err := os.Stat(fullPath)
+		// 检查文件/目录是否存在（不跟随符号链接）
+		info, err := os.Lstat(fullPath)
 		if err != nil {
 			log.Printf("警告: 跳过不存在的文件 %s: %v", selectedFile, err)
 			continue
 		}
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !addedPaths[selectedFile] {
+				addedPaths[selectedFile] = true
+				if err := writeZipSymlink(zipWriter, info, fullPath, selectedFile); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		if info.IsDir() {
 			// 添加目录及其所有内容
 			err = filepath.Walk(fullPath, func(filePath string, fileInfo os.FileInfo, walkErr error) error {
@@ -5758,6 +6010,10 @@ func (oem *OnlineEditorManager) addSelectedFilesToZip(zipWriter *zip.Writer, sou
 				}
 				addedPaths[relPath] = true
 
+				if fileInfo.Mode()&os.ModeSymlink != 0 {
+					return writeZipSymlink(zipWriter, fileInfo, filePath, relPath)
+				}
+
 				// 创建zip头
 				header, err := zip.FileInfoHeader(fileInfo)
 				if err != nil {
@@ -5895,6 +6151,11 @@ func (oem *OnlineEditorManager) createTarGzArchive(sourceDir, outputPath string,
 			return nil
 		}
 
+		// 符号链接作为链接条目写入，不跟随
+		if info.Mode()&os.ModeSymlink != 0 {
+			return writeTarSymlink(tarWriter, info, filePath, relPath)
+		}
+
 		// 创建tar头
 		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
@@ -5926,15 +6187,29 @@ func (oem *OnlineEditorManager) addSelectedFilesToTar(tarWriter *tar.Writer, sou
 	addedPaths := make(map[string]bool) // 避免重复添加
 
 	for _, selectedFile := range selectedFiles {
-		fullPath := filepath.Join(sourceDir, selectedFile)
+		fullPath, err := secureJoin(sourceDir, selectedFile, false)
+		if err != nil {
+			log.Printf("警告: 跳过超出工作空间范围的文件 %s: %v", selectedFile, err)
+			continue
+		}
 
-		// 检查文件/目录是否存在
-		info, err := os.Stat(fullPath)
+		// 检查文件/目录是否存在（不跟随符号链接）
+		info, err := os.Lstat(fullPath)
 		if err != nil {
 			log.Printf("警告: 跳过不存在的文件 %s: %v", selectedFile, err)
 			continue
 		}
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !addedPaths[selectedFile] {
+				addedPaths[selectedFile] = true
+				if err := writeTarSymlink(tarWriter, info, fullPath, selectedFile); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		if info.IsDir() {
 			// 添加目录及其所有内容
 			err = filepath.Walk(fullPath, func(filePath string, fileInfo os.FileInfo, walkErr error) error {
@@ -5954,6 +6229,10 @@ func (oem *OnlineEditorManager) addSelectedFilesToTar(tarWriter *tar.Writer, sou
 				}
 				addedPaths[relPath] = true
 
+				if fileInfo.Mode()&os.ModeSymlink != 0 {
+					return writeTarSymlink(tarWriter, fileInfo, filePath, relPath)
+				}
+
 				// 创建tar头
 				header, err := tar.FileInfoHeader(fileInfo, "")
 				if err != nil {
@@ -6042,6 +6321,43 @@ func (oem *OnlineEditorManager) addSelectedFilesToTar(tarWriter *tar.Writer, sou
 	return nil
 }
 
+// 将符号链接写入ZIP归档，内容为链接目标
+func writeZipSymlink(zipWriter *zip.Writer, info os.FileInfo, filePath, name string) error {
+	link, err := os.Readlink(filePath)
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte(link))
+	return err
+}
+
+// 将符号链接写入TAR归档
+func writeTarSymlink(tarWriter *tar.Writer, info os.FileInfo, filePath, name string) error {
+	link, err := os.Readlink(filePath)
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	return tarWriter.WriteHeader(header)
+}
+
 // 获取下载信息
 func (oem *OnlineEditorManager) GetDownloadInfo(downloadID string) (*DownloadInfo, error) {
 	oem.downloadsMutex.RLock()
@@ -6346,10 +6662,24 @@ func main() {
 	}
 	log.Println("Docker 连接正常")
 
+	if len(trustedProxies()) == 0 {
+		log.Println("未配置TRUSTED_PROXIES，不信任X-User-ID头，Git凭据和预览令牌不可用")
+	}
+
 	// 启动定期清理任务
 	manager.StartCleanupTask()
 	log.Println("定期清理任务已启动")
 
+	// 启动磁盘用量扫描
+	manager.StartDiskUsageMonitor()
+
+	// 启动监听端口检测
+	manager.StartPortDetector()
+	manager.StartIdleMonitor()
+
+	// 监听Docker容器事件，发现外部停止或删除的容器
+	manager.StartDockerEventWatcher()
+
 	// 启动HTTP服务器
 	port := 8080
 	if portEnv := os.Getenv("PORT"); portEnv != "" {
@@ -6365,21 +6695,82 @@ func main() {
 	log.Println("    GET    /api/v1/workspaces/{id} - 获取工作空间详情")
 	log.Println("    POST   /api/v1/workspaces/{id}/start - 启动工作空间")
 	log.Println("    POST   /api/v1/workspaces/{id}/stop - 停止工作空间")
+	log.Println("    GET    /api/v1/workspaces/{id}/activity - 获取最近活动时间和空闲自动停止状态")
 	log.Println("    DELETE /api/v1/workspaces/{id} - 删除工作空间")
 	log.Println("  文件系统:")
-	log.Println("    GET    /api/v1/workspaces/{id}/files - 列出文件")
+	log.Println("    GET    /api/v1/workspaces/{id}/files?depth=&hidden=&sort=&order= - 列出文件")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/read - 读取文件")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/write - 写入文件")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/delete - 删除文件")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/create - 创建文件")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/mkdir - 创建文件夹")
 	log.Println("    POST   /api/v1/workspaces/{id}/files/move - 移动文件")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/copy - 复制文件")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/batch/delete - 批量删除文件")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/batch/move - 批量移动文件")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/batch/copy - 批量复制文件")
+	log.Println("    GET    /api/v1/workspaces/{id}/files/stat?path= - 获取文件详细信息")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/chmod - 修改文件权限")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/chown - 修改文件所有者")
+	log.Println("    POST   /api/v1/workspaces/{id}/files/upload - 上传文件（上传文件夹时按顺序提供paths字段）")
+	log.Println("  代码工具:")
+	log.Println("    POST   /api/v1/workspaces/{id}/format - 格式化文件")
+	log.Println("    POST   /api/v1/workspaces/{id}/lint - 静态检查文件")
+	log.Println("    GET    /api/v1/workspaces/{id}/lsp - 列出语言服务器")
+	log.Println("    GET    /api/v1/workspaces/{id}/lsp/{language}/ws - 语言服务器WebSocket")
+	log.Println("  调试:")
+	log.Println("    GET    /api/v1/workspaces/{id}/debug/configurations - 获取启动配置")
+	log.Println("    PUT    /api/v1/workspaces/{id}/debug/configurations - 保存启动配置")
+	log.Println("    DELETE /api/v1/workspaces/{id}/debug/configurations/{name} - 删除启动配置")
+	log.Println("    GET    /api/v1/workspaces/{id}/debug/sessions - 列出调试会话")
+	log.Println("    DELETE /api/v1/workspaces/{id}/debug/sessions/{sessionId} - 结束调试会话")
+	log.Println("    GET    /api/v1/workspaces/{id}/debug/{type}/ws - 调试适配器WebSocket")
+	log.Println("  磁盘用量:")
+	log.Println("    GET    /api/v1/workspaces/{id}/disk-usage[?refresh=true] - 获取磁盘用量")
+	log.Println("    PUT    /api/v1/workspaces/{id}/disk-quota - 设置磁盘配额")
+	log.Println("    DELETE /api/v1/workspaces/{id}/disk-quota - 恢复默认磁盘配额")
+	log.Println("    GET    /api/v1/admin/disk-usage?limit= - 磁盘用量排行")
+	log.Println("  回收站:")
+	log.Println("    GET    /api/v1/workspaces/{id}/trash - 列出回收站")
+	log.Println("    POST   /api/v1/workspaces/{id}/trash/{trashId}/restore - 恢复文件")
+	log.Println("    DELETE /api/v1/workspaces/{id}/trash[/{trashId}] - 清空回收站或删除条目")
 	log.Println("  终端和命令:")
 	log.Println("    POST   /api/v1/workspaces/{id}/terminal - 创建终端")
 	log.Println("    GET    /api/v1/workspaces/{id}/terminal/{sessionId}/ws - 终端WebSocket")
 	log.Println("    POST   /api/v1/workspaces/{id}/exec - 执行命令")
 	log.Println("  Git操作:")
 	log.Println("    POST   /api/v1/workspaces/{id}/git - Git操作")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/clone - 克隆进度")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/clone/ws - 克隆进度WebSocket")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/status - 工作区状态")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/log?ref=&path=&skip=&limit= - 提交历史")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/branches - 分支列表")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/diff?path=&staged= - 文件差异")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/blame?path=&ref= - 逐行追溯")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/fetch - 拉取远程引用")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/merge - 合并分支")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/rebase - 变基")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/reset - 重置")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/conflicts - 冲突文件列表")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/conflicts/file?path= - 冲突文件的base/ours/theirs内容")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/conflicts/resolve - 解决冲突并暂存")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/conflicts/continue - 继续合并/变基")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/conflicts/abort - 中止合并/变基")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/pulls?state= - 拉取请求列表")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/pulls - 从当前分支创建拉取请求")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/pulls/{number}/checkout - 检出拉取请求")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/stash - 贮藏列表")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/stash - 贮藏操作")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/tags - 标签列表")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/tags - 创建标签")
+	log.Println("    DELETE /api/v1/workspaces/{id}/git/tags/{name} - 删除标签")
+	log.Println("    GET    /api/v1/workspaces/{id}/git/remotes - 远程仓库列表")
+	log.Println("    POST   /api/v1/workspaces/{id}/git/remotes - 添加远程仓库")
+	log.Println("    PUT    /api/v1/workspaces/{id}/git/remotes/{name} - 修改远程仓库地址")
+	log.Println("    DELETE /api/v1/workspaces/{id}/git/remotes/{name} - 删除远程仓库")
+	log.Println("    GET    /api/v1/git/credentials - 列出Git凭据（需要经网关认证的X-User-ID）")
+	log.Println("    POST   /api/v1/git/credentials - 添加Git凭据")
+	log.Println("    DELETE /api/v1/git/credentials/{credentialId} - 删除Git凭据")
 	log.Println("  镜像管理:")
 	log.Println("    GET    /api/v1/images - 列出镜像")
 	log.Println("    POST   /api/v1/images/search/data - 搜索镜像")
@@ -6396,8 +6787,43 @@ func main() {
 	log.Println("    GET    /api/v1/containers/{containerId}/status - 获取容器状态")
 	log.Println("    GET    /api/v1/containers/{containerId}/stats - 获取容器统计")
 	log.Println("  网络管理:")
+	log.Println("    GET    /api/v1/workspaces/{id}/ports - 端口映射和转发状态")
+	log.Println("    POST   /api/v1/workspaces/{id}/ports - 添加端口映射")
+	log.Println("    PUT    /api/v1/workspaces/{id}/ports - 替换全部端口映射")
+	log.Println("    PATCH  /api/v1/workspaces/{id}/ports/{port}?protocol= - 修改端口映射（公开/私有、宿主机端口）")
+	log.Println("    DELETE /api/v1/workspaces/{id}/ports/{port}?protocol= - 删除端口映射")
+	log.Println("    GET    /api/v1/workspaces/{id}/ports/detected - 检测容器内正在监听的端口")
+	log.Println("    GET    /api/v1/workspaces/{id}/ports/events/ws - 端口打开/关闭事件推送")
+	log.Println("    POST   /api/v1/workspaces/{id}/proxy/token - 获取Web预览令牌（仅所有者）")
+	log.Println("    ANY    /proxy/{id}/{port}/... - 经认证的反向代理访问工作空间Web应用（含WebSocket）")
+	log.Println("    GET    /api/v1/workspaces/{id}/shares - 列出预览分享链接")
+	log.Println("    POST   /api/v1/workspaces/{id}/shares - 创建预览分享链接（端口、有效期、可选密码）")
+	log.Println("    GET    /api/v1/workspaces/{id}/shares/{shareId} - 获取分享详情和访问记录")
+	log.Println("    DELETE /api/v1/workspaces/{id}/shares/{shareId} - 撤销预览分享链接")
 	log.Println("    GET    /api/v1/network/ip-pool/stats - 获取IP池统计")
 	log.Println("    GET    /api/v1/network/ip-pool/allocations - 获取IP分配信息")
+	log.Println("  Sidecar服务:")
+	log.Println("    GET    /api/v1/workspaces/{id}/sidecars - 列出sidecar服务及运行状态")
+	log.Println("    POST   /api/v1/workspaces/{id}/sidecars - 添加sidecar服务")
+	log.Println("    POST   /api/v1/workspaces/{id}/sidecars/import - 从docker-compose.yml导入服务")
+	log.Println("    DELETE /api/v1/workspaces/{id}/sidecars/{name} - 删除sidecar服务")
+	log.Println("  命名卷管理:")
+	log.Println("    GET    /api/v1/volumes?size=true - 列出当前用户的工作空间卷和缓存卷（管理员可以看到全部）")
+	log.Println("    GET    /api/v1/volumes/{name} - 获取卷详情和使用情况")
+	log.Println("    POST   /api/v1/volumes/prune?caches=true - 清理不再使用的卷（管理员）")
+	log.Println("  保留策略:")
+	log.Println("    POST   /api/v1/workspaces/{id}/pin - 切换固定状态（固定的工作空间不会过期）")
+	log.Println("    GET    /api/v1/retention/policy - 获取保留策略")
+	log.Println("    PUT    /api/v1/retention/policy - 更新保留策略（管理员）")
+	log.Println("    GET    /api/v1/retention/report - 预演保留策略，列出将要停止、归档、删除的工作空间")
+	log.Println("    POST   /api/v1/retention/run?dry_run=true - 立即执行保留策略（管理员）")
+	log.Println("    GET    /api/v1/retention/archives - 列出当前用户的工作空间快照")
+	log.Println("    GET    /api/v1/retention/archives/{id}/file - 下载工作空间快照")
+	log.Println("  生命周期事件:")
+	log.Println("    GET    /api/v1/events?types=status,error - 订阅当前用户所有工作空间的事件（SSE，支持Last-Event-ID）")
+	log.Println("    GET    /api/v1/events/ws?since={id} - 订阅当前用户所有工作空间的事件（WebSocket）")
+	log.Println("    GET    /api/v1/workspaces/{id}/events - 订阅工作空间事件（SSE）")
+	log.Println("    GET    /api/v1/workspaces/{id}/events/ws - 订阅工作空间事件（WebSocket）")
 	log.Println("  导出和下载:")
 	log.Println("    POST   /api/v1/workspaces/{id}/export - 导出工作空间文件或镜像")
 	log.Println("    GET    /api/v1/downloads - 列出用户的下载")
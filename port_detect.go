@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// 监听端口检测
+//
+// 后台定期读取容器内的/proc/net/tcp和/proc/net/tcp6（一次exec，不依赖netstat/ss），
+// 与上次结果比较，更新AccessURLs并在事件总线上发布端口打开/关闭事件（type为port），
+// 前端据此提示"打开预览"。
+
+const (
+	defaultPortDetectInterval = 3 * time.Second
+	portDetectTimeout         = 10 * time.Second
+	tcpStateListen            = "0A"
+)
+
+// 容器内正在监听的端口
+type DetectedPort struct {
+	Port      int      `json:"port"`
+	Protocol  string   `json:"protocol"`
+	Addresses []string `json:"addresses"`
+	LocalOnly bool     `json:"local_only"` // 只监听回环地址，无法从容器外访问
+	ProxyURL  string   `json:"proxy_url,omitempty"`
+}
+
+// 端口打开/关闭事件
+type PortEvent struct {
+	Type        string    `json:"type"` // "opened", "closed"
+	WorkspaceID string    `json:"workspace_id"`
+	Time        time.Time `json:"time"`
+	DetectedPort
+}
+
+// 各工作空间的检测结果
+type portDetector struct {
+	mutex     sync.Mutex
+	listening map[string]map[int]DetectedPort // 工作空间ID -> 端口，有记录表示已完成过检测
+}
+
+func newPortDetector() *portDetector {
+	return &portDetector{
+		listening: make(map[string]map[int]DetectedPort),
+	}
+}
+
+// 检测间隔，可通过PORT_DETECT_INTERVAL（如"5s"）调整
+func portDetectInterval() time.Duration {
+	value := os.Getenv("PORT_DETECT_INTERVAL")
+	if value == "" {
+		return defaultPortDetectInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		log.Printf("无效的PORT_DETECT_INTERVAL: %s，使用默认值 %v", value, defaultPortDetectInterval)
+		return defaultPortDetectInterval
+	}
+	return interval
+}
+
+// 解析/proc/net/tcp格式的内容，返回处于LISTEN状态的端口
+func parseProcNetTCP(content string) map[int]DetectedPort {
+	ports := make(map[int]DetectedPort)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "sl" || fields[3] != tcpStateListen {
+			continue
+		}
+
+		hexAddr, hexPort, found := strings.Cut(fields[1], ":")
+		if !found {
+			continue
+		}
+		port, err := strconv.ParseUint(hexPort, 16, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		ip := parseProcNetIP(hexAddr)
+		if ip == nil {
+			continue
+		}
+
+		detected, exists := ports[int(port)]
+		if !exists {
+			detected = DetectedPort{Port: int(port), Protocol: "tcp", LocalOnly: true}
+		}
+		address := ip.String()
+		if !containsString(detected.Addresses, address) {
+			detected.Addresses = append(detected.Addresses, address)
+		}
+		if !ip.IsLoopback() {
+			detected.LocalOnly = false
+		}
+		ports[int(port)] = detected
+	}
+	return ports
+}
+
+// /proc/net/tcp中的地址按32位字以主机字节序（小端）存放
+func parseProcNetIP(hexAddr string) net.IP {
+	raw, err := hex.DecodeString(hexAddr)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return ip
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 检测工作空间内正在监听的端口，并更新记录
+func (oem *OnlineEditorManager) detectPorts(workspaceID string) ([]DetectedPort, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), portDetectTimeout)
+	defer cancel()
+
+	// 没有IPv6时tcp6不存在，cat以非0退出，但tcp的内容仍然有效
+	result, err := oem.execInWorkspace(ctx, workspaceID, []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"}, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	if result.Stdout == "" {
+		return nil, fmt.Errorf("读取/proc/net/tcp失败: %s", strings.TrimSpace(result.Stderr))
+	}
+
+	ports := parseProcNetTCP(result.Stdout)
+	for port, detected := range ports {
+		detected.ProxyURL = previewURL(workspaceID, strconv.Itoa(port))
+		ports[port] = detected
+	}
+	oem.updateDetectedPorts(workspaceID, ports)
+	return sortedDetectedPorts(ports), nil
+}
+
+// 记录检测结果，推送变化的端口并更新访问URL
+func (oem *OnlineEditorManager) updateDetectedPorts(workspaceID string, ports map[int]DetectedPort) {
+	oem.mutex.Lock()
+	defer oem.mutex.Unlock()
+
+	detector := oem.portDetector
+	detector.mutex.Lock()
+	previous, scanned := detector.listening[workspaceID]
+	detector.listening[workspaceID] = ports
+	detector.mutex.Unlock()
+
+	changed := !scanned
+	for port, detected := range ports {
+		if old, exists := previous[port]; !exists {
+			oem.publishPortEvent(workspaceID, "opened", detected)
+			log.Printf("[%s] 检测到端口打开: %d", workspaceID, port)
+			changed = true
+		} else if old.LocalOnly != detected.LocalOnly {
+			changed = true
+		}
+	}
+	for port, detected := range previous {
+		if _, exists := ports[port]; !exists {
+			oem.publishPortEvent(workspaceID, "closed", detected)
+			log.Printf("[%s] 检测到端口关闭: %d", workspaceID, port)
+			changed = true
+		}
+	}
+
+	if workspace, exists := oem.workspaces[workspaceID]; exists && changed {
+		oem.generateAccessURLs(workspace)
+	}
+}
+
+// 工作空间停止或删除后清除检测记录，已打开的端口推送关闭事件
+func (oem *OnlineEditorManager) forgetDetectedPorts(workspaceID string) {
+	detector := oem.portDetector
+	detector.mutex.Lock()
+	previous := detector.listening[workspaceID]
+	delete(detector.listening, workspaceID)
+	detector.mutex.Unlock()
+
+	for _, detected := range previous {
+		oem.publishPortEvent(workspaceID, "closed", detected)
+	}
+}
+
+// 工作空间当前检测到的监听端口，scanned为false表示尚未检测
+func (oem *OnlineEditorManager) detectedPorts(workspaceID string) (ports map[int]DetectedPort, scanned bool) {
+	oem.portDetector.mutex.Lock()
+	defer oem.portDetector.mutex.Unlock()
+	ports, scanned = oem.portDetector.listening[workspaceID]
+	return ports, scanned
+}
+
+func sortedDetectedPorts(ports map[int]DetectedPort) []DetectedPort {
+	result := make([]DetectedPort, 0, len(ports))
+	for _, detected := range ports {
+		result = append(result, detected)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Port < result[j].Port })
+	return result
+}
+
+// 发布端口打开/关闭事件
+func (oem *OnlineEditorManager) publishPortEvent(workspaceID, eventType string, detected DetectedPort) {
+	oem.publishEvent(WorkspaceEvent{Type: eventTypePort, WorkspaceID: workspaceID, Message: eventType, Data: detected})
+}
+
+// 总线上的端口打开/关闭事件转换为PortEvent，其他端口事件（如映射修改）返回false
+func portEventFromWorkspaceEvent(event WorkspaceEvent) (PortEvent, bool) {
+	detected, ok := event.Data.(DetectedPort)
+	if event.Type != eventTypePort || !ok {
+		return PortEvent{}, false
+	}
+	return PortEvent{Type: event.Message, WorkspaceID: event.WorkspaceID, Time: event.Time, DetectedPort: detected}, true
+}
+
+// 启动后台端口检测，每轮并发检测所有运行中的工作空间
+func (oem *OnlineEditorManager) StartPortDetector() {
+	interval := portDetectInterval()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			running := make(map[string]bool)
+			oem.mutex.RLock()
+			for workspaceID, workspace := range oem.workspaces {
+				if workspace.Status == "running" && workspace.ContainerID != "" {
+					running[workspaceID] = true
+				}
+			}
+			oem.mutex.RUnlock()
+
+			oem.portDetector.mutex.Lock()
+			var stale []string
+			for workspaceID := range oem.portDetector.listening {
+				if !running[workspaceID] {
+					stale = append(stale, workspaceID)
+				}
+			}
+			oem.portDetector.mutex.Unlock()
+			for _, workspaceID := range stale {
+				oem.forgetDetectedPorts(workspaceID)
+			}
+
+			var wg sync.WaitGroup
+			for workspaceID := range running {
+				wg.Add(1)
+				go func(workspaceID string) {
+					defer wg.Done()
+					oem.detectPorts(workspaceID)
+				}(workspaceID)
+			}
+			wg.Wait()
+		}
+	}()
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/ports/detected
+// 立即检测一次并返回正在监听的端口
+func (oem *OnlineEditorManager) handleGetDetectedPorts(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	status, err := oem.GetWorkspaceStatus(workspaceID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if status != "running" {
+		http.Error(w, "工作空间未运行", http.StatusBadRequest)
+		return
+	}
+
+	ports, err := oem.detectPorts(workspaceID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("检测端口失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"workspace_id": workspaceID,
+		"ports":        ports,
+	})
+}
+
+// GET /workspaces/{id}/ports/events/ws
+// 先推送当前监听的端口（type为"snapshot"），之后推送端口打开/关闭事件
+func (oem *OnlineEditorManager) handlePortEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	if _, err := oem.GetWorkspaceStatus(workspaceID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	conn, err := oem.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	defer conn.Close()
+	pusher := newWebSocketPusher(conn)
+
+	sub, _ := oem.subscribeEvents(workspaceID, map[string]bool{eventTypePort: true}, 0)
+	defer oem.unsubscribeEvents(sub)
+
+	ports, _ := oem.detectedPorts(workspaceID)
+	if err := pusher.send(map[string]interface{}{
+		"type":  "snapshot",
+		"ports": sortedDetectedPorts(ports),
+	}); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case event := <-sub.ch:
+			portEvent, ok := portEventFromWorkspaceEvent(event)
+			if !ok {
+				continue
+			}
+			if err := pusher.send(portEvent); err != nil {
+				return
+			}
+		case <-sub.dropped:
+			// 重新连接后从新的快照开始
+			pusher.close(websocket.CloseTryAgainLater, "端口事件积压，请重新连接")
+			return
+		case <-pusher.gone:
+			pusher.close(websocket.CloseNormalClosure, "")
+			return
+		}
+	}
+}
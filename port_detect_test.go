@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// cat /proc/net/tcp /proc/net/tcp6 的输出
+const procNetTCPFixture = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1834518 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0BB8 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1834602 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1435 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835011 1 0000000000000000 100 0 0 10 0
+   3: 0100007F:0FA0 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835120 1 0000000000000000 100 0 0 10 0
+   4: 0100007F:1F90 0100007F:B26E 01 00000000:00000000 00:00000000 00000000  1000        0 1836220 1 0000000000000000 20 4 30 10 -1
+   5: 0100007F:B26E 0100007F:1F90 01 00000000:00000000 00:00000000 00000000  1000        0 1836219 1 0000000000000000 20 4 30 10 -1
+   6: 0200A8C0:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1820001 1 0000000000000000 100 0 0 10 0
+   7: 0200A8C0:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1820002 1 0000000000000000 100 0 0 10 0
+  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:1435 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835012 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000000000000:1F40 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835300 1 0000000000000000 100 0 0 10 0
+   2: 0000000000000000FFFF00000100007F:240D 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835400 1 0000000000000000 100 0 0 10 0
+   3: 0000000000000000FFFF000000000000:1388 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835500 1 0000000000000000 100 0 0 10 0
+   4: 00000000000000000000000000000000:0FA0 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1835121 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseProcNetTCP(t *testing.T) {
+	want := map[int]DetectedPort{
+		// 只监听IPv4回环地址
+		8080: {Port: 8080, Protocol: "tcp", Addresses: []string{"127.0.0.1"}, LocalOnly: true},
+		// 0.0.0.0
+		3000: {Port: 3000, Protocol: "tcp", Addresses: []string{"0.0.0.0"}},
+		// IPv4和IPv6回环地址都只能在容器内访问
+		5173: {Port: 5173, Protocol: "tcp", Addresses: []string{"127.0.0.1", "::1"}, LocalOnly: true},
+		// 回环地址加上::，可以从容器外访问
+		4000: {Port: 4000, Protocol: "tcp", Addresses: []string{"127.0.0.1", "::"}},
+		8000: {Port: 8000, Protocol: "tcp", Addresses: []string{"::"}},
+		// IPv4映射地址按IPv4显示
+		9229: {Port: 9229, Protocol: "tcp", Addresses: []string{"127.0.0.1"}, LocalOnly: true},
+		5000: {Port: 5000, Protocol: "tcp", Addresses: []string{"0.0.0.0"}},
+		// 同一地址的多个监听套接字（SO_REUSEPORT）只记录一次
+		22: {Port: 22, Protocol: "tcp", Addresses: []string{"192.168.0.2"}},
+	}
+
+	got := parseProcNetTCP(procNetTCPFixture)
+	if len(got) != len(want) {
+		t.Fatalf("端口数量 = %d, 期望 %d: %+v", len(got), len(want), got)
+	}
+	for port, expected := range want {
+		if !reflect.DeepEqual(got[port], expected) {
+			t.Errorf("端口 %d = %+v, 期望 %+v", port, got[port], expected)
+		}
+	}
+	// 已建立的连接（状态01）不算监听端口
+	if _, exists := got[45678]; exists {
+		t.Errorf("不应包含已建立连接的本地端口: %+v", got[45678])
+	}
+
+	if ports := parseProcNetTCP(""); len(ports) != 0 {
+		t.Fatalf("空内容应没有端口: %+v", ports)
+	}
+}
+
+func TestParseProcNetIP(t *testing.T) {
+	tests := map[string]string{
+		"0100007F":                         "127.0.0.1",
+		"00000000":                         "0.0.0.0",
+		"0200A8C0":                         "192.168.0.2",
+		"00000000000000000000000000000000": "::",
+		"00000000000000000000000001000000": "::1",
+		"0000000000000000FFFF00000100007F": "127.0.0.1",
+		"0000000000000000FFFF00000200A8C0": "192.168.0.2",
+		"B80D0120000000000000000001000000": "2001:db8::1",
+	}
+	for hexAddr, want := range tests {
+		ip := parseProcNetIP(hexAddr)
+		if ip == nil || ip.String() != want {
+			t.Errorf("parseProcNetIP(%s) = %v, 期望 %s", hexAddr, ip, want)
+		}
+	}
+
+	for _, invalid := range []string{"", "0100007", "0100007F00", "ZZ00007F"} {
+		if ip := parseProcNetIP(invalid); ip != nil {
+			t.Errorf("parseProcNetIP(%q) = %v, 期望nil", invalid, ip)
+		}
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/gorilla/mux"
+)
+
+// 端口转发
+//
+// 宿主机端口到容器端口的转发由进程内的TCP/UDP代理完成，而不是Docker端口绑定，
+// 因此可以在运行时增删端口、切换公开/私有，无需重建容器。
+// 公开端口监听所有地址，私有端口只监听127.0.0.1（供本机和反向代理访问）。
+// 代理在每次建立连接时读取容器当前的IP，容器重启后IP变化也不影响转发。
+// 旧版本创建的容器仍带有Docker端口绑定，这些宿主机端口由Docker转发，不再启动代理，
+// 修改它们需要先重建容器。
+
+const (
+	portForwardDialTimeout = 5 * time.Second
+	udpSessionIdleTimeout  = 60 * time.Second
+	udpMaxPacketSize       = 64 * 1024
+)
+
+// 一个宿主机端口的转发
+type portForward struct {
+	workspaceID   string
+	hostPort      int
+	containerPort string
+	protocol      string
+	public        bool
+
+	listener   net.Listener   // tcp
+	packetConn net.PacketConn // udp
+	err        string         // 监听失败的原因，下次同步时重试
+
+	mutex sync.Mutex
+	conns map[io.Closer]struct{} // 活动连接（TCP连接或UDP会话）
+}
+
+// 端口转发状态
+type PortForwardInfo struct {
+	HostPort      int    `json:"host_port"`
+	ContainerPort string `json:"container_port"`
+	Protocol      string `json:"protocol"`
+	PublicAccess  bool   `json:"public_access"`
+	Active        bool   `json:"active"`
+	Connections   int    `json:"connections"`
+	Docker        bool   `json:"docker,omitempty"` // 由容器的Docker端口绑定转发
+	Error         string `json:"error,omitempty"`
+}
+
+func (f *portForward) info() PortForwardInfo {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return PortForwardInfo{
+		HostPort:      f.hostPort,
+		ContainerPort: f.containerPort,
+		Protocol:      f.protocol,
+		PublicAccess:  f.public,
+		Active:        f.listener != nil || f.packetConn != nil,
+		Connections:   len(f.conns),
+		Error:         f.err,
+	}
+}
+
+func (f *portForward) sameAs(mapping PortMapping) bool {
+	return f.containerPort == mapping.ContainerPort && f.protocol == mapping.Protocol && f.public == mapping.PublicAccess
+}
+
+func (f *portForward) track(conn io.Closer) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.conns == nil {
+		// 转发已停止
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+func (f *portForward) untrack(conn io.Closer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.conns, conn)
+}
+
+// 开始监听
+func (f *portForward) start(oem *OnlineEditorManager) error {
+	host := "127.0.0.1"
+	if f.public {
+		host = ""
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(f.hostPort))
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.conns = make(map[io.Closer]struct{})
+
+	if f.protocol == "udp" {
+		packetConn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			f.err = err.Error()
+			return err
+		}
+		f.packetConn, f.err = packetConn, ""
+		go f.serveUDP(oem, packetConn)
+	} else {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			f.err = err.Error()
+			return err
+		}
+		f.listener, f.err = listener, ""
+		go f.serveTCP(oem, listener)
+	}
+
+	log.Printf("[%s] 端口转发已启动: %s/%s -> 容器端口 %s", f.workspaceID, address, f.protocol, f.containerPort)
+	return nil
+}
+
+// 停止监听并断开所有连接
+func (f *portForward) stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.listener != nil {
+		f.listener.Close()
+		f.listener = nil
+	}
+	if f.packetConn != nil {
+		f.packetConn.Close()
+		f.packetConn = nil
+	}
+	for conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+	log.Printf("[%s] 端口转发已停止: %d/%s", f.workspaceID, f.hostPort, f.protocol)
+}
+
+// 容器端口的当前地址
+func (oem *OnlineEditorManager) portForwardTarget(workspaceID, containerPort string) (string, error) {
+	oem.mutex.RLock()
+	defer oem.mutex.RUnlock()
+
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists || workspace.Status != "running" || workspace.NetworkIP == "" {
+		return "", fmt.Errorf("工作空间未运行: %s", workspaceID)
+	}
+	return net.JoinHostPort(workspace.NetworkIP, containerPort), nil
+}
+
+func (f *portForward) serveTCP(oem *OnlineEditorManager, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.proxyTCP(oem, conn)
+	}
+}
+
+func (f *portForward) proxyTCP(oem *OnlineEditorManager, client net.Conn) {
+	defer client.Close()
+	oem.recordActivity(f.workspaceID)
+
+	target, err := oem.portForwardTarget(f.workspaceID, f.containerPort)
+	if err != nil {
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", target, portForwardDialTimeout)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	if !f.track(client) {
+		return
+	}
+	defer f.untrack(client)
+	f.track(upstream)
+	defer f.untrack(upstream)
+
+	// 双向复制，一个方向结束后半关闭另一端的写，等待两个方向都结束
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if tcp, ok := dst.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(upstream, client)
+	go pipe(client, upstream)
+	<-done
+	<-done
+}
+
+// UDP会话：每个客户端地址对应一个到容器的连接
+type udpSession struct {
+	upstream *net.UDPConn
+}
+
+func (s *udpSession) Close() error {
+	return s.upstream.Close()
+}
+
+func (f *portForward) serveUDP(oem *OnlineEditorManager, packetConn net.PacketConn) {
+	sessions := make(map[string]*udpSession)
+	var sessionsMutex sync.Mutex
+	buffer := make([]byte, udpMaxPacketSize)
+
+	for {
+		n, clientAddr, err := packetConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		sessionsMutex.Lock()
+		session, exists := sessions[clientAddr.String()]
+		sessionsMutex.Unlock()
+
+		if !exists {
+			target, err := oem.portForwardTarget(f.workspaceID, f.containerPort)
+			if err != nil {
+				continue
+			}
+			targetAddr, err := net.ResolveUDPAddr("udp", target)
+			if err != nil {
+				continue
+			}
+			upstream, err := net.DialUDP("udp", nil, targetAddr)
+			if err != nil {
+				continue
+			}
+
+			session = &udpSession{upstream: upstream}
+			if !f.track(session) {
+				upstream.Close()
+				return
+			}
+			sessionsMutex.Lock()
+			sessions[clientAddr.String()] = session
+			sessionsMutex.Unlock()
+
+			// 把容器的响应转发回客户端，空闲超时后结束会话
+			go func(clientAddr net.Addr) {
+				defer func() {
+					sessionsMutex.Lock()
+					delete(sessions, clientAddr.String())
+					sessionsMutex.Unlock()
+					f.untrack(session)
+					session.Close()
+				}()
+
+				reply := make([]byte, udpMaxPacketSize)
+				for {
+					session.upstream.SetReadDeadline(time.Now().Add(udpSessionIdleTimeout))
+					n, err := session.upstream.Read(reply)
+					if err != nil {
+						return
+					}
+					if _, err := packetConn.WriteTo(reply[:n], clientAddr); err != nil {
+						return
+					}
+				}
+			}(clientAddr)
+		}
+
+		session.upstream.Write(buffer[:n])
+	}
+}
+
+// 按工作空间当前的端口配置启动、停止转发：运行中的工作空间转发所有配置了宿主机端口的映射，
+// 其他状态（或已删除）的工作空间停止全部转发
+func (oem *OnlineEditorManager) syncPortForwards(workspaceID string) {
+	desired := map[hostPortKey]PortMapping{}
+
+	oem.mutex.RLock()
+	if workspace, exists := oem.workspaces[workspaceID]; exists && workspace.Status == "running" {
+		for _, mapping := range workspace.Ports {
+			port, err := strconv.Atoi(mapping.HostPort)
+			key := portKey(port, mapping.Protocol)
+			if err != nil || mapping.ContainerPort == "" || workspace.dockerPortBindings[key] {
+				continue
+			}
+			mapping.Protocol = key.Protocol
+			desired[key] = mapping
+		}
+	}
+	oem.mutex.RUnlock()
+
+	oem.portForwardsMutex.Lock()
+	defer oem.portForwardsMutex.Unlock()
+
+	for key, forward := range oem.portForwards {
+		if forward.workspaceID != workspaceID {
+			continue
+		}
+		if mapping, ok := desired[key]; !ok || !forward.sameAs(mapping) {
+			forward.stop()
+			delete(oem.portForwards, key)
+		}
+	}
+
+	for key, mapping := range desired {
+		forward, exists := oem.portForwards[key]
+		if exists && forward.workspaceID != workspaceID {
+			continue
+		}
+		if exists && forward.info().Active {
+			continue
+		}
+		if !exists {
+			forward = &portForward{
+				workspaceID:   workspaceID,
+				hostPort:      key.Port,
+				containerPort: mapping.ContainerPort,
+				protocol:      key.Protocol,
+				public:        mapping.PublicAccess,
+			}
+			oem.portForwards[key] = forward
+		}
+		if err := forward.start(oem); err != nil {
+			log.Printf("[%s] 端口转发启动失败 %d/%s: %v", workspaceID, key.Port, key.Protocol, err)
+		}
+	}
+}
+
+// 工作空间的端口转发状态，包括由Docker端口绑定转发的端口
+func (oem *OnlineEditorManager) listPortForwards(workspaceID string) []PortForwardInfo {
+	forwards := []PortForwardInfo{}
+
+	oem.mutex.RLock()
+	if workspace, exists := oem.workspaces[workspaceID]; exists {
+		for _, mapping := range workspace.Ports {
+			if port, err := strconv.Atoi(mapping.HostPort); err == nil && workspace.dockerPortBindings[portKey(port, mapping.Protocol)] {
+				forwards = append(forwards, PortForwardInfo{
+					HostPort:      port,
+					ContainerPort: mapping.ContainerPort,
+					Protocol:      mapping.Protocol,
+					PublicAccess:  mapping.PublicAccess,
+					Active:        workspace.Status == "running",
+					Docker:        true,
+				})
+			}
+		}
+	}
+	oem.mutex.RUnlock()
+
+	oem.portForwardsMutex.Lock()
+	defer oem.portForwardsMutex.Unlock()
+
+	for _, forward := range oem.portForwards {
+		if forward.workspaceID == workspaceID {
+			forwards = append(forwards, forward.info())
+		}
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		if forwards[i].HostPort != forwards[j].HostPort {
+			return forwards[i].HostPort < forwards[j].HostPort
+		}
+		return forwards[i].Protocol < forwards[j].Protocol
+	})
+	return forwards
+}
+
+// 刷新容器在工作空间网络中的IP，容器每次启动后都可能变化
+func (oem *OnlineEditorManager) refreshNetworkIP(workspaceID string) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var containerID, networkName string
+	if exists {
+		containerID, networkName = workspace.ContainerID, workspace.NetworkName
+	}
+	oem.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	containerInfo, err := oem.dockerClient.ContainerInspect(context.Background(), containerID)
+	if err != nil || containerInfo.NetworkSettings == nil {
+		log.Printf("[%s] 获取容器IP失败: %v", workspaceID, err)
+		return
+	}
+
+	ip := ""
+	if endpoint, ok := containerInfo.NetworkSettings.Networks[networkName]; ok && endpoint != nil {
+		ip = endpoint.IPAddress
+	}
+
+	oem.mutex.Lock()
+	workspace.NetworkIP = ip
+	oem.mutex.Unlock()
+}
+
+// 修改工作空间的端口映射并立即应用，不重启容器
+func (oem *OnlineEditorManager) updatePortMappings(workspaceID string, update func(ports []PortMapping) ([]PortMapping, error)) ([]PortMapping, error) {
+	oem.mutex.Lock()
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		oem.mutex.Unlock()
+		return nil, &portMappingError{http.StatusNotFound, "工作空间不存在"}
+	}
+
+	ports, err := update(append([]PortMapping{}, workspace.Ports...))
+	if err == nil {
+		err = validatePortMappings(ports)
+	}
+	if err == nil {
+		err = checkDockerPortBindings(workspace, ports)
+	}
+	if err == nil {
+		err = oem.assignHostPorts(workspaceID, ports)
+	}
+	if err != nil {
+		oem.mutex.Unlock()
+		return nil, err
+	}
+	workspace.Ports = ports
+	if workspace.Status == "running" {
+		oem.generateAccessURLs(workspace)
+	}
+	oem.mutex.Unlock()
+
+	oem.syncPortForwards(workspaceID)
+	oem.publishEvent(WorkspaceEvent{Type: eventTypePort, WorkspaceID: workspaceID, Message: "mappings_updated", Data: ports})
+	return ports, nil
+}
+
+// 旧容器创建时的Docker端口绑定（只记录宿主机端口有效的绑定）
+func dockerPublishedPorts(hostConfig *container.HostConfig) map[hostPortKey]bool {
+	if hostConfig == nil || len(hostConfig.PortBindings) == 0 {
+		return nil
+	}
+	published := map[hostPortKey]bool{}
+	for containerPort, bindings := range hostConfig.PortBindings {
+		for _, binding := range bindings {
+			if port, err := strconv.Atoi(binding.HostPort); err == nil && port > 0 {
+				published[portKey(port, containerPort.Proto())] = true
+			}
+		}
+	}
+	return published
+}
+
+// 所有修改方式（新增、修改、整体替换）都要经过容器端口和协议检查
+func validatePortMappings(ports []PortMapping) error {
+	for _, mapping := range ports {
+		if port, err := strconv.Atoi(mapping.ContainerPort); err != nil || port < 1 || port > 65535 {
+			return &portMappingError{http.StatusBadRequest, fmt.Sprintf("无效的容器端口: %s", mapping.ContainerPort)}
+		}
+		if mapping.Protocol != "" && mapping.Protocol != "tcp" && mapping.Protocol != "udp" {
+			return &portMappingError{http.StatusBadRequest, "协议只能是tcp或udp"}
+		}
+	}
+	return nil
+}
+
+// Docker端口绑定在容器创建后不能修改，这些映射必须原样保留
+func checkDockerPortBindings(workspace *Workspace, ports []PortMapping) error {
+	for i := range ports {
+		if ports[i].Protocol == "" {
+			ports[i].Protocol = "tcp"
+		}
+	}
+	for _, existing := range workspace.Ports {
+		port, err := strconv.Atoi(existing.HostPort)
+		if err != nil || !workspace.dockerPortBindings[portKey(port, existing.Protocol)] {
+			continue
+		}
+		kept := false
+		for _, mapping := range ports {
+			if mapping.HostPort == existing.HostPort && mapping.ContainerPort == existing.ContainerPort &&
+				mapping.Protocol == existing.Protocol && mapping.PublicAccess == existing.PublicAccess {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			return &portMappingError{http.StatusConflict, fmt.Sprintf("宿主机端口 %d 由容器创建时的Docker端口绑定发布，需要重建容器后才能修改", port)}
+		}
+	}
+	return nil
+}
+
+// 端口映射请求错误，其他错误（端口冲突、无可用端口）返回409
+type portMappingError struct {
+	status  int
+	message string
+}
+
+func (e *portMappingError) Error() string {
+	return e.message
+}
+
+func writePortMappingResponse(w http.ResponseWriter, oem *OnlineEditorManager, workspaceID string, ports []PortMapping, err error) {
+	if err != nil {
+		status := http.StatusConflict
+		if e, ok := err.(*portMappingError); ok {
+			status = e.status
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ports":    ports,
+		"forwards": oem.listPortForwards(workspaceID),
+	})
+}
+
+// 查找端口映射，protocol为空时按tcp
+func findPortMapping(ports []PortMapping, containerPort, protocol string) int {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	for i, mapping := range ports {
+		mappingProtocol := mapping.Protocol
+		if mappingProtocol == "" {
+			mappingProtocol = "tcp"
+		}
+		if mapping.ContainerPort == containerPort && mappingProtocol == protocol {
+			return i
+		}
+	}
+	return -1
+}
+
+// HTTP处理器
+
+func (oem *OnlineEditorManager) handleListPortForwards(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var ports []PortMapping
+	if exists {
+		ports = append([]PortMapping{}, workspace.Ports...)
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "工作空间不存在", http.StatusNotFound)
+		return
+	}
+	writePortMappingResponse(w, oem, workspaceID, ports, nil)
+}
+
+// 添加端口映射，未指定宿主机端口且公开访问时自动分配
+func (oem *OnlineEditorManager) handleAddPortMapping(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req PortMapping
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ports, err := oem.updatePortMappings(workspaceID, func(ports []PortMapping) ([]PortMapping, error) {
+		if findPortMapping(ports, req.ContainerPort, req.Protocol) >= 0 {
+			return nil, &portMappingError{http.StatusConflict, fmt.Sprintf("容器端口 %s 已存在映射", req.ContainerPort)}
+		}
+		return append(ports, req), nil
+	})
+	writePortMappingResponse(w, oem, workspaceID, ports, err)
+}
+
+// 修改端口映射（切换公开/私有、更换宿主机端口）
+// PATCH /workspaces/{id}/ports/{containerPort}?protocol=
+func (oem *OnlineEditorManager) handleUpdatePortMapping(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	var req struct {
+		PublicAccess *bool  `json:"public_access"`
+		HostPort     string `json:"host_port"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ports, err := oem.updatePortMappings(workspaceID, func(ports []PortMapping) ([]PortMapping, error) {
+		i := findPortMapping(ports, vars["port"], r.URL.Query().Get("protocol"))
+		if i < 0 {
+			return nil, &portMappingError{http.StatusNotFound, fmt.Sprintf("容器端口 %s 没有映射", vars["port"])}
+		}
+		if req.PublicAccess != nil {
+			ports[i].PublicAccess = *req.PublicAccess
+		}
+		if req.HostPort != "" {
+			ports[i].HostPort = req.HostPort
+		}
+		return ports, nil
+	})
+	writePortMappingResponse(w, oem, workspaceID, ports, err)
+}
+
+// DELETE /workspaces/{id}/ports/{containerPort}?protocol=
+func (oem *OnlineEditorManager) handleRemovePortMapping(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+
+	ports, err := oem.updatePortMappings(workspaceID, func(ports []PortMapping) ([]PortMapping, error) {
+		i := findPortMapping(ports, vars["port"], r.URL.Query().Get("protocol"))
+		if i < 0 {
+			return nil, &portMappingError{http.StatusNotFound, fmt.Sprintf("容器端口 %s 没有映射", vars["port"])}
+		}
+		return append(ports[:i], ports[i+1:]...), nil
+	})
+	writePortMappingResponse(w, oem, workspaceID, ports, err)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 宿主机端口池
+//
+// 端口映射未指定宿主机端口时，从WORKSPACE_PORT_RANGE（如"20000-29999"）中自动分配。
+// 分配前检查宿主机上端口是否空闲，分配记录保存在port_allocations.json中，
+// 重启后在恢复工作空间时重新占用，删除工作空间时释放。
+// 以下方法的调用者必须持有oem.mutex写锁。检查端口需要系统调用，为了缩短持锁时间，
+// 自动分配从上次分配的下一个端口开始轮转查找，并限制每次检查的端口数量。
+
+// 默认的自动分配范围
+const (
+	defaultPortRangeStart = 20000
+	defaultPortRangeEnd   = 29999
+
+	// 自动分配一个端口时最多检查的宿主机端口数量（端口池中已分配的不计）
+	maxPortProbes = 64
+)
+
+// 端口池的键：TCP和UDP的同一端口号可以分别绑定，互不冲突
+type hostPortKey struct {
+	Port     int
+	Protocol string
+}
+
+func portKey(port int, protocol string) hostPortKey {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return hostPortKey{Port: port, Protocol: protocol}
+}
+
+// 已分配的宿主机端口
+type PortAllocation struct {
+	Port          int       `json:"port"`
+	WorkspaceID   string    `json:"workspace_id"`
+	ContainerPort string    `json:"container_port"`
+	Protocol      string    `json:"protocol"`
+	PublicAccess  bool      `json:"public_access"`
+	Allocated     time.Time `json:"allocated"`
+}
+
+// 自动分配的端口范围
+func workspacePortRange() (start, end int) {
+	start, end = defaultPortRangeStart, defaultPortRangeEnd
+	value := os.Getenv("WORKSPACE_PORT_RANGE")
+	if value == "" {
+		return start, end
+	}
+
+	first, last, found := strings.Cut(value, "-")
+	from, err1 := strconv.Atoi(strings.TrimSpace(first))
+	to, err2 := strconv.Atoi(strings.TrimSpace(last))
+	if !found || err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+		log.Printf("无效的WORKSPACE_PORT_RANGE: %s，使用默认范围 %d-%d", value, start, end)
+		return start, end
+	}
+	return from, to
+}
+
+// 检查宿主机端口是否空闲
+func hostPortAvailable(port int, protocol string) bool {
+	address := net.JoinHostPort("", strconv.Itoa(port))
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func (oem *OnlineEditorManager) portPoolFile() string {
+	return filepath.Join(oem.baseDir, "port_allocations.json")
+}
+
+// 保存分配记录
+func (oem *OnlineEditorManager) savePortPool() {
+	allocations := make([]*PortAllocation, 0, len(oem.portPool))
+	for _, allocation := range oem.portPool {
+		allocations = append(allocations, allocation)
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		if allocations[i].Port != allocations[j].Port {
+			return allocations[i].Port < allocations[j].Port
+		}
+		return allocations[i].Protocol < allocations[j].Protocol
+	})
+
+	data, err := json.MarshalIndent(allocations, "", "  ")
+	if err == nil {
+		err = os.WriteFile(oem.portPoolFile(), data, 0644)
+	}
+	if err != nil {
+		log.Printf("保存端口分配记录失败: %v", err)
+	}
+}
+
+// 为工作空间的端口映射分配宿主机端口：开启公共访问且未指定宿主机端口的自动分配，
+// 指定了的（包括私有端口）检查是否与其他工作空间或宿主机上的进程冲突。
+// 不再使用的端口会被释放。失败时不修改端口池和ports中的宿主机端口
+func (oem *OnlineEditorManager) assignHostPorts(workspaceID string, ports []PortMapping) error {
+	start, end := workspacePortRange()
+	assigned := map[hostPortKey]*PortAllocation{}
+	allocated := map[int]int{} // 自动分配的端口，ports下标 -> 宿主机端口
+
+	for i := range ports {
+		mapping := &ports[i]
+		if mapping.Protocol == "" {
+			mapping.Protocol = "tcp"
+		}
+		if mapping.ContainerPort == "" || (!mapping.PublicAccess && mapping.HostPort == "") {
+			continue
+		}
+
+		allocation := &PortAllocation{
+			WorkspaceID:   workspaceID,
+			ContainerPort: mapping.ContainerPort,
+			Protocol:      mapping.Protocol,
+			PublicAccess:  mapping.PublicAccess,
+			Allocated:     time.Now(),
+		}
+
+		if mapping.HostPort != "" {
+			port, err := strconv.Atoi(mapping.HostPort)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("无效的宿主机端口: %s", mapping.HostPort)
+			}
+			key := portKey(port, mapping.Protocol)
+			if assigned[key] != nil {
+				return fmt.Errorf("宿主机端口重复: %d/%s", port, mapping.Protocol)
+			}
+			if existing, ok := oem.portPool[key]; ok {
+				if existing.WorkspaceID != workspaceID {
+					return fmt.Errorf("宿主机端口 %d 已被工作空间 %s 使用", port, existing.WorkspaceID)
+				}
+				allocation.Allocated = existing.Allocated
+			} else if !hostPortAvailable(port, mapping.Protocol) {
+				return fmt.Errorf("宿主机端口 %d 已被占用", port)
+			}
+			allocation.Port = port
+			assigned[key] = allocation
+			continue
+		}
+
+		if oem.portCursor < start || oem.portCursor > end {
+			oem.portCursor = start
+		}
+		size, probes := end-start+1, 0
+		for offset := 0; offset < size && probes < maxPortProbes; offset++ {
+			port := start + (oem.portCursor-start+offset)%size
+			key := portKey(port, mapping.Protocol)
+			if _, used := oem.portPool[key]; used || assigned[key] != nil {
+				continue
+			}
+			probes++
+			if hostPortAvailable(port, mapping.Protocol) {
+				allocation.Port = port
+				break
+			}
+		}
+		if allocation.Port == 0 {
+			return fmt.Errorf("端口范围 %d-%d 内没有可用的宿主机端口", start, end)
+		}
+		oem.portCursor = allocation.Port + 1
+		allocated[i] = allocation.Port
+		assigned[portKey(allocation.Port, mapping.Protocol)] = allocation
+		log.Printf("[%s] 自动分配宿主机端口 %d -> 容器端口 %s/%s", workspaceID, allocation.Port, mapping.ContainerPort, mapping.Protocol)
+	}
+
+	for key, allocation := range oem.portPool {
+		if allocation.WorkspaceID == workspaceID && assigned[key] == nil {
+			delete(oem.portPool, key)
+		}
+	}
+	for key, allocation := range assigned {
+		oem.portPool[key] = allocation
+	}
+	for i, port := range allocated {
+		ports[i].HostPort = strconv.Itoa(port)
+	}
+	oem.savePortPool()
+	return nil
+}
+
+// 释放端口
+func (oem *OnlineEditorManager) releasePort(port int, protocol string) {
+	delete(oem.portPool, portKey(port, protocol))
+	oem.savePortPool()
+}
+
+// 释放工作空间的所有端口
+func (oem *OnlineEditorManager) releaseWorkspacePorts(workspaceID string) {
+	for key, allocation := range oem.portPool {
+		if allocation.WorkspaceID == workspaceID {
+			delete(oem.portPool, key)
+		}
+	}
+	oem.savePortPool()
+}
+
+// 恢复工作空间后重新占用端口：保留仍存在的工作空间的分配记录，
+// 并补充容器当前实际绑定的端口（已停止的容器查不到绑定，以记录为准）
+func (oem *OnlineEditorManager) restorePortPool() {
+	var allocations []*PortAllocation
+	if data, err := os.ReadFile(oem.portPoolFile()); err == nil {
+		if err := json.Unmarshal(data, &allocations); err != nil {
+			log.Printf("读取端口分配记录失败: %v", err)
+		}
+	}
+
+	for _, allocation := range allocations {
+		workspace, exists := oem.workspaces[allocation.WorkspaceID]
+		if !exists {
+			continue
+		}
+		// 旧的记录没有协议字段
+		if allocation.Protocol == "" {
+			allocation.Protocol = "tcp"
+		}
+		oem.portPool[portKey(allocation.Port, allocation.Protocol)] = allocation
+
+		hostPort := strconv.Itoa(allocation.Port)
+		found := false
+		for _, mapping := range workspace.Ports {
+			if mapping.HostPort == hostPort && portKey(allocation.Port, mapping.Protocol) == portKey(allocation.Port, allocation.Protocol) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			workspace.Ports = append(workspace.Ports, PortMapping{
+				HostPort:      hostPort,
+				ContainerPort: allocation.ContainerPort,
+				Protocol:      allocation.Protocol,
+				PublicAccess:  allocation.PublicAccess,
+			})
+		}
+	}
+
+	for _, workspace := range oem.workspaces {
+		for _, mapping := range workspace.Ports {
+			port, err := strconv.Atoi(mapping.HostPort)
+			if err != nil {
+				continue
+			}
+			key := portKey(port, mapping.Protocol)
+			if _, exists := oem.portPool[key]; !exists {
+				oem.portPool[key] = &PortAllocation{
+					Port:          port,
+					WorkspaceID:   workspace.ID,
+					ContainerPort: mapping.ContainerPort,
+					Protocol:      mapping.Protocol,
+					PublicAccess:  mapping.PublicAccess,
+					Allocated:     workspace.Created,
+				}
+			}
+		}
+	}
+
+	oem.savePortPool()
+	log.Printf("恢复端口分配: %d个", len(oem.portPool))
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 工作空间Web应用的反向代理
+//
+// 两种访问方式：
+//   - 子域名：{port}-{workspaceId}.<PREVIEW_DOMAIN>，需要配置泛域名解析到本服务
+//   - 路径：/proxy/{workspaceId}/{port}/...，默认关闭
+//
+// 路径方式下预览页面与编辑器同源，应用的脚本可以带着编辑器的会话调用/api/v1，
+// 只有完全信任工作空间中的应用时才设置PREVIEW_ALLOW_PATH_MODE=true开启，
+// 开启后也只允许所有者访问，分享链接只能使用子域名方式。
+//
+// 请求直接转发到容器的内部IP（HTTP和WebSocket），不需要宿主机端口。
+// 访问需要工作空间所有者的会话：前端先调用令牌接口获取签名令牌，带着
+// ?_preview_token=... 打开预览地址，代理校验后写入只对该工作空间有效的Cookie。
+// 来自可信认证网关的请求（见user_identity.go）按其中的用户身份直接校验。
+// 其他人通过所有者创建的分享链接访问，见preview_share.go。
+// 没有记录所有者的工作空间（没有用户身份时创建的）不能签发令牌，也就不能预览。
+
+const (
+	previewPathPrefix  = "/proxy/"
+	previewTokenParam  = "_preview_token"
+	previewCookieName  = "preview_session"
+	previewSessionTTL  = 12 * time.Hour
+	previewOwnerLabel  = "online-editor.owner"
+	previewProxyHeader = "X-Forwarded-Prefix"
+)
+
+// 预览令牌的内容：所有者令牌带UserID，分享令牌带Share和Port
+type previewClaims struct {
+	WorkspaceID string `json:"w"`
+	UserID      string `json:"u,omitempty"`
+	Share       string `json:"s,omitempty"`
+	Port        string `json:"p,omitempty"`
+	Unlocked    bool   `json:"k,omitempty"` // 分享链接的访问密码已验证
+	Expires     int64  `json:"exp"`
+}
+
+// 签名令牌：base64(JSON).base64(HMAC-SHA256)
+func signPreviewToken(key []byte, claims previewClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyPreviewToken(key []byte, token string) (*previewClaims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, fmt.Errorf("无效的令牌")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, fmt.Errorf("无效的令牌")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("无效的令牌")
+	}
+	var claims previewClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("无效的令牌")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, fmt.Errorf("令牌已过期")
+	}
+	return &claims, nil
+}
+
+// 子域名方式的预览域名
+func previewDomain() string {
+	return strings.ToLower(strings.Trim(os.Getenv("PREVIEW_DOMAIN"), "."))
+}
+
+// 是否允许路径方式的预览
+func previewPathModeEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("PREVIEW_ALLOW_PATH_MODE"))
+	return enabled
+}
+
+// 工作空间端口的预览地址，两种方式都不可用时返回空字符串
+func previewURL(workspaceID, port string) string {
+	if domain := previewDomain(); domain != "" {
+		return fmt.Sprintf("//%s-%s.%s/", port, workspaceID, domain)
+	}
+	if previewPathModeEnabled() {
+		return fmt.Sprintf("%s%s/%s/", previewPathPrefix, workspaceID, port)
+	}
+	return ""
+}
+
+// 解析子域名方式的Host：{port}-{workspaceId}.<PREVIEW_DOMAIN>
+func parsePreviewHost(host string) (workspaceID, port string, ok bool) {
+	domain := previewDomain()
+	if domain == "" {
+		return "", "", false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	label, found := strings.CutSuffix(strings.ToLower(host), "."+domain)
+	if !found || strings.Contains(label, ".") {
+		return "", "", false
+	}
+	port, workspaceID, found = strings.Cut(label, "-")
+	return workspaceID, port, found && workspaceID != ""
+}
+
+// 解析路径方式的URL：/proxy/{workspaceId}/{port}/...，返回去掉的前缀（不含末尾的斜杠）
+func parsePreviewPath(p string) (workspaceID, port, prefix string, ok bool) {
+	rest, found := strings.CutPrefix(p, previewPathPrefix)
+	if !found {
+		return "", "", "", false
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], previewPathPrefix + parts[0] + "/" + parts[1], true
+}
+
+// 在路由之前拦截预览请求，代理的响应不经过API的CORS等中间件
+func (oem *OnlineEditorManager) previewProxyHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if workspaceID, port, ok := parsePreviewHost(r.Host); ok {
+			oem.servePreview(w, r, workspaceID, port, "")
+			return
+		}
+
+		if workspaceID, port, prefix, ok := parsePreviewPath(r.URL.Path); ok {
+			if !previewPathModeEnabled() {
+				http.Error(w, "路径方式的预览已关闭，请配置PREVIEW_DOMAIN使用子域名方式访问", http.StatusNotFound)
+				return
+			}
+			// /proxy/{id}/{port} 补上末尾的斜杠，保证页面中的相对路径正确
+			if r.URL.Path == prefix {
+				target := *r.URL
+				target.Path = prefix + "/"
+				http.Redirect(w, r, target.String(), http.StatusFound)
+				return
+			}
+			oem.servePreview(w, r, workspaceID, port, prefix)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 校验访问权限，令牌在URL中时写入Cookie并重定向到去掉令牌的地址
+// 返回false时已写入响应
+func (oem *OnlineEditorManager) authorizePreview(w http.ResponseWriter, r *http.Request, workspaceID, port, owner, prefix string) bool {
+	if token := r.URL.Query().Get(previewTokenParam); token != "" {
+		claims, err := verifyPreviewToken(oem.previewKey, token)
+		if err != nil || claims.WorkspaceID != workspaceID {
+			http.Error(w, "预览令牌无效或已过期", http.StatusUnauthorized)
+			return false
+		}
+
+		cookiePath := "/"
+		if prefix != "" {
+			cookiePath = previewPathPrefix + workspaceID + "/"
+		}
+		unlocking := false
+
+		if claims.Share != "" {
+			// 路径方式与编辑器同源，不允许所有者以外的人访问
+			if prefix != "" {
+				http.Error(w, "分享链接只能通过子域名方式访问", http.StatusForbidden)
+				return false
+			}
+			share, err := oem.previewShares.Validate(claims, port)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return false
+			}
+			if share.HasPassword && !claims.Unlocked {
+				if !oem.checkSharePassword(w, r, share.ID) {
+					return false
+				}
+				claims.Unlocked = true
+				unlocking = true
+			}
+			oem.previewShares.RecordAccess(share.ID, "session", r)
+		} else if owner == "" || claims.UserID != owner {
+			http.Error(w, "预览令牌无效或已过期", http.StatusUnauthorized)
+			return false
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     previewCookieName,
+			Value:    signPreviewToken(oem.previewKey, *claims),
+			Path:     cookiePath,
+			Expires:  time.Unix(claims.Expires, 0),
+			HttpOnly: true,
+			Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || unlocking {
+			target := *r.URL
+			query := target.Query()
+			query.Del(previewTokenParam)
+			target.RawQuery = query.Encode()
+			http.Redirect(w, r, target.RequestURI(), http.StatusSeeOther)
+			return false
+		}
+		return true
+	}
+
+	// 同名Cookie可能有多个（所有者会话和分享会话的Path不同），逐个检查
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != previewCookieName {
+			continue
+		}
+		claims, err := verifyPreviewToken(oem.previewKey, cookie.Value)
+		if err != nil || claims.WorkspaceID != workspaceID {
+			continue
+		}
+		if claims.Share == "" {
+			if owner != "" && claims.UserID == owner {
+				return true
+			}
+			continue
+		}
+		if prefix != "" {
+			continue
+		}
+		if share, err := oem.previewShares.Validate(claims, port); err == nil && (!share.HasPassword || claims.Unlocked) {
+			oem.previewShares.RecordAccess(share.ID, "request", r)
+			return true
+		}
+	}
+	if userID := requestUserID(r); owner != "" && userID == owner {
+		return true
+	}
+
+	http.Error(w, "需要工作空间所有者的会话或有效的分享链接才能访问预览", http.StatusUnauthorized)
+	return false
+}
+
+// 转发到容器端口
+func (oem *OnlineEditorManager) servePreview(w http.ResponseWriter, r *http.Request, workspaceID, port, prefix string) {
+	if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+		http.Error(w, "无效的端口号", http.StatusBadRequest)
+		return
+	}
+
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var owner, status, ip string
+	if exists {
+		owner, status, ip = workspace.Owner, workspace.Status, workspace.NetworkIP
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "工作空间不存在", http.StatusNotFound)
+		return
+	}
+	if !oem.authorizePreview(w, r, workspaceID, port, owner, prefix) {
+		return
+	}
+
+	// 预览流量算作活动，空闲停止的工作空间先自动启动
+	oem.recordActivity(workspaceID)
+	if status != "running" {
+		if err := oem.wakeWorkspace(workspaceID); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		oem.mutex.RLock()
+		status, ip = workspace.Status, workspace.NetworkIP
+		oem.mutex.RUnlock()
+	}
+	if status != "running" || ip == "" {
+		http.Error(w, "工作空间未运行", http.StatusServiceUnavailable)
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: net.JoinHostPort(ip, port)}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			if prefix != "" {
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, prefix), "/")
+				pr.Out.URL.RawPath = ""
+				pr.Out.Header.Set(previewProxyHeader, prefix)
+			}
+
+			// 不把预览令牌和会话Cookie转发给应用
+			query := pr.Out.URL.Query()
+			if query.Has(previewTokenParam) {
+				query.Del(previewTokenParam)
+				pr.Out.URL.RawQuery = query.Encode()
+			}
+			cookies := pr.In.Cookies()
+			pr.Out.Header.Del("Cookie")
+			for _, cookie := range cookies {
+				if cookie.Name != previewCookieName {
+					pr.Out.AddCookie(cookie)
+				}
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			rewritePreviewResponse(resp, r, target, prefix)
+			return nil
+		},
+		// 开发服务器常用SSE、流式响应，立即刷新
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("[%s] 预览代理失败 %s: %v", workspaceID, port, err)
+			http.Error(w, fmt.Sprintf("无法连接到工作空间端口 %s，请确认服务已启动", port), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// 改写重定向地址和Cookie路径，使其指向代理地址
+func rewritePreviewResponse(resp *http.Response, in *http.Request, target *url.URL, prefix string) {
+	if location := resp.Header.Get("Location"); location != "" {
+		if loc, err := url.Parse(location); err == nil {
+			if loc.IsAbs() && isPreviewTargetHost(loc, target) {
+				scheme := "http"
+				if in.TLS != nil || in.Header.Get("X-Forwarded-Proto") == "https" {
+					scheme = "https"
+				}
+				loc.Scheme, loc.Host = scheme, in.Host
+				loc.Path = prefix + loc.Path
+				resp.Header.Set("Location", loc.String())
+			} else if !loc.IsAbs() && loc.Host == "" && strings.HasPrefix(loc.Path, "/") && prefix != "" {
+				loc.Path = prefix + loc.Path
+				resp.Header.Set("Location", loc.String())
+			}
+		}
+	}
+
+	if prefix == "" {
+		return
+	}
+	cookies := resp.Cookies()
+	if len(cookies) == 0 {
+		return
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		cookie.Domain = ""
+		cookie.Path = prefix + "/" + strings.TrimPrefix(cookie.Path, "/")
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+}
+
+// 重定向是否指向容器本身（应用通常用localhost或容器IP生成绝对地址）
+func isPreviewTargetHost(loc, target *url.URL) bool {
+	if loc.Host == target.Host {
+		return true
+	}
+	_, targetPort, _ := net.SplitHostPort(target.Host)
+	switch loc.Hostname() {
+	case "localhost", "127.0.0.1", "0.0.0.0", "::1":
+		return loc.Port() == targetPort
+	}
+	return false
+}
+
+// 检查请求者是否为工作空间所有者，返回false时已写入响应
+func (oem *OnlineEditorManager) requireWorkspaceOwner(w http.ResponseWriter, r *http.Request, workspaceID string) bool {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var owner string
+	if exists {
+		owner = workspace.Owner
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "工作空间不存在", http.StatusNotFound)
+		return false
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return false
+	}
+	if owner == "" || userID != owner {
+		http.Error(w, "只有工作空间所有者可以访问预览", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// 签发预览令牌，只有工作空间所有者可以签发
+// POST /workspaces/{id}/proxy/token
+func (oem *OnlineEditorManager) handleCreatePreviewToken(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+		return
+	}
+	urlPattern := previewURL(workspaceID, "{port}")
+	if urlPattern == "" {
+		http.Error(w, "未配置PREVIEW_DOMAIN，预览代理不可用", http.StatusServiceUnavailable)
+		return
+	}
+
+	expires := time.Now().Add(previewSessionTTL)
+	token := signPreviewToken(oem.previewKey, previewClaims{WorkspaceID: workspaceID, UserID: requestUserID(r), Expires: expires.Unix()})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":       token,
+		"param":       previewTokenParam,
+		"expires_at":  expires,
+		"url_pattern": urlPattern,
+	})
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 预览分享链接
+//
+// 工作空间所有者可以为某个端口生成带有效期的分享链接，链接中是签名的预览令牌，
+// 经本服务的预览代理访问，无需开放宿主机端口。可以设置访问密码，
+// 每次访问都会记录，所有者可以随时撤销（删除分享后令牌立即失效）。
+// 密码错误次数按分享和来源IP计数，超过限制后暂时锁定，计数只保存在内存中。
+// 分享的页面由所有者以外的人打开，只能使用子域名方式（需要配置PREVIEW_DOMAIN）。
+
+const (
+	defaultShareTTL      = 24 * time.Hour
+	maxShareTTL          = 30 * 24 * time.Hour
+	maxShareAccessLog    = 200
+	sharePasswordField   = "_preview_password"
+	sharePasswordRounds  = 100000
+	sharePasswordKeySize = 32
+
+	sharePasswordWindow      = 15 * time.Minute // 错误次数的统计窗口，也是锁定时长
+	sharePasswordMaxPerIP    = 5                // 同一来源IP在窗口内的最多错误次数
+	sharePasswordMaxPerShare = 50               // 同一分享在窗口内的最多错误次数（所有来源）
+)
+
+// 分享链接
+type PreviewShare struct {
+	ID           string               `json:"id"`
+	WorkspaceID  string               `json:"workspace_id"`
+	Port         string               `json:"port"`
+	Note         string               `json:"note,omitempty"`
+	CreatedBy    string               `json:"created_by"`
+	Created      time.Time            `json:"created"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+	HasPassword  bool                 `json:"has_password"`
+	PasswordHash string               `json:"password_hash,omitempty"` // 不返回给客户端
+	AccessCount  int                  `json:"access_count"`
+	LastAccess   *time.Time           `json:"last_access,omitempty"`
+	AccessLog    []PreviewShareAccess `json:"access_log,omitempty"`
+	URL          string               `json:"url,omitempty"`
+}
+
+// 分享链接的访问记录
+type PreviewShareAccess struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"` // "session"（打开链接）, "request", "password_failed"
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+type PreviewShareRequest struct {
+	Port      string `json:"port"`
+	ExpiresIn int    `json:"expires_in"` // 有效期（秒），默认24小时，最长30天
+	Password  string `json:"password"`
+	Note      string `json:"note"`
+}
+
+// 分享链接管理器
+type PreviewShareManager struct {
+	shares   map[string]*PreviewShare
+	failures map[string]*passwordFailures // 分享ID或"分享ID|IP" -> 密码错误记录
+	file     string
+	mutex    sync.Mutex
+}
+
+// 统计窗口内的密码错误次数
+type passwordFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func NewPreviewShareManager(baseDir string) *PreviewShareManager {
+	manager := &PreviewShareManager{
+		shares:   make(map[string]*PreviewShare),
+		failures: make(map[string]*passwordFailures),
+		file:     filepath.Join(baseDir, "preview_shares.json"),
+	}
+
+	if data, err := os.ReadFile(manager.file); err == nil {
+		var shares []*PreviewShare
+		if err := json.Unmarshal(data, &shares); err != nil {
+			log.Printf("读取预览分享失败: %v", err)
+		}
+		for _, share := range shares {
+			manager.shares[share.ID] = share
+		}
+	}
+	return manager
+}
+
+// 返回给客户端的副本，withLog为false时不含访问记录
+func (s *PreviewShare) public(withLog bool) *PreviewShare {
+	copied := *s
+	copied.PasswordHash = ""
+	if withLog {
+		copied.AccessLog = append([]PreviewShareAccess(nil), s.AccessLog...)
+	} else {
+		copied.AccessLog = nil
+	}
+	return &copied
+}
+
+func hashSharePassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, sharePasswordRounds, sharePasswordKeySize)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", sharePasswordRounds, hex.EncodeToString(salt), hex.EncodeToString(key)), nil
+}
+
+func verifySharePassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	rounds, err := strconv.Atoi(parts[1])
+	salt, err1 := hex.DecodeString(parts[2])
+	expected, err2 := hex.DecodeString(parts[3])
+	if err != nil || err1 != nil || err2 != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, rounds, len(expected))
+	return err == nil && subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// 删除已过期的分享（调用者必须持有锁），返回是否有删除
+func (m *PreviewShareManager) removeExpiredLocked() bool {
+	removed := false
+	for id, share := range m.shares {
+		if time.Now().After(share.ExpiresAt) {
+			delete(m.shares, id)
+			removed = true
+		}
+	}
+	return removed
+}
+
+func (m *PreviewShareManager) saveLocked() error {
+	shares := make([]*PreviewShare, 0, len(m.shares))
+	for _, share := range m.shares {
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Created.Before(shares[j].Created) })
+
+	data, err := json.MarshalIndent(shares, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.file, data, 0600)
+}
+
+// 列出工作空间的有效分享，按创建时间排序
+func (m *PreviewShareManager) List(workspaceID string) []*PreviewShare {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.removeExpiredLocked() {
+		m.saveLocked()
+	}
+
+	shares := []*PreviewShare{}
+	for _, share := range m.shares {
+		if share.WorkspaceID == workspaceID {
+			shares = append(shares, share.public(false))
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Created.Before(shares[j].Created) })
+	return shares
+}
+
+func (m *PreviewShareManager) Get(workspaceID, shareID string) (*PreviewShare, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	share, exists := m.shares[shareID]
+	if !exists || share.WorkspaceID != workspaceID || time.Now().After(share.ExpiresAt) {
+		return nil, fmt.Errorf("分享不存在: %s", shareID)
+	}
+	return share.public(true), nil
+}
+
+func (m *PreviewShareManager) Add(workspaceID, userID string, req PreviewShareRequest) (*PreviewShare, error) {
+	port, err := strconv.Atoi(req.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("无效的端口号: %s", req.Port)
+	}
+
+	ttl := defaultShareTTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > maxShareTTL {
+		return nil, fmt.Errorf("有效期不能超过%d天", int(maxShareTTL.Hours()/24))
+	}
+
+	now := time.Now()
+	share := &PreviewShare{
+		ID:          fmt.Sprintf("share_%d", now.UnixNano()),
+		WorkspaceID: workspaceID,
+		Port:        strconv.Itoa(port),
+		Note:        req.Note,
+		CreatedBy:   userID,
+		Created:     now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	if req.Password != "" {
+		hash, err := hashSharePassword(req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("设置访问密码失败: %v", err)
+		}
+		share.PasswordHash = hash
+		share.HasPassword = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.removeExpiredLocked()
+	m.shares[share.ID] = share
+	if err := m.saveLocked(); err != nil {
+		delete(m.shares, share.ID)
+		return nil, fmt.Errorf("保存分享失败: %v", err)
+	}
+
+	log.Printf("[%s] 用户 %s 创建端口 %s 的预览分享: %s (有效期至 %s)", workspaceID, userID, share.Port, share.ID, share.ExpiresAt.Format(time.RFC3339))
+	return share.public(false), nil
+}
+
+// 撤销分享
+func (m *PreviewShareManager) Delete(workspaceID, shareID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	share, exists := m.shares[shareID]
+	if !exists || share.WorkspaceID != workspaceID {
+		return fmt.Errorf("分享不存在: %s", shareID)
+	}
+
+	delete(m.shares, shareID)
+	if err := m.saveLocked(); err != nil {
+		m.shares[shareID] = share
+		return fmt.Errorf("保存分享失败: %v", err)
+	}
+	log.Printf("[%s] 撤销预览分享: %s", workspaceID, shareID)
+	return nil
+}
+
+// 删除工作空间的所有分享
+func (m *PreviewShareManager) DeleteWorkspace(workspaceID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	removed := false
+	for id, share := range m.shares {
+		if share.WorkspaceID == workspaceID {
+			delete(m.shares, id)
+			removed = true
+		}
+	}
+	if removed {
+		m.saveLocked()
+	}
+}
+
+// 检查令牌对应的分享是否有效并可以访问该端口
+func (m *PreviewShareManager) Validate(claims *previewClaims, port string) (*PreviewShare, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	share, exists := m.shares[claims.Share]
+	if !exists || share.WorkspaceID != claims.WorkspaceID {
+		return nil, fmt.Errorf("分享链接已被撤销")
+	}
+	if time.Now().After(share.ExpiresAt) {
+		return nil, fmt.Errorf("分享链接已过期")
+	}
+	if share.Port != port || claims.Port != port {
+		return nil, fmt.Errorf("分享链接不能访问该端口")
+	}
+	return share.public(false), nil
+}
+
+// 校验访问密码。同一来源或同一分享错误次数过多时在锁定期间不再校验，
+// 返回需要等待的时间
+func (m *PreviewShareManager) CheckPassword(shareID, password, clientIP string) (bool, time.Duration) {
+	ipKey := shareID + "|" + clientIP
+
+	m.mutex.Lock()
+	now := time.Now()
+	for _, key := range []string{shareID, ipKey} {
+		if failures := m.failures[key]; failures != nil && now.Before(failures.lockedUntil) {
+			m.mutex.Unlock()
+			return false, failures.lockedUntil.Sub(now)
+		}
+	}
+	hash := ""
+	if share, exists := m.shares[shareID]; exists {
+		hash = share.PasswordHash
+	}
+	m.mutex.Unlock()
+
+	if hash != "" && verifySharePassword(hash, password) {
+		m.mutex.Lock()
+		delete(m.failures, ipKey)
+		m.mutex.Unlock()
+		return true, 0
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.recordFailureLocked(ipKey, sharePasswordMaxPerIP)
+	m.recordFailureLocked(shareID, sharePasswordMaxPerShare)
+	return false, 0
+}
+
+// 记录一次密码错误，窗口内达到limit次后锁定。顺便清理已过期的记录
+func (m *PreviewShareManager) recordFailureLocked(key string, limit int) {
+	now := time.Now()
+	for k, failures := range m.failures {
+		if now.Sub(failures.first) > sharePasswordWindow && now.After(failures.lockedUntil) {
+			delete(m.failures, k)
+		}
+	}
+
+	failures := m.failures[key]
+	if failures == nil {
+		failures = &passwordFailures{first: now}
+		m.failures[key] = failures
+	}
+	failures.count++
+	if failures.count >= limit {
+		failures.lockedUntil = now.Add(sharePasswordWindow)
+		failures.count, failures.first = 0, failures.lockedUntil
+		log.Printf("预览分享密码错误次数过多，暂时锁定: %s", key)
+	}
+}
+
+// 访问者的IP，只有来自可信代理的请求才使用X-Forwarded-For
+func shareClientIP(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ip, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(ip)
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// 记录访问，打开链接时同时保存到文件（请求和密码错误只记在内存中，随下次保存落盘）
+func (m *PreviewShareManager) RecordAccess(shareID, event string, r *http.Request) {
+	entry := PreviewShareAccess{
+		Time:       time.Now(),
+		Event:      event,
+		RemoteAddr: shareClientIP(r),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		UserAgent:  r.UserAgent(),
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	share, exists := m.shares[shareID]
+	if !exists {
+		return
+	}
+	if event != "password_failed" {
+		share.AccessCount++
+		share.LastAccess = &entry.Time
+	}
+	share.AccessLog = append(share.AccessLog, entry)
+	if len(share.AccessLog) > maxShareAccessLog {
+		share.AccessLog = share.AccessLog[len(share.AccessLog)-maxShareAccessLog:]
+	}
+
+	if event == "session" {
+		log.Printf("[%s] 预览分享 %s 访问: %s %s", share.WorkspaceID, shareID, event, entry.RemoteAddr)
+		if err := m.saveLocked(); err != nil {
+			log.Printf("保存预览分享失败: %v", err)
+		}
+	}
+}
+
+// 分享链接的地址，未配置子域名方式时返回空字符串
+func (oem *OnlineEditorManager) previewShareURL(share *PreviewShare) string {
+	if previewDomain() == "" {
+		return ""
+	}
+	token := signPreviewToken(oem.previewKey, previewClaims{
+		WorkspaceID: share.WorkspaceID,
+		Share:       share.ID,
+		Port:        share.Port,
+		Expires:     share.ExpiresAt.Unix(),
+	})
+	return previewURL(share.WorkspaceID, share.Port) + "?" + previewTokenParam + "=" + token
+}
+
+var sharePasswordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>预览需要密码</title></head>
+<body style="font-family: sans-serif; max-width: 360px; margin: 80px auto;">
+<h3>此预览链接需要访问密码</h3>
+{{if .}}<p style="color: #c00;">{{.}}</p>{{end}}
+<form method="post">
+<input type="password" name="` + sharePasswordField + `" autofocus style="width: 100%; padding: 6px;">
+<p><button type="submit">访问</button></p>
+</form>
+</body>
+</html>`))
+
+// 显示或校验分享链接的访问密码，返回false时已写入响应
+func (oem *OnlineEditorManager) checkSharePassword(w http.ResponseWriter, r *http.Request, shareID string) bool {
+	message, status := "", http.StatusUnauthorized
+	if r.Method == http.MethodPost {
+		ok, retryAfter := oem.previewShares.CheckPassword(shareID, r.PostFormValue(sharePasswordField), shareClientIP(r))
+		if ok {
+			return true
+		}
+		if retryAfter > 0 {
+			minutes := int(retryAfter.Minutes()) + 1
+			message, status = fmt.Sprintf("密码错误次数过多，请%d分钟后再试", minutes), http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		} else {
+			oem.previewShares.RecordAccess(shareID, "password_failed", r)
+			message = "密码错误"
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	sharePasswordPage.Execute(w, message)
+	return false
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/shares
+func (oem *OnlineEditorManager) handleListPreviewShares(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+		return
+	}
+
+	shares := oem.previewShares.List(workspaceID)
+	for _, share := range shares {
+		share.URL = oem.previewShareURL(share)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shares)
+}
+
+// POST /workspaces/{id}/shares
+func (oem *OnlineEditorManager) handleCreatePreviewShare(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+		return
+	}
+	if previewDomain() == "" {
+		http.Error(w, "未配置PREVIEW_DOMAIN，不能创建分享链接", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req PreviewShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	share, err := oem.previewShares.Add(workspaceID, requestUserID(r), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	share.URL = oem.previewShareURL(share)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(share)
+}
+
+// GET /workspaces/{id}/shares/{shareId}
+// 返回分享详情和访问记录
+func (oem *OnlineEditorManager) handleGetPreviewShare(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+		return
+	}
+
+	share, err := oem.previewShares.Get(workspaceID, vars["shareId"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	share.URL = oem.previewShareURL(share)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(share)
+}
+
+// DELETE /workspaces/{id}/shares/{shareId}
+func (oem *OnlineEditorManager) handleRevokePreviewShare(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	workspaceID := vars["id"]
+	if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+		return
+	}
+
+	if err := oem.previewShares.Delete(workspaceID, vars["shareId"]); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "revoked"})
+}
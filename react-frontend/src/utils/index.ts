@@ -7,6 +7,7 @@ export const getStatusText = (status: string): string => {
     'pulling': '拉取镜像',
     'creating': '创建中',
     'starting': '启动中',
+    'cloning': '克隆仓库',
     'initializing': '初始化',
     'running': '运行中',
     'stopped': '已停止',
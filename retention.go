@@ -0,0 +1,735 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// 工作空间保留策略
+//
+// 按最后一次活动时间（见idle.go）而不是创建时间判断工作空间是否过期，分阶段处理：
+// 无活动超过stop_after_hours后停止，超过archive_after_hours后把工作空间目录归档为快照，
+// 超过delete_after_hours后删除工作空间，快照保留archive_retention_days天。
+// 相邻两个阶段之间至少间隔grace_hours，服务长时间停机后也不会一次执行完所有阶段。
+// 固定（pinned）的工作空间和收藏的工作空间（exempt_favorites）不受策略影响，
+// 阶段开始后如果又有活动，重新从头计算。收藏、固定标记和阶段保存在retention_state.json中。
+// 创建失败的工作空间同样按阶段处理；创建中的工作空间超过retentionStuckAfter仍没有完成时按失败处理。
+// 快照只包含工作空间目录，有命名卷或sidecar匿名卷的工作空间归档后不自动删除。
+// 修改策略和立即执行需要管理员权限；快照只有工作空间所有者可以查看和下载，
+// 工作空间删除后按保存的所有者判断。
+
+const (
+	retentionStageActive   = ""
+	retentionStageStopped  = "stopped"
+	retentionStageArchived = "archived"
+	retentionStageDeleted  = "deleted"
+
+	retentionActionNone    = "none"
+	retentionActionStop    = "stop"
+	retentionActionArchive = "archive"
+	retentionActionDelete  = "delete"
+
+	// 创建中的工作空间超过此时间没有完成也没有活动时按失败处理
+	retentionStuckAfter = time.Hour
+)
+
+// 保留策略，时间为0表示不执行该阶段
+type RetentionPolicy struct {
+	Enabled              bool `json:"enabled"`
+	StopAfterHours       int  `json:"stop_after_hours"`
+	ArchiveAfterHours    int  `json:"archive_after_hours"`
+	DeleteAfterHours     int  `json:"delete_after_hours"`
+	GraceHours           int  `json:"grace_hours"`            // 相邻阶段之间的最短间隔
+	ExemptFavorites      bool `json:"exempt_favorites"`       // 收藏的工作空间不过期
+	ArchiveRetentionDays int  `json:"archive_retention_days"` // 删除工作空间后快照保留天数
+}
+
+func defaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		Enabled:              true,
+		StopAfterHours:       24,
+		ArchiveAfterHours:    7 * 24,
+		DeleteAfterHours:     30 * 24,
+		GraceHours:           24,
+		ExemptFavorites:      true,
+		ArchiveRetentionDays: 90,
+	}
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.StopAfterHours < 0 || p.ArchiveAfterHours < 0 || p.DeleteAfterHours < 0 || p.GraceHours < 0 || p.ArchiveRetentionDays < 0 {
+		return fmt.Errorf("时间不能为负数")
+	}
+	if p.DeleteAfterHours > 0 {
+		// 删除前必须先归档
+		if p.ArchiveAfterHours == 0 {
+			return fmt.Errorf("启用删除时必须同时启用归档")
+		}
+		if p.DeleteAfterHours <= p.ArchiveAfterHours {
+			return fmt.Errorf("delete_after_hours必须大于archive_after_hours")
+		}
+	}
+	if p.ArchiveAfterHours > 0 && p.StopAfterHours > 0 && p.ArchiveAfterHours < p.StopAfterHours {
+		return fmt.Errorf("archive_after_hours不能小于stop_after_hours")
+	}
+	return nil
+}
+
+// 下一个阶段对应的操作和无活动时间阈值
+func (p RetentionPolicy) nextStep(stage string) (string, time.Duration) {
+	steps := []struct {
+		action string
+		stage  string
+		hours  int
+	}{
+		{retentionActionStop, retentionStageStopped, p.StopAfterHours},
+		{retentionActionArchive, retentionStageArchived, p.ArchiveAfterHours},
+		{retentionActionDelete, retentionStageDeleted, p.DeleteAfterHours},
+	}
+
+	passed := stage == retentionStageActive
+	for _, step := range steps {
+		if !passed {
+			passed = step.stage == stage
+			continue
+		}
+		if step.hours > 0 {
+			return step.action, time.Duration(step.hours) * time.Hour
+		}
+	}
+	return retentionActionNone, 0
+}
+
+// 工作空间的保留状态
+type retentionState struct {
+	WorkspaceID string     `json:"workspace_id"`
+	Name        string     `json:"name,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Pinned      bool       `json:"pinned,omitempty"`
+	Favorite    bool       `json:"favorite,omitempty"`
+	Stage       string     `json:"stage,omitempty"`
+	StageSince  time.Time  `json:"stage_since,omitempty"`
+	ArchivePath string     `json:"archive_path,omitempty"`
+	ArchiveSize int64      `json:"archive_size,omitempty"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// 报告中的一项
+type RetentionReportItem struct {
+	WorkspaceID   string     `json:"workspace_id"`
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	LastActivity  time.Time  `json:"last_activity"`
+	InactiveHours float64    `json:"inactive_hours"`
+	Stage         string     `json:"stage,omitempty"`
+	Exempt        bool       `json:"exempt"`
+	ExemptReason  string     `json:"exempt_reason,omitempty"`
+	Action        string     `json:"action"`                   // 本次执行（或将要执行）的操作
+	NextAction    string     `json:"next_action,omitempty"`    // 之后的操作
+	NextActionAt  *time.Time `json:"next_action_at,omitempty"` // 没有新的活动时执行下一操作的时间
+	Result        string     `json:"result,omitempty"`         // "done", "failed", "dry_run"
+	Error         string     `json:"error,omitempty"`
+}
+
+type RetentionManager struct {
+	policyFile string
+	stateFile  string
+	archiveDir string
+	policy     RetentionPolicy
+	states     map[string]*retentionState
+	runMutex   sync.Mutex // 同一时间只执行一次策略
+	mutex      sync.Mutex
+}
+
+func NewRetentionManager(baseDir string) *RetentionManager {
+	rm := &RetentionManager{
+		policyFile: filepath.Join(baseDir, "retention_policy.json"),
+		stateFile:  filepath.Join(baseDir, "retention_state.json"),
+		archiveDir: filepath.Join(baseDir, "archives"),
+		policy:     defaultRetentionPolicy(),
+		states:     make(map[string]*retentionState),
+	}
+
+	if err := os.MkdirAll(rm.archiveDir, 0755); err != nil {
+		log.Printf("创建归档目录失败: %v", err)
+	}
+	if data, err := os.ReadFile(rm.policyFile); err == nil {
+		policy := defaultRetentionPolicy()
+		err := json.Unmarshal(data, &policy)
+		if err == nil {
+			err = policy.validate()
+		}
+		if err != nil {
+			log.Printf("读取保留策略失败，使用默认策略: %v", err)
+		} else {
+			rm.policy = policy
+		}
+	}
+	if data, err := os.ReadFile(rm.stateFile); err == nil {
+		if err := json.Unmarshal(data, &rm.states); err != nil {
+			log.Printf("读取保留状态失败: %v", err)
+		}
+	}
+	return rm
+}
+
+func (rm *RetentionManager) Policy() RetentionPolicy {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+	return rm.policy
+}
+
+func (rm *RetentionManager) SetPolicy(policy RetentionPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(rm.policyFile, data, 0644); err != nil {
+		return fmt.Errorf("保存保留策略失败: %v", err)
+	}
+	rm.policy = policy
+	return nil
+}
+
+// 调用者必须持有rm.mutex
+func (rm *RetentionManager) stateLocked(workspaceID string) *retentionState {
+	state, exists := rm.states[workspaceID]
+	if !exists {
+		state = &retentionState{WorkspaceID: workspaceID}
+		rm.states[workspaceID] = state
+	}
+	return state
+}
+
+func (rm *RetentionManager) saveLocked() {
+	data, err := json.MarshalIndent(rm.states, "", "  ")
+	if err == nil {
+		err = os.WriteFile(rm.stateFile, data, 0644)
+	}
+	if err != nil {
+		log.Printf("保存保留状态失败: %v", err)
+	}
+}
+
+// 更新工作空间的阶段
+func (rm *RetentionManager) setStage(workspaceID, stage string, update func(state *retentionState)) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	state := rm.stateLocked(workspaceID)
+	state.Stage = stage
+	state.StageSince = time.Now()
+	if update != nil {
+		update(state)
+	}
+	rm.saveLocked()
+}
+
+// 删除工作空间后清理状态，有快照的保留到快照过期
+func (rm *RetentionManager) Forget(workspaceID string) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	state, exists := rm.states[workspaceID]
+	if !exists {
+		return
+	}
+	if state.ArchivePath == "" {
+		delete(rm.states, workspaceID)
+	} else if state.DeletedAt == nil {
+		now := time.Now()
+		state.DeletedAt = &now
+	}
+	rm.saveLocked()
+}
+
+// 保存工作空间的收藏和固定标记
+func (oem *OnlineEditorManager) saveRetentionFlags(workspaceID string) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var name, owner string
+	var pinned, favorite bool
+	if exists {
+		name, owner, pinned, favorite = workspace.DisplayName, workspace.Owner, workspace.IsPinned, workspace.IsFavorite
+	}
+	oem.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	oem.retention.mutex.Lock()
+	defer oem.retention.mutex.Unlock()
+	state := oem.retention.stateLocked(workspaceID)
+	state.Name, state.Owner, state.Pinned, state.Favorite = name, owner, pinned, favorite
+	oem.retention.saveLocked()
+}
+
+// 恢复工作空间后应用保存的收藏和固定标记
+func (oem *OnlineEditorManager) restoreRetentionFlags() {
+	oem.mutex.Lock()
+	defer oem.mutex.Unlock()
+	oem.retention.mutex.Lock()
+	defer oem.retention.mutex.Unlock()
+
+	for workspaceID, state := range oem.retention.states {
+		if workspace, exists := oem.workspaces[workspaceID]; exists {
+			workspace.IsPinned = state.Pinned
+			workspace.IsFavorite = state.Favorite
+			if state.Name != "" && workspace.DisplayName == workspaceID {
+				workspace.DisplayName = state.Name
+			}
+		}
+	}
+}
+
+// 按策略计算每个工作空间的操作
+func (oem *OnlineEditorManager) retentionReport(now time.Time) []*RetentionReportItem {
+	policy := oem.retention.Policy()
+
+	type candidate struct {
+		id, name, status string
+		created          time.Time
+		pinned, favorite bool
+		unarchived       bool
+	}
+	var candidates []candidate
+	oem.mutex.RLock()
+	for workspaceID, workspace := range oem.workspaces {
+		candidates = append(candidates, candidate{
+			id:         workspaceID,
+			name:       workspace.DisplayName,
+			status:     workspace.Status,
+			created:    workspace.Created,
+			pinned:     workspace.IsPinned,
+			favorite:   workspace.IsFavorite,
+			unarchived: workspace.hasUnarchivedVolumes(),
+		})
+	}
+	oem.mutex.RUnlock()
+
+	var report []*RetentionReportItem
+	for _, c := range candidates {
+		last := oem.lastActivity(c.id)
+		if last.IsZero() || last.Before(c.created) {
+			last = c.created
+		}
+
+		oem.retention.mutex.Lock()
+		var stage string
+		var stageSince time.Time
+		if state, exists := oem.retention.states[c.id]; exists {
+			stage, stageSince = state.Stage, state.StageSince
+		}
+		oem.retention.mutex.Unlock()
+
+		// 阶段开始后又有活动，重新计算
+		if stage != retentionStageActive && last.After(stageSince) {
+			stage = retentionStageActive
+		}
+
+		item := &RetentionReportItem{
+			WorkspaceID:   c.id,
+			Name:          c.name,
+			Status:        c.status,
+			LastActivity:  last,
+			InactiveHours: float64(int(now.Sub(last).Hours()*10)) / 10,
+			Stage:         stage,
+			Action:        retentionActionNone,
+		}
+		report = append(report, item)
+
+		switch {
+		case c.pinned:
+			item.Exempt, item.ExemptReason = true, "pinned"
+		case c.favorite && policy.ExemptFavorites:
+			item.Exempt, item.ExemptReason = true, "favorite"
+		case retentionCreating(c.status) && now.Sub(last) < retentionStuckAfter:
+			// 创建中的工作空间等状态稳定后再处理
+			item.Exempt, item.ExemptReason = true, "status"
+		}
+		if item.Exempt {
+			continue
+		}
+
+		action, after := policy.nextStep(stage)
+		if action == retentionActionNone {
+			continue
+		}
+		if action == retentionActionDelete && c.unarchived {
+			// 删除会丢失快照中没有的卷数据，停留在归档阶段
+			item.Exempt, item.ExemptReason = true, "volumes"
+			continue
+		}
+		due := last.Add(after)
+		if stage != retentionStageActive {
+			if graceEnd := stageSince.Add(time.Duration(policy.GraceHours) * time.Hour); graceEnd.After(due) {
+				due = graceEnd
+			}
+		}
+		if now.Before(due) {
+			item.NextAction, item.NextActionAt = action, &due
+		} else {
+			item.Action = action
+		}
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].LastActivity.Before(report[j].LastActivity)
+	})
+	return report
+}
+
+// 工作空间是否处于创建过程中
+func retentionCreating(status string) bool {
+	switch status {
+	case "running", "stopped", "exited", "created", "failed":
+		return false
+	}
+	return true
+}
+
+// 是否有快照中不包含的数据：工作空间专属卷和sidecar的匿名卷
+// 共享缓存卷不随工作空间删除，sidecar的绑定挂载位于工作空间目录中
+func (w *Workspace) hasUnarchivedVolumes() bool {
+	for _, v := range w.Volumes {
+		if v.Type == volumeTypeVolume {
+			return true
+		}
+	}
+	for _, sidecar := range w.Sidecars {
+		for _, v := range sidecar.Volumes {
+			if v.Source == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 执行保留策略，dryRun为true时只返回报告
+func (oem *OnlineEditorManager) ApplyRetentionPolicy(dryRun bool) ([]*RetentionReportItem, error) {
+	oem.retention.runMutex.Lock()
+	defer oem.retention.runMutex.Unlock()
+
+	policy := oem.retention.Policy()
+	report := oem.retentionReport(time.Now())
+	if dryRun || !policy.Enabled {
+		for _, item := range report {
+			if item.Action != retentionActionNone {
+				item.Result = "dry_run"
+			}
+		}
+		return report, nil
+	}
+
+	for _, item := range report {
+		if item.Action == retentionActionNone {
+			continue
+		}
+
+		var err error
+		switch item.Action {
+		case retentionActionStop:
+			err = oem.retentionStop(item.WorkspaceID)
+		case retentionActionArchive:
+			err = oem.retentionArchive(item.WorkspaceID)
+		case retentionActionDelete:
+			err = oem.retentionDelete(item.WorkspaceID)
+		}
+
+		if err != nil {
+			item.Result, item.Error = "failed", err.Error()
+			log.Printf("[%s] 保留策略执行失败 (%s): %v", item.WorkspaceID, item.Action, err)
+			oem.publishWorkspaceError(item.WorkspaceID, fmt.Sprintf("保留策略执行失败 (%s): %v", item.Action, err))
+			continue
+		}
+		item.Result = "done"
+		log.Printf("[%s] 保留策略: %s (无活动 %.1f 小时)", item.WorkspaceID, item.Action, item.InactiveHours)
+	}
+
+	oem.expireRetentionArchives(policy)
+	return report, nil
+}
+
+func (oem *OnlineEditorManager) retentionStop(workspaceID string) error {
+	if status, err := oem.GetWorkspaceStatus(workspaceID); err == nil && status == "running" {
+		if err := oem.StopWorkspace(workspaceID); err != nil {
+			return err
+		}
+	}
+	oem.saveRetentionFlags(workspaceID)
+	oem.retention.setStage(workspaceID, retentionStageStopped, nil)
+	return nil
+}
+
+// 停止工作空间并把工作空间目录打包为快照
+func (oem *OnlineEditorManager) retentionArchive(workspaceID string) error {
+	if status, err := oem.GetWorkspaceStatus(workspaceID); err == nil && status == "running" {
+		if err := oem.StopWorkspace(workspaceID); err != nil {
+			return err
+		}
+	}
+
+	sourceDir, err := oem.workspaceRealDir(workspaceID)
+	if err != nil {
+		return err
+	}
+	archivePath := filepath.Join(oem.retention.archiveDir, fmt.Sprintf("%s_%s.tar.gz", workspaceID, time.Now().Format("20060102_150405")))
+	if err := oem.createTarGzArchive(sourceDir, archivePath, nil); err != nil {
+		os.Remove(archivePath)
+		return fmt.Errorf("创建快照失败: %v", err)
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return fmt.Errorf("获取快照信息失败: %v", err)
+	}
+
+	oem.saveRetentionFlags(workspaceID)
+	oem.retention.setStage(workspaceID, retentionStageArchived, func(state *retentionState) {
+		// 只保留最新的快照
+		if state.ArchivePath != "" && state.ArchivePath != archivePath {
+			os.Remove(state.ArchivePath)
+		}
+		now := time.Now()
+		state.ArchivePath = archivePath
+		state.ArchiveSize = info.Size()
+		state.ArchivedAt = &now
+	})
+	return nil
+}
+
+// 删除已归档的工作空间，快照保留
+func (oem *OnlineEditorManager) retentionDelete(workspaceID string) error {
+	oem.retention.mutex.Lock()
+	var archivePath string
+	if state, exists := oem.retention.states[workspaceID]; exists {
+		archivePath = state.ArchivePath
+	}
+	oem.retention.mutex.Unlock()
+
+	if archivePath == "" {
+		return fmt.Errorf("工作空间没有快照，不能删除")
+	}
+	if _, err := os.Stat(archivePath); err != nil {
+		return fmt.Errorf("快照不存在，不能删除: %v", err)
+	}
+
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	unarchived := exists && workspace.hasUnarchivedVolumes()
+	oem.mutex.RUnlock()
+	if unarchived {
+		return fmt.Errorf("工作空间有快照中不包含的卷，不能删除")
+	}
+
+	oem.saveRetentionFlags(workspaceID)
+	if err := oem.DeleteWorkspace(workspaceID); err != nil {
+		return err
+	}
+	oem.retention.setStage(workspaceID, retentionStageDeleted, func(state *retentionState) {
+		now := time.Now()
+		state.DeletedAt = &now
+	})
+	return nil
+}
+
+// 删除超过保留期的快照
+func (oem *OnlineEditorManager) expireRetentionArchives(policy RetentionPolicy) {
+	if policy.ArchiveRetentionDays <= 0 {
+		return
+	}
+	maxAge := time.Duration(policy.ArchiveRetentionDays) * 24 * time.Hour
+
+	oem.retention.mutex.Lock()
+	defer oem.retention.mutex.Unlock()
+
+	changed := false
+	for workspaceID, state := range oem.retention.states {
+		if state.DeletedAt == nil || time.Since(*state.DeletedAt) < maxAge {
+			continue
+		}
+		if state.ArchivePath != "" {
+			if err := os.Remove(state.ArchivePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("[%s] 删除过期快照失败: %v", workspaceID, err)
+				continue
+			}
+			log.Printf("[%s] 删除过期快照: %s", workspaceID, state.ArchivePath)
+		}
+		delete(oem.retention.states, workspaceID)
+		changed = true
+	}
+	if changed {
+		oem.retention.saveLocked()
+	}
+}
+
+// 用户的快照列表
+func (oem *OnlineEditorManager) retentionArchives(userID string) []retentionState {
+	oem.retention.mutex.Lock()
+	defer oem.retention.mutex.Unlock()
+
+	archives := []retentionState{}
+	for _, state := range oem.retention.states {
+		if state.ArchivePath != "" && state.Owner == userID {
+			archives = append(archives, *state)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ArchivedAt.After(*archives[j].ArchivedAt)
+	})
+	return archives
+}
+
+// HTTP处理器
+
+// GET /retention/policy
+func (oem *OnlineEditorManager) handleGetRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oem.retention.Policy())
+}
+
+// PUT /retention/policy
+func (oem *OnlineEditorManager) handleUpdateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	policy := oem.retention.Policy()
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := oem.retention.SetPolicy(policy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// GET /retention/report
+// 预演：按当前策略列出每个工作空间将要执行的操作，不做任何修改
+func (oem *OnlineEditorManager) handleGetRetentionReport(w http.ResponseWriter, r *http.Request) {
+	report, _ := oem.ApplyRetentionPolicy(true)
+	writeRetentionReport(w, oem.retention.Policy(), report, true)
+}
+
+// POST /retention/run?dry_run=true
+func (oem *OnlineEditorManager) handleRunRetention(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	report, err := oem.ApplyRetentionPolicy(dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeRetentionReport(w, oem.retention.Policy(), report, dryRun)
+}
+
+func writeRetentionReport(w http.ResponseWriter, policy RetentionPolicy, report []*RetentionReportItem, dryRun bool) {
+	summary := map[string]int{}
+	for _, item := range report {
+		summary[item.Action]++
+	}
+	if report == nil {
+		report = []*RetentionReportItem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dry_run":    dryRun || !policy.Enabled,
+		"policy":     policy,
+		"summary":    summary,
+		"workspaces": report,
+	})
+}
+
+// GET /retention/archives
+func (oem *OnlineEditorManager) handleListRetentionArchives(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(oem.retentionArchives(userID))
+}
+
+// GET /retention/archives/{id}/file
+func (oem *OnlineEditorManager) handleDownloadRetentionArchive(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	oem.mutex.RLock()
+	_, exists := oem.workspaces[workspaceID]
+	oem.mutex.RUnlock()
+	if exists {
+		if !oem.requireWorkspaceOwner(w, r, workspaceID) {
+			return
+		}
+	}
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	oem.retention.mutex.Lock()
+	var archivePath, owner string
+	if state, exists := oem.retention.states[workspaceID]; exists {
+		archivePath, owner = state.ArchivePath, state.Owner
+	}
+	oem.retention.mutex.Unlock()
+
+	// 工作空间已删除时按保存的所有者判断，其他用户的快照同样返回不存在
+	if archivePath == "" || (!exists && owner != userID) {
+		http.Error(w, "快照不存在", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(archivePath)))
+	http.ServeFile(w, r, archivePath)
+}
+
+// POST /workspaces/{id}/pin
+// 切换固定状态，固定的工作空间不会被保留策略停止、归档或删除
+func (oem *OnlineEditorManager) handleTogglePin(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	oem.mutex.Lock()
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		oem.mutex.Unlock()
+		http.Error(w, "工作空间不存在", http.StatusNotFound)
+		return
+	}
+	workspace.IsPinned = !workspace.IsPinned
+	pinned := workspace.IsPinned
+	oem.mutex.Unlock()
+
+	oem.saveRetentionFlags(workspaceID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":        workspaceID,
+		"is_pinned": pinned,
+		"message":   fmt.Sprintf("工作空间已%s", map[bool]string{true: "固定", false: "取消固定"}[pinned]),
+	})
+}
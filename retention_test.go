@@ -0,0 +1,177 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionNextStep(t *testing.T) {
+	policy := defaultRetentionPolicy()
+	noStop := policy
+	noStop.StopAfterHours = 0
+	noDelete := policy
+	noDelete.DeleteAfterHours = 0
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		stage  string
+		action string
+		after  time.Duration
+	}{
+		{"活动中", policy, retentionStageActive, retentionActionStop, 24 * time.Hour},
+		{"已停止", policy, retentionStageStopped, retentionActionArchive, 7 * 24 * time.Hour},
+		{"已归档", policy, retentionStageArchived, retentionActionDelete, 30 * 24 * time.Hour},
+		{"已删除", policy, retentionStageDeleted, retentionActionNone, 0},
+		{"未知阶段", policy, "unknown", retentionActionNone, 0},
+		// 跳过未启用的阶段
+		{"不停止", noStop, retentionStageActive, retentionActionArchive, 7 * 24 * time.Hour},
+		{"不删除", noDelete, retentionStageArchived, retentionActionNone, 0},
+		{"全部关闭", RetentionPolicy{}, retentionStageActive, retentionActionNone, 0},
+	}
+	for _, tt := range tests {
+		action, after := tt.policy.nextStep(tt.stage)
+		if action != tt.action || after != tt.after {
+			t.Errorf("%s: nextStep(%q) = %s, %v, 期望 %s, %v", tt.name, tt.stage, action, after, tt.action, tt.after)
+		}
+	}
+}
+
+func TestRetentionReport(t *testing.T) {
+	now := time.Now()
+	hours := func(h int) time.Time { return now.Add(-time.Duration(h) * time.Hour) }
+
+	type setup struct {
+		status           string
+		created          time.Time
+		activity         time.Time // 为零表示没有活动记录
+		stage            string
+		stageSince       time.Time
+		pinned, favorite bool
+		volumes          []VolumeMount
+	}
+	type expect struct {
+		stage        string
+		action       string
+		nextAction   string
+		nextActionAt time.Time
+		exempt       string
+	}
+	tests := map[string]struct {
+		setup  setup
+		expect expect
+	}{
+		"活动不久": {
+			setup{status: "running", activity: hours(1)},
+			expect{action: retentionActionNone, nextAction: retentionActionStop, nextActionAt: hours(1).Add(24 * time.Hour)},
+		},
+		"到期停止": {
+			setup{status: "running", activity: hours(30)},
+			expect{action: retentionActionStop},
+		},
+		// 归档时间已到，但距停止还不到grace_hours
+		"宽限期": {
+			setup{status: "stopped", activity: hours(200), stage: retentionStageStopped, stageSince: hours(2)},
+			expect{stage: retentionStageStopped, action: retentionActionNone, nextAction: retentionActionArchive, nextActionAt: hours(2).Add(24 * time.Hour)},
+		},
+		"到期归档": {
+			setup{status: "stopped", activity: hours(200), stage: retentionStageStopped, stageSince: hours(30)},
+			expect{stage: retentionStageStopped, action: retentionActionArchive},
+		},
+		// 归档后又有活动，从头计算
+		"活动重置": {
+			setup{status: "running", activity: hours(5), stage: retentionStageArchived, stageSince: hours(10)},
+			expect{action: retentionActionNone, nextAction: retentionActionStop, nextActionAt: hours(5).Add(24 * time.Hour)},
+		},
+		"到期删除": {
+			setup{status: "stopped", activity: hours(800), stage: retentionStageArchived, stageSince: hours(48)},
+			expect{stage: retentionStageArchived, action: retentionActionDelete},
+		},
+		"有命名卷": {
+			setup{status: "stopped", activity: hours(800), stage: retentionStageArchived, stageSince: hours(48),
+				volumes: []VolumeMount{{Name: "data", Type: volumeTypeVolume}}},
+			expect{stage: retentionStageArchived, action: retentionActionNone, exempt: "volumes"},
+		},
+		// 共享缓存卷不随工作空间删除
+		"只有缓存卷": {
+			setup{status: "stopped", activity: hours(800), stage: retentionStageArchived, stageSince: hours(48),
+				volumes: []VolumeMount{{Name: "npm", Type: volumeTypeCache}}},
+			expect{stage: retentionStageArchived, action: retentionActionDelete},
+		},
+		"固定": {
+			setup{status: "running", activity: hours(800), pinned: true},
+			expect{action: retentionActionNone, exempt: "pinned"},
+		},
+		"收藏": {
+			setup{status: "running", activity: hours(800), favorite: true},
+			expect{action: retentionActionNone, exempt: "favorite"},
+		},
+		"创建中": {
+			setup{status: "creating", created: now.Add(-10 * time.Minute)},
+			expect{action: retentionActionNone, exempt: "status"},
+		},
+		// 创建中超过retentionStuckAfter按失败处理，没有活动时按创建时间计算
+		"创建卡住": {
+			setup{status: "creating", created: hours(30)},
+			expect{action: retentionActionStop},
+		},
+	}
+
+	oem := &OnlineEditorManager{
+		workspaces: map[string]*Workspace{},
+		activity:   &activityTracker{last: map[string]time.Time{}},
+		retention:  &RetentionManager{policy: defaultRetentionPolicy(), states: map[string]*retentionState{}},
+	}
+	for name, tt := range tests {
+		created := tt.setup.created
+		if created.IsZero() {
+			created = hours(1000)
+		}
+		oem.workspaces[name] = &Workspace{
+			ID:         name,
+			Status:     tt.setup.status,
+			Created:    created,
+			IsPinned:   tt.setup.pinned,
+			IsFavorite: tt.setup.favorite,
+			Volumes:    tt.setup.volumes,
+		}
+		if !tt.setup.activity.IsZero() {
+			oem.activity.last[name] = tt.setup.activity
+		}
+		if tt.setup.stage != "" {
+			oem.retention.states[name] = &retentionState{WorkspaceID: name, Stage: tt.setup.stage, StageSince: tt.setup.stageSince}
+		}
+	}
+
+	items := map[string]*RetentionReportItem{}
+	for _, item := range oem.retentionReport(now) {
+		items[item.WorkspaceID] = item
+	}
+	for name, tt := range tests {
+		item := items[name]
+		if item == nil {
+			t.Errorf("%s: 报告中没有该工作空间", name)
+			continue
+		}
+		var nextActionAt time.Time
+		if item.NextActionAt != nil {
+			nextActionAt = *item.NextActionAt
+		}
+		got := expect{item.Stage, item.Action, item.NextAction, nextActionAt, item.ExemptReason}
+		if got.stage != tt.expect.stage || got.action != tt.expect.action || got.nextAction != tt.expect.nextAction ||
+			!got.nextActionAt.Equal(tt.expect.nextActionAt) || got.exempt != tt.expect.exempt {
+			t.Errorf("%s: 结果 = %+v, 期望 %+v", name, got, tt.expect)
+		}
+		if item.Exempt != (tt.expect.exempt != "") {
+			t.Errorf("%s: Exempt = %v, 期望原因 %q", name, item.Exempt, tt.expect.exempt)
+		}
+	}
+
+	// 不豁免收藏时收藏的工作空间同样会被停止
+	oem.retention.policy.ExemptFavorites = false
+	for _, item := range oem.retentionReport(now) {
+		if item.WorkspaceID == "收藏" && (item.Exempt || item.Action != retentionActionStop) {
+			t.Errorf("不豁免收藏: 结果 = %+v", item)
+		}
+	}
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// Sidecar服务
+//
+// 工作空间可以声明数据库、缓存等辅助服务，每个服务一个容器，连接到工作空间的独立网络，
+// 以服务名（和额外的别名）作为DNS名称，开发容器中可以直接访问postgres:5432等地址。
+// 服务随工作空间一起启动、停止和删除。服务定义保存在容器标签中，重启后据此恢复。
+// 同一共享网络中的DNS别名会在工作空间之间冲突，因此sidecar要求使用workspace网络模式。
+
+const (
+	sidecarOfLabel     = "online-editor.sidecar-of"
+	sidecarSpecLabel   = "online-editor.sidecar"
+	sidecarNamePrefix  = "sidecar-"
+	sidecarStopTimeout = 10 // 秒
+)
+
+var sidecarNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Sidecar服务的卷
+type SidecarVolume struct {
+	Source   string `json:"source,omitempty"` // 工作空间内的相对路径，为空时使用匿名卷
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// 健康检查，时间单位为秒
+type SidecarHealthCheck struct {
+	Test        []string `json:"test"` // 如["CMD", "pg_isready"]，单个字符串按CMD-SHELL执行
+	Interval    int      `json:"interval,omitempty"`
+	Timeout     int      `json:"timeout,omitempty"`
+	Retries     int      `json:"retries,omitempty"`
+	StartPeriod int      `json:"start_period,omitempty"`
+}
+
+// Sidecar服务
+type SidecarService struct {
+	Name        string              `json:"name"`
+	Image       string              `json:"image"`
+	Environment map[string]string   `json:"environment,omitempty"`
+	Command     []string            `json:"command,omitempty"`
+	Volumes     []SidecarVolume     `json:"volumes,omitempty"`
+	HealthCheck *SidecarHealthCheck `json:"health_check,omitempty"`
+	Aliases     []string            `json:"aliases,omitempty"` // 服务名之外的DNS别名
+
+	// 运行状态
+	ContainerID string `json:"container_id,omitempty"`
+	Status      string `json:"status,omitempty"` // "pending", "created", "running", "exited", "failed"
+	Health      string `json:"health,omitempty"` // "starting", "healthy", "unhealthy"
+	IP          string `json:"ip,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// 不含运行状态的服务定义
+func (s SidecarService) spec() SidecarService {
+	return SidecarService{
+		Name:        s.Name,
+		Image:       s.Image,
+		Environment: s.Environment,
+		Command:     s.Command,
+		Volumes:     s.Volumes,
+		HealthCheck: s.HealthCheck,
+		Aliases:     s.Aliases,
+	}
+}
+
+func sidecarContainerName(workspaceID, name string) string {
+	return sidecarNamePrefix + workspaceID + "-" + name
+}
+
+// 校验服务定义，existing为工作空间已有的服务
+func validateSidecars(sidecars []SidecarService, existing []SidecarService) error {
+	names := make(map[string]bool)
+	for _, sidecar := range existing {
+		names[sidecar.Name] = true
+	}
+
+	for i := range sidecars {
+		sidecar := &sidecars[i]
+		if !sidecarNamePattern.MatchString(sidecar.Name) {
+			return fmt.Errorf("无效的服务名称: %q", sidecar.Name)
+		}
+		if names[sidecar.Name] {
+			return fmt.Errorf("服务名称重复: %s", sidecar.Name)
+		}
+		names[sidecar.Name] = true
+
+		if strings.TrimSpace(sidecar.Image) == "" {
+			return fmt.Errorf("服务 %s 缺少镜像", sidecar.Name)
+		}
+		for _, alias := range sidecar.Aliases {
+			if !sidecarNamePattern.MatchString(alias) {
+				return fmt.Errorf("服务 %s 的别名无效: %q", sidecar.Name, alias)
+			}
+		}
+		for _, volume := range sidecar.Volumes {
+			if !path.IsAbs(volume.Target) {
+				return fmt.Errorf("服务 %s 的挂载点必须是绝对路径: %s", sidecar.Name, volume.Target)
+			}
+		}
+		if check := sidecar.HealthCheck; check != nil {
+			if len(check.Test) == 0 {
+				return fmt.Errorf("服务 %s 的健康检查缺少命令", sidecar.Name)
+			}
+			switch check.Test[0] {
+			case "CMD", "CMD-SHELL", "NONE":
+			default:
+				if len(check.Test) == 1 {
+					check.Test = []string{"CMD-SHELL", check.Test[0]}
+				} else {
+					check.Test = append([]string{"CMD"}, check.Test...)
+				}
+			}
+		}
+		sidecar.Status = "pending"
+	}
+	return nil
+}
+
+// 创建sidecar容器
+func (oem *OnlineEditorManager) createSidecarContainer(ctx context.Context, workspaceID, networkName string, sidecar SidecarService) (string, error) {
+	if _, _, err := oem.dockerClient.ImageInspectWithRaw(ctx, sidecar.Image); err != nil {
+		log.Printf("[%s] 拉取sidecar镜像: %s", workspaceID, sidecar.Image)
+		if _, err := oem.pullImageWithFallback(ctx, sidecar.Image); err != nil {
+			return "", fmt.Errorf("拉取镜像失败: %v", err)
+		}
+	}
+
+	var mounts []mount.Mount
+	for _, volume := range sidecar.Volumes {
+		if volume.Source == "" {
+			mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Target: volume.Target, ReadOnly: volume.ReadOnly})
+			continue
+		}
+		hostPath, err := oem.resolveWorkspacePath(workspaceID, volume.Source)
+		if err != nil {
+			return "", fmt.Errorf("无效的卷路径 %s: %v", volume.Source, err)
+		}
+		if err := os.MkdirAll(hostPath, 0755); err != nil {
+			return "", fmt.Errorf("创建卷目录失败: %v", err)
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   hostPath,
+			Target:   volume.Target,
+			ReadOnly: volume.ReadOnly,
+		})
+	}
+
+	var envs []string
+	for k, v := range sidecar.Environment {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	spec, _ := json.Marshal(sidecar.spec())
+	containerConfig := &container.Config{
+		Image: sidecar.Image,
+		Env:   envs,
+		Cmd:   sidecar.Command,
+		Labels: map[string]string{
+			sidecarOfLabel:   workspaceID,
+			sidecarSpecLabel: string(spec),
+		},
+	}
+	if check := sidecar.HealthCheck; check != nil {
+		containerConfig.Healthcheck = &container.HealthConfig{
+			Test:        check.Test,
+			Interval:    time.Duration(check.Interval) * time.Second,
+			Timeout:     time.Duration(check.Timeout) * time.Second,
+			Retries:     check.Retries,
+			StartPeriod: time.Duration(check.StartPeriod) * time.Second,
+		}
+	}
+
+	hostConfig := &container.HostConfig{
+		Mounts: mounts,
+		Resources: container.Resources{
+			Memory:    512 * 1024 * 1024,
+			CPUShares: 512,
+		},
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+	}
+
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {Aliases: append([]string{sidecar.Name}, sidecar.Aliases...)},
+		},
+	}
+
+	resp, err := oem.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, sidecarContainerName(workspaceID, sidecar.Name))
+	if err != nil {
+		return "", fmt.Errorf("创建容器失败: %v", err)
+	}
+	return resp.ID, nil
+}
+
+// 更新sidecar的运行状态
+func (oem *OnlineEditorManager) setSidecarState(workspaceID, name string, update func(sidecar *SidecarService)) {
+	oem.mutex.Lock()
+	defer oem.mutex.Unlock()
+
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		return
+	}
+	for i := range workspace.Sidecars {
+		if workspace.Sidecars[i].Name == name {
+			update(&workspace.Sidecars[i])
+			return
+		}
+	}
+}
+
+// 启动工作空间的sidecar，没有容器的先创建。单个服务失败不影响其他服务和工作空间
+func (oem *OnlineEditorManager) startSidecars(workspaceID string) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var sidecars []SidecarService
+	var networkName string
+	if exists {
+		sidecars = append(sidecars, workspace.Sidecars...)
+		networkName = workspace.NetworkName
+	}
+	oem.mutex.RUnlock()
+
+	for _, sidecar := range sidecars {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		err := oem.startSidecar(ctx, workspaceID, networkName, sidecar)
+		cancel()
+
+		if err != nil {
+			log.Printf("[%s] 启动sidecar %s 失败: %v", workspaceID, sidecar.Name, err)
+			oem.setSidecarState(workspaceID, sidecar.Name, func(s *SidecarService) {
+				s.Status = "failed"
+				s.Error = err.Error()
+			})
+			oem.publishWorkspaceError(workspaceID, fmt.Sprintf("启动sidecar %s 失败: %v", sidecar.Name, err))
+			continue
+		}
+		log.Printf("[%s] sidecar %s 已启动", workspaceID, sidecar.Name)
+	}
+	oem.refreshSidecars(workspaceID)
+}
+
+func (oem *OnlineEditorManager) startSidecar(ctx context.Context, workspaceID, networkName string, sidecar SidecarService) error {
+	containerID := sidecar.ContainerID
+	if containerID != "" {
+		if _, err := oem.dockerClient.ContainerInspect(ctx, containerID); err != nil {
+			containerID = ""
+		}
+	}
+	if containerID == "" {
+		var err error
+		if containerID, err = oem.createSidecarContainer(ctx, workspaceID, networkName, sidecar); err != nil {
+			return err
+		}
+		oem.setSidecarState(workspaceID, sidecar.Name, func(s *SidecarService) {
+			s.ContainerID = containerID
+			s.Status = "created"
+			s.Error = ""
+		})
+	}
+
+	if err := oem.dockerClient.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("启动容器失败: %v", err)
+	}
+	return nil
+}
+
+// 停止工作空间的sidecar
+func (oem *OnlineEditorManager) stopSidecars(workspaceID string) {
+	for _, sidecar := range oem.workspaceSidecars(workspaceID) {
+		if sidecar.ContainerID == "" {
+			continue
+		}
+		timeout := sidecarStopTimeout
+		if err := oem.dockerClient.ContainerStop(context.Background(), sidecar.ContainerID, container.StopOptions{Timeout: &timeout}); err != nil {
+			log.Printf("[%s] 停止sidecar %s 失败: %v", workspaceID, sidecar.Name, err)
+		}
+	}
+	oem.refreshSidecars(workspaceID)
+}
+
+// 删除sidecar容器（匿名卷一起删除）
+func (oem *OnlineEditorManager) removeSidecarContainers(workspaceID string, sidecars []SidecarService) {
+	for _, sidecar := range sidecars {
+		if sidecar.ContainerID == "" {
+			continue
+		}
+		if err := oem.dockerClient.ContainerRemove(context.Background(), sidecar.ContainerID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			log.Printf("[%s] 删除sidecar %s 失败: %v", workspaceID, sidecar.Name, err)
+		}
+	}
+}
+
+func (oem *OnlineEditorManager) workspaceSidecars(workspaceID string) []SidecarService {
+	oem.mutex.RLock()
+	defer oem.mutex.RUnlock()
+
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		return nil
+	}
+	return append([]SidecarService(nil), workspace.Sidecars...)
+}
+
+// 从Docker刷新sidecar的状态、健康状态和IP
+func (oem *OnlineEditorManager) refreshSidecars(workspaceID string) {
+	for _, sidecar := range oem.workspaceSidecars(workspaceID) {
+		if sidecar.ContainerID == "" {
+			continue
+		}
+
+		info, err := oem.dockerClient.ContainerInspect(context.Background(), sidecar.ContainerID)
+		if err != nil {
+			oem.setSidecarState(workspaceID, sidecar.Name, func(s *SidecarService) {
+				s.Status = "failed"
+				s.Health = ""
+				s.Error = fmt.Sprintf("获取容器状态失败: %v", err)
+			})
+			continue
+		}
+
+		oem.setSidecarState(workspaceID, sidecar.Name, func(s *SidecarService) {
+			s.Status = info.State.Status
+			s.Health = ""
+			if info.State.Health != nil {
+				s.Health = info.State.Health.Status
+			}
+			s.IP = ""
+			if info.NetworkSettings != nil {
+				for _, endpoint := range info.NetworkSettings.Networks {
+					if endpoint != nil && endpoint.IPAddress != "" {
+						s.IP = endpoint.IPAddress
+						break
+					}
+				}
+			}
+			if s.Status == "running" {
+				s.Error = ""
+			}
+		})
+	}
+}
+
+// 恢复工作空间后，根据容器标签重建sidecar列表（调用者持有锁或处于启动阶段）
+func (oem *OnlineEditorManager) restoreSidecars(containers []container.Summary) {
+	for _, cont := range containers {
+		workspaceID := cont.Labels[sidecarOfLabel]
+		workspace, exists := oem.workspaces[workspaceID]
+		if workspaceID == "" || !exists {
+			continue
+		}
+
+		var sidecar SidecarService
+		if err := json.Unmarshal([]byte(cont.Labels[sidecarSpecLabel]), &sidecar); err != nil {
+			log.Printf("[%s] 读取sidecar定义失败 %s: %v", workspaceID, cont.ID, err)
+			continue
+		}
+		sidecar.ContainerID = cont.ID
+		sidecar.Status = cont.State
+		workspace.Sidecars = append(workspace.Sidecars, sidecar)
+		log.Printf("[%s] 恢复sidecar: %s (状态: %s)", workspaceID, sidecar.Name, cont.State)
+	}
+}
+
+// 为已有工作空间添加sidecar，工作空间运行中时立即启动
+func (oem *OnlineEditorManager) AddSidecars(workspaceID string, sidecars []SidecarService) error {
+	oem.mutex.Lock()
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		oem.mutex.Unlock()
+		return fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	if workspace.Network.Mode != networkModeWorkspace {
+		oem.mutex.Unlock()
+		return fmt.Errorf("sidecar服务需要使用独立网络（workspace模式）")
+	}
+	if err := validateSidecars(sidecars, workspace.Sidecars); err != nil {
+		oem.mutex.Unlock()
+		return err
+	}
+	workspace.Sidecars = append(workspace.Sidecars, sidecars...)
+	running := workspace.Status == "running"
+	oem.mutex.Unlock()
+
+	if running {
+		go oem.startSidecars(workspaceID)
+	}
+	return nil
+}
+
+// 删除sidecar
+func (oem *OnlineEditorManager) RemoveSidecar(workspaceID, name string) error {
+	oem.mutex.Lock()
+	workspace, exists := oem.workspaces[workspaceID]
+	if !exists {
+		oem.mutex.Unlock()
+		return fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	var removed []SidecarService
+	for i, sidecar := range workspace.Sidecars {
+		if sidecar.Name == name {
+			removed = append(removed, sidecar)
+			workspace.Sidecars = append(workspace.Sidecars[:i:i], workspace.Sidecars[i+1:]...)
+			break
+		}
+	}
+	oem.mutex.Unlock()
+
+	if len(removed) == 0 {
+		return fmt.Errorf("服务不存在: %s", name)
+	}
+	oem.removeSidecarContainers(workspaceID, removed)
+	return nil
+}
+
+// docker-compose.yml导入
+
+// 字符串或字符串列表
+type composeStrings struct {
+	Values []string
+	Scalar bool // 以字符串形式给出
+}
+
+func (c *composeStrings) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		c.Values, c.Scalar = []string{value.Value}, true
+		return nil
+	}
+	return value.Decode(&c.Values)
+}
+
+// 按shell规则拆分命令行，支持单引号、双引号和反斜杠转义
+func splitCommandLine(line string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// 映射或"KEY=VALUE"列表
+type composeEnvironment map[string]string
+
+func (c *composeEnvironment) UnmarshalYAML(value *yaml.Node) error {
+	result := make(map[string]string)
+	if value.Kind == yaml.SequenceNode {
+		var list []string
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+		for _, item := range list {
+			key, val, _ := strings.Cut(item, "=")
+			result[key] = val
+		}
+	} else {
+		var values map[string]interface{}
+		if err := value.Decode(&values); err != nil {
+			return err
+		}
+		for key, val := range values {
+			if val == nil {
+				result[key] = ""
+			} else {
+				result[key] = fmt.Sprint(val)
+			}
+		}
+	}
+	*c = result
+	return nil
+}
+
+// 短格式"source:target[:ro]"或长格式
+type composeVolume struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+func (c *composeVolume) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		parts := strings.Split(value.Value, ":")
+		switch len(parts) {
+		case 1:
+			c.Target = parts[0]
+		default:
+			c.Source, c.Target = parts[0], parts[1]
+			c.ReadOnly = len(parts) > 2 && strings.Contains(parts[2], "ro")
+		}
+		return nil
+	}
+	var long struct {
+		Source   string `yaml:"source"`
+		Target   string `yaml:"target"`
+		ReadOnly bool   `yaml:"read_only"`
+	}
+	if err := value.Decode(&long); err != nil {
+		return err
+	}
+	c.Source, c.Target, c.ReadOnly = long.Source, long.Target, long.ReadOnly
+	return nil
+}
+
+type composeService struct {
+	Image       string             `yaml:"image"`
+	Build       interface{}        `yaml:"build"`
+	Environment composeEnvironment `yaml:"environment"`
+	Command     composeStrings     `yaml:"command"`
+	Volumes     []composeVolume    `yaml:"volumes"`
+	Healthcheck *struct {
+		Test        composeStrings `yaml:"test"`
+		Interval    string         `yaml:"interval"`
+		Timeout     string         `yaml:"timeout"`
+		Retries     int            `yaml:"retries"`
+		StartPeriod string         `yaml:"start_period"`
+		Disable     bool           `yaml:"disable"`
+	} `yaml:"healthcheck"`
+}
+
+func composeSeconds(value string) int {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return int(duration.Seconds())
+}
+
+// 解析docker-compose.yml中的服务，返回可导入的sidecar和被跳过的原因。
+// composeDir为compose文件在工作空间中的目录，相对路径的卷以它为基准
+func parseComposeSidecars(data []byte, composeDir string, only []string) ([]SidecarService, []string, error) {
+	var file struct {
+		Services map[string]composeService `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("解析compose文件失败: %v", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range only {
+		wanted[name] = true
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sidecars []SidecarService
+	var skipped []string
+	for _, name := range names {
+		service := file.Services[name]
+		if len(wanted) > 0 && !wanted[name] {
+			continue
+		}
+		if service.Image == "" {
+			skipped = append(skipped, fmt.Sprintf("%s: 只支持使用镜像的服务，不支持build", name))
+			continue
+		}
+
+		sidecar := SidecarService{
+			Name:        strings.ToLower(name),
+			Image:       service.Image,
+			Environment: service.Environment,
+			Command:     service.Command.Values,
+		}
+		if service.Command.Scalar {
+			sidecar.Command = splitCommandLine(service.Command.Values[0])
+		}
+		for _, volume := range service.Volumes {
+			switch {
+			case volume.Source == "":
+				sidecar.Volumes = append(sidecar.Volumes, SidecarVolume{Target: volume.Target, ReadOnly: volume.ReadOnly})
+			case strings.HasPrefix(volume.Source, "./") || strings.HasPrefix(volume.Source, "../") || volume.Source == "." || volume.Source == "..":
+				// 相对路径相对于compose文件所在的目录，转换为相对工作空间根目录的路径
+				source := path.Join(composeDir, volume.Source)
+				if source == ".." || strings.HasPrefix(source, "../") {
+					skipped = append(skipped, fmt.Sprintf("%s: 卷路径 %s 超出工作空间", name, volume.Source))
+					continue
+				}
+				sidecar.Volumes = append(sidecar.Volumes, SidecarVolume{Source: source, Target: volume.Target, ReadOnly: volume.ReadOnly})
+			case strings.HasPrefix(volume.Source, "/") || strings.HasPrefix(volume.Source, "~"):
+				skipped = append(skipped, fmt.Sprintf("%s: 不支持挂载宿主机路径 %s", name, volume.Source))
+			default:
+				// 命名卷按匿名卷处理，随sidecar容器一起删除
+				sidecar.Volumes = append(sidecar.Volumes, SidecarVolume{Target: volume.Target, ReadOnly: volume.ReadOnly})
+			}
+		}
+		if check := service.Healthcheck; check != nil && !check.Disable && len(check.Test.Values) > 0 {
+			test := check.Test.Values
+			if check.Test.Scalar {
+				test = []string{"CMD-SHELL", test[0]}
+			}
+			sidecar.HealthCheck = &SidecarHealthCheck{
+				Test:        test,
+				Interval:    composeSeconds(check.Interval),
+				Timeout:     composeSeconds(check.Timeout),
+				Retries:     check.Retries,
+				StartPeriod: composeSeconds(check.StartPeriod),
+			}
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+	return sidecars, skipped, nil
+}
+
+// HTTP处理器
+
+// GET /workspaces/{id}/sidecars
+func (oem *OnlineEditorManager) handleListSidecars(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+	if _, err := oem.GetWorkspaceStatus(workspaceID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	oem.refreshSidecars(workspaceID)
+	sidecars := oem.workspaceSidecars(workspaceID)
+	if sidecars == nil {
+		sidecars = []SidecarService{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sidecars)
+}
+
+// POST /workspaces/{id}/sidecars
+func (oem *OnlineEditorManager) handleAddSidecar(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var sidecar SidecarService
+	if err := json.NewDecoder(r.Body).Decode(&sidecar); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := oem.AddSidecars(workspaceID, []SidecarService{sidecar.spec()}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "added", "name": sidecar.Name})
+}
+
+// DELETE /workspaces/{id}/sidecars/{name}
+func (oem *OnlineEditorManager) handleRemoveSidecar(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := oem.RemoveSidecar(vars["id"], vars["name"]); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+}
+
+// POST /workspaces/{id}/sidecars/import
+// 从工作空间中的docker-compose.yml导入服务，services为空时导入全部使用镜像的服务
+func (oem *OnlineEditorManager) handleImportComposeSidecars(w http.ResponseWriter, r *http.Request) {
+	workspaceID := mux.Vars(r)["id"]
+
+	var req struct {
+		Path     string   `json:"path"`
+		Services []string `json:"services"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		req.Path = "docker-compose.yml"
+	}
+
+	hostPath, err := oem.resolveWorkspacePath(workspaceID, req.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("读取compose文件失败: %v", err), http.StatusNotFound)
+		return
+	}
+
+	composeDir := path.Dir(strings.TrimPrefix(path.Clean("/"+req.Path), "/"))
+	sidecars, skipped, err := parseComposeSidecars(data, composeDir, req.Services)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(sidecars) == 0 {
+		http.Error(w, "compose文件中没有可导入的服务", http.StatusBadRequest)
+		return
+	}
+	if err := oem.AddSidecars(workspaceID, sidecars); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	names := make([]string, 0, len(sidecars))
+	for _, sidecar := range sidecars {
+		names = append(names, sidecar.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": names,
+		"skipped":  skipped,
+	})
+}
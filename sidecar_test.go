@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"redis-server --appendonly yes", []string{"redis-server", "--appendonly", "yes"}},
+		{"postgres -c 'log_statement=all' -c \"max_connections=200\"", []string{"postgres", "-c", "log_statement=all", "-c", "max_connections=200"}},
+		{`sh -c "echo \"hi there\" > /tmp/out"`, []string{"sh", "-c", `echo "hi there" > /tmp/out`}},
+		{`a\ b c`, []string{"a b", "c"}},
+		// 单引号中反斜杠没有特殊含义
+		{`'C:\path' x`, []string{`C:\path`, "x"}},
+		{`'it'"'"'s'`, []string{"it's"}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{"  spaces \t tabs\nnewlines  ", []string{"spaces", "tabs", "newlines"}},
+		{"", nil},
+		{"   ", nil},
+	}
+	for _, tt := range tests {
+		got := splitCommandLine(tt.line)
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("splitCommandLine(%q) = %q, 期望 %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+const composeFixture = `
+services:
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_PASSWORD: secret
+      PGPORT: 5432
+      EMPTY:
+    command: postgres -c 'log_statement=all'
+    volumes:
+      - pgdata:/var/lib/postgresql/data
+      - ./init:/docker-entrypoint-initdb.d:ro
+      - /var/run/docker.sock:/var/run/docker.sock
+      - ~/.ssh:/root/.ssh
+      - ../../outside:/outside
+      - /tmp/anonymous
+    healthcheck:
+      test: pg_isready -U postgres
+      interval: 5s
+      timeout: 3s
+      retries: 5
+      start_period: 1m
+  cache:
+    image: redis:7
+    environment:
+      - REDIS_ARGS=--save 60 1
+      - NO_VALUE
+    command: ["redis-server", "--appendonly", "yes"]
+    volumes:
+      - type: bind
+        source: ../cache
+        target: /data
+        read_only: true
+      - type: volume
+        source: cachedata
+        target: /cache
+    healthcheck:
+      test: ["CMD", "redis-cli", "ping"]
+  web:
+    build: .
+  Worker:
+    image: busybox
+    healthcheck:
+      disable: true
+      test: ["CMD", "true"]
+`
+
+func TestParseComposeSidecars(t *testing.T) {
+	// compose文件在工作空间的docker/目录下
+	sidecars, skipped, err := parseComposeSidecars([]byte(composeFixture), "docker", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SidecarService{
+		{
+			Name:  "worker",
+			Image: "busybox",
+		},
+		{
+			Name:        "cache",
+			Image:       "redis:7",
+			Environment: map[string]string{"REDIS_ARGS": "--save 60 1", "NO_VALUE": ""},
+			Command:     []string{"redis-server", "--appendonly", "yes"},
+			Volumes: []SidecarVolume{
+				{Source: "cache", Target: "/data", ReadOnly: true},
+				{Target: "/cache"},
+			},
+			HealthCheck: &SidecarHealthCheck{Test: []string{"CMD", "redis-cli", "ping"}},
+		},
+		{
+			Name:        "db",
+			Image:       "postgres:16",
+			Environment: map[string]string{"POSTGRES_PASSWORD": "secret", "PGPORT": "5432", "EMPTY": ""},
+			Command:     []string{"postgres", "-c", "log_statement=all"},
+			Volumes: []SidecarVolume{
+				{Target: "/var/lib/postgresql/data"},
+				{Source: "docker/init", Target: "/docker-entrypoint-initdb.d", ReadOnly: true},
+				{Target: "/tmp/anonymous"},
+			},
+			HealthCheck: &SidecarHealthCheck{
+				Test:        []string{"CMD-SHELL", "pg_isready -U postgres"},
+				Interval:    5,
+				Timeout:     3,
+				Retries:     5,
+				StartPeriod: 60,
+			},
+		},
+	}
+	if len(sidecars) != len(want) {
+		t.Fatalf("服务数量 = %d, 期望 %d: %+v", len(sidecars), len(want), sidecars)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(sidecars[i], want[i]) {
+			t.Errorf("服务 %d = %+v, 期望 %+v", i, sidecars[i], want[i])
+		}
+	}
+
+	wantSkipped := []string{
+		"db: 不支持挂载宿主机路径 /var/run/docker.sock",
+		"db: 不支持挂载宿主机路径 ~/.ssh",
+		"db: 卷路径 ../../outside 超出工作空间",
+		"web: 只支持使用镜像的服务，不支持build",
+	}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Fatalf("跳过的项 = %q, 期望 %q", skipped, wantSkipped)
+	}
+}
+
+func TestParseComposeSidecarsRelativeVolumes(t *testing.T) {
+	compose := []byte(`
+services:
+  db:
+    image: postgres
+    volumes:
+      - .:/src
+      - ./data:/data
+      - ../data:/up
+      - type: bind
+        source: ..
+        target: /parent
+`)
+	tests := []struct {
+		composeDir string
+		volumes    []SidecarVolume
+		skipped    int
+	}{
+		// 工作空间根目录下的compose文件不能引用上级目录
+		{".", []SidecarVolume{{Source: ".", Target: "/src"}, {Source: "data", Target: "/data"}}, 2},
+		{"a/b", []SidecarVolume{
+			{Source: "a/b", Target: "/src"},
+			{Source: "a/b/data", Target: "/data"},
+			{Source: "a/data", Target: "/up"},
+			{Source: "a", Target: "/parent"},
+		}, 0},
+	}
+	for _, tt := range tests {
+		sidecars, skipped, err := parseComposeSidecars(compose, tt.composeDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sidecars) != 1 || !reflect.DeepEqual(sidecars[0].Volumes, tt.volumes) {
+			t.Errorf("目录 %s: 卷 = %+v, 期望 %+v", tt.composeDir, sidecars, tt.volumes)
+		}
+		if len(skipped) != tt.skipped {
+			t.Errorf("目录 %s: 跳过 %q, 期望 %d 项", tt.composeDir, skipped, tt.skipped)
+		}
+	}
+}
+
+func TestParseComposeSidecarsOnly(t *testing.T) {
+	sidecars, skipped, err := parseComposeSidecars([]byte(composeFixture), ".", []string{"cache", "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sidecars) != 1 || sidecars[0].Name != "cache" {
+		t.Fatalf("只应导入指定的服务: %+v", sidecars)
+	}
+	// ../cache超出工作空间
+	if len(skipped) != 2 {
+		t.Fatalf("跳过的项 = %q", skipped)
+	}
+
+	if _, _, err := parseComposeSidecars([]byte("services: [\n"), ".", nil); err == nil {
+		t.Fatal("无效的YAML应返回错误")
+	}
+}
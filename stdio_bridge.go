@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/websocket"
+)
+
+// 容器内stdio进程桥接
+//
+// 语言服务器（LSP）和调试适配器（DAP）都通过stdio收发以Content-Length分帧的JSON消息。
+// 这里负责通过容器exec启动进程、处理分帧、把消息与WebSocket互相转发，并按工作空间
+// 登记进程，工作空间停止或删除时统一结束。
+
+// 单条消息的最大长度，防止错误的Content-Length导致分配过大的缓冲区
+const stdioMaxMessageSize = 64 << 20
+
+// 容器内的stdio进程会话
+type stdioSession struct {
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Kind        string    `json:"kind"` // lsp, dap
+	Name        string    `json:"name"` // 语言或调试器名称
+	Command     []string  `json:"command"`
+	Started     time.Time `json:"started"`
+
+	// 最近一次收发消息的时间（UnixNano），读写消息的goroutine与列表、空闲检测并发访问
+	lastActivity atomic.Int64
+
+	manager   *OnlineEditorManager
+	attach    types.HijackedResponse
+	stdout    *bufio.Reader
+	pidFile   string
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	done      chan struct{}
+
+	// 桥接自身发出的请求（如关闭语言服务器）等待的响应，请求ID -> 收到响应后关闭
+	pending   map[string]chan struct{}
+	pendingMu sync.Mutex
+}
+
+// 启动容器内的stdio进程
+// 进程通过sh包装，先把自身PID写入pidFile，便于之后结束进程（Docker没有结束exec的API）
+func (oem *OnlineEditorManager) startStdioSession(workspaceID, kind, name string, cmd []string, workingDir string) (*stdioSession, error) {
+	oem.mutex.RLock()
+	workspace, exists := oem.workspaces[workspaceID]
+	var containerID, status string
+	var envs []string
+	if exists {
+		containerID = workspace.ContainerID
+		status = workspace.Status
+		envs = workspaceExecEnv(workspace)
+	}
+	oem.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("工作空间不存在: %s", workspaceID)
+	}
+	if status != "running" {
+		return nil, fmt.Errorf("工作空间未运行: %s", workspaceID)
+	}
+
+	session := &stdioSession{
+		ID:          fmt.Sprintf("%s_%d", kind, time.Now().UnixNano()),
+		WorkspaceID: workspaceID,
+		Kind:        kind,
+		Name:        name,
+		Command:     cmd,
+		Started:     time.Now(),
+		manager:     oem,
+		done:        make(chan struct{}),
+	}
+	session.touch()
+	session.pidFile = path.Join("/tmp", "."+session.ID+".pid")
+
+	if workingDir == "" {
+		workingDir = containerWorkspaceRoot
+	}
+
+	// 命令作为位置参数传入，不拼接到脚本中
+	wrapped := append([]string{"sh", "-c", `pidfile="$1"; shift; echo $$ > "$pidfile"; exec "$@"`, "stdio-bridge", session.pidFile}, cmd...)
+
+	ctx := context.Background()
+	execResp, err := oem.dockerClient.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          wrapped,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		WorkingDir:   workingDir,
+		Env:          envs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建执行配置失败: %v", err)
+	}
+
+	session.attach, err = oem.dockerClient.ContainerExecAttach(ctx, execResp.ID, container.ExecStartOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("启动进程失败: %v", err)
+	}
+
+	// stdout用于消息收发，stderr只记录日志
+	stdoutReader, stdoutWriter := io.Pipe()
+	session.stdout = bufio.NewReader(stdoutReader)
+	go func() {
+		stderrWriter := &stdioLogWriter{prefix: fmt.Sprintf("[%s] %s/%s", workspaceID, kind, name)}
+		_, err := stdcopy.StdCopy(stdoutWriter, stderrWriter, session.attach.Reader)
+		if err == nil {
+			err = io.EOF
+		}
+		stdoutWriter.CloseWithError(err)
+		session.Close()
+	}()
+
+	oem.stdioMutex.Lock()
+	oem.stdioSessions[session.ID] = session
+	oem.stdioMutex.Unlock()
+
+	log.Printf("[%s] 启动%s进程 %s: %v", workspaceID, kind, session.ID, cmd)
+	return session, nil
+}
+
+// 把进程的stderr按行写入日志
+type stdioLogWriter struct {
+	prefix string
+}
+
+func (w *stdioLogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line != "" {
+			log.Printf("%s: %s", w.prefix, line)
+		}
+	}
+	return len(p), nil
+}
+
+func (s *stdioSession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// 最近一次收发消息的时间
+func (s *stdioSession) LastActivity() time.Time {
+	return time.Unix(0, s.lastActivity.Load())
+}
+
+func (s *stdioSession) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID           string    `json:"id"`
+		WorkspaceID  string    `json:"workspace_id"`
+		Kind         string    `json:"kind"`
+		Name         string    `json:"name"`
+		Command      []string  `json:"command"`
+		Started      time.Time `json:"started"`
+		LastActivity time.Time `json:"last_activity"`
+	}{s.ID, s.WorkspaceID, s.Kind, s.Name, s.Command, s.Started, s.LastActivity()})
+}
+
+// 读取一条Content-Length分帧的消息
+func (s *stdioSession) ReadMessage() ([]byte, error) {
+	// 消息之间的EOF是正常结束，读到一半的EOF说明消息被截断
+	if _, err := s.stdout.Peek(1); err != nil {
+		return nil, err
+	}
+	headers, err := textproto.NewReader(s.stdout).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("无效的Content-Length: %q", headers.Get("Content-Length"))
+	}
+	if length > stdioMaxMessageSize {
+		return nil, fmt.Errorf("消息过大: %d 字节", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.stdout, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	s.touch()
+	s.resolvePending(body)
+	return body, nil
+}
+
+// 登记桥接自身发出的JSON-RPC请求，返回的通道在收到对应响应后关闭
+// 响应仍由ReadMessage正常返回，读取消息的goroutine需要一直运行
+func (s *stdioSession) expectResponse(id string) <-chan struct{} {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+
+	if s.pending == nil {
+		s.pending = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	s.pending[id] = ch
+	return ch
+}
+
+func (s *stdioSession) resolvePending(body []byte) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if len(s.pending) == 0 {
+		return
+	}
+
+	var response struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	var id string
+	if json.Unmarshal(body, &response) != nil || response.Method != "" || json.Unmarshal(response.ID, &id) != nil {
+		return
+	}
+	if ch, exists := s.pending[id]; exists {
+		close(ch)
+		delete(s.pending, id)
+	}
+}
+
+// 写入一条Content-Length分帧的消息
+func (s *stdioSession) WriteMessage(body []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := fmt.Fprintf(s.attach.Conn, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	if _, err := s.attach.Conn.Write(body); err != nil {
+		return err
+	}
+
+	s.touch()
+	return nil
+}
+
+// 结束进程并注销会话
+func (s *stdioSession) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.attach.CloseWrite()
+		s.attach.Close()
+
+		s.manager.stdioMutex.Lock()
+		delete(s.manager.stdioSessions, s.ID)
+		s.manager.stdioMutex.Unlock()
+
+		// 关闭stdin后大多数服务会自行退出，这里再按PID结束一次以防残留
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			script := `kill "$(cat "$1")" 2>/dev/null; rm -f "$1"`
+			s.manager.execInWorkspace(ctx, s.WorkspaceID, []string{"sh", "-c", script, "stdio-bridge", s.pidFile}, "/", nil)
+		}()
+
+		log.Printf("[%s] %s进程已结束: %s", s.WorkspaceID, s.Kind, s.ID)
+	})
+}
+
+// 列出工作空间的stdio会话，kind为空时列出全部
+func (oem *OnlineEditorManager) listStdioSessions(workspaceID, kind string) []*stdioSession {
+	oem.stdioMutex.Lock()
+	defer oem.stdioMutex.Unlock()
+
+	sessions := []*stdioSession{}
+	for _, session := range oem.stdioSessions {
+		if session.WorkspaceID == workspaceID && (kind == "" || session.Kind == kind) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// 结束工作空间的stdio会话，kind为空时结束全部（工作空间停止、删除、重建时调用）
+func (oem *OnlineEditorManager) stopStdioSessions(workspaceID, kind string) {
+	for _, session := range oem.listStdioSessions(workspaceID, kind) {
+		session.Close()
+	}
+}
+
+// 在WebSocket与stdio会话之间转发消息，每个WebSocket文本消息对应一条JSON消息
+// toServer/toClient用于改写消息内容（如URI转换），可以为nil
+// 返回时WebSocket已断开或进程已退出，调用者负责关闭会话
+// WebSocket断开后继续读取并丢弃进程输出，直到会话关闭，桥接自身发出的请求仍能收到响应
+func relayStdioWebSocket(conn *websocket.Conn, session *stdioSession, toServer, toClient func([]byte) []byte) {
+	serverDone := make(chan struct{})
+	clientDone := make(chan struct{})
+
+	// 进程 -> WebSocket
+	go func() {
+		var closeServerDone sync.Once
+		defer closeServerDone.Do(func() { close(serverDone) })
+		for {
+			body, err := session.ReadMessage()
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("[%s] 读取%s消息失败: %v", session.WorkspaceID, session.Kind, err)
+				}
+				return
+			}
+			select {
+			case <-serverDone:
+				continue
+			default:
+			}
+			if toClient != nil {
+				body = toClient(body)
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				log.Printf("[%s] 发送%s消息到WebSocket失败: %v", session.WorkspaceID, session.Kind, err)
+				closeServerDone.Do(func() { close(serverDone) })
+			}
+		}
+	}()
+
+	// WebSocket -> 进程
+	go func() {
+		defer close(clientDone)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Printf("[%s] %s WebSocket读取失败: %v", session.WorkspaceID, session.Kind, err)
+				}
+				return
+			}
+			if toServer != nil {
+				message = toServer(message)
+			}
+			if err := session.WriteMessage(message); err != nil {
+				log.Printf("[%s] 写入%s消息失败: %v", session.WorkspaceID, session.Kind, err)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-serverDone:
+	case <-clientDone:
+	case <-session.done:
+	}
+}
+
+// 替换JSON中以from为路径前缀的字符串（包括对象的键），前缀换成to
+// 用于在客户端与容器内进程之间转换文件URI或路径
+func rewriteJSONPrefix(body []byte, from, to string) []byte {
+	if from == "" || from == to || !strings.Contains(string(body), from) {
+		return body
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber()
+	var message interface{}
+	if err := decoder.Decode(&message); err != nil {
+		return body
+	}
+
+	var rewrite func(value interface{}) interface{}
+	rewrite = func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			if v == from || strings.HasPrefix(v, from+"/") {
+				return to + strings.TrimPrefix(v, from)
+			}
+			return v
+		case []interface{}:
+			for i := range v {
+				v[i] = rewrite(v[i])
+			}
+			return v
+		case map[string]interface{}:
+			result := make(map[string]interface{}, len(v))
+			for key, item := range v {
+				result[rewrite(key).(string)] = rewrite(item)
+			}
+			return result
+		default:
+			return v
+		}
+	}
+
+	rewritten, err := json.Marshal(rewrite(message))
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
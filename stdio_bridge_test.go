@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func testStdioSession(r io.Reader) *stdioSession {
+	return &stdioSession{stdout: bufio.NewReader(r), done: make(chan struct{})}
+}
+
+func stdioFrame(body string) string {
+	return "Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+}
+
+func TestStdioReadMessage(t *testing.T) {
+	input := "Content-Length: 17\r\n\r\n{\"jsonrpc\":\"2.0\"}" +
+		// 额外的头部（LSP允许Content-Type）和大小写不同的头部名
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length: 2\r\n\r\n{}" +
+		// 长度为0的消息
+		"Content-Length: 0\r\n\r\n"
+
+	for name, reader := range map[string]io.Reader{
+		"整块读取": strings.NewReader(input),
+		// 头部和消息体被拆成多次读取
+		"逐字节读取": iotest.OneByteReader(strings.NewReader(input)),
+	} {
+		session := testStdioSession(reader)
+		for i, want := range []string{`{"jsonrpc":"2.0"}`, `{}`, ``} {
+			body, err := session.ReadMessage()
+			if err != nil {
+				t.Fatalf("%s: 消息 %d 读取失败: %v", name, i, err)
+			}
+			if string(body) != want {
+				t.Fatalf("%s: 消息 %d = %q, 期望 %q", name, i, body, want)
+			}
+		}
+		if _, err := session.ReadMessage(); err != io.EOF {
+			t.Fatalf("%s: 读完后应返回io.EOF, 实际 %v", name, err)
+		}
+	}
+}
+
+func TestStdioReadMessageInvalid(t *testing.T) {
+	tests := map[string]string{
+		"头部不完整":            "Content-Length: 10\r\n",
+		"头部没有结束":           "Content-Length: 10",
+		"消息体不完整":           "Content-Length: 10\r\n\r\n{}",
+		"缺少Content-Length": "Content-Type: application/json\r\n\r\n{}",
+		"非数字长度":            "Content-Length: abc\r\n\r\n{}",
+		"负数长度":             "Content-Length: -1\r\n\r\n{}",
+		"长度超出上限":           "Content-Length: 1099511627776\r\n\r\n{}",
+	}
+	for name, input := range tests {
+		body, err := testStdioSession(strings.NewReader(input)).ReadMessage()
+		if err == nil {
+			t.Errorf("%s: 应返回错误, 实际读到 %q", name, body)
+		}
+		if err == io.EOF {
+			t.Errorf("%s: 截断的消息不应返回io.EOF（会被当作正常结束）", name)
+		}
+	}
+}
+
+func TestStdioWriteMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	session := &stdioSession{attach: types.HijackedResponse{Conn: client}}
+	messages := []string{`{"id":1,"method":"initialize"}`, `{"method":"中文"}`}
+	go func() {
+		for _, message := range messages {
+			if err := session.WriteMessage([]byte(message)); err != nil {
+				t.Errorf("写入失败: %v", err)
+				return
+			}
+		}
+	}()
+
+	// 写入的内容按同样的分帧读回
+	reader := testStdioSession(server)
+	for _, want := range messages {
+		body, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("读回失败: %v", err)
+		}
+		if string(body) != want {
+			t.Fatalf("读回 %q, 期望 %q", body, want)
+		}
+	}
+}
+
+func TestStdioExpectResponse(t *testing.T) {
+	input := "" +
+		stdioFrame(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{}}`) +
+		// 服务器发给客户端的请求，ID相同也不算响应
+		stdioFrame(`{"jsonrpc":"2.0","id":"bridge-shutdown","method":"window/workDoneProgress/create"}`) +
+		stdioFrame(`{"jsonrpc":"2.0","id":7,"result":null}`) +
+		stdioFrame(`{"jsonrpc":"2.0","id":"bridge-shutdown","result":null}`)
+	session := testStdioSession(strings.NewReader(input))
+	replied := session.expectResponse("bridge-shutdown")
+
+	for i := 0; i < 3; i++ {
+		if _, err := session.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-replied:
+			t.Fatalf("第 %d 条消息不是shutdown响应", i)
+		default:
+		}
+	}
+
+	body, err := session.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-replied:
+	case <-time.After(time.Second):
+		t.Fatal("收到shutdown响应后通道应关闭")
+	}
+	// 响应仍然正常返回
+	if !strings.Contains(string(body), `"result":null`) {
+		t.Fatalf("响应内容错误: %s", body)
+	}
+	if len(session.pending) != 0 {
+		t.Fatalf("收到响应后应清理登记: %v", session.pending)
+	}
+}
+
+func TestRewriteJSONPrefix(t *testing.T) {
+	const client = "file:///home/me/project"
+
+	body := `{"jsonrpc":"2.0","id":12345678901234567890,"method":"workspace/applyEdit","params":{` +
+		`"uri":"file:///home/me/project/src/main.go",` +
+		`"root":"file:///home/me/project",` +
+		`"other":"file:///home/me/project2/main.go",` +
+		`"changes":{"file:///home/me/project/a.go":[{"newText":"file:///home/me/project/x"}]},` +
+		`"list":["file:///home/me/project/b.go",1.5,true,null]}}`
+
+	rewritten := rewriteJSONPrefix([]byte(body), client, containerWorkspaceURI)
+
+	var got map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(rewritten)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&got); err != nil {
+		t.Fatalf("改写结果不是合法JSON: %v\n%s", err, rewritten)
+	}
+	params := got["params"].(map[string]interface{})
+
+	checks := map[string]interface{}{
+		"uri":   "file:///workspace/src/main.go",
+		"root":  "file:///workspace",
+		"other": "file:///home/me/project2/main.go", // 前缀相同但不是子路径
+	}
+	for key, want := range checks {
+		if params[key] != want {
+			t.Errorf("%s = %v, 期望 %v", key, params[key], want)
+		}
+	}
+
+	changes := params["changes"].(map[string]interface{})
+	edits, exists := changes["file:///workspace/a.go"]
+	if !exists || len(changes) != 1 {
+		t.Fatalf("对象的键应被改写: %v", changes)
+	}
+	if text := edits.([]interface{})[0].(map[string]interface{})["newText"]; text != "file:///workspace/x" {
+		t.Errorf("嵌套的值 = %v", text)
+	}
+
+	list := params["list"].([]interface{})
+	if list[0] != "file:///workspace/b.go" || list[1] != json.Number("1.5") || list[2] != true || list[3] != nil {
+		t.Errorf("数组改写错误: %v", list)
+	}
+	// 大整数ID不能因为浮点转换丢失精度
+	if got["id"] != json.Number("12345678901234567890") {
+		t.Errorf("id = %v", got["id"])
+	}
+
+	// 反方向
+	back := rewriteJSONPrefix(rewritten, containerWorkspaceURI, client)
+	if !strings.Contains(string(back), `"uri":"file:///home/me/project/src/main.go"`) {
+		t.Errorf("反向改写失败: %s", back)
+	}
+}
+
+func TestRewriteJSONPrefixUnchanged(t *testing.T) {
+	tests := []struct {
+		name, body, from, to string
+	}{
+		{"不包含前缀", `{"uri":"file:///other/a.go"}`, "file:///home/me", "file:///workspace"},
+		{"非JSON", `file:///home/me/a.go`, "file:///home/me", "file:///workspace"},
+		{"前缀相同", `{"uri":"file:///workspace/a.go"}`, "file:///workspace", "file:///workspace"},
+		{"前缀为空", `{"uri":"file:///workspace/a.go"}`, "", "file:///workspace"},
+	}
+	for _, test := range tests {
+		if got := rewriteJSONPrefix([]byte(test.body), test.from, test.to); string(got) != test.body {
+			t.Errorf("%s: 不应改写, 得到 %s", test.name, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 用户身份
+//
+// 服务本身不做登录，用户身份由前置的认证网关（如启用auth_request的nginx）通过
+// X-User-ID头传入。客户端可以随意伪造这个头，因此只有直接来自TRUSTED_PROXIES
+// （逗号分隔的IP或CIDR，如"127.0.0.1,10.0.0.0/8"）的请求才信任它，网关必须覆盖
+// 而不是透传客户端发来的X-User-ID。未配置TRUSTED_PROXIES或来源不可信时请求没有
+// 用户身份：不能使用Git凭据，也不能签发预览令牌和分享链接。
+// ADMIN_USERS（逗号分隔的用户ID）中的用户是管理员，可以使用跨用户的管理接口。
+
+const userIDHeader = "X-User-ID"
+
+var trustedProxies = sync.OnceValue(func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("忽略无效的TRUSTED_PROXIES条目: %s", entry)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+})
+
+var adminUsers = sync.OnceValue(func() map[string]bool {
+	users := make(map[string]bool)
+	for _, entry := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			users[entry] = true
+		}
+	}
+	return users
+})
+
+// 请求是否直接来自可信的认证网关
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies() {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 请求所属的已认证用户，没有可信的用户身份时返回空字符串
+func requestUserID(r *http.Request) string {
+	if !fromTrustedProxy(r) {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(userIDHeader))
+}
+
+// 要求请求带有已认证的用户身份，返回false时已写入401响应
+func requireUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := requestUserID(r)
+	if userID == "" {
+		http.Error(w, "需要经过认证的用户身份", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// 用户ID的短摘要，用于按用户区分的Docker资源名称（用户ID不一定是合法的名称）
+func ownerDigest(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:6])
+}
+
+func isAdminUser(userID string) bool {
+	return userID != "" && adminUsers()[userID]
+}
+
+// 要求请求来自管理员，返回false时已写入401或403响应
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return false
+	}
+	if !isAdminUser(userID) {
+		http.Error(w, "需要管理员权限", http.StatusForbidden)
+		return false
+	}
+	return true
+}
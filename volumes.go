@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/gorilla/mux"
+)
+
+// 命名卷
+//
+// 除了/workspace目录，工作空间可以挂载Docker命名卷，重建容器后内容保留：
+//   - volume：工作空间专属的卷，删除工作空间时一起删除
+//   - cache：同一用户的多个工作空间共享的缓存卷（npm、maven、go模块等），删除工作空间后保留，
+//     可以通过清理接口删除不再使用的缓存。不同用户的缓存是不同的卷，避免互相污染；
+//     没有用户身份时创建的工作空间共用不带用户摘要的缓存卷
+//
+// 卷名称带有固定前缀并打上标签，只有本服务创建的卷才会出现在列表和清理中。
+// 重启后从容器的挂载信息中恢复卷配置。
+// 用户只能查看自己的卷（按所有者摘要区分），清理不再使用的卷需要管理员权限。
+
+const (
+	volumeTypeBind   = "bind"
+	volumeTypeVolume = "volume"
+	volumeTypeCache  = "cache"
+
+	workspaceVolumePrefix = "online-editor-vol-"
+	cacheVolumePrefix     = "online-editor-cache-"
+
+	volumeOfLabel    = "online-editor.volume-of"
+	volumeCacheLabel = "online-editor.cache"
+	volumeOwnerLabel = "online-editor.owner-digest"
+)
+
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+// 预设的缓存卷，未指定挂载路径时使用
+var cacheVolumePresets = map[string]string{
+	"npm":    "/root/.npm",
+	"yarn":   "/usr/local/share/.cache/yarn",
+	"pnpm":   "/root/.local/share/pnpm/store",
+	"pip":    "/root/.cache/pip",
+	"maven":  "/root/.m2",
+	"gradle": "/root/.gradle",
+	"go":     "/go/pkg/mod",
+	"cargo":  "/usr/local/cargo/registry",
+}
+
+// 卷信息
+type VolumeInfo struct {
+	Name        string            `json:"name"` // Docker卷名称
+	Type        string            `json:"type"` // "volume", "cache"
+	VolumeName  string            `json:"volume_name"`
+	WorkspaceID string            `json:"workspace_id,omitempty"` // volume类型所属的工作空间
+	Owner       string            `json:"owner,omitempty"`        // 所有者的用户ID摘要，为空表示没有用户身份时创建的
+	UsedBy      []string          `json:"used_by"`                // 挂载该卷的工作空间
+	Orphaned    bool              `json:"orphaned"`               // 所属工作空间已不存在或缓存无人使用
+	CreatedAt   string            `json:"created_at,omitempty"`
+	Driver      string            `json:"driver,omitempty"`
+	Mountpoint  string            `json:"mountpoint,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Size        int64             `json:"size"` // 字节，-1表示未统计
+}
+
+func workspaceVolumeName(workspaceID, name string) string {
+	return workspaceVolumePrefix + workspaceID + "-" + name
+}
+
+// 用户的缓存卷名称前缀
+func cacheVolumeOwnerPrefix(owner string) string {
+	if owner == "" {
+		return cacheVolumePrefix
+	}
+	return cacheVolumePrefix + ownerDigest(owner) + "-"
+}
+
+func cacheVolumeName(owner, name string) string {
+	return cacheVolumeOwnerPrefix(owner) + name
+}
+
+// 校验并补全额外的卷挂载，填写Docker卷名称，owner为工作空间所有者
+func normalizeWorkspaceVolumes(workspaceID, owner string, volumes []VolumeMount) ([]VolumeMount, error) {
+	targets := map[string]bool{"/workspace": true}
+	names := make(map[string]bool)
+	var result []VolumeMount
+
+	for _, v := range volumes {
+		if v.Type == "" {
+			v.Type = volumeTypeVolume
+		}
+		if !volumeNamePattern.MatchString(v.Name) {
+			return nil, fmt.Errorf("无效的卷名称: %q", v.Name)
+		}
+
+		switch v.Type {
+		case volumeTypeVolume:
+			v.VolumeName = workspaceVolumeName(workspaceID, v.Name)
+		case volumeTypeCache:
+			if v.ContainerPath == "" {
+				v.ContainerPath = cacheVolumePresets[v.Name]
+			}
+			v.VolumeName = cacheVolumeName(owner, v.Name)
+		default:
+			return nil, fmt.Errorf("不支持的卷类型: %s", v.Type)
+		}
+		if names[v.VolumeName] {
+			return nil, fmt.Errorf("卷重复: %s", v.Name)
+		}
+		names[v.VolumeName] = true
+
+		if v.ContainerPath == "" || !path.IsAbs(v.ContainerPath) {
+			return nil, fmt.Errorf("卷 %s 的挂载路径必须是绝对路径", v.Name)
+		}
+		v.ContainerPath = path.Clean(v.ContainerPath)
+		if v.ContainerPath == "/" || v.ContainerPath == "/workspace" || strings.HasPrefix(v.ContainerPath, "/workspace/") {
+			return nil, fmt.Errorf("卷 %s 不能挂载到 %s", v.Name, v.ContainerPath)
+		}
+		if targets[v.ContainerPath] {
+			return nil, fmt.Errorf("挂载路径重复: %s", v.ContainerPath)
+		}
+		targets[v.ContainerPath] = true
+
+		v.HostPath = ""
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// 从容器的挂载信息恢复命名卷，owner为工作空间所有者
+func workspaceVolumesFromMounts(workspaceID, owner string, mounts []container.MountPoint) []VolumeMount {
+	var volumes []VolumeMount
+	for _, m := range mounts {
+		if m.Type != mount.TypeVolume {
+			continue
+		}
+		v := VolumeMount{
+			VolumeName:    m.Name,
+			ContainerPath: m.Destination,
+			ReadOnly:      !m.RW,
+		}
+		switch {
+		case strings.HasPrefix(m.Name, workspaceVolumePrefix+workspaceID+"-"):
+			v.Type = volumeTypeVolume
+			v.Name = strings.TrimPrefix(m.Name, workspaceVolumePrefix+workspaceID+"-")
+		case strings.HasPrefix(m.Name, cacheVolumeOwnerPrefix(owner)):
+			v.Type = volumeTypeCache
+			v.Name = strings.TrimPrefix(m.Name, cacheVolumeOwnerPrefix(owner))
+		case strings.HasPrefix(m.Name, cacheVolumePrefix):
+			// 缓存按用户区分之前创建的容器挂载的是共用缓存
+			v.Type = volumeTypeCache
+			v.Name = strings.TrimPrefix(m.Name, cacheVolumePrefix)
+		default:
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes
+}
+
+// 工作空间容器的挂载：工作空间目录和命名卷。命名卷不存在时先创建
+func (oem *OnlineEditorManager) workspaceMounts(ctx context.Context, workspace *Workspace, workspaceDir string) ([]mount.Mount, error) {
+	mounts := []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: workspaceDir,
+			Target: "/workspace",
+			BindOptions: &mount.BindOptions{
+				Propagation: mount.PropagationRPrivate,
+			},
+		},
+	}
+
+	for _, v := range workspace.Volumes {
+		if v.Type != volumeTypeVolume && v.Type != volumeTypeCache {
+			continue
+		}
+
+		labels := map[string]string{managedLabel: "true"}
+		if workspace.Owner != "" {
+			labels[volumeOwnerLabel] = ownerDigest(workspace.Owner)
+		}
+		if v.Type == volumeTypeVolume {
+			labels[volumeOfLabel] = workspace.ID
+		} else {
+			labels[volumeCacheLabel] = v.Name
+		}
+		if _, err := oem.dockerClient.VolumeCreate(ctx, volume.CreateOptions{Name: v.VolumeName, Labels: labels}); err != nil {
+			return nil, fmt.Errorf("创建卷 %s 失败: %v", v.VolumeName, err)
+		}
+
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   v.VolumeName,
+			Target:   v.ContainerPath,
+			ReadOnly: v.ReadOnly,
+		})
+	}
+	return mounts, nil
+}
+
+// 删除工作空间专属的卷，共享缓存保留
+func (oem *OnlineEditorManager) removeWorkspaceVolumes(ctx context.Context, workspaceID string, volumes []VolumeMount) {
+	for _, v := range volumes {
+		if v.Type != volumeTypeVolume {
+			continue
+		}
+		if err := oem.dockerClient.VolumeRemove(ctx, v.VolumeName, false); err != nil && !cerrdefs.IsNotFound(err) {
+			log.Printf("[%s] 删除卷失败 %s: %v", workspaceID, v.VolumeName, err)
+			continue
+		}
+		log.Printf("[%s] 删除卷: %s", workspaceID, v.VolumeName)
+	}
+}
+
+// 列出本服务管理的卷，withSize为true时统计大小（需要遍历卷内容，较慢）
+func (oem *OnlineEditorManager) ListVolumes(ctx context.Context, withSize bool) ([]*VolumeInfo, error) {
+	resp, err := oem.dockerClient.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel+"=true")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("获取卷列表失败: %v", err)
+	}
+
+	sizes := make(map[string]int64)
+	if withSize {
+		usage, err := oem.dockerClient.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+		if err != nil {
+			log.Printf("统计卷大小失败: %v", err)
+		}
+		for _, v := range usage.Volumes {
+			if v.UsageData != nil {
+				sizes[v.Name] = v.UsageData.Size
+			}
+		}
+	}
+
+	// 按工作空间记录统计使用者，已停止的工作空间也算在内
+	usedBy := make(map[string][]string)
+	oem.mutex.RLock()
+	workspaceOwners := make(map[string]string, len(oem.workspaces))
+	for id, workspace := range oem.workspaces {
+		workspaceOwners[id] = ""
+		if workspace.Owner != "" {
+			workspaceOwners[id] = ownerDigest(workspace.Owner)
+		}
+		for _, v := range workspace.Volumes {
+			if v.VolumeName != "" {
+				usedBy[v.VolumeName] = append(usedBy[v.VolumeName], id)
+			}
+		}
+	}
+	oem.mutex.RUnlock()
+
+	var volumes []*VolumeInfo
+	for _, v := range resp.Volumes {
+		info := &VolumeInfo{
+			Name:       v.Name,
+			VolumeName: v.Name,
+			CreatedAt:  v.CreatedAt,
+			Driver:     v.Driver,
+			Mountpoint: v.Mountpoint,
+			Labels:     v.Labels,
+			Owner:      v.Labels[volumeOwnerLabel],
+			UsedBy:     usedBy[v.Name],
+			Size:       -1,
+		}
+		if size, exists := sizes[v.Name]; exists {
+			info.Size = size
+		}
+		if info.UsedBy == nil {
+			info.UsedBy = []string{}
+		}
+
+		if workspaceID := v.Labels[volumeOfLabel]; workspaceID != "" {
+			info.Type = volumeTypeVolume
+			info.WorkspaceID = workspaceID
+			info.Name = strings.TrimPrefix(v.Name, workspaceVolumePrefix+workspaceID+"-")
+			owner, exists := workspaceOwners[workspaceID]
+			info.Orphaned = !exists
+			if info.Owner == "" {
+				// 记录所有者标签之前创建的卷
+				info.Owner = owner
+			}
+		} else if name := v.Labels[volumeCacheLabel]; name != "" {
+			info.Type = volumeTypeCache
+			info.Name = name
+			info.Orphaned = len(info.UsedBy) == 0
+			if info.Owner == "" {
+				info.Owner = cacheVolumeOwner(v.Name, name)
+			}
+		} else {
+			continue
+		}
+		volumes = append(volumes, info)
+	}
+
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].VolumeName < volumes[j].VolumeName
+	})
+	return volumes, nil
+}
+
+// 从缓存卷名称中取出所有者摘要，共用缓存返回空字符串
+func cacheVolumeOwner(volumeName, name string) string {
+	rest := strings.TrimPrefix(volumeName, cacheVolumePrefix)
+	digest, found := strings.CutSuffix(rest, "-"+name)
+	if !found || len(digest) != len(ownerDigest("")) || strings.Contains(digest, "-") {
+		return ""
+	}
+	return digest
+}
+
+// 请求者可以看到的卷：管理员可以看到全部，其他用户只能看到自己的卷
+func volumesVisibleTo(userID string, volumes []*VolumeInfo) []*VolumeInfo {
+	if isAdminUser(userID) {
+		return volumes
+	}
+	digest := ownerDigest(userID)
+	visible := []*VolumeInfo{}
+	for _, v := range volumes {
+		if v.Owner == digest {
+			visible = append(visible, v)
+		}
+	}
+	return visible
+}
+
+// 删除不再使用的卷：所属工作空间已不存在的卷，以及includeCaches为true时无人使用的缓存卷。
+// 仍被容器使用的卷Docker会拒绝删除
+func (oem *OnlineEditorManager) PruneVolumes(ctx context.Context, includeCaches bool) ([]string, error) {
+	volumes, err := oem.ListVolumes(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, v := range volumes {
+		if !v.Orphaned || (v.Type == volumeTypeCache && !includeCaches) {
+			continue
+		}
+		if err := oem.dockerClient.VolumeRemove(ctx, v.VolumeName, false); err != nil {
+			if !cerrdefs.IsNotFound(err) {
+				log.Printf("清理卷失败 %s: %v", v.VolumeName, err)
+			}
+			continue
+		}
+		log.Printf("清理卷: %s", v.VolumeName)
+		removed = append(removed, v.VolumeName)
+	}
+	return removed, nil
+}
+
+// HTTP处理器
+
+// GET /volumes?size=true
+func (oem *OnlineEditorManager) handleListVolumes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	volumes, err := oem.ListVolumes(ctx, r.URL.Query().Get("size") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	volumes = volumesVisibleTo(userID, volumes)
+	if volumes == nil {
+		volumes = []*VolumeInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(volumes)
+}
+
+// GET /volumes/{name}
+func (oem *OnlineEditorManager) handleGetVolume(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	userID, ok := requireUserID(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	volumes, err := oem.ListVolumes(ctx, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 其他用户的卷同样返回不存在
+	for _, v := range volumesVisibleTo(userID, volumes) {
+		if v.VolumeName == name {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(v)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("卷不存在: %s", name), http.StatusNotFound)
+}
+
+// POST /volumes/prune?caches=true
+// 会删除所有用户不再使用的卷，只有管理员可以调用
+func (oem *OnlineEditorManager) handlePruneVolumes(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	removed, err := oem.PruneVolumes(r.Context(), r.URL.Query().Get("caches") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"removed": removed,
+		"count":   len(removed),
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/network"
+)
+
+// 工作空间网络
+//
+// 默认所有工作空间都在共享网络中（见ip_pool.go），工作空间之间可以通过IP互相访问。
+// 可以为每个工作空间（workspace）或每个团队（team）创建独立的用户自定义网络，
+// Docker会隔离不同的用户自定义网络。egress为deny时创建internal网络，容器无法访问外网。
+// 团队名称在所有者范围内有效，不同用户的同名团队是不同的网络；加入已有团队网络时
+// 外网访问策略必须与网络一致。
+// 网络配置保存在容器标签中，重启后据此恢复；删除工作空间时清理不再使用的网络。
+
+const (
+	networkModeShared    = "shared"
+	networkModeWorkspace = "workspace"
+	networkModeTeam      = "team"
+
+	networkEgressAllow = "allow"
+	networkEgressDeny  = "deny"
+
+	networkModeLabel   = "online-editor.network-mode"
+	networkTeamLabel   = "online-editor.team"
+	networkEgressLabel = "online-editor.egress"
+	networkNamePrefix  = "online-editor-"
+)
+
+var networkTeamPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+// 工作空间的网络配置
+type WorkspaceNetwork struct {
+	Mode   string `json:"mode"`             // "shared"（共享网络）, "workspace", "team"
+	Team   string `json:"team,omitempty"`   // mode为team时的团队名称
+	Egress string `json:"egress,omitempty"` // "allow", "deny"（禁止访问外网）
+}
+
+// 补全默认值并校验，默认值取自WORKSPACE_NETWORK_MODE和WORKSPACE_NETWORK_EGRESS
+func normalizeWorkspaceNetwork(options WorkspaceNetwork) (WorkspaceNetwork, error) {
+	if options.Mode == "" {
+		options.Mode = os.Getenv("WORKSPACE_NETWORK_MODE")
+	}
+	if options.Mode == "" {
+		options.Mode = networkModeShared
+	}
+	if options.Egress == "" {
+		options.Egress = os.Getenv("WORKSPACE_NETWORK_EGRESS")
+	}
+	if options.Egress == "" {
+		options.Egress = networkEgressAllow
+	}
+
+	switch options.Mode {
+	case networkModeShared, networkModeWorkspace:
+		options.Team = ""
+	case networkModeTeam:
+		if !networkTeamPattern.MatchString(options.Team) {
+			return options, fmt.Errorf("无效的团队名称: %q", options.Team)
+		}
+	default:
+		return options, fmt.Errorf("不支持的网络模式: %s", options.Mode)
+	}
+
+	switch options.Egress {
+	case networkEgressAllow:
+	case networkEgressDeny:
+		if options.Mode == networkModeShared {
+			return options, fmt.Errorf("共享网络不支持禁止外网访问，请使用独立网络")
+		}
+	default:
+		return options, fmt.Errorf("不支持的外网访问策略: %s", options.Egress)
+	}
+	return options, nil
+}
+
+// 从容器标签恢复网络配置，没有标签的旧容器使用共享网络
+func workspaceNetworkFromLabels(labels map[string]string) WorkspaceNetwork {
+	options := WorkspaceNetwork{
+		Mode:   labels[networkModeLabel],
+		Team:   labels[networkTeamLabel],
+		Egress: labels[networkEgressLabel],
+	}
+	if options.Mode == "" {
+		options.Mode = networkModeShared
+	}
+	if options.Egress == "" {
+		options.Egress = networkEgressAllow
+	}
+	return options
+}
+
+func (options WorkspaceNetwork) labels() map[string]string {
+	labels := map[string]string{
+		networkModeLabel:   options.Mode,
+		networkEgressLabel: options.Egress,
+	}
+	if options.Team != "" {
+		labels[networkTeamLabel] = options.Team
+	}
+	return labels
+}
+
+// 工作空间所在网络的名称，团队网络名称中包含所有者的摘要（用户ID不一定是合法的网络名称）
+func (oem *OnlineEditorManager) workspaceNetworkName(workspaceID, owner string, options WorkspaceNetwork) string {
+	switch options.Mode {
+	case networkModeWorkspace:
+		return networkNamePrefix + workspaceID
+	case networkModeTeam:
+		return networkNamePrefix + "team-" + ownerDigest(owner) + "-" + options.Team
+	}
+	return oem.networkName
+}
+
+// 团队名称按所有者区分之前创建的团队网络，恢复旧容器时使用
+func legacyTeamNetworkName(options WorkspaceNetwork) string {
+	return networkNamePrefix + "team-" + options.Team
+}
+
+// 检查已存在的网络的外网访问策略是否与请求一致，网络不存在时返回exists为false
+func (oem *OnlineEditorManager) checkNetworkEgress(ctx context.Context, name string, options WorkspaceNetwork) (exists bool, err error) {
+	existing, err := oem.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("检查网络失败: %v", err)
+	}
+
+	egress := networkEgressAllow
+	if existing.Internal {
+		egress = networkEgressDeny
+	}
+	if egress != options.Egress {
+		return true, fmt.Errorf("网络 %s 已存在，外网访问策略为%s，与请求的%s不一致", name, egress, options.Egress)
+	}
+	return true, nil
+}
+
+// 确保工作空间的网络name存在
+func (oem *OnlineEditorManager) ensureWorkspaceNetwork(ctx context.Context, workspaceID, name string, options WorkspaceNetwork) error {
+	if options.Mode == networkModeShared || options.Mode == "" {
+		return nil
+	}
+
+	// 团队网络由第一个工作空间创建，之后加入的工作空间必须使用相同的外网策略
+	exists, err := oem.checkNetworkEgress(ctx, name, options)
+	if err != nil || exists {
+		return err
+	}
+
+	labels := options.labels()
+	labels[managedLabel] = "true"
+	_, err = oem.dockerClient.NetworkCreate(ctx, name, network.CreateOptions{
+		Driver:   "bridge",
+		Internal: options.Egress == networkEgressDeny,
+		Labels:   labels,
+	})
+	if cerrdefs.IsConflict(err) {
+		// 并发创建的团队网络，同样需要检查外网策略
+		_, err = oem.checkNetworkEgress(ctx, name, options)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("创建网络失败: %v", err)
+	}
+
+	log.Printf("[%s] 创建工作空间网络: %s (模式: %s, 外网访问: %s)", workspaceID, name, options.Mode, options.Egress)
+	return nil
+}
+
+// 删除工作空间后清理网络name，团队网络在仍有容器使用时保留
+func (oem *OnlineEditorManager) removeWorkspaceNetwork(ctx context.Context, workspaceID, name string, options WorkspaceNetwork) {
+	if options.Mode == networkModeShared || options.Mode == "" || name == oem.networkName || name == dockerDefaultNetwork {
+		return
+	}
+
+	// 已停止的容器不在网络的Containers中，按工作空间记录判断团队网络是否仍在使用
+	oem.mutex.RLock()
+	for id, workspace := range oem.workspaces {
+		if id != workspaceID && workspace.NetworkName == name {
+			oem.mutex.RUnlock()
+			return
+		}
+	}
+	oem.mutex.RUnlock()
+
+	existing, err := oem.dockerClient.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err != nil {
+		if !cerrdefs.IsNotFound(err) {
+			log.Printf("[%s] 检查网络失败 %s: %v", workspaceID, name, err)
+		}
+		return
+	}
+	if len(existing.Containers) > 0 {
+		return
+	}
+
+	if err := oem.dockerClient.NetworkRemove(ctx, name); err != nil && !cerrdefs.IsNotFound(err) {
+		log.Printf("[%s] 删除网络失败 %s: %v", workspaceID, name, err)
+		return
+	}
+	log.Printf("[%s] 删除工作空间网络: %s", workspaceID, name)
+}
+
+// 容器连接到工作空间网络的配置，staticIP为共享网络中分配的固定IP。
+// Docker默认网络不支持指定IP，使用默认配置
+func workspaceNetworkingConfig(workspace *Workspace, staticIP string) *network.NetworkingConfig {
+	if workspace.NetworkName == "" || workspace.NetworkName == dockerDefaultNetwork {
+		return &network.NetworkingConfig{}
+	}
+
+	endpoint := &network.EndpointSettings{}
+	if staticIP != "" {
+		endpoint.IPAMConfig = &network.EndpointIPAMConfig{IPv4Address: staticIP}
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			workspace.NetworkName: endpoint,
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 工作空间路径解析
+//
+// 工作空间目录通过bind mount挂载到容器的/workspace，容器内的进程可以在其中
+// 随意创建符号链接。宿主机上的文件API如果直接跟随这些链接，就可能读写宿主机
+// 上的任意文件。这里按照openat2(RESOLVE_IN_ROOT)的语义逐级解析路径：
+//   - 每一级都用Lstat检查，遇到符号链接时在工作空间内展开
+//   - 绝对路径的链接目标按容器视角解释，只允许指向/workspace内部
+//   - ".." 不能越过工作空间根目录
+// 所有文件操作都必须经过resolveWorkspacePath获取宿主机路径。
+
+// 容器内工作空间的挂载点
+const containerWorkspaceRoot = "/workspace"
+
+// 单次解析允许展开的最大符号链接数量，防止链接循环
+const maxSymlinkHops = 40
+
+// 访问路径超出工作空间范围
+var errPathOutsideWorkspace = fmt.Errorf("访问路径超出工作空间范围")
+
+// 判断child是否位于parent之内（或相同），按路径分隔符比较，避免ws_1匹配ws_12
+func isSubPath(parent, child string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// 在root内解析unsafePath，返回宿主机上的绝对路径
+// followFinal为false时不跟随最后一级符号链接（用于删除、移动等操作链接本身的场景）
+func secureJoin(root, unsafePath string, followFinal bool) (string, error) {
+	if strings.ContainsRune(unsafePath, 0) {
+		return "", fmt.Errorf("路径包含非法字符")
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("解析工作空间目录失败: %v", err)
+	}
+
+	components := strings.Split(filepath.ToSlash(unsafePath), "/")
+	current := realRoot
+	hops := 0
+
+	for len(components) > 0 {
+		part := components[0]
+		components = components[1:]
+
+		if part == "" || part == "." {
+			continue
+		}
+
+		if part == ".." {
+			if current == realRoot {
+				return "", errPathOutsideWorkspace
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		info, err := os.Lstat(next)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// 不存在的路径不可能是符号链接，按字面继续
+				current = next
+				continue
+			}
+			return "", fmt.Errorf("解析路径失败: %v", err)
+		}
+
+		isLast := len(components) == 0
+		if info.Mode()&os.ModeSymlink == 0 || (isLast && !followFinal) {
+			current = next
+			continue
+		}
+
+		hops++
+		if hops > maxSymlinkHops {
+			return "", fmt.Errorf("符号链接层级过多: %s", unsafePath)
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", fmt.Errorf("读取符号链接失败: %v", err)
+		}
+
+		if filepath.IsAbs(target) {
+			// 链接由容器内创建，绝对路径是容器视角，只接受/workspace下的目标
+			if target != containerWorkspaceRoot && !strings.HasPrefix(target, containerWorkspaceRoot+"/") {
+				return "", fmt.Errorf("符号链接指向工作空间之外: %s -> %s", part, target)
+			}
+			current = realRoot
+			target = strings.TrimPrefix(target, containerWorkspaceRoot)
+		}
+
+		components = append(strings.Split(target, "/"), components...)
+	}
+
+	if !isSubPath(realRoot, current) {
+		return "", errPathOutsideWorkspace
+	}
+
+	// 二次校验：对已存在的路径做一次完整的EvalSymlinks
+	if followFinal {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil && !isSubPath(realRoot, resolved) {
+			return "", errPathOutsideWorkspace
+		}
+	}
+
+	return current, nil
+}
+
+// 解析工作空间内的路径（跟随最后一级符号链接），用于读取、写入、列目录
+func (oem *OnlineEditorManager) resolveWorkspacePath(workspaceID, relPath string) (string, error) {
+	return secureJoin(filepath.Join(oem.workspacesDir, workspaceID), relPath, true)
+}
+
+// 解析工作空间内的路径（不跟随最后一级符号链接），用于删除、移动、复制源
+func (oem *OnlineEditorManager) resolveWorkspacePathNoFollow(workspaceID, relPath string) (string, error) {
+	return secureJoin(filepath.Join(oem.workspacesDir, workspaceID), relPath, false)
+}
+
+// 工作空间根目录在宿主机上的真实路径
+func (oem *OnlineEditorManager) workspaceRealDir(workspaceID string) (string, error) {
+	return secureJoin(filepath.Join(oem.workspacesDir, workspaceID), ".", true)
+}
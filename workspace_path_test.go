@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 创建 base/ws_1（工作空间）和 base/ws_12（相邻工作空间），返回两者的真实路径
+func newTestWorkspaceRoot(t *testing.T) (root, sibling string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(base, "ws_1")
+	sibling = filepath.Join(base, "ws_12")
+	for _, dir := range []string{filepath.Join(root, "src"), sibling} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sibling, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root, sibling
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsSubPathSiblingPrefix(t *testing.T) {
+	root, sibling := newTestWorkspaceRoot(t)
+
+	if isSubPath(root, sibling) {
+		t.Fatalf("%s 不应被视为 %s 的子路径", sibling, root)
+	}
+	if isSubPath(root, filepath.Join(sibling, "secret")) {
+		t.Fatalf("相邻工作空间的文件不应被视为子路径")
+	}
+	if !isSubPath(root, root) || !isSubPath(root, filepath.Join(root, "src")) {
+		t.Fatalf("工作空间自身和子目录应被视为子路径")
+	}
+}
+
+func TestSecureJoinRegularPaths(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", root},
+		{".", root},
+		{"/", root},
+		{"src/main.go", filepath.Join(root, "src", "main.go")},
+		{"/src/./main.go", filepath.Join(root, "src", "main.go")},
+		{"src/../src/main.go", filepath.Join(root, "src", "main.go")},
+		{"src/new/file.txt", filepath.Join(root, "src", "new", "file.txt")},
+	}
+	for _, tt := range tests {
+		got, err := secureJoin(root, tt.path, true)
+		if err != nil {
+			t.Errorf("secureJoin(%q) 返回错误: %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("secureJoin(%q) = %q, 期望 %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSecureJoinRejectsEscapes(t *testing.T) {
+	root, sibling := newTestWorkspaceRoot(t)
+
+	// 绝对路径符号链接指向宿主机路径
+	mustSymlink(t, "/etc", filepath.Join(root, "hostetc"))
+	// 相对路径符号链接逃逸到相邻工作空间
+	mustSymlink(t, "../ws_12", filepath.Join(root, "neighbour"))
+	mustSymlink(t, "../../ws_12/secret", filepath.Join(root, "src", "deep"))
+	// 指向宿主机上相邻工作空间绝对路径的链接
+	mustSymlink(t, sibling, filepath.Join(root, "abs-neighbour"))
+
+	tests := []string{
+		"..",
+		"../ws_12/secret",
+		"src/../../ws_12/secret",
+		"src/../../../etc/passwd",
+		"hostetc/passwd",
+		"hostetc",
+		"neighbour/secret",
+		"neighbour",
+		"src/deep",
+		"abs-neighbour/secret",
+	}
+	for _, path := range tests {
+		if got, err := secureJoin(root, path, true); err == nil {
+			t.Errorf("secureJoin(%q) = %q, 期望返回错误", path, got)
+		}
+	}
+}
+
+func TestSecureJoinDotDotReturnsOutsideError(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	_, err := secureJoin(root, "src/../../ws_12", true)
+	if !errors.Is(err, errPathOutsideWorkspace) {
+		t.Fatalf("期望 errPathOutsideWorkspace，实际: %v", err)
+	}
+}
+
+func TestSecureJoinContainerAbsoluteSymlink(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	// 容器内创建的 /workspace/src 链接按容器视角解析到工作空间内部
+	mustSymlink(t, "/workspace/src", filepath.Join(root, "link"))
+
+	got, err := secureJoin(root, "link/main.go", true)
+	if err != nil {
+		t.Fatalf("secureJoin 返回错误: %v", err)
+	}
+	if want := filepath.Join(root, "src", "main.go"); got != want {
+		t.Fatalf("secureJoin = %q, 期望 %q", got, want)
+	}
+}
+
+func TestSecureJoinRelativeSymlinkInside(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	if err := os.MkdirAll(filepath.Join(root, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mustSymlink(t, "../../src/main.go", filepath.Join(root, "a", "b", "entry"))
+
+	got, err := secureJoin(root, "a/b/entry", true)
+	if err != nil {
+		t.Fatalf("secureJoin 返回错误: %v", err)
+	}
+	if want := filepath.Join(root, "src", "main.go"); got != want {
+		t.Fatalf("secureJoin = %q, 期望 %q", got, want)
+	}
+}
+
+func TestSecureJoinSymlinkLoop(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	mustSymlink(t, "loop-b", filepath.Join(root, "loop-a"))
+	mustSymlink(t, "loop-a", filepath.Join(root, "loop-b"))
+	mustSymlink(t, "self", filepath.Join(root, "self"))
+
+	for _, path := range []string{"loop-a", "loop-b/file", "self"} {
+		if got, err := secureJoin(root, path, true); err == nil {
+			t.Errorf("secureJoin(%q) = %q, 期望返回错误", path, got)
+		}
+	}
+}
+
+func TestSecureJoinRejectsNulByte(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	if got, err := secureJoin(root, "src/main.go\x00.txt", true); err == nil {
+		t.Fatalf("secureJoin = %q, 期望返回错误", got)
+	}
+}
+
+func TestSecureJoinNoFollowFinal(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+
+	mustSymlink(t, "/etc/passwd", filepath.Join(root, "passwd"))
+	mustSymlink(t, "src", filepath.Join(root, "srclink"))
+
+	// 不跟随最后一级时返回链接本身，删除、移动只作用于工作空间内的链接
+	got, err := secureJoin(root, "passwd", false)
+	if err != nil {
+		t.Fatalf("secureJoin 返回错误: %v", err)
+	}
+	if want := filepath.Join(root, "passwd"); got != want {
+		t.Fatalf("secureJoin = %q, 期望 %q", got, want)
+	}
+
+	// 跟随时同一个链接必须被拒绝
+	if _, err := secureJoin(root, "passwd", true); err == nil {
+		t.Fatalf("跟随指向宿主机的链接时期望返回错误")
+	}
+
+	// 中间一级仍然跟随
+	got, err = secureJoin(root, "srclink/main.go", false)
+	if err != nil {
+		t.Fatalf("secureJoin 返回错误: %v", err)
+	}
+	if want := filepath.Join(root, "src", "main.go"); got != want {
+		t.Fatalf("secureJoin = %q, 期望 %q", got, want)
+	}
+}
+
+func TestResolveWorkspacePathSiblingWorkspace(t *testing.T) {
+	root, _ := newTestWorkspaceRoot(t)
+	oem := &OnlineEditorManager{workspacesDir: filepath.Dir(root)}
+
+	if got, err := oem.resolveWorkspacePath("ws_1", "../ws_12/secret"); err == nil {
+		t.Fatalf("resolveWorkspacePath = %q, 期望返回错误", got)
+	}
+	got, err := oem.resolveWorkspacePath("ws_1", "src/main.go")
+	if err != nil {
+		t.Fatalf("resolveWorkspacePath 返回错误: %v", err)
+	}
+	if want := filepath.Join(root, "src", "main.go"); got != want {
+		t.Fatalf("resolveWorkspacePath = %q, 期望 %q", got, want)
+	}
+}